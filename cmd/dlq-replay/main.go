@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// dlq-replay re-publishes records from gobmp-arango dead letter topic back to their original
+// gobmp topics, once the cause of the failure has been fixed.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cisco-open/jalapeno/gobmp-arango/config"
+	"github.com/cisco-open/jalapeno/gobmp-arango/deadletter"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/golang/glog"
+)
+
+var (
+	msgSrvAddr  string
+	dlqTopic    string
+	topicFilter string
+	since       string
+	dryRun      bool
+	kafkaConfig kafkaclient.Config
+)
+
+func init() {
+	flag.StringVar(&msgSrvAddr, "message-server", "", "URL to the messages supplying server")
+	flag.StringVar(&dlqTopic, "dead-letter-topic", deadletter.DefaultTopic, "name of the dead letter topic")
+	flag.StringVar(&topicFilter, "topic", "", "when set, only records originally received from this topic are replayed")
+	flag.StringVar(&since, "since", "", "when set, only records dead-lettered after this RFC3339 time are replayed")
+	flag.BoolVar(&dryRun, "dry-run", false, "when true, records are only logged and not re-published")
+	kafkaclient.RegisterFlags(&kafkaConfig)
+}
+
+func main() {
+	// Settings are read from the configuration file, environment variables and flags
+	err := config.Parse("message-server")
+	_ = flag.Set("logtostderr", "true")
+	if err != nil {
+		glog.Errorf("failed to load the configuration with error: %+v", err)
+		os.Exit(1)
+	}
+	config.PrintIfRequested()
+
+	var start int64 = sarama.OffsetOldest
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			glog.Errorf("invalid value of \"--since\" parameter: %s", since)
+			os.Exit(1)
+		}
+		start = t.UnixMilli()
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.ClientID = "gobmp-arango-dlq-replay"
+	saramaConfig.Version = sarama.V2_6_0_0
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	if err := kafkaConfig.Apply(saramaConfig); err != nil {
+		glog.Errorf("failed to configure kafka client with error: %+v", err)
+		os.Exit(1)
+	}
+	client, err := sarama.NewClient(strings.Split(msgSrvAddr, ","), saramaConfig)
+	if err != nil {
+		glog.Errorf("failed to connect to kafka with error: %+v", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		glog.Errorf("failed to initialize kafka consumer with error: %+v", err)
+		os.Exit(1)
+	}
+	defer consumer.Close()
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		glog.Errorf("failed to initialize kafka producer with error: %+v", err)
+		os.Exit(1)
+	}
+	defer producer.Close()
+
+	partitions, err := client.Partitions(dlqTopic)
+	if err != nil {
+		glog.Errorf("failed to get partitions of the topic %s with error: %+v", dlqTopic, err)
+		os.Exit(1)
+	}
+	replayed, skipped := 0, 0
+	for _, p := range partitions {
+		r, s, err := replayPartition(client, consumer, producer, p, start)
+		if err != nil {
+			glog.Errorf("failed to replay partition %d of the topic %s with error: %+v", p, dlqTopic, err)
+			os.Exit(1)
+		}
+		replayed += r
+		skipped += s
+	}
+	glog.Infof("Replayed %d records, skipped %d records from the topic %s", replayed, skipped, dlqTopic)
+}
+
+// replayPartition re-publishes records of the partition starting from the offset corresponding to start
+// and up to the last record present in the partition when the replay started.
+func replayPartition(client sarama.Client, consumer sarama.Consumer, producer sarama.SyncProducer, partition int32, start int64) (int, int, error) {
+	high, err := client.GetOffset(dlqTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+	// GetOffset with a timestamp returns the offset of the first record at or after the time,
+	// or -1 when there is no such record.
+	from, err := client.GetOffset(dlqTopic, partition, start)
+	if err != nil {
+		return 0, 0, err
+	}
+	if from == -1 || from >= high {
+		return 0, 0, nil
+	}
+	pc, err := consumer.ConsumePartition(dlqTopic, partition, from)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer pc.Close()
+	replayed, skipped := 0, 0
+	for msg := range pc.Messages() {
+		r := decode(msg, topicFilter)
+		if r == nil {
+			skipped++
+		} else if dryRun {
+			glog.Infof("record for topic: %s key: %s failed after %d attempts with error: %s, payload: %s", r.Topic, r.Key, r.Attempts, r.Error, string(r.Payload))
+			replayed++
+		} else {
+			if _, _, err := producer.SendMessage(replay(r)); err != nil {
+				return replayed, skipped, err
+			}
+			glog.V(5).Infof("replayed record for topic: %s key: %s", r.Topic, r.Key)
+			replayed++
+		}
+		if msg.Offset >= high-1 {
+			break
+		}
+	}
+
+	return replayed, skipped, nil
+}
+
+// decode returns the dead letter record carried by the message, nil when the record is malformed or
+// was not received from the topic, an empty topic matches records of all topics.
+func decode(msg *sarama.ConsumerMessage, topic string) *deadletter.Record {
+	r := &deadletter.Record{}
+	if err := json.Unmarshal(msg.Value, r); err != nil {
+		glog.Errorf("skipping malformed record at partition: %d offset: %d with error: %+v", msg.Partition, msg.Offset, err)
+		return nil
+	}
+	if r.Topic == "" || (topic != "" && r.Topic != topic) {
+		return nil
+	}
+
+	return r
+}
+
+// replay returns the message re-publishing the original payload of the record to its original topic
+func replay(r *deadletter.Record) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: r.Topic,
+		Key:   sarama.StringEncoder(r.Key),
+		Value: sarama.ByteEncoder(r.Payload),
+	}
+}
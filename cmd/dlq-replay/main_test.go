@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		topic  string
+		expect bool
+	}{
+		{
+			name:   "record",
+			value:  `{"topic":"gobmp.parsed.ls_link","key":"1","payload":"e30="}`,
+			expect: true,
+		},
+		{
+			name:   "record of the topic",
+			value:  `{"topic":"gobmp.parsed.ls_link","key":"1","payload":"e30="}`,
+			topic:  "gobmp.parsed.ls_link",
+			expect: true,
+		},
+		{
+			name:  "record of another topic",
+			value: `{"topic":"gobmp.parsed.ls_node","key":"1","payload":"e30="}`,
+			topic: "gobmp.parsed.ls_link",
+		},
+		{
+			name:  "record without topic",
+			value: `{"key":"1","payload":"e30="}`,
+		},
+		{
+			name:  "malformed record",
+			value: `{"topic":`,
+		},
+	}
+	for _, tt := range tests {
+		r := decode(&sarama.ConsumerMessage{Value: []byte(tt.value)}, tt.topic)
+		if (r != nil) != tt.expect {
+			t.Errorf("%s: expected record %t, actual %+v", tt.name, tt.expect, r)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	r := decode(&sarama.ConsumerMessage{Value: []byte(`{"topic":"gobmp.parsed.ls_link","key":"1","payload":"e30="}`)}, "")
+	m := replay(r)
+	key, _ := m.Key.Encode()
+	value, _ := m.Value.Encode()
+	if m.Topic != "gobmp.parsed.ls_link" || string(key) != "1" || string(value) != "{}" {
+		t.Errorf("expected message of topic gobmp.parsed.ls_link with key 1 and payload {}, actual %s %s %s", m.Topic, key, value)
+	}
+}
@@ -25,18 +25,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"os/signal"
 	"runtime"
 	"strconv"
+	"time"
 
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/arangodb"
+	"github.com/cisco-open/jalapeno/gobmp-arango/config"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
 	"github.com/cisco-open/jalapeno/gobmp-arango/mockdb"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/gobmpsrv"
 	"github.com/sbezverk/gobmp/pkg/pub"
@@ -49,28 +56,29 @@ import (
 	_ "net/http/pprof"
 )
 
-const (
-	// userFile defines the name of file containing base64 encoded user name
-	userFile = "./credentials/.username"
-	// passFile defines the name of file containing base64 encoded password
-	passFile = "./credentials/.password"
-	// MAXUSERNAME defines maximum length of ArangoDB user name
-	MAXUSERNAME = 256
-	// MAXPASS defines maximum length of ArangoDB password
-	MAXPASS = 256
-)
-
 var (
-	dstPort   int
-	srcPort   int
-	dbSrvAddr string
-	intercept string
-	splitAF   string
-	mockDB    string
-	dbName    string
-	dbUser    string
-	dbPass    string
-	perfPort  = 56768
+	dstPort      int
+	srcPort      int
+	dbSrvAddr    string
+	intercept    string
+	splitAF      string
+	mockDB       string
+	mockFile     string
+	notifyTo     string
+	notifyDst    string
+	dbName       string
+	dbUser       string
+	dbPass       string
+	arangoConfig arangoclient.Config
+	credConfig   credentials.Config
+	kafkaConf    kafkaclient.Config
+	spoolFile    string
+	drainWait    time.Duration
+	healthPort   int
+	peerCascade  string
+	peerResync   string
+	traceConfig  tracing.Config
+	perfPort     = 56768
 )
 
 func init() {
@@ -81,9 +89,21 @@ func init() {
 	flag.StringVar(&intercept, "intercept", "false", "When intercept set \"true\", all incomming BMP messges will be copied to TCP port specified by destination-port, otherwise received BMP messages will be published to Kafka.")
 	flag.StringVar(&splitAF, "split-af", "true", "When set \"true\" (default) ipv4 and ipv6 will be published in separate topics. if set \"false\" the same topic will be used for both address families.")
 	flag.StringVar(&mockDB, "mock-database", "false", "when set to true, received messages are stored in the file")
+	flag.StringVar(&mockFile, "mock-database-file", "gobmp-capture.jsonl", "JSON lines file where the mock database records received messages, empty disables recording")
+	flag.StringVar(&notifyTo, "notifier", "", "backend receiving change events: webhook, file or channel, events are disabled when not set")
+	flag.StringVar(&notifyDst, "notifier-target", "", "webhook url or events file name")
 	flag.StringVar(&dbName, "database-name", "", "DB name")
 	flag.StringVar(&dbUser, "database-user", "", "DB User name")
 	flag.StringVar(&dbPass, "database-pass", "", "DB User's password")
+	arangoclient.RegisterFlags(&arangoConfig)
+	credentials.RegisterFlags(&credConfig)
+	kafkaclient.RegisterFlags(&kafkaConf)
+	tracing.RegisterFlags(&traceConfig)
+	flag.StringVar(&spoolFile, "spool-file", "gobmp-arango-spool.jsonl", "file storing change events which were not sent on shutdown, they are sent on the next start, empty discards them")
+	flag.DurationVar(&drainWait, "drain-timeout", 30*time.Second, "how long shutdown waits for received BMP messages to be stored")
+	flag.IntVar(&healthPort, "health-port", 8080, "port serving liveness on /healthz and readiness on /readyz, 0 disables health endpoints")
+	flag.StringVar(&peerCascade, "peer-down-cascade", "false", "when true, unicast prefixes learned from a BMP peer are removed when the peer goes down")
+	flag.StringVar(&peerResync, "peer-resync", "false", "when true, unicast prefixes a BMP peer did not re-advertise after it came up again are removed")
 }
 
 var (
@@ -108,22 +128,34 @@ func setupSignalHandler() (stopCh <-chan struct{}) {
 }
 
 func main() {
-	flag.Parse()
+	// Settings are read from the configuration file, environment variables and flags
+	err := config.Parse()
 	_ = flag.Set("logtostderr", "true")
+	if err != nil {
+		glog.Errorf("failed to load the configuration with error: %+v", err)
+		os.Exit(1)
+	}
+	config.PrintIfRequested()
+
+	stopTracing, err := tracing.Init(&traceConfig, "gobmp-arango-aio")
+	if err != nil {
+		glog.Errorf("failed to initialize tracing with error: %+v", err)
+		os.Exit(1)
+	}
 
 	// Starting performance collecting http server
 	go func() {
 		glog.Infof("Starting performance debugging server on %d", perfPort)
 		glog.Info(http.ListenAndServe(fmt.Sprintf(":%d", perfPort), nil))
 	}()
-	var dbSrv dbclient.Srv
-	// validateDBCreds check if the user name and the password are provided either as
-	// command line parameters or via files. If both are provided command line parameters
-	// will be used, if neither, topology will fail.
-	if err := validateDBCreds(); err != nil {
-		glog.Errorf("failed to validate the database credentials with error: %+v", err)
-		os.Exit(1)
+	// Readiness reports the progress of the startup until the BMP server accepts sessions
+	starting := health.NewState("processor is starting")
+	healthSrv := health.NewServer(healthPort)
+	healthSrv.Register("startup", starting)
+	if healthPort != 0 {
+		healthSrv.Start()
 	}
+	var dbSrv dbclient.Srv
 
 	// Initializing database client
 	isMockDB, err := strconv.ParseBool(mockDB)
@@ -133,24 +165,84 @@ func main() {
 	}
 
 	var notifier kafkanotifier.Event
+	if notifyTo != "" {
+		notifier, err = kafkanotifier.NewNotifier(kafkanotifier.Config{
+			Kind:         notifyTo,
+			Target:       notifyDst,
+			MaxRetries:   5,
+			RetryBackoff: time.Second,
+			Kafka:        &kafkaConf,
+		})
+		if err != nil {
+			glog.Errorf("failed to initialize events notifier with error: %+v", err)
+			os.Exit(1)
+		}
+		if b, ok := notifier.(*kafkanotifier.Broadcaster); ok {
+			// Without an in-process consumer, events are logged
+			events, _ := b.Subscribe(1024)
+			go func() {
+				for e := range events {
+					glog.V(5).Infof("event: %s key: %s sequence: %d", e.Action, e.ID, e.Sequence)
+				}
+			}()
+		}
+	}
 	if !isMockDB {
-		// validateDBCreds check if the user name and the password are provided either as
-		// command line parameters or via files. If both are provided command line parameters
-		// will be used, if neither, topology will fail.
-		if err := validateDBCreds(); err != nil {
-			glog.Errorf("failed to validate the database credentials with error: %+v", err)
+		// Credentials of a watched source are picked up without a restart when they rotate
+		creds, err := credConfig.Provider(dbUser, dbPass)
+		if err != nil {
+			glog.Errorf("failed to initialize the database credentials with error: %+v", err)
+			os.Exit(1)
+		}
+		arangoConfig.Credentials = creds
+		isPeerCascade, err := strconv.ParseBool(peerCascade)
+		if err != nil {
+			glog.Errorf("invalid value of \"--peer-down-cascade\" parameter: %s", peerCascade)
+			os.Exit(1)
+		}
+		var cascade []dbclient.CollectionType
+		if isPeerCascade {
+			cascade = arangodb.DefaultPeerDownCascade
+		}
+		isPeerResync, err := strconv.ParseBool(peerResync)
+		if err != nil {
+			glog.Errorf("invalid value of \"--peer-resync\" parameter: %s", peerResync)
 			os.Exit(1)
 		}
+		var resync []dbclient.CollectionType
+		if isPeerResync {
+			resync = arangodb.DefaultResync
+		}
 		glog.Infof("dbSrvAddr is %+v", dbSrvAddr)
-		dbSrv, err = arangodb.NewDBSrvClient(dbSrvAddr, dbUser, dbPass, dbName, notifier)
+		dbSrv, err = arangodb.NewDBSrvClient(arangodb.Config{
+			URL:             dbSrvAddr,
+			User:            dbUser,
+			Password:        dbPass,
+			Database:        dbName,
+			Connection:      &arangoConfig,
+			Notifier:        notifier,
+			PeerDownCascade: cascade,
+			Resync:          resync,
+			SpoolFile:       spoolFile,
+		})
 		if err != nil {
 			glog.Errorf("failed to initialize database client with error: %+v", err)
 			os.Exit(1)
 		}
 	} else {
-		dbSrv, _ = mockdb.NewDBSrvClient()
+		dbSrv, err = mockdb.NewDBSrvClient(mockdb.Config{CaptureFile: mockFile})
+		if err != nil {
+			glog.Errorf("failed to initialize mock database client with error: %+v", err)
+			os.Exit(1)
+		}
 	}
 
+	if c, ok := dbSrv.(health.Checker); ok {
+		healthSrv.Register("arangodb", c)
+	}
+	if l, ok := dbSrv.(health.Liveness); ok {
+		healthSrv.RegisterLiveness("arangodb", health.CheckFunc(l.Live))
+	}
 	if err := dbSrv.Start(); err != nil {
 		if err != nil {
 			glog.Errorf("failed to connect to database with error: %+v", err)
@@ -178,56 +270,25 @@ func main() {
 	}
 	// Starting Interceptor server
 	bmpSrv.Start()
+	starting.Ready()
 
 	stopCh := setupSignalHandler()
 	<-stopCh
 
 	bmpSrv.Stop()
-	dbSrv.Stop()
-
-	os.Exit(0)
-}
-
-func validateDBCreds() error {
-	// Attempting to access username and password files.
-	u, err := readAndDecode(userFile, MAXUSERNAME)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
+	if d, ok := dbSrv.GetInterface().(dbclient.Drainer); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), drainWait)
+		if err := d.Drain(ctx); err != nil {
+			glog.Warningf("shutdown did not drain received messages with error: %+v", err)
 		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", userFile, err)
+		cancel()
 	}
-	p, err := readAndDecode(passFile, MAXPASS)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
-		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", passFile, err)
-	}
-	dbUser, dbPass = u, p
-
-	return nil
-}
+	dbSrv.Stop()
 
-func readAndDecode(fn string, max int) (string, error) {
-	f, err := os.Open(fn)
-	if err != nil {
-		return "", err
+	if healthPort != 0 {
+		healthSrv.Stop()
 	}
-	defer f.Close()
-	l, err := f.Stat()
-	if err != nil {
-		return "", err
-	}
-	b := make([]byte, int(l.Size()))
-	n, err := io.ReadFull(f, b)
-	if err != nil {
-		return "", err
-	}
-	if n > max {
-		return "", fmt.Errorf("length of data %d exceeds maximum acceptable length: %d", n, max)
-	}
-	b = b[:n]
 
-	return string(b), nil
+	stopTracing()
+	os.Exit(0)
 }
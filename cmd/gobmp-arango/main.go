@@ -23,47 +23,89 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/arangodb"
+	"github.com/cisco-open/jalapeno/gobmp-arango/config"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/deadletter"
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkamessenger"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
 	"github.com/cisco-open/jalapeno/gobmp-arango/messenger"
 	"github.com/cisco-open/jalapeno/gobmp-arango/mockdb"
+	"github.com/cisco-open/jalapeno/gobmp-arango/mockmessenger"
+	"github.com/cisco-open/jalapeno/gobmp-arango/stats"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tenant"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 
 	"net/http"
 	_ "net/http/pprof"
 )
 
-const (
-	// userFile defines the name of file containing base64 encoded user name
-	userFile = "./credentials/.username"
-	// passFile defines the name of file containing base64 encoded password
-	passFile = "./credentials/.password"
-	// MAXUSERNAME defines maximum length of ArangoDB user name
-	MAXUSERNAME = 256
-	// MAXPASS defines maximum length of ArangoDB password
-	MAXPASS = 256
-)
-
 var (
-	msgSrvAddr  string
-	dbSrvAddr   string
-	mockDB      string
-	mockMsg     string
-	dbName      string
-	dbUser      string
-	dbPass      string
-	notifyEvent string
-	perfPort    = 56768
+	msgSrvAddr   string
+	dbSrvAddr    string
+	mockDB       string
+	mockMsg      string
+	fixturesDir  string
+	mockLoop     string
+	mockDelay    time.Duration
+	captureFile  string
+	captureSize  int64
+	captureNum   int
+	mockView     string
+	viewFile     string
+	dbName       string
+	dbUser       string
+	dbPass       string
+	arangoConfig arangoclient.Config
+	credConfig   credentials.Config
+	notifyEvent  string
+	eventDocs    string
+	notifyKind   string
+	notifyDest   string
+	notifyRetry  int
+	notifyDelay  time.Duration
+	deadLetter   string
+	dlqTopic     string
+	maxRetries   int
+	retryDelay   time.Duration
+	batchSize    int
+	batchDelay   time.Duration
+	schemaFile   string
+	peerCascade  string
+	peerResync   string
+	resyncQuiet  time.Duration
+	historyList  string
+	historyTTL   time.Duration
+	migrateKeys  string
+	tenantFile   string
+	queueSize    int
+	queueHigh    int
+	queueLow     int
+	priority     string
+	metricsPort  int
+	healthPort   int
+	traceConfig  tracing.Config
+	kafkaConfig  kafkaclient.Config
+	spoolFile    string
+	drainWait    time.Duration
+	perfPort     = 56768
 )
 
 func init() {
@@ -71,12 +113,51 @@ func init() {
 	flag.StringVar(&msgSrvAddr, "message-server", "", "URL to the messages supplying server")
 	flag.StringVar(&dbSrvAddr, "database-server", "", "{dns name}:port or X.X.X.X:port of the graph database")
 	flag.StringVar(&mockDB, "mock-database", "false", "when set to true, received messages are stored in the file")
-	flag.StringVar(&mockMsg, "mock-messenger", "false", "when set to true, message server is disabled.")
+	flag.StringVar(&captureFile, "mock-database-file", "gobmp-capture.jsonl", "JSON lines file where the mock database records received messages, empty disables recording")
+	flag.Int64Var(&captureSize, "mock-database-max-size", 100, "size in megabytes at which the mock database file is rotated, 0 disables rotation")
+	flag.IntVar(&captureNum, "mock-database-max-files", 5, "number of rotated mock database files to keep")
+	flag.StringVar(&mockView, "mock-database-view", "false", "when set to true, the mock database keeps in memory the documents the collections would contain")
+	flag.StringVar(&viewFile, "mock-database-view-file", "", "file where the mock database writes its view on exit")
+	flag.StringVar(&mockMsg, "mock-messenger", "false", "when set to true, message server is disabled and messages are played from fixture files.")
+	flag.StringVar(&fixturesDir, "mock-messenger-dir", "./fixtures", "directory with fixture files named after topics, for example gobmp.parsed.ls_node.json, one message per line")
+	flag.StringVar(&mockLoop, "mock-messenger-loop", "false", "when set to true, fixture files are played again once all of them have been played")
+	flag.DurationVar(&mockDelay, "mock-messenger-interval", 0, "delay between messages played from fixture files")
 	flag.StringVar(&dbName, "database-name", "", "DB name")
 	flag.StringVar(&dbUser, "database-user", "", "DB User name")
 
 	flag.StringVar(&dbPass, "database-pass", "", "DB User's password")
+	arangoclient.RegisterFlags(&arangoConfig)
+	credentials.RegisterFlags(&credConfig)
 	flag.StringVar(&notifyEvent, "notify-event", "false", "when true, a completion message is sent to kafka, indicating and end of processing of the topic's message")
+	flag.StringVar(&notifyKind, "notifier", kafkanotifier.KafkaNotifier, "backend receiving change events: kafka, webhook or file")
+	flag.StringVar(&notifyDest, "notifier-target", "", "webhook url or events file name, kafka notifier uses the message server")
+	flag.IntVar(&notifyRetry, "notifier-retries", 5, "number of retries of a failed webhook request")
+	flag.DurationVar(&notifyDelay, "notifier-backoff", time.Second, "initial delay between retries of a failed webhook request, doubles with every attempt")
+	flag.StringVar(&eventDocs, "event-payload", string(kafkanotifier.PayloadNone), "documents carried by change events: none, full (new and previous document) or diff (new document and changed fields)")
+	flag.StringVar(&deadLetter, "dead-letter", "false", "when true, messages which cannot be parsed or stored after all retries are published to the dead letter topic")
+	flag.StringVar(&dlqTopic, "dead-letter-topic", deadletter.DefaultTopic, "name of the dead letter topic")
+	flag.IntVar(&maxRetries, "max-retries", 10, "number of retries of a failed document write before it is dead-lettered, 0 retries forever, writes are retried forever when --dead-letter is false")
+	flag.DurationVar(&retryDelay, "retry-backoff", 500*time.Millisecond, "initial delay between retries of a failed document write, doubles with every attempt")
+	flag.IntVar(&batchSize, "batch-size", 500, "maximum number of documents written to a collection in a single request, 1 disables batching")
+	flag.DurationVar(&batchDelay, "batch-interval", 20*time.Millisecond, "maximum time a document waits for its batch to fill up before it is written")
+	flag.IntVar(&metricsPort, "metrics-port", 9090, "port serving Prometheus metrics on /metrics, 0 disables metrics")
+	flag.IntVar(&healthPort, "health-port", 8080, "port serving liveness on /healthz and readiness on /readyz, 0 disables health endpoints")
+	flag.StringVar(&peerCascade, "peer-down-cascade", "false", "when true, unicast prefixes learned from a BMP peer are removed when the peer goes down")
+	flag.StringVar(&peerResync, "peer-resync", "false", "when true, unicast prefixes a BMP peer did not re-advertise after it came up again are removed")
+	flag.DurationVar(&resyncQuiet, "peer-resync-quiet-period", arangodb.DefaultResyncQuietPeriod, "how long a BMP peer which came up must stay quiet before documents it did not re-advertise are removed, End-of-RIB triggers the removal earlier")
+	flag.StringVar(&historyList, "history-collections", "", "comma separated list of collections whose changes are recorded in <collection>_history collections")
+	flag.DurationVar(&historyTTL, "history-ttl", 30*24*time.Hour, "how long superseded or removed versions of documents are kept in history collections, 0 keeps them forever")
+	flag.StringVar(&migrateKeys, "migrate-keys", "false", "when true, existing documents are re-keyed with the key strategies of the collection schema before messages are processed")
+	flag.StringVar(&tenantFile, "tenant-config", "", "YAML or JSON file mapping messages to tenant databases by router IP, peer ASN, RD or collector ID, \"--database-name\" is used when not set")
+	flag.IntVar(&queueSize, "queue-size", flowcontrol.DefaultQueueSize, "maximum number of consumed messages queued in a lane before they are handed to the database client, and of messages of a collection queued or being written by the database client")
+	flag.IntVar(&queueHigh, "queue-high-watermark", 0, "number of queued messages pausing Kafka partitions feeding the lane, 0 sets 80% of the queue size")
+	flag.IntVar(&queueLow, "queue-low-watermark", 0, "number of queued messages resuming paused Kafka partitions, 0 sets 50% of the queue size")
+	flag.StringVar(&priority, "priority-lanes", "true", "when true, peer and link-state messages are queued separately from other messages and are not delayed by unicast prefix floods")
+	kafkaclient.RegisterFlags(&kafkaConfig)
+	tracing.RegisterFlags(&traceConfig)
+	flag.StringVar(&spoolFile, "spool-file", "gobmp-arango-spool.jsonl", "file storing change events and history records which were not sent on shutdown, they are sent on the next start, empty discards them")
+	flag.DurationVar(&drainWait, "drain-timeout", 30*time.Second, "how long shutdown waits for consumed messages to be stored, messages not stored in time are consumed again after a restart")
+	flag.StringVar(&schemaFile, "collection-schema", "", "YAML or JSON file defining collections and BMP message types feeding them, built-in collections are used when not set")
 }
 
 var (
@@ -101,15 +182,38 @@ func setupSignalHandler() (stopCh <-chan struct{}) {
 }
 
 func main() {
-	flag.Parse()
+	// Settings are read from the configuration file, environment variables and flags
+	err := config.Parse()
 	_ = flag.Set("logtostderr", "true")
+	if err != nil {
+		glog.Errorf("failed to load the configuration with error: %+v", err)
+		os.Exit(1)
+	}
+	config.PrintIfRequested()
+
+	stopTracing, err := tracing.Init(&traceConfig, "gobmp-arango")
+	if err != nil {
+		glog.Errorf("failed to initialize tracing with error: %+v", err)
+		os.Exit(1)
+	}
 
 	// Starting performance collecting http server
 	go func() {
 		glog.Infof("Starting performance debugging server on %d", perfPort)
 		glog.Info(http.ListenAndServe(fmt.Sprintf(":%d", perfPort), nil))
 	}()
-	var err error
+	var statsSrv stats.Srv
+	if metricsPort != 0 {
+		statsSrv = stats.NewStatsWebSrv(metricsPort)
+		statsSrv.Start()
+	}
+	// Readiness reports the progress of the startup until the messenger consumes messages
+	starting := health.NewState("processor is starting")
+	healthSrv := health.NewServer(healthPort)
+	healthSrv.Register("startup", starting)
+	if healthPort != 0 {
+		healthSrv.Start()
+	}
 	isNotify, err := strconv.ParseBool(notifyEvent)
 	if err != nil {
 		glog.Errorf("invalid value of \"--notify-event\" parameter: %s", notifyEvent)
@@ -117,20 +221,49 @@ func main() {
 	}
 	var notifier kafkanotifier.Event
 	if isNotify {
-		notifier, err = kafkanotifier.NewKafkaNotifier(msgSrvAddr)
+		// Subscribers of the channel notifier run in the same process, only gobmp-arango-aio has them
+		if notifyKind == kafkanotifier.ChannelNotifier {
+			glog.Errorf("\"--notifier=%s\" is only supported by gobmp-arango-aio, use kafka, webhook or file", notifyKind)
+			os.Exit(1)
+		}
+		target := notifyDest
+		if notifyKind == kafkanotifier.KafkaNotifier {
+			target = msgSrvAddr
+		}
+		notifier, err = kafkanotifier.NewNotifier(kafkanotifier.Config{
+			Kind:         notifyKind,
+			Target:       target,
+			MaxRetries:   notifyRetry,
+			RetryBackoff: notifyDelay,
+			Kafka:        &kafkaConfig,
+		})
 		if err != nil {
 			glog.Errorf("failed to initialize events notifier with error: %+v", err)
 			os.Exit(1)
 		}
 	}
-	var dbSrv dbclient.Srv
-	// validateDBCreds check if the user name and the password are provided either as
-	// command line parameters or via files. If both are provided command line parameters
-	// will be used, if neither, gobmp-arango will fail.
-	if err := validateDBCreds(); err != nil {
-		glog.Errorf("failed to validate the database credentials with error: %+v", err)
+	isDeadLetter, err := strconv.ParseBool(deadLetter)
+	if err != nil {
+		glog.Errorf("invalid value of \"--dead-letter\" parameter: %s", deadLetter)
 		os.Exit(1)
 	}
+	var dlq deadletter.Publisher
+	if isDeadLetter {
+		dlq, err = deadletter.NewKafkaPublisher(msgSrvAddr, dlqTopic, &kafkaConfig)
+		if err != nil {
+			glog.Errorf("failed to initialize dead letter publisher with error: %+v", err)
+			os.Exit(1)
+		}
+	}
+	// The schema defines keys of the documents in both the database and the mock database view
+	var schema *arangodb.Schema
+	if schemaFile != "" {
+		if schema, err = arangodb.LoadSchema(schemaFile); err != nil {
+			glog.Errorf("failed to load collection schema with error: %+v", err)
+			os.Exit(1)
+		}
+	}
+	var dbSrv dbclient.Srv
 	// Initializing database client
 	isMockDB, err := strconv.ParseBool(mockDB)
 	if err != nil {
@@ -138,22 +271,121 @@ func main() {
 		os.Exit(1)
 	}
 	if !isMockDB {
-		// validateDBCreds check if the user name and the password are provided either as
-		// command line parameters or via files. If both are provided command line parameters
-		// will be used, if neither, gobmp-arango will fail.
-		if err := validateDBCreds(); err != nil {
-			glog.Errorf("failed to validate the database credentials with error: %+v", err)
+		// Credentials of a watched source are picked up without a restart when they rotate
+		creds, err := credConfig.Provider(dbUser, dbPass)
+		if err != nil {
+			glog.Errorf("failed to initialize the database credentials with error: %+v", err)
+			os.Exit(1)
+		}
+		arangoConfig.Credentials = creds
+		isPeerCascade, err := strconv.ParseBool(peerCascade)
+		if err != nil {
+			glog.Errorf("invalid value of \"--peer-down-cascade\" parameter: %s", peerCascade)
+			os.Exit(1)
+		}
+		var cascade []dbclient.CollectionType
+		if isPeerCascade {
+			cascade = arangodb.DefaultPeerDownCascade
+		}
+		isPeerResync, err := strconv.ParseBool(peerResync)
+		if err != nil {
+			glog.Errorf("invalid value of \"--peer-resync\" parameter: %s", peerResync)
 			os.Exit(1)
 		}
-		dbSrv, err = arangodb.NewDBSrvClient(dbSrvAddr, dbUser, dbPass, dbName, notifier)
+		var resync []dbclient.CollectionType
+		if isPeerResync {
+			resync = arangodb.DefaultResync
+		}
+		isMigrateKeys, err := strconv.ParseBool(migrateKeys)
 		if err != nil {
-			glog.Errorf("failed to initialize database client with error: %+v", err)
+			glog.Errorf("invalid value of \"--migrate-keys\" parameter: %s", migrateKeys)
 			os.Exit(1)
 		}
+		var history []string
+		if historyList != "" {
+			history = strings.Split(historyList, ",")
+		}
+		dbConfig := arangodb.Config{
+			URL:               dbSrvAddr,
+			User:              dbUser,
+			Password:          dbPass,
+			Database:          dbName,
+			Connection:        &arangoConfig,
+			Notifier:          notifier,
+			EventPayload:      kafkanotifier.EventPayload(eventDocs),
+			DeadLetter:        dlq,
+			MaxRetries:        maxRetries,
+			RetryBackoff:      retryDelay,
+			BatchSize:         batchSize,
+			QueueSize:         queueSize,
+			BatchInterval:     batchDelay,
+			Schema:            schema,
+			PeerDownCascade:   cascade,
+			Resync:            resync,
+			ResyncQuietPeriod: resyncQuiet,
+			History:           history,
+			HistoryTTL:        historyTTL,
+			MigrateKeys:       isMigrateKeys,
+			SpoolFile:         spoolFile,
+		}
+		if tenantFile == "" {
+			dbSrv, err = arangodb.NewDBSrvClient(dbConfig)
+			if err != nil {
+				glog.Errorf("failed to initialize database client with error: %+v", err)
+				os.Exit(1)
+			}
+		} else {
+			tc, err := tenant.Load(tenantFile)
+			if err != nil {
+				glog.Errorf("failed to load tenant config with error: %+v", err)
+				os.Exit(1)
+			}
+			router, err := tenant.NewRouter(tc)
+			if err != nil {
+				glog.Errorf("failed to initialize tenant router with error: %+v", err)
+				os.Exit(1)
+			}
+			// Every tenant gets its own connection and collections in the tenant database
+			srvs := make(map[string]dbclient.Srv)
+			for _, t := range tc.Tenants {
+				tenantConfig := dbConfig
+				tenantConfig.Database = t.Database
+				if spoolFile != "" {
+					tenantConfig.SpoolFile = filepath.Join(filepath.Dir(spoolFile), t.Name+"-"+filepath.Base(spoolFile))
+				}
+				if srvs[t.Name], err = arangodb.NewDBSrvClient(tenantConfig); err != nil {
+					glog.Errorf("failed to initialize database client of tenant %s with error: %+v", t.Name, err)
+					os.Exit(1)
+				}
+			}
+			dbSrv = tenant.NewDBSrv(router, srvs, dlq)
+		}
 	} else {
-		dbSrv, _ = mockdb.NewDBSrvClient()
+		isMockView, err := strconv.ParseBool(mockView)
+		if err != nil {
+			glog.Errorf("invalid value of \"--mock-database-view\" parameter: %s", mockView)
+			os.Exit(1)
+		}
+		dbSrv, err = mockdb.NewDBSrvClient(mockdb.Config{
+			CaptureFile: captureFile,
+			MaxFileSize: captureSize * 1024 * 1024,
+			MaxFiles:    captureNum,
+			KeepView:    isMockView,
+			ViewFile:    viewFile,
+			Schema:      schema,
+		})
+		if err != nil {
+			glog.Errorf("failed to initialize mock database client with error: %+v", err)
+			os.Exit(1)
+		}
 	}
 
+	if c, ok := dbSrv.(health.Checker); ok {
+		healthSrv.Register("arangodb", c)
+	}
+	if l, ok := dbSrv.(health.Liveness); ok {
+		healthSrv.RegisterLiveness("arangodb", health.CheckFunc(l.Live))
+	}
 	if err := dbSrv.Start(); err != nil {
 		if err != nil {
 			glog.Errorf("failed to connect to database with error: %+v", err)
@@ -161,6 +393,24 @@ func main() {
 		}
 	}
 
+	isPriority, err := strconv.ParseBool(priority)
+	if err != nil {
+		glog.Errorf("invalid value of \"--priority-lanes\" parameter: %s", priority)
+		os.Exit(1)
+	}
+	fc, err := flowcontrol.New(flowcontrol.Config{
+		QueueSize:     queueSize,
+		HighWatermark: queueHigh,
+		LowWatermark:  queueLow,
+		PriorityLanes: isPriority,
+	}, dbSrv.GetInterface())
+	if err != nil {
+		glog.Errorf("failed to initialize flow control with error: %+v", err)
+		os.Exit(1)
+	}
+	fc.Start()
+	healthSrv.Register("flow-control", fc)
+
 	// Initializing messenger process
 	isMockMsg, err := strconv.ParseBool(mockMsg)
 	if err != nil {
@@ -169,66 +419,58 @@ func main() {
 	}
 	var msgSrv messenger.Srv
 	if !isMockMsg {
-		msgSrv, err = kafkamessenger.NewKafkaMessenger(msgSrvAddr, dbSrv.GetInterface())
+		msgSrv, err = kafkamessenger.NewKafkaMessenger(msgSrvAddr, fc, &kafkaConfig)
 		if err != nil {
 			glog.Errorf("failed to initialize message server with error: %+v", err)
 			os.Exit(1)
 		}
 	} else {
-		// msgSrv, _ = mockmessenger.NewMockMessenger(dbSrv.GetInterface())
+		isMockLoop, err := strconv.ParseBool(mockLoop)
+		if err != nil {
+			glog.Errorf("invalid mock-messenger-loop parameter: %s", mockLoop)
+			os.Exit(1)
+		}
+		msgSrv, err = mockmessenger.NewMockMessenger(mockmessenger.Config{
+			Dir:      fixturesDir,
+			Loop:     isMockLoop,
+			Interval: mockDelay,
+		}, fc)
+		if err != nil {
+			glog.Errorf("failed to initialize mock messenger with error: %+v", err)
+			os.Exit(1)
+		}
 	}
 
+	// Kafka partitions are paused when a lane of flow control fills up
+	if p, ok := msgSrv.(flowcontrol.Pauser); ok {
+		fc.SetPauser(p)
+	}
+	if c, ok := msgSrv.(health.Checker); ok {
+		healthSrv.Register("kafka", c)
+	}
 	msgSrv.Start()
+	starting.Ready()
 
 	stopCh := setupSignalHandler()
 	<-stopCh
 
+	// Fetching stops and messages already consumed are stored, offsets of messages which are not
+	// stored before the timeout are not committed.
+	ctx, cancel := context.WithTimeout(context.Background(), drainWait)
+	if err := fc.Drain(ctx); err != nil {
+		glog.Warningf("shutdown did not drain consumed messages with error: %+v", err)
+	}
+	cancel()
 	msgSrv.Stop()
+	fc.Stop()
 	dbSrv.Stop()
-
-	os.Exit(0)
-}
-
-func validateDBCreds() error {
-	// Attempting to access username and password files.
-	u, err := readAndDecode(userFile, MAXUSERNAME)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
-		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", userFile, err)
+	if statsSrv != nil {
+		statsSrv.Stop()
 	}
-	p, err := readAndDecode(passFile, MAXPASS)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
-		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", passFile, err)
+	if healthPort != 0 {
+		healthSrv.Stop()
 	}
-	dbUser, dbPass = u, p
-
-	return nil
-}
 
-func readAndDecode(fn string, max int) (string, error) {
-	f, err := os.Open(fn)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	l, err := f.Stat()
-	if err != nil {
-		return "", err
-	}
-	b := make([]byte, int(l.Size()))
-	n, err := io.ReadFull(f, b)
-	if err != nil {
-		return "", err
-	}
-	if n > max {
-		return "", fmt.Errorf("length of data %d exceeds maximum acceptable length: %d", n, max)
-	}
-	b = b[:n]
-
-	return string(b), nil
+	stopTracing()
+	os.Exit(0)
 }
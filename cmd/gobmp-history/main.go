@@ -0,0 +1,136 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// gobmp-history reconstructs a collection or the collections of a graph as they were at a given time
+// from the <collection>_history collections recorded by gobmp-arango, the snapshot is written as JSON.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangodb"
+	"github.com/cisco-open/jalapeno/gobmp-arango/config"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+	"github.com/golang/glog"
+)
+
+var (
+	dbSrvAddr    string
+	dbName       string
+	dbUser       string
+	dbPass       string
+	arangoConfig arangoclient.Config
+	credConfig   credentials.Config
+	collection   string
+	graph        string
+	at           string
+	output       string
+)
+
+func init() {
+	flag.StringVar(&dbSrvAddr, "database-server", "", "{dns name}:port or X.X.X.X:port of the graph database")
+	flag.StringVar(&dbName, "database-name", "", "DB name")
+	flag.StringVar(&dbUser, "database-user", "", "DB User name")
+	flag.StringVar(&dbPass, "database-pass", "", "DB User's password")
+	arangoclient.RegisterFlags(&arangoConfig)
+	credentials.RegisterFlags(&credConfig)
+	flag.StringVar(&collection, "collection", "", "collection to reconstruct, its changes must be recorded in <collection>_history")
+	flag.StringVar(&graph, "graph", "", "graph whose vertex and edge collections are reconstructed, collections without history, such as those written by igp-graph and ip-graph, are skipped")
+	flag.StringVar(&at, "at", "", "RFC 3339 time of the snapshot, for example 2026-02-13T02:13:00Z, current time when not set")
+	flag.StringVar(&output, "output", "", "file where the snapshot is written, standard output when not set")
+}
+
+func main() {
+	// Settings are read from the configuration file, environment variables and flags
+	err := config.Parse("database-server", "database-name")
+	_ = flag.Set("logtostderr", "true")
+	if err != nil {
+		glog.Errorf("failed to load the configuration with error: %+v", err)
+		os.Exit(1)
+	}
+	config.PrintIfRequested()
+
+	if (collection == "") == (graph == "") {
+		glog.Errorf("either \"--collection\" or \"--graph\" parameter is required")
+		os.Exit(1)
+	}
+	ts := time.Now()
+	if at != "" {
+		var err error
+		if ts, err = time.Parse(time.RFC3339, at); err != nil {
+			glog.Errorf("invalid value of \"--at\" parameter: %s", at)
+			os.Exit(1)
+		}
+	}
+	// Credentials of a watched source are picked up without a restart when they rotate
+	creds, err := credConfig.Provider(dbUser, dbPass)
+	if err != nil {
+		glog.Errorf("failed to initialize the database credentials with error: %+v", err)
+		os.Exit(1)
+	}
+	arangoConfig.Credentials = creds
+	conn, err := arangodb.NewArango(arangodb.ArangoConfig{
+		URL:        dbSrvAddr,
+		User:       dbUser,
+		Password:   dbPass,
+		Database:   dbName,
+		Connection: &arangoConfig,
+	})
+	if err != nil {
+		glog.Errorf("failed to connect to the database with error: %+v", err)
+		os.Exit(1)
+	}
+	ctx := context.Background()
+	var snapshot interface{}
+	if collection != "" {
+		snapshot, err = conn.Snapshot(ctx, collection, ts)
+	} else {
+		snapshot, err = conn.GraphSnapshot(ctx, graph, ts)
+	}
+	if err != nil {
+		glog.Errorf("failed to reconstruct the snapshot at %s with error: %+v", ts.UTC().Format(arangodb.HistoryTimeFormat), err)
+		os.Exit(1)
+	}
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			glog.Errorf("failed to create output file %s with error: %+v", output, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snapshot); err != nil {
+		glog.Errorf("failed to write the snapshot with error: %+v", err)
+		os.Exit(1)
+	}
+}
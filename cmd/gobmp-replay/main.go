@@ -0,0 +1,255 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// gobmp-replay feeds messages captured from gobmp.parsed.* topics into the ArangoDB database client
+// or into the mock database, so gobmp-arango and the graph processors can be exercised without Kafka.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangodb"
+	"github.com/cisco-open/jalapeno/gobmp-arango/capture"
+	"github.com/cisco-open/jalapeno/gobmp-arango/config"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/mockdb"
+	"github.com/golang/glog"
+)
+
+var (
+	input        string
+	speed        float64
+	dbSrvAddr    string
+	dbName       string
+	dbUser       string
+	dbPass       string
+	arangoConfig arangoclient.Config
+	credConfig   credentials.Config
+	schemaFile   string
+	batchSize    int
+	mockDB       string
+	viewFile     string
+)
+
+func init() {
+	flag.StringVar(&input, "input", "", "comma separated list of capture files or directories with capture files")
+	flag.Float64Var(&speed, "speed", 0, "pacing of the replay relative to the capture timestamps, 1 replays in real time, 10 ten times faster, 0 as fast as possible")
+	flag.StringVar(&dbSrvAddr, "database-server", "", "{dns name}:port or X.X.X.X:port of the graph database")
+	flag.StringVar(&dbName, "database-name", "", "DB name")
+	flag.StringVar(&dbUser, "database-user", "", "DB User name")
+	flag.StringVar(&dbPass, "database-pass", "", "DB User's password")
+	arangoclient.RegisterFlags(&arangoConfig)
+	credentials.RegisterFlags(&credConfig)
+	flag.StringVar(&schemaFile, "collection-schema", "", "YAML or JSON file defining collections and BMP message types feeding them, built-in collections are used when not set")
+	flag.IntVar(&batchSize, "batch-size", 500, "maximum number of documents written to a collection in a single request, 1 disables batching")
+	flag.StringVar(&mockDB, "mock-database", "false", "when set to true, messages are replayed into the mock database instead of ArangoDB")
+	flag.StringVar(&viewFile, "mock-database-view-file", "", "file where the mock database writes the documents the collections would contain")
+}
+
+// pacer delays records, so the time between records matches their capture timestamps divided by speed
+type pacer struct {
+	speed float64
+	first time.Time
+	start time.Time
+}
+
+// wait blocks until the record captured at ts is due, it returns false if the replay has been stopped.
+// Records without a timestamp are not delayed.
+func (p *pacer) wait(ts time.Time, stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	if p.speed <= 0 || ts.IsZero() {
+		return true
+	}
+	if p.first.IsZero() {
+		p.first, p.start = ts, time.Now()
+		return true
+	}
+	due := p.start.Add(time.Duration(float64(ts.Sub(p.first)) / p.speed))
+	d := time.Until(due)
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func main() {
+	// Settings are read from the configuration file, environment variables and flags
+	err := config.Parse("input")
+	_ = flag.Set("logtostderr", "true")
+	if err != nil {
+		glog.Errorf("failed to load the configuration with error: %+v", err)
+		os.Exit(1)
+	}
+	config.PrintIfRequested()
+
+	if input == "" {
+		glog.Errorf("\"--input\" parameter is required")
+		os.Exit(1)
+	}
+	var files []string
+	for _, in := range strings.Split(input, ",") {
+		f, err := capture.Files(in)
+		if err != nil {
+			glog.Errorf("failed to access input %s with error: %+v", in, err)
+			os.Exit(1)
+		}
+		files = append(files, f...)
+	}
+	isMockDB, err := strconv.ParseBool(mockDB)
+	if err != nil {
+		glog.Errorf("invalid value of \"--mock-database\" parameter: %s", mockDB)
+		os.Exit(1)
+	}
+	var schema *arangodb.Schema
+	if schemaFile != "" {
+		if schema, err = arangodb.LoadSchema(schemaFile); err != nil {
+			glog.Errorf("failed to load collection schema with error: %+v", err)
+			os.Exit(1)
+		}
+	}
+	var dbSrv dbclient.Srv
+	if isMockDB {
+		dbSrv, err = mockdb.NewDBSrvClient(mockdb.Config{
+			KeepView: true,
+			ViewFile: viewFile,
+			Schema:   schema,
+		})
+	} else {
+		// Credentials of a watched source are picked up without a restart when they rotate
+		var creds credentials.Provider
+		creds, err = credConfig.Provider(dbUser, dbPass)
+		if err != nil {
+			glog.Errorf("failed to initialize the database credentials with error: %+v", err)
+			os.Exit(1)
+		}
+		arangoConfig.Credentials = creds
+		dbSrv, err = arangodb.NewDBSrvClient(arangodb.Config{
+			URL:        dbSrvAddr,
+			User:       dbUser,
+			Password:   dbPass,
+			Database:   dbName,
+			Connection: &arangoConfig,
+			BatchSize:  batchSize,
+			Schema:     schema,
+		})
+	}
+	if err != nil {
+		glog.Errorf("failed to initialize database client with error: %+v", err)
+		os.Exit(1)
+	}
+	if err := dbSrv.Start(); err != nil {
+		glog.Errorf("failed to connect to database with error: %+v", err)
+		os.Exit(1)
+	}
+
+	stop := make(chan struct{})
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		close(stop)
+		<-c
+		os.Exit(1) // second signal. Exit directly.
+	}()
+
+	replayed, err := replay(files, dbSrv.GetInterface(), stop)
+	if err != nil {
+		glog.Errorf("replay failed after %d messages with error: %+v", replayed, err)
+	} else {
+		glog.Infof("Replayed %d messages from %d files", replayed, len(files))
+	}
+	dbSrv.Stop()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// replay feeds records of the files to the database client, when the client supports acknowledgements,
+// replay returns only after all records fed to the client have been persisted, also when the replay is
+// interrupted, a second interrupt exits without waiting.
+func replay(files []string, db dbclient.DB, stop <-chan struct{}) (int, error) {
+	ackDB, isAckDB := db.(dbclient.AckDB)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	p := &pacer{speed: speed}
+	replayed := 0
+	for _, fn := range files {
+		glog.Infof("Replaying %s", fn)
+		f, err := os.Open(fn)
+		if err != nil {
+			return replayed, err
+		}
+		r := capture.NewReader(f)
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return replayed, err
+			}
+			if !p.wait(rec.Timestamp, stop) {
+				f.Close()
+				return replayed, nil
+			}
+			if isAckDB {
+				wg.Add(1)
+				err = ackDB.StoreMessageWithAck(rec.CollectionType, rec.Message, wg.Done)
+			} else {
+				err = db.StoreMessage(rec.CollectionType, rec.Message)
+			}
+			if err != nil {
+				glog.Errorf("failed to store message from topic %s with error: %+v", rec.Topic, err)
+				if isAckDB {
+					// The message was rejected and will never be acknowledged
+					wg.Done()
+				}
+			}
+			replayed++
+		}
+		f.Close()
+	}
+
+	return replayed, nil
+}
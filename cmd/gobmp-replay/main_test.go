@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacerWait(t *testing.T) {
+	stopped := make(chan struct{})
+	close(stopped)
+	now := time.Now()
+	tests := []struct {
+		name   string
+		speed  float64
+		ts     []time.Time
+		stop   chan struct{}
+		expect bool
+	}{
+		{name: "unpaced", ts: []time.Time{now, now.Add(time.Hour)}, stop: make(chan struct{}), expect: true},
+		{name: "unpaced stopped", ts: []time.Time{now}, stop: stopped},
+		{name: "paced without timestamp", speed: 1, ts: []time.Time{{}}, stop: make(chan struct{}), expect: true},
+		{name: "paced record due", speed: 1000, ts: []time.Time{now, now.Add(time.Millisecond)}, stop: make(chan struct{}), expect: true},
+		{name: "paced stopped", speed: 1, ts: []time.Time{now, now.Add(time.Hour)}, stop: stopped},
+	}
+	for _, tt := range tests {
+		p := &pacer{speed: tt.speed}
+		got := true
+		for _, ts := range tt.ts {
+			got = p.wait(ts, tt.stop)
+		}
+		if got != tt.expect {
+			t.Errorf("%s: expected %t, actual %t", tt.name, tt.expect, got)
+		}
+	}
+}
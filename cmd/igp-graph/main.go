@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
-	"io"
 	"os"
 	"os/signal"
 	"runtime"
-
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/config"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/cisco-open/jalapeno/igp-graph/arangodb"
 	"github.com/cisco-open/jalapeno/igp-graph/kafkamessenger"
 	"github.com/golang/glog"
@@ -16,23 +23,14 @@ import (
 	_ "net/http/pprof"
 )
 
-const (
-	// userFile defines the name of file containing base64 encoded user name
-	userFile = "./credentials/.username"
-	// passFile defines the name of file containing base64 encoded password
-	passFile = "./credentials/.password"
-	// MAXUSERNAME defines maximum length of ArangoDB user name
-	MAXUSERNAME = 256
-	// MAXPASS defines maximum length of ArangoDB password
-	MAXPASS = 256
-)
-
 var (
 	msgSrvAddr        string
 	dbSrvAddr         string
 	dbName            string
 	dbUser            string
 	dbPass            string
+	arangoConfig      arangoclient.Config
+	credConfig        credentials.Config
 	lsprefix          string
 	lslink            string
 	lssrv6sid         string
@@ -44,6 +42,14 @@ var (
 	lsNodeEdge        string
 	batchSize         int
 	concurrentWorkers int
+	queueSize         int
+	queueHigh         int
+	queueLow          int
+	priorityLanes     bool
+	kafkaConfig       kafkaclient.Config
+	drainTimeout      time.Duration
+	healthPort        int
+	traceConfig       tracing.Config
 )
 
 func init() {
@@ -53,20 +59,33 @@ func init() {
 	flag.StringVar(&dbName, "database-name", "", "DB name")
 	flag.StringVar(&dbUser, "database-user", "", "DB User name")
 	flag.StringVar(&dbPass, "database-pass", "", "DB User's password")
-
-	flag.StringVar(&lsprefix, "ls_prefix", "ls_prefix", "ls_prefix Collection name, default: \"ls_prefix\"")
-	flag.StringVar(&lslink, "ls_link", "ls_link", "ls_link Collection name, default \"ls_link\"")
-	flag.StringVar(&lssrv6sid, "ls_srv6_sid", "ls_srv6_sid", "ls_srv6_sid Collection name, default: \"ls_srv6_sid\"")
-	flag.StringVar(&lsnode, "ls_node", "ls_node", "ls_node Collection name, default \"ls_node\"")
-	flag.StringVar(&igpDomain, "igp_domain", "igp_domain", "igp_domain Collection name, default \"igp_domain\"")
-	flag.StringVar(&igpNode, "igp_node", "igp_node", "igp_node Collection name, default \"igp_node\"")
-	flag.StringVar(&igpv4Graph, "igpv4_graph", "igpv4_graph", "igpv4_graph Collection name, default \"igpv4_graph\"")
-	flag.StringVar(&igpv6Graph, "igpv6_graph", "igpv6_graph", "igpv6_graph Collection name, default \"igpv6_graph\"")
-	flag.StringVar(&lsNodeEdge, "ls_node_edge", "ls_node_edge", "ls_node_edge Collection name, default \"ls_node_edge\"")
+	arangoclient.RegisterFlags(&arangoConfig)
+	credentials.RegisterFlags(&credConfig)
+
+	flag.StringVar(&lsprefix, "ls-prefix", "ls_prefix", "ls_prefix collection name")
+	flag.StringVar(&lslink, "ls-link", "ls_link", "ls_link collection name")
+	flag.StringVar(&lssrv6sid, "ls-srv6-sid", "ls_srv6_sid", "ls_srv6_sid collection name")
+	flag.StringVar(&lsnode, "ls-node", "ls_node", "ls_node collection name")
+	flag.StringVar(&igpDomain, "igp-domain", "igp_domain", "igp_domain collection name")
+	flag.StringVar(&igpNode, "igp-node", "igp_node", "igp_node collection name")
+	flag.StringVar(&igpv4Graph, "igpv4-graph", "igpv4_graph", "igpv4_graph collection name")
+	flag.StringVar(&igpv6Graph, "igpv6-graph", "igpv6_graph", "igpv6_graph collection name")
+	flag.StringVar(&lsNodeEdge, "ls-node-edge", "ls_node_edge", "ls_node_edge collection name")
 
 	// Performance tuning flags
-	flag.IntVar(&batchSize, "batch_size", 1000, "Batch size for bulk operations, default: 1000")
-	flag.IntVar(&concurrentWorkers, "concurrent_workers", 0, "Number of concurrent workers, default: 2x CPU cores")
+	flag.IntVar(&batchSize, "batch-size", 1000, "Batch size for bulk operations")
+	flag.IntVar(&concurrentWorkers, "concurrent-workers", 0, "Number of concurrent workers, 0 sets 2x CPU cores")
+
+	kafkaclient.RegisterFlags(&kafkaConfig)
+	tracing.RegisterFlags(&traceConfig)
+
+	// Flow control flags
+	flag.IntVar(&queueSize, "queue-size", flowcontrol.DefaultQueueSize, "Maximum number of consumed messages queued in a lane before they are handed to the graph processor")
+	flag.IntVar(&queueHigh, "queue-high-watermark", 0, "Number of queued messages pausing Kafka partitions feeding the lane, 0 sets 80% of the queue size")
+	flag.IntVar(&queueLow, "queue-low-watermark", 0, "Number of queued messages resuming paused Kafka partitions, 0 sets 50% of the queue size")
+	flag.BoolVar(&priorityLanes, "priority-lanes", true, "Queue peer and link-state messages separately from other messages")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "How long shutdown waits for consumed messages to be processed, messages not processed in time are consumed again after a restart")
+	flag.IntVar(&healthPort, "health-port", 8080, "Port serving liveness on /healthz and readiness on /readyz, 0 disables health endpoints")
 }
 
 var (
@@ -91,26 +110,46 @@ func setupSignalHandler() (stopCh <-chan struct{}) {
 }
 
 func main() {
-	flag.Parse()
+	// Settings are read from the configuration file, environment variables and flags
+	err := config.Parse("message-server", "database-server", "database-name")
 	_ = flag.Set("logtostderr", "true")
+	if err != nil {
+		glog.Errorf("failed to load the configuration with error: %+v", err)
+		os.Exit(1)
+	}
+	config.PrintIfRequested()
+
+	stopTracing, err := tracing.Init(&traceConfig, "igp-graph")
+	if err != nil {
+		glog.Errorf("failed to initialize tracing with error: %+v", err)
+		os.Exit(1)
+	}
+
+	// Readiness reports the progress of the startup until the messenger consumes messages
+	starting := health.NewState("processor is starting")
+	healthSrv := health.NewServer(healthPort)
+	healthSrv.Register("startup", starting)
+	if healthPort != 0 {
+		healthSrv.Start()
+	}
 
 	// Set default concurrent workers if not specified
 	if concurrentWorkers == 0 {
 		concurrentWorkers = runtime.NumCPU() * 2
 	}
 
-	glog.Infof("IGP Graph processor starting with batch_size=%d, workers=%d", batchSize, concurrentWorkers)
+	glog.Infof("IGP Graph processor starting with batch-size=%d, workers=%d", batchSize, concurrentWorkers)
 
-	// validateDBCreds check if the user name and the password are provided either as
-	// command line parameters or via files. If both are provided command line parameters
-	// will be used, if neither, processor will fail.
-	if err := validateDBCreds(); err != nil {
-		glog.Errorf("failed to validate the database credentials with error: %+v", err)
+	// Credentials of a watched source are picked up without a restart when they rotate
+	creds, err := credConfig.Provider(dbUser, dbPass)
+	if err != nil {
+		glog.Errorf("failed to initialize the database credentials with error: %+v", err)
 		os.Exit(1)
 	}
+	arangoConfig.Credentials = creds
 
 	// initialize kafkanotifier to write back processed events into igp graph topics
-	notifier, err := kafkanotifier.NewKafkaNotifier(msgSrvAddr)
+	notifier, err := kafkanotifier.NewKafkaNotifier(msgSrvAddr, &kafkaConfig)
 	if err != nil {
 		glog.Errorf("failed to initialize events notifier with error: %+v", err)
 		os.Exit(1)
@@ -122,6 +161,7 @@ func main() {
 		User:              dbUser,
 		Password:          dbPass,
 		Database:          dbName,
+		Connection:        &arangoConfig,
 		LSPrefix:          lsprefix,
 		LSLink:            lslink,
 		LSSRv6SID:         lssrv6sid,
@@ -140,70 +180,62 @@ func main() {
 		os.Exit(1)
 	}
 
+	if c, ok := dbSrv.(health.Checker); ok {
+		healthSrv.Register("arangodb", c)
+	}
+	if l, ok := dbSrv.(health.Liveness); ok {
+		healthSrv.RegisterLiveness("arangodb", health.CheckFunc(l.Live))
+	}
 	if err := dbSrv.Start(); err != nil {
 		glog.Errorf("failed to start database client with error: %+v", err)
 		os.Exit(1)
 	}
 
+	// Initializing flow control between Kafka consumption and the graph processor
+	fc, err := flowcontrol.New(flowcontrol.Config{
+		QueueSize:     queueSize,
+		HighWatermark: queueHigh,
+		LowWatermark:  queueLow,
+		PriorityLanes: priorityLanes,
+	}, dbSrv.GetInterface())
+	if err != nil {
+		glog.Errorf("failed to initialize flow control with error: %+v", err)
+		os.Exit(1)
+	}
+	fc.Start()
+	healthSrv.Register("flow-control", fc)
+
 	// Initializing messenger process
-	msgSrv, err := kafkamessenger.NewKafkaMessenger(msgSrvAddr, dbSrv.GetInterface())
+	msgSrv, err := kafkamessenger.NewKafkaMessenger(msgSrvAddr, fc, &kafkaConfig)
 	if err != nil {
 		glog.Errorf("failed to initialize message server with error: %+v", err)
 		os.Exit(1)
 	}
+	fc.SetPauser(msgSrv)
 
+	healthSrv.Register("kafka", msgSrv)
 	msgSrv.Start()
+	starting.Ready()
 
 	stopCh := setupSignalHandler()
 	<-stopCh
 
 	glog.Info("Shutting down IGP Graph processor...")
+	// Fetching stops and consumed messages are processed, offsets of messages which are not
+	// processed before the timeout are not committed.
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	if err := fc.Drain(ctx); err != nil {
+		glog.Warningf("Shutdown did not drain consumed messages: %v", err)
+	}
+	cancel()
 	msgSrv.Stop()
+	fc.Stop()
 	dbSrv.Stop()
 
-	os.Exit(0)
-}
-
-func validateDBCreds() error {
-	// Attempting to access username and password files.
-	u, err := readAndDecode(userFile, MAXUSERNAME)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
-		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", userFile, err)
-	}
-	p, err := readAndDecode(passFile, MAXPASS)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
-		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", passFile, err)
+	if healthPort != 0 {
+		healthSrv.Stop()
 	}
-	dbUser, dbPass = u, p
-
-	return nil
-}
 
-func readAndDecode(fn string, max int) (string, error) {
-	f, err := os.Open(fn)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	l, err := f.Stat()
-	if err != nil {
-		return "", err
-	}
-	b := make([]byte, int(l.Size()))
-	n, err := io.ReadFull(f, b)
-	if err != nil {
-		return "", err
-	}
-	if n > max {
-		return "", fmt.Errorf("length of data %d exceeds maximum acceptable length: %d", n, max)
-	}
-	b = b[:n]
-
-	return string(b), nil
+	stopTracing()
+	os.Exit(0)
 }
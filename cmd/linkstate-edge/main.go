@@ -24,12 +24,16 @@ package main
 
 import (
 	"flag"
-	"fmt"
-	"io"
 	"os"
 	"os/signal"
 	"runtime"
 
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/config"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/cisco-open/jalapeno/linkstate-edge/arangodb"
 	"github.com/cisco-open/jalapeno/linkstate-edge/kafkamessenger"
 	"github.com/cisco-open/jalapeno/linkstate-edge/kafkanotifier"
@@ -38,25 +42,19 @@ import (
 	_ "net/http/pprof"
 )
 
-const (
-	// userFile defines the name of file containing base64 encoded user name
-	userFile = "./credentials/.username"
-	// passFile defines the name of file containing base64 encoded password
-	passFile = "./credentials/.password"
-	// MAXUSERNAME defines maximum length of ArangoDB user name
-	MAXUSERNAME = 256
-	// MAXPASS defines maximum length of ArangoDB password
-	MAXPASS = 256
-)
-
 var (
 	msgSrvAddr       string
 	dbSrvAddr        string
 	dbName           string
 	dbUser           string
 	dbPass           string
+	arangoConfig     arangoclient.Config
+	credConfig       credentials.Config
 	vertexCollection string
 	edgeCollection   string
+	kafkaConfig      kafkaclient.Config
+	healthPort       int
+	traceConfig      tracing.Config
 )
 
 func init() {
@@ -66,8 +64,13 @@ func init() {
 	flag.StringVar(&dbName, "database-name", "", "DB name")
 	flag.StringVar(&dbUser, "database-user", "", "DB User name")
 	flag.StringVar(&dbPass, "database-pass", "", "DB User's password")
+	arangoclient.RegisterFlags(&arangoConfig)
+	credentials.RegisterFlags(&credConfig)
 	flag.StringVar(&vertexCollection, "vertex-name", "ls_node", "Vertex Collection name, default: \"ls_node\"")
 	flag.StringVar(&edgeCollection, "edge-name", "ls_link", "Edge Collection name, default \"ls_link\"")
+	flag.IntVar(&healthPort, "health-port", 8080, "port serving liveness on /healthz and readiness on /readyz, 0 disables health endpoints")
+	kafkaclient.RegisterFlags(&kafkaConfig)
+	tracing.RegisterFlags(&traceConfig)
 }
 
 var (
@@ -92,32 +95,55 @@ func setupSignalHandler() (stopCh <-chan struct{}) {
 }
 
 func main() {
-	flag.Parse()
+	// Settings are read from the configuration file, environment variables and flags
+	err := config.Parse("message-server", "database-server", "database-name")
 	_ = flag.Set("logtostderr", "true")
+	if err != nil {
+		glog.Errorf("failed to load the configuration with error: %+v", err)
+		os.Exit(1)
+	}
+	config.PrintIfRequested()
+
+	stopTracing, err := tracing.Init(&traceConfig, "linkstate-edge")
+	if err != nil {
+		glog.Errorf("failed to initialize tracing with error: %+v", err)
+		os.Exit(1)
+	}
+
+	// Readiness reports the progress of the startup until the messenger consumes messages
+	starting := health.NewState("processor is starting")
+	healthSrv := health.NewServer(healthPort)
+	healthSrv.Register("startup", starting)
+	if healthPort != 0 {
+		healthSrv.Start()
+	}
 
 	// TODO (sbezverk) pass vertex collection type and edge collection type are parameters
 
-	// validateDBCreds check if the user name and the password are provided either as
-	// command line parameters or via files. If both are provided command line parameters
-	// will be used, if neither, processor will fail.
-	if err := validateDBCreds(); err != nil {
-		glog.Errorf("failed to validate the database credentials with error: %+v", err)
+	// Credentials of a watched source are picked up without a restart when they rotate
+	creds, err := credConfig.Provider(dbUser, dbPass)
+	if err != nil {
+		glog.Errorf("failed to initialize the database credentials with error: %+v", err)
 		os.Exit(1)
 	}
+	arangoConfig.Credentials = creds
 
 	// initialize kafkanotifier to write back processed events into ls_node_edge_events topic
-	notifier, err := kafkanotifier.NewKafkaNotifier(msgSrvAddr)
+	notifier, err := kafkanotifier.NewKafkaNotifier(msgSrvAddr, &kafkaConfig)
 	if err != nil {
 		glog.Errorf("failed to initialize events notifier with error: %+v", err)
 		os.Exit(1)
 	}
 
-	dbSrv, err := arangodb.NewDBSrvClient(dbSrvAddr, dbUser, dbPass, dbName, vertexCollection, edgeCollection, notifier)
+	dbSrv, err := arangodb.NewDBSrvClient(dbSrvAddr, dbUser, dbPass, dbName, vertexCollection, edgeCollection, notifier, &arangoConfig)
 	if err != nil {
 		glog.Errorf("failed to initialize database client with error: %+v", err)
 		os.Exit(1)
 	}
 
+	if c, ok := dbSrv.(health.Checker); ok {
+		healthSrv.Register("arangodb", c)
+	}
 	if err := dbSrv.Start(); err != nil {
 		if err != nil {
 			glog.Errorf("failed to connect to database with error: %+v", err)
@@ -126,13 +152,17 @@ func main() {
 	}
 
 	// initializing messenger process
-	msgSrv, err := kafkamessenger.NewKafkaMessenger(msgSrvAddr, dbSrv.GetInterface())
+	msgSrv, err := kafkamessenger.NewKafkaMessenger(msgSrvAddr, dbSrv.GetInterface(), &kafkaConfig)
 	if err != nil {
 		glog.Errorf("failed to initialize message server with error: %+v", err)
 		os.Exit(1)
 	}
 
+	if c, ok := msgSrv.(health.Checker); ok {
+		healthSrv.Register("kafka", c)
+	}
 	msgSrv.Start()
+	starting.Ready()
 
 	stopCh := setupSignalHandler()
 	<-stopCh
@@ -140,49 +170,10 @@ func main() {
 	msgSrv.Stop()
 	dbSrv.Stop()
 
-	os.Exit(0)
-}
-
-func validateDBCreds() error {
-	// Attempting to access username and password files.
-	u, err := readAndDecode(userFile, MAXUSERNAME)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
-		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", userFile, err)
-	}
-	p, err := readAndDecode(passFile, MAXPASS)
-	if err != nil {
-		if dbUser != "" && dbPass != "" {
-			return nil
-		}
-		return fmt.Errorf("failed to access %s with error: %+v and no username and password provided via command line arguments", passFile, err)
-	}
-	dbUser, dbPass = u, p
-
-	return nil
-}
-
-func readAndDecode(fn string, max int) (string, error) {
-	f, err := os.Open(fn)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	l, err := f.Stat()
-	if err != nil {
-		return "", err
+	if healthPort != 0 {
+		healthSrv.Stop()
 	}
-	b := make([]byte, int(l.Size()))
-	n, err := io.ReadFull(f, b)
-	if err != nil {
-		return "", err
-	}
-	if n > max {
-		return "", fmt.Errorf("length of data %d exceeds maximum acceptable length: %d", n, max)
-	}
-	b = b[:n]
 
-	return string(b), nil
+	stopTracing()
+	os.Exit(0)
 }
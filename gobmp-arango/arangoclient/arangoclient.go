@@ -0,0 +1,295 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package arangoclient builds ArangoDB clients shared by all database clients, requests are spread
+// round-robin over coordinators with failover, connections use TLS with an optional client certificate,
+// and clients authenticate with basic authentication or JWT.
+package arangoclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/arangodb/go-driver/http"
+	"github.com/arangodb/go-driver/jwt"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+	"github.com/golang/glog"
+	"github.com/sbezverk/gobmp/pkg/tools"
+)
+
+const (
+	// AuthBasic authenticates every request with the user name and password
+	AuthBasic = "basic"
+	// AuthJWT exchanges the user name and password for a JWT token which is renewed when it expires
+	AuthJWT = "jwt"
+	// AuthJWTSecret signs a superuser JWT token with the cluster's JWT secret
+	AuthJWTSecret = "jwt-secret"
+	// jwtServerID identifies jalapeno in superuser JWT tokens
+	jwtServerID = "jalapeno"
+	// DefaultRequestTimeout defines how long a request may take, including failover to other coordinators
+	DefaultRequestTimeout = time.Minute
+)
+
+// Config defines how clients connect to ArangoDB, the zero value connects over HTTP with basic
+// authentication.
+type Config struct {
+	// TLS enables HTTPS for endpoints without a scheme, it is enabled as well when a CA bundle
+	// or a client certificate is set
+	TLS bool
+	// CAFile is a PEM bundle of CAs verifying the coordinators, system CAs are used when not set
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented to the coordinators
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables the verification of the coordinators' certificates
+	InsecureSkipVerify bool
+	// Auth is one of basic, jwt or jwt-secret, basic when not set
+	Auth string
+	// JWTSecretFile is a file storing the JWT secret of the cluster, used by jwt-secret authentication
+	JWTSecretFile string
+	// RequestTimeout defines how long a request without a deadline may take, DefaultRequestTimeout when 0
+	RequestTimeout time.Duration
+	// Credentials supplies the user name and password of basic and jwt authentications, when set it
+	// replaces the static user name and password, clients re-authenticate when they change
+	Credentials credentials.Provider
+}
+
+// RegisterFlags registers command line flags of the configuration, every binary uses the same flags
+func RegisterFlags(c *Config) {
+	flag.BoolVar(&c.TLS, "arango-tls", false, "when true, database servers given without a scheme are connected over HTTPS")
+	flag.StringVar(&c.CAFile, "arango-ca-file", "", "PEM bundle of CAs verifying ArangoDB coordinators, enables TLS, system CAs are used when not set")
+	flag.StringVar(&c.CertFile, "arango-cert-file", "", "PEM client certificate presented to ArangoDB coordinators, enables TLS")
+	flag.StringVar(&c.KeyFile, "arango-key-file", "", "PEM private key of the client certificate")
+	flag.BoolVar(&c.InsecureSkipVerify, "arango-tls-skip-verify", false, "when true, certificates of ArangoDB coordinators are not verified")
+	flag.StringVar(&c.Auth, "arango-auth", AuthBasic, "ArangoDB authentication: basic, jwt (a token is obtained with the user name and password) or jwt-secret (a superuser token is signed with the cluster's secret)")
+	flag.StringVar(&c.JWTSecretFile, "arango-jwt-secret-file", "", "file storing the JWT secret of the ArangoDB cluster, used by jwt-secret authentication")
+	flag.DurationVar(&c.RequestTimeout, "arango-request-timeout", DefaultRequestTimeout, "how long an ArangoDB request may take, including failover to other coordinators")
+}
+
+// Endpoints splits the comma separated list of servers, servers without a scheme get https:// when
+// TLS is enabled and http:// otherwise.
+func (c *Config) Endpoints(servers string) []string {
+	scheme := "http://"
+	if c.tlsEnabled() {
+		scheme = "https://"
+	}
+	endpoints := make([]string, 0)
+	for _, s := range strings.Split(servers, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "://") {
+			s = scheme + s
+		}
+		endpoints = append(endpoints, s)
+	}
+
+	return endpoints
+}
+
+// Validate checks that the comma separated list of servers holds valid endpoints
+func (c *Config) Validate(servers string) error {
+	endpoints := c.Endpoints(servers)
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no ArangoDB endpoints in %q", servers)
+	}
+	for _, ep := range endpoints {
+		if err := tools.URLAddrValidation(ep); err != nil {
+			return fmt.Errorf("invalid ArangoDB endpoint %s: %w", ep, err)
+		}
+	}
+
+	return nil
+}
+
+// NewConnection returns a connection spreading requests over the comma separated list of servers
+func (c *Config) NewConnection(servers string) (driver.Connection, error) {
+	endpoints := c.Endpoints(servers)
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no ArangoDB endpoints in %q", servers)
+	}
+	var t *tls.Config
+	if c.tlsEnabled() {
+		var err error
+		if t, err = c.tlsConfig(); err != nil {
+			return nil, err
+		}
+	}
+	timeout := DefaultRequestTimeout
+	if c != nil && c.RequestTimeout > 0 {
+		timeout = c.RequestTimeout
+	}
+	conns := make([]driver.Connection, 0, len(endpoints))
+	for _, ep := range endpoints {
+		conn, err := http.NewConnection(http.ConnectionConfig{
+			Endpoints: []string{ep},
+			TLSConfig: t,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create connection to %s with error: %w", ep, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	return newBalancer(conns, timeout), nil
+}
+
+// NewClient returns an authenticated client of the comma separated list of servers, user and password
+// are used when the configuration has no credentials provider.
+func (c *Config) NewClient(servers, user, password string) (driver.Client, error) {
+	conn, err := c.NewConnection(servers)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := c.provider(user, password)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newAuthenticator(conn, provider, c.authentication)
+	if err != nil {
+		return nil, err
+	}
+	client, err := driver.NewClient(driver.ClientConfig{
+		Connection: auth,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArangoDB client with error: %w", err)
+	}
+	glog.Infof("ArangoDB client of %v created", conn.Endpoints())
+
+	return client, nil
+}
+
+// Database returns the database, when create is true, a missing database is created
+func Database(ctx context.Context, client driver.Client, name string, create bool) (driver.Database, error) {
+	exists, err := client.DatabaseExists(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return client.Database(ctx, name)
+	}
+	if !create {
+		return nil, fmt.Errorf("database %s does not exist", name)
+	}
+	glog.Infof("Creating database: %s", name)
+
+	return client.CreateDatabase(ctx, name, nil)
+}
+
+// Ping checks that the database is reachable with the client's credentials
+func Ping(ctx context.Context, db driver.Database) error {
+	if _, err := db.Info(ctx); err != nil {
+		return fmt.Errorf("ArangoDB database is not reachable: %w", err)
+	}
+
+	return nil
+}
+
+// UsesCredentials returns true when the client authenticates with the user name and password
+// passed to NewClient, rather than with a credentials provider or the JWT secret
+func (c *Config) UsesCredentials() bool {
+	return c == nil || (c.Auth != AuthJWTSecret && c.Credentials == nil)
+}
+
+func (c *Config) tlsEnabled() bool {
+	return c != nil && (c.TLS || c.CAFile != "" || c.CertFile != "")
+}
+
+func (c *Config) mode() string {
+	if c == nil || c.Auth == "" {
+		return AuthBasic
+	}
+
+	return c.Auth
+}
+
+// provider returns the provider of credentials, the JWT secret is provided as the password
+func (c *Config) provider(user, password string) (credentials.Provider, error) {
+	switch mode := c.mode(); {
+	case mode == AuthJWTSecret:
+		p, err := credentials.NewFile("", c.JWTSecretFile, credentials.DefaultInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ArangoDB JWT secret with error: %w", err)
+		}
+		return p, nil
+	case c != nil && c.Credentials != nil:
+		return c.Credentials, nil
+	default:
+		return credentials.NewStatic(user, password), nil
+	}
+}
+
+func (c *Config) authentication(creds credentials.Credentials) (driver.Authentication, error) {
+	switch mode := c.mode(); mode {
+	case AuthBasic:
+		return driver.BasicAuthentication(creds.User, creds.Password), nil
+	case AuthJWT:
+		return driver.JWTAuthentication(creds.User, creds.Password), nil
+	case AuthJWTSecret:
+		header, err := jwt.CreateArangodJwtAuthorizationHeader(creds.Password, jwtServerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign ArangoDB JWT token with error: %w", err)
+		}
+		return driver.RawAuthentication(header), nil
+	default:
+		return nil, fmt.Errorf("unknown ArangoDB authentication %q, supported authentications are %s, %s and %s", mode, AuthBasic, AuthJWT, AuthJWTSecret)
+	}
+}
+
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	t := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ArangoDB CA bundle with error: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ArangoDB CA bundle %s", c.CAFile)
+		}
+		t.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("both ArangoDB client certificate and key must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ArangoDB client certificate with error: %w", err)
+		}
+		t.Certificates = []tls.Certificate{cert}
+	}
+
+	return t, nil
+}
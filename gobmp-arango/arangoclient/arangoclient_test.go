@@ -0,0 +1,307 @@
+package arangoclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+)
+
+// coordinator is a fake coordinator answering version requests and counting them
+type coordinator struct {
+	sync.Mutex
+	requests int
+	auth     string
+}
+
+func (c *coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	c.requests++
+	c.auth = r.Header.Get("Authorization")
+	c.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"server":"arango","version":"3.11.0"}`))
+}
+
+func (c *coordinator) get() (int, string) {
+	c.Lock()
+	defer c.Unlock()
+	return c.requests, c.auth
+}
+
+func TestEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		servers string
+		expect  []string
+	}{
+		{
+			name:    "nil config",
+			servers: "arangodb:8529",
+			expect:  []string{"http://arangodb:8529"},
+		},
+		{
+			name:    "tls and explicit scheme",
+			config:  &Config{TLS: true},
+			servers: "a:8529, http://b:8529,,c:8529",
+			expect:  []string{"https://a:8529", "http://b:8529", "https://c:8529"},
+		},
+		{
+			name:    "ca file enables tls",
+			config:  &Config{CAFile: "ca.pem"},
+			servers: "a:8529",
+			expect:  []string{"https://a:8529"},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.config.Endpoints(tt.servers); !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%s: expected endpoints %v, got %v", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestRoundRobinFailover(t *testing.T) {
+	c1, c2 := &coordinator{}, &coordinator{}
+	s1, s2 := httptest.NewServer(c1), httptest.NewServer(c2)
+	defer s1.Close()
+	defer s2.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	servers := strings.Join([]string{s1.URL, down.URL, s2.URL}, ",")
+	client, err := (&Config{}).NewClient(servers, "root", "secret")
+	if err != nil {
+		t.Fatalf("failed to create client with error: %+v", err)
+	}
+	for i := 0; i < 6; i++ {
+		if _, err := client.Version(context.Background()); err != nil {
+			t.Fatalf("request %d failed with error: %+v", i, err)
+		}
+	}
+	n1, auth := c1.get()
+	n2, _ := c2.get()
+	if n1+n2 != 6 || n1 < 2 || n2 < 2 {
+		t.Errorf("expected requests spread over live coordinators, got %d and %d", n1, n2)
+	}
+	if !strings.HasPrefix(auth, "Basic ") {
+		t.Errorf("expected basic authentication, got %q", auth)
+	}
+}
+
+// cursorCoordinator is a fake coordinator returning query results in batches of one document, it only
+// knows the cursors it created itself
+type cursorCoordinator struct {
+	sync.Mutex
+	name    string
+	cursors map[string][]int
+}
+
+func (c *cursorCoordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/_db/test/_api/database/current":
+		w.Write([]byte(`{"result":{"name":"test","id":"1"}}`))
+		return
+	case r.URL.Path == "/_db/test/_api/cursor":
+		id := fmt.Sprintf("%s-%d", c.name, len(c.cursors))
+		c.cursors[id] = []int{1, 2, 3}
+		w.WriteHeader(http.StatusCreated)
+		c.batch(w, id)
+		return
+	case strings.HasPrefix(r.URL.Path, "/_db/test/_api/cursor/"):
+		id := strings.TrimPrefix(r.URL.Path, "/_db/test/_api/cursor/")
+		if _, ok := c.cursors[id]; ok && r.Method == http.MethodDelete {
+			delete(c.cursors, id)
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"id":%q}`, id)
+			return
+		}
+		if _, ok := c.cursors[id]; ok {
+			c.batch(w, id)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"error":true,"code":404,"errorNum":1600,"errorMessage":"cursor not found"}`))
+}
+
+// batch writes the next document of the cursor
+func (c *cursorCoordinator) batch(w http.ResponseWriter, id string) {
+	docs := c.cursors[id]
+	c.cursors[id] = docs[1:]
+	fmt.Fprintf(w, `{"id":%q,"result":[%d],"hasMore":%t}`, id, docs[0], len(docs) > 1)
+}
+
+func TestCursorBatchesPinned(t *testing.T) {
+	c1 := &cursorCoordinator{name: "c1", cursors: map[string][]int{}}
+	c2 := &cursorCoordinator{name: "c2", cursors: map[string][]int{}}
+	s1, s2 := httptest.NewServer(c1), httptest.NewServer(c2)
+	defer s1.Close()
+	defer s2.Close()
+	client, err := (&Config{}).NewClient(s1.URL+","+s2.URL, "root", "secret")
+	if err != nil {
+		t.Fatalf("failed to create client with error: %+v", err)
+	}
+	ctx := context.Background()
+	db, err := client.Database(ctx, "test")
+	if err != nil {
+		t.Fatalf("failed to open database with error: %+v", err)
+	}
+	// Queries are spread over both coordinators, the batches of each must come from the one that created it
+	for i := 0; i < 2; i++ {
+		cursor, err := db.Query(ctx, "FOR d IN c RETURN d", nil)
+		if err != nil {
+			t.Fatalf("query %d failed with error: %+v", i, err)
+		}
+		var got []int
+		for cursor.HasMore() {
+			var d int
+			if _, err := cursor.ReadDocument(ctx, &d); err != nil {
+				t.Fatalf("query %d: failed to read document with error: %+v", i, err)
+			}
+			got = append(got, d)
+		}
+		if err := cursor.Close(); err != nil {
+			t.Errorf("query %d: failed to close cursor with error: %+v", i, err)
+		}
+		if !reflect.DeepEqual(got, []int{1, 2, 3}) {
+			t.Errorf("query %d: expected documents [1 2 3], got %v", i, got)
+		}
+	}
+}
+
+func TestTLSAndJWTSecret(t *testing.T) {
+	c := &coordinator{}
+	s := httptest.NewTLSServer(c)
+	defer s.Close()
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("cluster-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := (&Config{CAFile: ca, Auth: AuthJWTSecret, JWTSecretFile: secret}).NewClient(strings.TrimPrefix(s.URL, "https://"), "", "")
+	if err != nil {
+		t.Fatalf("failed to create client with error: %+v", err)
+	}
+	if _, err := client.Version(context.Background()); err != nil {
+		t.Fatalf("request failed with error: %+v", err)
+	}
+	if _, auth := c.get(); !strings.HasPrefix(auth, "bearer ") {
+		t.Errorf("expected a JWT bearer token, got %q", auth)
+	}
+	// Without the CA, the coordinator's certificate is not trusted
+	client, err = (&Config{TLS: true}).NewClient(s.URL, "root", "")
+	if err != nil {
+		t.Fatalf("failed to create client with error: %+v", err)
+	}
+	if _, err := client.Version(context.Background()); err == nil {
+		t.Errorf("expected certificate verification to fail")
+	}
+}
+
+// rotating is a provider whose credentials are changed by the test
+type rotating struct {
+	sync.Mutex
+	creds credentials.Credentials
+}
+
+func (r *rotating) Credentials() (credentials.Credentials, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.creds, nil
+}
+
+func TestRotatingCredentials(t *testing.T) {
+	c := &coordinator{}
+	s := httptest.NewServer(c)
+	defer s.Close()
+	p := &rotating{creds: credentials.Credentials{User: "root", Password: "old"}}
+	client, err := (&Config{Credentials: p}).NewClient(s.URL, "", "")
+	if err != nil {
+		t.Fatalf("failed to create client with error: %+v", err)
+	}
+	for _, password := range []string{"old", "new"} {
+		p.Lock()
+		p.creds.Password = password
+		p.Unlock()
+		if _, err := client.Version(context.Background()); err != nil {
+			t.Fatalf("request failed with error: %+v", err)
+		}
+		expect := "Basic " + base64.StdEncoding.EncodeToString([]byte("root:"+password))
+		if _, auth := c.get(); auth != expect {
+			t.Errorf("expected authentication %q, got %q", expect, auth)
+		}
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:   "unavailable coordinator",
+			err:    fmt.Errorf("failed to read node: %w", driver.ArangoError{HasError: true, Code: http.StatusServiceUnavailable}),
+			expect: true,
+		},
+		{
+			name:   "write-write conflict",
+			err:    driver.ArangoError{HasError: true, Code: http.StatusConflict, ErrorNum: driver.ErrArangoConflict},
+			expect: true,
+		},
+		{
+			name: "unique constraint violated",
+			err:  driver.ArangoError{HasError: true, Code: http.StatusConflict, ErrorNum: driver.ErrArangoUniqueConstraintViolated},
+		},
+		{
+			name: "invalid query",
+			err:  fmt.Errorf("query failed: %w", driver.ArangoError{HasError: true, Code: http.StatusBadRequest}),
+		},
+		{
+			name:   "timeout",
+			err:    fmt.Errorf("all ArangoDB coordinators failed, last error: %w", context.DeadlineExceeded),
+			expect: true,
+		},
+		{
+			name:   "connection refused",
+			err:    &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			expect: true,
+		},
+		{
+			name: "canceled",
+			err:  context.Canceled,
+		},
+		{
+			name: "invalid event",
+			err:  errors.New("key is empty"),
+		},
+	}
+	for _, tt := range tests {
+		if actual := Transient(tt.err); actual != tt.expect {
+			t.Errorf("%s: expected %t, actual %t", tt.name, tt.expect, actual)
+		}
+	}
+}
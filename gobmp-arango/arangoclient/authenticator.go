@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangoclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+	"github.com/golang/glog"
+)
+
+// authenticator is a connection authenticating requests with the current credentials of the provider.
+// When the credentials change, the connection is authenticated again, so JWT tokens are obtained with
+// the new credentials.
+type authenticator struct {
+	conn     driver.Connection
+	provider credentials.Provider
+	auth     func(credentials.Credentials) (driver.Authentication, error)
+
+	sync.Mutex
+	creds  credentials.Credentials
+	authed driver.Connection
+}
+
+func newAuthenticator(conn driver.Connection, provider credentials.Provider, auth func(credentials.Credentials) (driver.Authentication, error)) (*authenticator, error) {
+	a := &authenticator{
+		conn:     conn,
+		provider: provider,
+		auth:     auth,
+	}
+	if _, err := a.current(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// current returns the connection authenticated with the current credentials
+func (a *authenticator) current() (driver.Connection, error) {
+	creds, err := a.provider.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.authed != nil && creds == a.creds {
+		return a.authed, nil
+	}
+	auth, err := a.auth(creds)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := a.conn.SetAuthentication(auth)
+	if err != nil {
+		return nil, err
+	}
+	if a.authed != nil {
+		glog.Infof("ArangoDB credentials of %v changed, re-authenticating", a.conn.Endpoints())
+	}
+	a.creds, a.authed = creds, conn
+
+	return conn, nil
+}
+
+// NewRequest creates a request
+func (a *authenticator) NewRequest(method, path string) (driver.Request, error) {
+	return a.conn.NewRequest(method, path)
+}
+
+// Do sends the request authenticated with the current credentials
+func (a *authenticator) Do(ctx context.Context, req driver.Request) (driver.Response, error) {
+	conn, err := a.current()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.Do(ctx, req)
+}
+
+// Unmarshal unmarshals the raw object with the protocol of the connection
+func (a *authenticator) Unmarshal(data driver.RawObject, result interface{}) error {
+	return a.conn.Unmarshal(data, result)
+}
+
+// Endpoints returns endpoints of the connection
+func (a *authenticator) Endpoints() []string {
+	return a.conn.Endpoints()
+}
+
+// UpdateEndpoints updates endpoints of the connection
+func (a *authenticator) UpdateEndpoints(endpoints []string) error {
+	return a.conn.UpdateEndpoints(endpoints)
+}
+
+// SetAuthentication is not supported, the credentials provider authenticates requests
+func (a *authenticator) SetAuthentication(driver.Authentication) (driver.Connection, error) {
+	return nil, fmt.Errorf("authentication of the connection is set by its credentials provider")
+}
+
+// Protocols returns protocols of the connection
+func (a *authenticator) Protocols() driver.ProtocolSet {
+	return a.conn.Protocols()
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangoclient
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/golang/glog"
+)
+
+// balancer is a connection sending every request to the next coordinator, a request which could not be
+// sent to a coordinator fails over to the following one. A request which has been written is not resent,
+// since the coordinator may have executed it. A request pinned to an endpoint, such as the next batch of a
+// cursor, is only sent to the coordinator of that endpoint since other coordinators do not know the cursor.
+type balancer struct {
+	conns   []driver.Connection
+	next    *atomic.Uint64
+	timeout time.Duration
+}
+
+func newBalancer(conns []driver.Connection, timeout time.Duration) *balancer {
+	return &balancer{
+		conns:   conns,
+		next:    &atomic.Uint64{},
+		timeout: timeout,
+	}
+}
+
+// NewRequest creates a request, all coordinators use the same protocol
+func (b *balancer) NewRequest(method, path string) (driver.Request, error) {
+	return b.conns[0].NewRequest(method, path)
+}
+
+// keyEndpoint is the context key under which driver.WithEndpoint stores the endpoint a request is pinned to
+const keyEndpoint driver.ContextKey = "arangodb-endpoint"
+
+// pinned returns the coordinator of the endpoint the request is pinned to
+func (b *balancer) pinned(ctx context.Context) (driver.Connection, bool) {
+	endpoint, ok := ctx.Value(keyEndpoint).(string)
+	if !ok || endpoint == "" {
+		return nil, false
+	}
+	for _, c := range b.conns {
+		for _, ep := range c.Endpoints() {
+			if ep == endpoint {
+				return c, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Do sends the request to the coordinator it is pinned to, otherwise to the next coordinator and fails
+// over to the following ones
+func (b *balancer) Do(ctx context.Context, req driver.Request) (driver.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	if conn, ok := b.pinned(ctx); ok {
+		return conn.Do(ctx, req)
+	}
+	start := b.next.Add(1)
+	var err error
+	for i := range b.conns {
+		conn := b.conns[(start+uint64(i))%uint64(len(b.conns))]
+		var resp driver.Response
+		resp, err = conn.Do(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if driver.IsCanceled(err) || ctx.Err() != nil || req.Written() {
+			return nil, err
+		}
+		glog.Warningf("ArangoDB coordinator %v failed with error: %+v, failing over", conn.Endpoints(), err)
+	}
+
+	return nil, fmt.Errorf("all ArangoDB coordinators failed, last error: %w", err)
+}
+
+// Unmarshal unmarshals the raw object with the protocol of the coordinators
+func (b *balancer) Unmarshal(data driver.RawObject, result interface{}) error {
+	return b.conns[0].Unmarshal(data, result)
+}
+
+// Endpoints returns endpoints of all coordinators
+func (b *balancer) Endpoints() []string {
+	endpoints := make([]string, 0, len(b.conns))
+	for _, c := range b.conns {
+		endpoints = append(endpoints, c.Endpoints()...)
+	}
+
+	return endpoints
+}
+
+// UpdateEndpoints is not supported, the list of coordinators is static
+func (b *balancer) UpdateEndpoints(endpoints []string) error {
+	return fmt.Errorf("updating endpoints of a balanced connection is not supported")
+}
+
+// SetAuthentication returns a copy of the connection authenticating requests to all coordinators
+func (b *balancer) SetAuthentication(auth driver.Authentication) (driver.Connection, error) {
+	conns := make([]driver.Connection, 0, len(b.conns))
+	for _, c := range b.conns {
+		conn, err := c.SetAuthentication(auth)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	return &balancer{conns: conns, next: b.next, timeout: b.timeout}, nil
+}
+
+// Protocols returns protocols of the coordinators
+func (b *balancer) Protocols() driver.ProtocolSet {
+	return b.conns[0].Protocols()
+}
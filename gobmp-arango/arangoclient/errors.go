@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangoclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	driver "github.com/arangodb/go-driver"
+)
+
+// Transient returns true when the request failed because ArangoDB or the network was temporarily
+// unavailable, such a request may succeed when it is retried. Other errors, such as invalid documents
+// or queries, fail again on every retry.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if driver.IsTimeout(e) || driver.IsResponse(e) {
+			return true
+		}
+		if ae, ok := driver.AsArangoError(e); ok {
+			switch ae.Code {
+			case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+				http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				return true
+			}
+			// A write-write conflict with a concurrent transaction
+			return ae.ErrorNum == driver.ErrArangoConflict
+		}
+	}
+	var ne net.Error
+
+	return errors.As(err, &ne)
+}
@@ -26,10 +26,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 
 	driver "github.com/arangodb/go-driver"
-	"github.com/arangodb/go-driver/http"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/golang/glog"
 )
 
@@ -41,10 +40,12 @@ var (
 )
 
 type ArangoConfig struct {
-	URL      string `desc:"Arangodb server URL (http://127.0.0.1:8529)"`
+	URL      string `desc:"Arangodb server URL (http://127.0.0.1:8529), or a comma separated list of coordinators"`
 	User     string `desc:"Arangodb server username"`
 	Password string `desc:"Arangodb server user password"`
 	Database string `desc:"Arangodb database name"`
+	// Connection configures TLS, authentication and timeouts, nil connects over HTTP with basic authentication
+	Connection *arangoclient.Config
 }
 
 func NewConfig() ArangoConfig {
@@ -61,36 +62,19 @@ var (
 
 func NewArango(cfg ArangoConfig) (*ArangoConn, error) {
 	// Connect to DB
-	if cfg.URL == "" || cfg.User == "" || cfg.Password == "" || cfg.Database == "" {
+	if cfg.URL == "" || cfg.Database == "" {
 		return nil, ErrEmptyConfig
 	}
-	if !strings.Contains(cfg.URL, "http") {
-		cfg.URL = "http://" + cfg.URL
-	}
-	conn, err := http.NewConnection(http.ConnectionConfig{
-		Endpoints: []string{cfg.URL},
-	})
-	if err != nil {
-		glog.Errorf("Failed to create HTTP connection: %v", err)
-		return nil, err
-	}
-
-	// Authenticate with DB
-	conn, err = conn.SetAuthentication(driver.BasicAuthentication(cfg.User, cfg.Password))
-	if err != nil {
-		glog.Errorf("Failed to authenticate with arango: %v", err)
-		return nil, err
+	if cfg.Connection.UsesCredentials() && (cfg.User == "" || cfg.Password == "") {
+		return nil, ErrEmptyConfig
 	}
-
-	c, err := driver.NewClient(driver.ClientConfig{
-		Connection: conn,
-	})
+	c, err := cfg.Connection.NewClient(cfg.URL, cfg.User, cfg.Password)
 	if err != nil {
 		glog.Errorf("Failed to create client: %v", err)
 		return nil, err
 	}
 
-	db, err := ensureDatabase(c, cfg)
+	db, err := arangoclient.Database(context.Background(), c, cfg.Database, true)
 	if err != nil {
 		glog.Errorf("Failed to create DB")
 		return nil, err
@@ -99,25 +83,7 @@ func NewArango(cfg ArangoConfig) (*ArangoConn, error) {
 	return &ArangoConn{db: db}, nil
 }
 
-func ensureDatabase(c driver.Client, cfg ArangoConfig) (driver.Database, error) {
-	var db driver.Database
-
-	exists, err := c.DatabaseExists(context.Background(), cfg.Database)
-	if err != nil {
-		return db, err
-	}
-
-	if !exists {
-		// Create database
-		db, err = c.CreateDatabase(context.Background(), cfg.Database, nil)
-		if err != nil {
-			return db, err
-		}
-	} else {
-		db, err = c.Database(context.Background(), cfg.Database)
-		if err != nil {
-			return db, err
-		}
-	}
-	return db, nil
+// Check reports whether the database is reachable, it implements health.Checker
+func (a *ArangoConn) Check(ctx context.Context) error {
+	return arangoclient.Ping(ctx, a.db)
 }
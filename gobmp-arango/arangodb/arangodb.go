@@ -25,88 +25,190 @@ package arangodb
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/deadletter"
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	metrics "github.com/cisco-open/jalapeno/gobmp-arango/stats"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
-	"github.com/sbezverk/gobmp/pkg/tools"
+	"go.uber.org/atomic"
 )
 
 const (
 	concurrentWorkers = 1024
+	// defaultRetryBackoff defines the initial retry delay when none is configured
+	defaultRetryBackoff = 500 * time.Millisecond
+	// defaultBatchInterval defines the batch flush interval when none is configured
+	defaultBatchInterval = 20 * time.Millisecond
+	// maxRetryBackoff defines the upper limit of the delay between two attempts for the same record
+	maxRetryBackoff = time.Minute
 )
 
-var (
-	collections = map[dbclient.CollectionType]*collectionProperties{
-		dbclient.PeerStateChange: {name: "peer", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.LSLink:          {name: "ls_link", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.LSNode:          {name: "ls_node", isVertex: true, options: &driver.CreateCollectionOptions{}},
-		dbclient.LSPrefix:        {name: "ls_prefix", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.LSSRv6SID:       {name: "ls_srv6_sid", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.L3VPN:           {name: "l3vpn_prefix", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.L3VPNV4:         {name: "l3vpn_v4_prefix", isVertex: true, options: &driver.CreateCollectionOptions{}},
-		dbclient.L3VPNV6:         {name: "l3vpn_v6_prefix", isVertex: true, options: &driver.CreateCollectionOptions{}},
-		dbclient.UnicastPrefix:   {name: "unicast_prefix", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.UnicastPrefixV4: {name: "unicast_prefix_v4", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.UnicastPrefixV6: {name: "unicast_prefix_v6", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.SRPolicy:        {name: "sr_policy", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.SRPolicyV4:      {name: "sr_policy_v4", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.SRPolicyV6:      {name: "sr_policy_v6", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.Flowspec:        {name: "flowspec", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.FlowspecV4:      {name: "flowspec_v4", isVertex: false, options: &driver.CreateCollectionOptions{}},
-		dbclient.FlowspecV6:      {name: "flowspec_v6", isVertex: false, options: &driver.CreateCollectionOptions{}},
-	}
-)
-
-// collectionProperties defines a collection specific properties
-// TODO this information should be configurable without recompiling code.
+// collectionProperties defines a collection specific properties, properties are built from the collection schema.
 type collectionProperties struct {
 	name     string
 	isVertex bool
 	options  *driver.CreateCollectionOptions
+	indexes  []*indexProperties
+	// keys stores message fields building document keys of message types which do not use their built-in key
+	keys map[dbclient.CollectionType][]string
+}
+
+// Config holds the configuration of gobmp-arango database client
+type Config struct {
+	// URL is the database server or a comma separated list of coordinators
+	URL      string
+	User     string
+	Password string
+	Database string
+	// Connection configures TLS, authentication and timeouts, nil connects over HTTP with basic authentication
+	Connection *arangoclient.Config
+	// Notifier, when not nil, is used to send topology change events
+	Notifier kafkanotifier.Event
+	// EventPayload defines documents carried by change events, kafkanotifier.PayloadNone when empty
+	EventPayload kafkanotifier.EventPayload
+	// DeadLetter, when not nil, receives records which could not be parsed or stored
+	DeadLetter deadletter.Publisher
+	// MaxRetries defines how many times a failed record is retried before it is dead-lettered,
+	// 0 means the record is retried until it succeeds. Without DeadLetter records are always
+	// retried until they succeed since dropping them would lose the change.
+	MaxRetries int
+	// RetryBackoff defines the initial delay before retrying a failed record, the delay
+	// doubles with every attempt up to maxRetryBackoff.
+	RetryBackoff time.Duration
+	// BatchSize defines the maximum number of records written to a collection by a single query,
+	// 0 or 1 disables batching and every record is written by its own request.
+	BatchSize int
+	// BatchInterval defines how long records can wait in a batch which has not reached BatchSize
+	BatchInterval time.Duration
+	// QueueSize bounds the number of messages of a collection which are queued, waiting in the backlog
+	// of their key or being written, messages beyond it are rejected with flowcontrol.ErrQueueFull and
+	// retried by the caller. 0 does not bound the number, storing a message then blocks until the
+	// collection handler takes it.
+	QueueSize int
+	// Schema defines collections and message types feeding them, when nil DefaultSchema is used
+	Schema *Schema
+	// PeerDownCascade lists message types whose documents are removed when the BMP peer they were
+	// learned from goes down, collections of the message types must be keyed by the peer. DefaultPeerDownCascade
+	// is a suitable default, nil disables the cleanup.
+	PeerDownCascade []dbclient.CollectionType
+	// Resync lists message types whose documents are stamped with the generation of the BMP peer they
+	// were learned from, when the peer comes up again, documents it did not re-advertise are removed.
+	// Collections of the message types must be keyed by the peer. DefaultResync is a suitable default,
+	// nil disables the resynchronisation.
+	Resync []dbclient.CollectionType
+	// ResyncQuietPeriod defines how long a peer which came up must stay quiet before documents it did not
+	// re-advertise are removed, End-of-RIB triggers the removal earlier, DefaultResyncQuietPeriod when 0.
+	ResyncQuietPeriod time.Duration
+	// History lists collections whose changes are appended to <collection>_history collections
+	History []string
+	// HistoryTTL defines how long a version of a document is kept in the history collection once it has
+	// been superseded or removed, 0 keeps versions forever.
+	HistoryTTL time.Duration
+	// MigrateKeys re-keys existing documents stored under keys which differ from the keys built by the
+	// collection's key strategy, the migration runs once when the client starts.
+	MigrateKeys bool
+	// SpoolFile stores events and history records which have not been sent when the client stops,
+	// they are sent when the client starts again, an empty name discards them.
+	SpoolFile string
 }
 
 type arangoDB struct {
 	dbclient.DB
 	*ArangoConn
+	config           Config
 	stop             chan struct{}
 	collections      map[dbclient.CollectionType]*collection
 	notifyCompletion bool
 	notifier         kafkanotifier.Event
+	resync           *resync
+	histories        []*history
+	// pending counts messages queued for processing and not yet acknowledged
+	pending atomic.Int64
+	// unsent counts events and history records queued and not yet sent
+	unsent atomic.Int64
+	// spilled stores events and history records which could not be queued because the client was stopping
+	spilled     []*spoolRecord
+	spilledLock sync.Mutex
+	// wg tracks goroutines which must return before unsent events and history records are spooled
+	wg sync.WaitGroup
 }
 
 // NewDBSrvClient returns an instance of a DB server client process
-func NewDBSrvClient(arangoSrv, user, pass, dbname string, notifier kafkanotifier.Event) (dbclient.Srv, error) {
-	if err := tools.URLAddrValidation(arangoSrv); err != nil {
+func NewDBSrvClient(config Config) (dbclient.Srv, error) {
+	if err := config.Connection.Validate(config.URL); err != nil {
 		return nil, err
 	}
 	arangoConn, err := NewArango(ArangoConfig{
-		URL:      arangoSrv,
-		User:     user,
-		Password: pass,
-		Database: dbname,
+		URL:        config.URL,
+		User:       config.User,
+		Password:   config.Password,
+		Database:   config.Database,
+		Connection: config.Connection,
 	})
 	if err != nil {
 		return nil, err
 	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaultRetryBackoff
+	}
+	if config.BatchInterval <= 0 {
+		config.BatchInterval = defaultBatchInterval
+	}
+	switch config.EventPayload {
+	case "":
+		config.EventPayload = kafkanotifier.PayloadNone
+	case kafkanotifier.PayloadNone, kafkanotifier.PayloadFull, kafkanotifier.PayloadDiff:
+	default:
+		return nil, fmt.Errorf("unknown event payload %q", config.EventPayload)
+	}
+	if config.Schema == nil {
+		config.Schema = DefaultSchema()
+	}
 	arango := &arangoDB{
+		config:      config,
 		stop:        make(chan struct{}),
 		collections: make(map[dbclient.CollectionType]*collection),
 	}
 	arango.DB = arango
+	metrics.WorkersLimit.Set(concurrentWorkers)
 	arango.ArangoConn = arangoConn
-	if notifier != nil {
+	if config.Notifier != nil {
 		arango.notifyCompletion = true
-		arango.notifier = notifier
+		arango.notifier = config.Notifier
+	}
+	props, err := config.Schema.collectionProperties()
+	if err != nil {
+		return nil, err
 	}
 	// Init collections
-	for t, n := range collections {
+	for t, n := range props {
 		if err := arango.ensureCollection(n, t); err != nil {
 			return nil, err
 		}
 	}
+	if err := arango.ensureHistory(); err != nil {
+		return nil, err
+	}
+	if err := arango.checkPeerDownCascade(); err != nil {
+		return nil, err
+	}
+	if len(config.Resync) != 0 {
+		if arango.resync, err = newResync(arango, config.Resync, config.ResyncQuietPeriod); err != nil {
+			return nil, err
+		}
+		if err := arango.resync.load(); err != nil {
+			return nil, err
+		}
+	}
 
 	return arango, nil
 }
@@ -114,12 +216,17 @@ func NewDBSrvClient(arangoSrv, user, pass, dbname string, notifier kafkanotifier
 func (a *arangoDB) ensureCollection(p *collectionProperties, collectionType dbclient.CollectionType) error {
 	if _, ok := a.collections[collectionType]; !ok {
 		a.collections[collectionType] = &collection{
-			queue:          make(chan *queueMsg),
+			queue:          make(chan *queueMsg, a.config.QueueSize),
+			retry:          make(chan *result),
 			stats:          &stats{},
 			stop:           a.stop,
 			arango:         a,
 			collectionType: collectionType,
 			properties:     p,
+			events:         make(chan *kafkanotifier.EventMessage, eventQueueSize),
+			sweeps:         make(chan *sweep, sweepQueueSize),
+			swept:          make(chan *sweep, sweepQueueSize),
+			heartbeat:      health.NewHeartbeat(health.DefaultHeartbeatTimeout),
 		}
 		switch collectionType {
 		case bmp.PeerStateChangeMsg:
@@ -138,6 +245,8 @@ func (a *arangoDB) ensureCollection(p *collectionProperties, collectionType dbcl
 			a.collections[collectionType].handler = a.collections[collectionType].genericHandler
 		case bmp.L3VPNV6Msg:
 			a.collections[collectionType].handler = a.collections[collectionType].genericHandler
+		case bmp.EVPNMsg:
+			a.collections[collectionType].handler = a.collections[collectionType].genericHandler
 		case bmp.UnicastPrefixMsg:
 			a.collections[collectionType].handler = a.collections[collectionType].genericHandler
 		case bmp.UnicastPrefixV4Msg:
@@ -165,7 +274,7 @@ func (a *arangoDB) ensureCollection(p *collectionProperties, collectionType dbcl
 	// There are two possible collection types, base type and edge type
 	// for Edge type a collection must be created as a Vertex collection
 	if a.collections[collectionType].properties.isVertex {
-		graph, err := a.ensureGraph(a.collections[collectionType].properties.name)
+		graph, err := a.ensureGraph(a.collections[collectionType].properties)
 		if err != nil {
 			return err
 		}
@@ -182,7 +291,7 @@ func (a *arangoDB) ensureCollection(p *collectionProperties, collectionType dbcl
 			}
 		}
 		a.collections[collectionType].topicCollection = ci
-		return nil
+		return a.ensureIndexes(ci, a.collections[collectionType].properties)
 	}
 	ci, err = a.db.Collection(context.TODO(), a.collections[collectionType].properties.name)
 	if err != nil {
@@ -196,10 +305,37 @@ func (a *arangoDB) ensureCollection(p *collectionProperties, collectionType dbcl
 	}
 	a.collections[collectionType].topicCollection = ci
 
+	return a.ensureIndexes(ci, a.collections[collectionType].properties)
+}
+
+// ensureHistory creates history collections of the collections listed in the configuration,
+// all message types feeding a collection share its history.
+func (a *arangoDB) ensureHistory() error {
+	for _, name := range a.config.History {
+		var h *history
+		for _, c := range a.collections {
+			if c.properties.name != name {
+				continue
+			}
+			if h == nil {
+				var err error
+				if h, err = newHistory(a, c.properties, a.config.HistoryTTL); err != nil {
+					return err
+				}
+				a.histories = append(a.histories, h)
+			}
+			c.history = h
+		}
+		if h == nil {
+			return fmt.Errorf("history requested for unknown collection %s", name)
+		}
+	}
+
 	return nil
 }
 
-func (a *arangoDB) ensureGraph(name string) (driver.Graph, error) {
+func (a *arangoDB) ensureGraph(p *collectionProperties) (driver.Graph, error) {
+	name := p.name
 	var edgeDefinition driver.EdgeDefinition
 	edgeDefinition.Collection = name + "_edge"
 	edgeDefinition.From = []string{name}
@@ -207,6 +343,10 @@ func (a *arangoDB) ensureGraph(name string) (driver.Graph, error) {
 
 	var options driver.CreateGraphOptions
 	options.EdgeDefinitions = []driver.EdgeDefinition{edgeDefinition}
+	// Sharding and replication of a vertex collection are defined by its graph
+	options.NumberOfShards = p.options.NumberOfShards
+	options.ReplicationFactor = p.options.ReplicationFactor
+	options.WriteConcern = p.options.WriteConcern
 	graph, err := a.db.Graph(context.TODO(), name)
 	if err == nil {
 		graph.Remove(context.TODO())
@@ -223,13 +363,63 @@ func (a *arangoDB) Start() error {
 	glog.Infof("Connected to arango database, starting monitor")
 	go a.monitor()
 	for _, c := range a.collections {
-		go c.handler()
+		if a.notifyCompletion {
+			a.wg.Add(1)
+			go c.eventNotifier()
+		}
+	}
+	for _, h := range a.histories {
+		a.wg.Add(1)
+		go h.writer()
+	}
+	// Events and history records left by the previous stop are queued ahead of new changes
+	if err := a.replaySpool(); err != nil {
+		return err
+	}
+	// Documents are re-keyed before messages are processed, events and history of the migration
+	// are sent by the goroutines started above.
+	if a.config.MigrateKeys {
+		if err := a.migrateKeys(); err != nil {
+			return err
+		}
+	}
+	for _, c := range a.collections {
+		a.wg.Add(1)
+		go func(c *collection) {
+			defer a.wg.Done()
+			c.handler()
+		}(c)
 	}
 	return nil
 }
 
+// Stop stops processing, events and history records which have not been sent are stored in the spool file
 func (a *arangoDB) Stop() error {
+	if a.resync != nil {
+		a.resync.stop()
+	}
 	close(a.stop)
+	a.wg.Wait()
+	if a.config.SpoolFile == "" {
+		return nil
+	}
+
+	return a.writeSpool(a.leftovers())
+}
+
+// Live reports whether handlers of all collections are processing messages, it implements health.Liveness
+func (a *arangoDB) Live(ctx context.Context) error {
+	types := make([]dbclient.CollectionType, 0, len(a.collections))
+	for t := range a.collections {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, t := range types {
+		c := a.collections[t]
+		if err := c.heartbeat.Check(ctx); err != nil {
+			return fmt.Errorf("handler of message type %s of collection %s: %w", dbclient.TopicName(t), c.properties.name, err)
+		}
+	}
 
 	return nil
 }
@@ -243,11 +433,45 @@ func (a *arangoDB) GetArangoDBInterface() *ArangoConn {
 }
 
 func (a *arangoDB) StoreMessage(msgType dbclient.CollectionType, msg []byte) error {
-	if t, ok := a.collections[msgType]; ok {
-		t.queue <- &queueMsg{
-			msgType: msgType,
-			msgData: msg,
+	return a.StoreMessageWithAck(msgType, msg, nil)
+}
+
+// StoreMessageWithAck queues the message for processing, ack is called once the document
+// has been persisted or the message has been discarded.
+func (a *arangoDB) StoreMessageWithAck(msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	return a.StoreMessageWithContext(context.Background(), msgType, msg, ack)
+}
+
+// StoreMessageWithContext queues the message for processing, transforming, writing and notifying the
+// change are recorded as spans of the trace carried by the context. ack is handled as by StoreMessageWithAck.
+func (a *arangoDB) StoreMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	t, ok := a.collections[msgType]
+	if !ok {
+		// Messages of types without a collection are not stored, nothing to wait for
+		if ack != nil {
+			ack()
 		}
+		return nil
+	}
+	// The queue holds QueueSize messages, a message within the bound is queued without blocking
+	// whatever the collection handler is doing
+	if n := t.pending.Add(1); a.config.QueueSize > 0 && n > int64(a.config.QueueSize) {
+		t.pending.Add(-1)
+		return fmt.Errorf("collection %s has %d pending messages: %w", t.properties.name, a.config.QueueSize, flowcontrol.ErrQueueFull)
+	}
+	metrics.MessagesReceived.WithLabelValues(a.config.Database, t.properties.name).Inc()
+	a.pending.Add(1)
+	t.queue <- &queueMsg{
+		ctx:     ctx,
+		msgType: msgType,
+		msgData: msg,
+		ack: func() {
+			t.pending.Add(-1)
+			a.pending.Add(-1)
+			if ack != nil {
+				ack()
+			}
+		},
 	}
 
 	return nil
@@ -0,0 +1,211 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	metrics "github.com/cisco-open/jalapeno/gobmp-arango/stats"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
+	"github.com/golang/glog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// upsertQuery inserts or merges every document of the batch and returns the action which
+	// actually took place for each key, "add" when the document did not exist and "update" otherwise,
+	// along with the new revision of the document.
+	upsertQuery = "FOR d IN @docs " +
+		"UPSERT { _key: d._key } INSERT d UPDATE d IN @@collection " +
+		"RETURN { _key: NEW._key, _rev: NEW._rev, action: OLD ? \"update\" : \"add\" }"
+	// upsertDocsQuery is upsertQuery which also returns the document after and before the change
+	upsertDocsQuery = "FOR d IN @docs " +
+		"UPSERT { _key: d._key } INSERT d UPDATE d IN @@collection " +
+		"RETURN { _key: NEW._key, _rev: NEW._rev, action: OLD ? \"update\" : \"add\", new: NEW, old: OLD }"
+	// removeQuery removes every document of the batch, documents which do not exist are ignored,
+	// the revision of each removed document is returned.
+	removeQuery = "FOR k IN @keys " +
+		"REMOVE { _key: k } IN @@collection OPTIONS { ignoreErrors: true } " +
+		"RETURN { _key: OLD._key, _rev: OLD._rev }"
+	// removeDocsQuery is removeQuery which also returns the removed document
+	removeDocsQuery = "FOR k IN @keys " +
+		"REMOVE { _key: k } IN @@collection OPTIONS { ignoreErrors: true } " +
+		"RETURN { _key: OLD._key, _rev: OLD._rev, old: OLD }"
+)
+
+// batchItem defines a record which is waiting in the batch to be written to the database
+type batchItem struct {
+	// ctx carries the trace context of the message the record was built from
+	ctx    context.Context
+	key    string
+	object DBRecord
+}
+
+type upsertResult struct {
+	Key    string          `json:"_key"`
+	Rev    string          `json:"_rev"`
+	Action actionType      `json:"action"`
+	New    json.RawMessage `json:"new,omitempty"`
+	Old    json.RawMessage `json:"old,omitempty"`
+}
+
+// batchWorker writes records of the batch using at most two AQL queries, one for inserts and updates and
+// one for removals. Since every key is present in the batch only once and a key stays busy until its result
+// is processed, the order of changes for the key is preserved. A result is returned for each record, if a query
+// fails all its records are reported as failed and get retried individually. The write is recorded as a span
+// in the trace of every record of the batch.
+func (c *collection) batchWorker(batch []*batchItem, done chan *result, tokens chan struct{}) {
+	results := make([]*result, 0, len(batch))
+	spans := make([]trace.Span, 0, len(batch))
+	defer func() {
+		for i, r := range results {
+			spans[i].SetAttributes(attribute.String("jalapeno.action", string(r.action)))
+			tracing.End(spans[i], r.err)
+		}
+		<-tokens
+		for _, r := range results {
+			if r.err == nil {
+				c.stats.total.Add(1)
+				metrics.MessagesPersisted.WithLabelValues(c.arango.config.Database, c.properties.name).Inc()
+			}
+			done <- r
+		}
+		glog.V(6).Infof("done batch of %d records, type: %d total messages: %s", len(batch), c.collectionType, c.stats.total.String())
+	}()
+	ctx := context.TODO()
+	docs := make([]interface{}, 0, len(batch))
+	upserts := make(map[string]*result)
+	keys := make([]string, 0)
+	removes := make(map[string]*result)
+	for _, item := range batch {
+		r := &result{object: item.object, key: item.key}
+		results = append(results, r)
+		_, span := tracing.StartDB(item.ctx, "write", c.properties.name,
+			attribute.String("jalapeno.key", item.key),
+			attribute.Int("jalapeno.batch_size", len(batch)))
+		spans = append(spans, span)
+		obj, action, err := c.prepareRecord(item.key, item.object)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		r.action = action
+		switch action {
+		case addAction:
+			docs = append(docs, obj)
+			upserts[item.key] = r
+		case delAction:
+			fallthrough
+		case downAction:
+			keys = append(keys, item.key)
+			removes[item.key] = r
+		}
+	}
+	if len(docs) != 0 {
+		if err := c.upsertDocuments(ctx, docs, upserts); err != nil {
+			for _, r := range upserts {
+				r.err = err
+			}
+		}
+	}
+	if len(keys) != 0 {
+		if err := c.removeDocuments(ctx, keys, removes); err != nil {
+			for _, r := range removes {
+				r.err = err
+			}
+		}
+	}
+}
+
+func (c *collection) upsertDocuments(ctx context.Context, docs []interface{}, upserts map[string]*result) error {
+	defer metrics.ObserveOperation(c.arango.config.Database, c.properties.name, "batch_upsert", time.Now())
+	query := upsertQuery
+	if c.richEvents() {
+		query = upsertDocsQuery
+	}
+	cursor, err := c.arango.db.Query(ctx, query, map[string]interface{}{
+		"docs":        docs,
+		"@collection": c.properties.name,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for {
+		var u upsertResult
+		if _, err := cursor.ReadDocument(ctx, &u); err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return err
+		}
+		r, ok := upserts[u.Key]
+		if !ok {
+			return fmt.Errorf("unexpected key %s returned by upsert", u.Key)
+		}
+		// Fixing action in result message to the actual action occured
+		r.action = u.Action
+		r.rev = u.Rev
+		r.newDoc = u.New
+		r.oldDoc = u.Old
+	}
+
+	return nil
+}
+
+func (c *collection) removeDocuments(ctx context.Context, keys []string, removes map[string]*result) error {
+	defer metrics.ObserveOperation(c.arango.config.Database, c.properties.name, "batch_remove", time.Now())
+	query := removeQuery
+	if c.richEvents() {
+		query = removeDocsQuery
+	}
+	cursor, err := c.arango.db.Query(ctx, query, map[string]interface{}{
+		"keys":        keys,
+		"@collection": c.properties.name,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for {
+		var u upsertResult
+		if _, err := cursor.ReadDocument(ctx, &u); err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return err
+		}
+		// Documents which did not exist return null
+		if r, ok := removes[u.Key]; ok {
+			r.rev = u.Rev
+			r.oldDoc = u.Old
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package arangodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+	"github.com/sbezverk/gobmp/pkg/message"
+)
+
+// fakeDatabase implements queries of the batch worker and peer sweeps over a map of documents, queries fail
+// when err is set. When gate is set, upserts wait for a value from the gate before they are applied.
+type fakeDatabase struct {
+	driver.Database
+	lock    sync.Mutex
+	docs    map[string]bool
+	err     error
+	queries []string
+	gate    chan struct{}
+}
+
+func (d *fakeDatabase) Query(ctx context.Context, query string, bindVars map[string]interface{}) (driver.Cursor, error) {
+	if _, ok := bindVars["docs"]; ok && d.gate != nil {
+		<-d.gate
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.queries = append(d.queries, query)
+	if d.err != nil {
+		return nil, d.err
+	}
+	cursor := &fakeCursor{}
+	if peer, ok := bindVars["peer_ip"].(string); ok {
+		// Keys of unicast prefixes end with the peer address
+		for k := range d.docs {
+			if strings.HasSuffix(k, "_"+peer) {
+				delete(d.docs, k)
+				cursor.docs = append(cursor.docs, upsertResult{Key: k, Rev: "rev-" + k})
+			}
+		}
+	}
+	if docs, ok := bindVars["docs"].([]interface{}); ok {
+		for _, doc := range docs {
+			b, _ := json.Marshal(doc)
+			var k struct {
+				Key string `json:"_key"`
+			}
+			_ = json.Unmarshal(b, &k)
+			action := addAction
+			if d.docs[k.Key] {
+				action = updateAction
+			}
+			d.docs[k.Key] = true
+			cursor.docs = append(cursor.docs, upsertResult{Key: k.Key, Rev: "rev-" + k.Key, Action: action})
+		}
+	}
+	if keys, ok := bindVars["keys"].([]string); ok {
+		for _, k := range keys {
+			if !d.docs[k] {
+				// Removing a document which does not exist returns null
+				cursor.docs = append(cursor.docs, upsertResult{})
+				continue
+			}
+			delete(d.docs, k)
+			cursor.docs = append(cursor.docs, upsertResult{Key: k, Rev: "rev-" + k})
+		}
+	}
+
+	return cursor, nil
+}
+
+type fakeCursor struct {
+	driver.Cursor
+	docs []upsertResult
+}
+
+func (c *fakeCursor) ReadDocument(ctx context.Context, result interface{}) (driver.DocumentMeta, error) {
+	if len(c.docs) == 0 {
+		return driver.DocumentMeta{}, driver.NoMoreDocumentsError{}
+	}
+	b, _ := json.Marshal(c.docs[0])
+	c.docs = c.docs[1:]
+
+	return driver.DocumentMeta{}, json.Unmarshal(b, result)
+}
+
+func (c *fakeCursor) Close() error {
+	return nil
+}
+
+func newTestCollection(db driver.Database) *collection {
+	return &collection{
+		arango:         &arangoDB{ArangoConn: &ArangoConn{db: db}},
+		collectionType: bmp.UnicastPrefixV4Msg,
+		properties:     &collectionProperties{name: "unicast_prefix_v4"},
+		stats:          &stats{},
+	}
+}
+
+func TestBatchWorker(t *testing.T) {
+	tests := []struct {
+		name    string
+		docs    map[string]bool
+		err     error
+		batch   map[string]string
+		queries int
+		expect  map[string]actionType
+		revs    map[string]string
+		failed  bool
+	}{
+		{
+			name:    "upserts and removes",
+			docs:    map[string]bool{"existing": true, "removed": true},
+			batch:   map[string]string{"existing": "add", "new": "add", "removed": "del", "missing": "del"},
+			queries: 2,
+			expect:  map[string]actionType{"existing": updateAction, "new": addAction, "removed": delAction, "missing": delAction},
+			revs:    map[string]string{"existing": "rev-existing", "new": "rev-new", "removed": "rev-removed", "missing": ""},
+		},
+		{
+			name:    "upserts only",
+			docs:    map[string]bool{},
+			batch:   map[string]string{"new": "add"},
+			queries: 1,
+			expect:  map[string]actionType{"new": addAction},
+			revs:    map[string]string{"new": "rev-new"},
+		},
+		{
+			name:    "whole batch fails",
+			docs:    map[string]bool{},
+			err:     errors.New("connection refused"),
+			batch:   map[string]string{"new": "add", "removed": "del"},
+			queries: 2,
+			expect:  map[string]actionType{"new": addAction, "removed": delAction},
+			failed:  true,
+		},
+	}
+	for _, tt := range tests {
+		db := &fakeDatabase{docs: tt.docs, err: tt.err}
+		c := newTestCollection(db)
+		batch := make([]*batchItem, 0, len(tt.batch))
+		for k, action := range tt.batch {
+			batch = append(batch, &batchItem{key: k, object: &unicastPrefixArangoMessage{UnicastPrefix: &message.UnicastPrefix{Action: action}}})
+		}
+		done := make(chan *result, len(batch))
+		tokens := make(chan struct{}, 1)
+		tokens <- struct{}{}
+		c.batchWorker(batch, done, tokens)
+		close(done)
+		if len(db.queries) != tt.queries {
+			t.Errorf("%s: expected %d queries, actual %d: %s", tt.name, tt.queries, len(db.queries), strings.Join(db.queries, "; "))
+		}
+		results := 0
+		for r := range done {
+			results++
+			if r.action != tt.expect[r.key] {
+				t.Errorf("%s: key %s expected action %s, actual %s", tt.name, r.key, tt.expect[r.key], r.action)
+			}
+			if (r.err != nil) != tt.failed {
+				t.Errorf("%s: key %s expected failure %t, actual error %v", tt.name, r.key, tt.failed, r.err)
+			}
+			if !tt.failed && r.rev != tt.revs[r.key] {
+				t.Errorf("%s: key %s expected revision %q, actual %q", tt.name, r.key, tt.revs[r.key], r.rev)
+			}
+		}
+		if results != len(batch) {
+			t.Errorf("%s: expected %d results, actual %d", tt.name, len(batch), results)
+		}
+	}
+}
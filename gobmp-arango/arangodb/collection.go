@@ -23,17 +23,24 @@
 package arangodb
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	driver "github.com/arangodb/go-driver"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/deadletter"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	metrics "github.com/cisco-open/jalapeno/gobmp-arango/stats"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/atomic"
 )
 
@@ -70,20 +77,39 @@ type result struct {
 	object DBRecord
 	key    string
 	action actionType
+	// rev is the revision of the document returned by the write, for removals it is the revision
+	// of the removed document, empty if the document did not exist.
+	rev string
+	// newDoc and oldDoc carry the document after and before the change, they are requested
+	// from the database only when events carry documents.
+	newDoc json.RawMessage
+	oldDoc json.RawMessage
 	err    error
 }
 
 type queueMsg struct {
+	// ctx carries the trace context of the message
+	ctx     context.Context
 	msgType dbclient.CollectionType
 	msgData []byte
+	// ack, if not nil, is called when the message has been persisted or discarded
+	ack func()
+	// seq numbers messages in the order the collection handler received them
+	seq uint64
 }
 
 type stats struct {
 	total atomic.Int64
 }
 
+const (
+	// eventQueueSize defines the number of events waiting to be sent before the handler blocks
+	eventQueueSize = 1024
+)
+
 type collection struct {
 	queue           chan *queueMsg
+	retry           chan *result
 	stats           *stats
 	stop            chan struct{}
 	topicCollection driver.Collection
@@ -91,6 +117,18 @@ type collection struct {
 	handler         func()
 	arango          *arangoDB
 	properties      *collectionProperties
+	// events carries change events to be sent in the order the changes were persisted
+	events chan *kafkanotifier.EventMessage
+	// history, when not nil, records changes of the collection's documents
+	history *history
+	// pending counts messages of the collection which are queued, in the backlog or being written,
+	// it is bounded by Config.QueueSize
+	pending atomic.Int64
+	// sweeps carries requests to remove documents of a peer, swept carries sweeps which are completed
+	sweeps chan *sweep
+	swept  chan *sweep
+	// heartbeat reports that the handler is processing messages
+	heartbeat *health.Heartbeat
 }
 
 const (
@@ -103,10 +141,9 @@ func (c *collection) processError(r *result) bool {
 	switch {
 	// Condition when a collection was deleted while the gobmp-arango was running
 	case driver.IsArangoErrorWithErrorNum(r.err, driver.ErrArangoDataSourceNotFound, ErrArangoGraphNotFound):
-		if err := c.arango.ensureCollection(c.properties, c.collectionType); err != nil {
-			return true
-		}
-		return false
+		var err error
+		c.heartbeat.While(func() { err = c.arango.ensureCollection(c.properties, c.collectionType) })
+		return err != nil
 	case driver.IsPreconditionFailed(r.err):
 		glog.Errorf("precondition for %+v failed", r.key)
 		return false
@@ -116,10 +153,6 @@ func (c *collection) processError(r *result) bool {
 	}
 }
 
-var (
-	backlogCheckInterval = time.Millisecond * 500
-)
-
 func (c *collection) genericHandler() {
 	glog.Infof("Starting handler for type: %d", c.collectionType)
 	// keyStore is used to track duplicate key in messages, duplicate key means there is already in processing
@@ -131,19 +164,192 @@ func (c *collection) genericHandler() {
 	// conflicting database changes, each go routine processes a message with the unique key.
 	tokens := make(chan struct{}, concurrentWorkers)
 	done := make(chan *result, concurrentWorkers*2)
-	backlogTicker := time.NewTicker(backlogCheckInterval)
+	// inflight stores original messages of records being processed or waiting in the backlog, the message
+	// is needed to acknowledge the record once it is persisted, or to dead-letter its original payload.
+	inflight := make(map[DBRecord]*queueMsg)
+	// attempts stores the number of failed attempts of the record currently processed for the key,
+	// while the record waits for its retry, the key stays busy so the order of changes is preserved.
+	attempts := make(map[string]int)
+	// batch accumulates records of distinct keys which are ready to be written, when batching is enabled
+	// the batch is written when it reaches the configured size or when the flush interval expires.
+	batchSize := c.arango.config.BatchSize
+	batch := make([]*batchItem, 0, batchSize)
+	flushTicker := time.NewTicker(c.arango.config.BatchInterval)
+	defer flushTicker.Stop()
+	heartbeatTicker := time.NewTicker(health.HeartbeatInterval)
+	defer heartbeatTicker.Stop()
+	// backlogDepth counts records stored in the backlog of all keys
+	backlogDepth := 0
+	// received counts messages received by the handler, it is used to number the messages
+	received := uint64(0)
+	// order holds sequence numbers of messages in inflight in the order they were received, settled marks
+	// messages which left inflight before older ones, so the oldest message in flight is known without a scan.
+	order := make([]uint64, 0)
+	settled := make(map[uint64]bool)
+	// sweeps holds sweeps of the collection requested from the handler, they wait for records received
+	// before them to be persisted. New records are not taken while a peer down sweep is pending.
+	sweeps := make([]*pendingSweep, 0)
+	// msgContext returns the trace context of the message the record was built from
+	msgContext := func(o DBRecord) context.Context {
+		if m, ok := inflight[o]; ok && m.ctx != nil {
+			return m.ctx
+		}
+		return context.Background()
+	}
+	// retries holds timers of records waiting for their retry, they are stopped when the client stops
+	retries := make(map[*result]*time.Timer)
+	// work runs the worker in a goroutine the client waits for when it stops
+	work := func(worker func()) {
+		c.arango.wg.Add(1)
+		go func() {
+			defer c.arango.wg.Done()
+			worker()
+		}()
+	}
+	// acquire takes a token, while all workers are busy with slow writes the handler keeps beating
+	acquire := func() {
+		select {
+		case tokens <- struct{}{}:
+		default:
+			c.heartbeat.While(func() { tokens <- struct{}{} })
+		}
+	}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		acquire()
+		b := batch
+		work(func() { c.batchWorker(b, done, tokens) })
+		batch = make([]*batchItem, 0, batchSize)
+	}
+	// dispatch marks the key as busy and either adds the record to the batch or starts a worker for it
+	dispatch := func(k string, o DBRecord) {
+		keyStore[k] = true
+		if batchSize <= 1 {
+			// Depositing one token and calling worker to process message for the key
+			acquire()
+			ctx := msgContext(o)
+			work(func() { c.genericWorker(ctx, k, o, done, tokens) })
+			return
+		}
+		batch = append(batch, &batchItem{ctx: msgContext(o), key: k, object: o})
+		if len(batch) >= batchSize {
+			flush()
+		}
+	}
+	// track adds the message of the record to inflight
+	track := func(o DBRecord, m *queueMsg) {
+		inflight[o] = m
+		order = append(order, m.seq)
+	}
+	// untrack removes the message of the record from inflight
+	untrack := func(o DBRecord, m *queueMsg) {
+		delete(inflight, o)
+		settled[m.seq] = true
+		for len(order) != 0 && settled[order[0]] {
+			delete(settled, order[0])
+			order = order[1:]
+		}
+	}
+	// oldest returns the sequence number of the oldest message in flight, or the number of the next
+	// message when nothing is in flight
+	oldest := func() uint64 {
+		if len(order) == 0 {
+			return received + 1
+		}
+		return order[0]
+	}
+	// release completes the processing of the record and starts processing of the next record
+	// for the same key if there is one in the backlog.
+	release := func(k string, o DBRecord) {
+		if m, ok := inflight[o]; ok {
+			if m.ack != nil {
+				m.ack()
+			}
+			untrack(o, m)
+		}
+		delete(attempts, k)
+		delete(keyStore, k)
+		// Check if there an entry for this key in the backlog, if there is, retrieve it and process it
+		b, ok := backlog[k]
+		if !ok {
+			return
+		}
+		bo := b.Pop()
+		if bo != nil {
+			backlogDepth--
+			dispatch(k, bo)
+		}
+		// If Backlog for a specific key is empty, remove it from the backlog
+		if b.Len() == 0 {
+			delete(backlog, k)
+		}
+	}
+	// checkSweeps notifies sweeps once records received before them are persisted, otherwise a document
+	// still waiting to be written could be written again after its removal.
+	checkSweeps := func() {
+		o := oldest()
+		for _, p := range sweeps {
+			if !p.arrived && p.seq < o {
+				p.arrived = true
+				p.arrive()
+			}
+		}
+	}
 	for {
+		c.heartbeat.Beat()
+		// While a peer down sweep is pending, records are left in the queue
+		queue := c.queue
+		for _, p := range sweeps {
+			if p.exclusive {
+				queue = nil
+			}
+		}
+		metrics.QueueDepth.WithLabelValues(c.arango.config.Database, c.properties.name).Set(float64(len(inflight)))
+		metrics.BacklogDepth.WithLabelValues(c.arango.config.Database, c.properties.name).Set(float64(backlogDepth))
+		metrics.WorkersInUse.WithLabelValues(c.arango.config.Database, c.properties.name).Set(float64(len(tokens)))
 		select {
-		case m := <-c.queue:
-			backlogTicker.Reset(backlogCheckInterval)
+		case m := <-queue:
+			_, span := tracing.Start(m.ctx, "transform "+c.properties.name)
 			o, err := newDBRecord(m.msgData, c.collectionType)
 			if err != nil {
 				glog.Errorf("failed to unmarshal message of type %d with error: %+v", c.collectionType, err)
+				metrics.MessagesFailed.WithLabelValues(c.arango.config.Database, c.properties.name, "parse").Inc()
+				tracing.End(span, err)
+				c.discard("", m.msgData, err, 0, m.ack)
+				continue
+			}
+			received++
+			m.seq = received
+			c.consumed(o)
+			if rec, ok := c.endOfRIB(o); ok {
+				// End-of-RIB carries no route and is not stored, stale documents are removed once records
+				// received before it are written.
+				span.AddEvent("End-of-RIB")
+				span.End()
+				if m.ack != nil {
+					m.ack()
+				}
+				c.arango.resync.endOfRIB(c, rec)
 				continue
 			}
-			k := o.MakeKey()
+			track(o, m)
+			k, err := c.makeKey(o, m.msgData)
+			if err != nil {
+				glog.Errorf("failed to build the key of message of type %d with error: %+v", c.collectionType, err)
+				metrics.MessagesFailed.WithLabelValues(c.arango.config.Database, c.properties.name, "parse").Inc()
+				untrack(o, m)
+				tracing.End(span, err)
+				c.discard("", m.msgData, err, 0, m.ack)
+				continue
+			}
+			span.SetAttributes(attribute.String("jalapeno.key", k))
 			busy, ok := keyStore[k]
 			if ok && busy {
+				// The time the record waits for the previous change of the key shows as the gap before its write
+				span.AddEvent("waiting for the previous change of the key")
+				span.End()
 				// Check if there is already a backlog for this key, if not then create it
 				b, ok := backlog[k]
 				if !ok {
@@ -152,164 +358,408 @@ func (c *collection) genericHandler() {
 				// Saving message in the backlog
 				b.Push(o)
 				backlog[k] = b
+				backlogDepth++
 				continue
 			}
-			// Depositing one token and calling worker to process message for the key
-			tokens <- struct{}{}
-			keyStore[k] = true
-			go c.genericWorker(k, o, done, tokens)
+			span.End()
+			dispatch(k, o)
 		case r := <-done:
-			backlogTicker.Reset(backlogCheckInterval)
 			if r.err != nil {
 				// Error was encountered during processing of the key, attempting to correct the error condition
-				// and pushing failed DBObject to the backlog queue
+				// and scheduling a retry of the failed record
 				if c.processError(r) {
 					glog.Errorf("genericWorker for key: %s reported a fatal error: %+v", r.key, r.err)
 				} else {
 					glog.Errorf("genericWorker for key: %s reported a non fatal error: %+v", r.key, r.err)
 				}
-				glog.Infof("Pushing key: %s to the backlog after the failure", r.key)
-				b, ok := backlog[r.key]
-				if !ok {
-					b = newFIFO()
+				metrics.MessagesFailed.WithLabelValues(c.arango.config.Database, c.properties.name, "write").Inc()
+				attempts[r.key]++
+				// Without a dead letter topic the record is retried until it is stored, otherwise it would be
+				// acknowledged and lost. When publishing the dead letter record fails, the record is retried.
+				if c.arango.config.DeadLetter != nil && c.arango.config.MaxRetries != 0 && attempts[r.key] > c.arango.config.MaxRetries {
+					glog.Errorf("key: %s exhausted %d retries, dead-lettering the record", r.key, c.arango.config.MaxRetries)
+					var payload []byte
+					if m, ok := inflight[r.object]; ok {
+						payload = m.msgData
+					}
+					if c.deadLetter(r.key, payload, r.err, attempts[r.key]) {
+						metrics.MessagesFailed.WithLabelValues(c.arango.config.Database, c.properties.name, "dead_letter").Inc()
+						release(r.key, r.object)
+						checkSweeps()
+						continue
+					}
 				}
-				// Saving message in the backlog
-				b.Push(r.object)
-				backlog[r.key] = b
+				delay := retryBackoff(c.arango.config.RetryBackoff, attempts[r.key])
+				glog.Infof("Retrying key: %s in %s, attempt: %d", r.key, delay, attempts[r.key])
+				c.arango.wg.Add(1)
+				retries[r] = time.AfterFunc(delay, func() {
+					defer c.arango.wg.Done()
+					select {
+					case c.retry <- r:
+					case <-c.stop:
+					}
+				})
 				continue
 			}
-			if c.arango.notifyCompletion && c.arango.notifier != nil {
-				go c.reliableNotifier(r)
-				// Need to dispatch a go routine which should ensure that the record has been stored in DB and only then to send the notification
-
-				// m := &kafkanotifier.EventMessage{
-				// 	TopicType: c.collectionType,
-				// 	Key:       r.key,
-				// 	ID:        c.properties.name + "/" + r.key,
-				// 	Action:    r.action,
-				// }
-				// if err := c.arango.notifier.EventNotification(m); err != nil {
-				// 	glog.Errorf("genericWorker for key: %s failed to send notification with error: %+v", r.key, r.err)
-				// }
+			// The write has returned, the change is persisted and the event can be sent
+			c.changed(msgContext(r.object), r)
+			if p, ok := r.object.(*peerStateChangeArangoMessage); ok {
+				c.peerStateChanged(p, r.action)
 			}
-			delete(keyStore, r.key)
-			// Check if there an entry for this key in the backlog, if there is, retrieve it and process it
-			b, ok := backlog[r.key]
-			if !ok {
-				continue
-			}
-			bo := b.Pop()
-			if bo != nil {
-				tokens <- struct{}{}
-				keyStore[r.key] = true
-				go c.genericWorker(r.key, bo, done, tokens)
-			}
-			// If Backlog for a specific key is empty, remove it from the backlog
-			if b.Len() == 0 {
-				delete(backlog, r.key)
+			// The record has been persisted, acknowledging it and moving to the next record for the key
+			release(r.key, r.object)
+			checkSweeps()
+		case r := <-c.retry:
+			delete(retries, r)
+			// The key is still marked as busy, only a token is required to process the record again,
+			// retries are not batched so a failing record cannot fail other records.
+			acquire()
+			ctx := msgContext(r.object)
+			work(func() { c.genericWorker(ctx, r.key, r.object, done, tokens) })
+		case s := <-c.sweeps:
+			// Records received before the sweep are written without waiting for the batch interval
+			flush()
+			sweeps = append(sweeps, &pendingSweep{sweep: s, seq: received})
+			checkSweeps()
+		case s := <-c.swept:
+			for i := range sweeps {
+				if sweeps[i].sweep == s {
+					sweeps = append(sweeps[:i], sweeps[i+1:]...)
+					break
+				}
 			}
+		case <-flushTicker.C:
+			flush()
+		case <-heartbeatTicker.C:
 		case <-c.stop:
-			backlogTicker.Stop()
-			return
-		case <-backlogTicker.C:
-			// If loop is idle for backlogCheckInterval, trying to process any outstanding items stored in the backlog
-			for key, b := range backlog {
-				glog.Infof("Extracted key %s from backlog (backlogTicker)", key)
-				bo := b.Pop()
-				if bo != nil {
-					tokens <- struct{}{}
-					keyStore[key] = true
-					go c.genericWorker(key, bo, done, tokens)
-				}
-				// If Backlog for a specific key is empty, remove it from the backlog
-				if b.Len() == 0 {
-					delete(backlog, key)
+			// A retry whose timer has not fired is not sent, its record is not acknowledged
+			for _, t := range retries {
+				if t.Stop() {
+					c.arango.wg.Done()
 				}
-				// Processing a single item per backlogTicker cycle
-				break
 			}
+			return
 		}
 	}
 }
 
-// reliableNotifier is function which is called if gobmp-arango needs to send an event out notifying
-// about topology change event.
-func (c *collection) reliableNotifier(r *result) {
-	// The check for the DB record state will be done every 1000 milliseconds
-	ticker := time.NewTicker(time.Millisecond * 1000)
-	defer func() {
-		ticker.Stop()
+// changed sends the event and records the history of a persisted change, the event carries the
+// trace context of the message which caused the change
+func (c *collection) changed(ctx context.Context, r *result) {
+	if c.arango.notifyCompletion && c.arango.notifier != nil {
+		c.notify(ctx, r)
+	}
+	if c.history != nil {
+		c.recordHistory(r)
+	}
+}
+
+// retryBackoff returns the delay before the attempt, the delay doubles with every attempt
+// and is capped by maxRetryBackoff.
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+	return delay
+}
+
+// deadLetter publishes the original message which could not be parsed or stored to the dead letter topic,
+// when no dead letter publisher is configured, the message is logged and dropped. It returns false when
+// the record could not be published, the message must then not be acknowledged.
+func (c *collection) deadLetter(key string, payload []byte, err error, attempts int) bool {
+	if c.arango.config.DeadLetter == nil {
+		glog.Errorf("dropping message of type %d, key: %s, error: %+v, payload: %s", c.collectionType, key, err, string(payload))
+		return true
+	}
+	r := &deadletter.Record{
+		Topic:          dbclient.TopicName(c.collectionType),
+		CollectionType: c.collectionType,
+		Key:            key,
+		Error:          err.Error(),
+		Attempts:       attempts,
+		Timestamp:      time.Now(),
+		Payload:        payload,
+	}
+	if e := c.arango.config.DeadLetter.Publish(r); e != nil {
+		glog.Errorf("failed to publish dead letter record for key: %s with error: %+v, payload: %s", key, e, string(payload))
+		return false
+	}
+
+	return true
+}
+
+// discard dead-letters a message which can never be stored and acknowledges it once the dead letter record
+// is published. Publishing is retried until it succeeds or the client stops, an unpublished message is not
+// acknowledged and is consumed again after a restart.
+func (c *collection) discard(key string, payload []byte, err error, attempts int, ack func()) {
+	done := func() {
+		if ack != nil {
+			ack()
+		}
+	}
+	if c.deadLetter(key, payload, err, attempts) {
+		done()
+		return
+	}
+	c.arango.wg.Add(1)
+	go func() {
+		defer c.arango.wg.Done()
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-time.After(retryBackoff(c.arango.config.RetryBackoff, attempt)):
+			case <-c.stop:
+				return
+			}
+			if c.deadLetter(key, payload, err, attempts) {
+				done()
+				return
+			}
+		}
 	}()
+}
+
+// notify queues the event of the change, since a key stays busy until its result is processed, events of
+// the same key are queued in the order of changes.
+func (c *collection) notify(ctx context.Context, r *result) {
+	if r.action == unknownAction {
+		return
+	}
 	m := &kafkanotifier.EventMessage{
-		TopicType: c.collectionType,
-		Key:       r.key,
-		ID:        c.properties.name + "/" + r.key,
-		Action:    string(r.action),
+		TopicType:    c.collectionType,
+		Database:     c.arango.config.Database,
+		Key:          r.key,
+		ID:           c.properties.name + "/" + r.key,
+		Rev:          r.rev,
+		Sequence:     revSequence(r.rev, r.action),
+		Action:       string(r.action),
+		TraceContext: tracing.Inject(ctx),
 	}
-notify:
-	for {
-		found, err := c.topicCollection.DocumentExists(context.TODO(), r.key)
-		if err != nil {
-			glog.Errorf("reliableNotifier for key: %s failed to check the state of the document with error: %+v", r.key, err)
-			return
-		}
+	if c.richEvents() {
 		switch r.action {
 		case addAction:
-			fallthrough
+			m.Document = r.newDoc
 		case updateAction:
-			// For add and update actions, the record must be "readable" from the collection,
-			// if collection read error Document not found, it means DB has not yet written it
-			// any other error terminates notifier and generate the error message
-			if found {
-				break notify
+			m.Document = r.newDoc
+			if c.arango.config.EventPayload == kafkanotifier.PayloadFull {
+				m.Previous = r.oldDoc
+			} else {
+				m.ChangedFields = changedFields(r.oldDoc, r.newDoc)
 			}
 		case delAction:
-			// For del action, the read opration should return Document not found, if no error received
-			//
-			if !found {
-				break notify
-			}
+			fallthrough
 		case downAction:
-			// For down action, the read opration should return Document not found, if no error received
-			//
-			if !found {
-				break notify
-			}
-		case unknownAction:
-			return
+			m.Previous = r.oldDoc
+		}
+	}
+	c.queueEvent(m)
+}
+
+// revAlphabet is the alphabet ArangoDB encodes hybrid logical clock revisions with, 6 bits per character
+const revAlphabet = "-_ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// revSequence returns the sequence number of the change, it is the revision of the document decoded to the
+// hybrid logical clock value ArangoDB assigned to it. The database assigns increasing revisions to changes
+// of a document whichever client and process wrote them, so sequence numbers keep increasing across
+// restarts and members of a consumer group. A removal gets the revision of the removed document plus one,
+// it is newer than the last change. 0, which consumers do not filter, is returned for an unknown revision.
+func revSequence(rev string, action actionType) uint64 {
+	if rev == "" || len(rev) > 11 {
+		return 0
+	}
+	var v uint64
+	for i := 0; i < len(rev); i++ {
+		d := strings.IndexByte(revAlphabet, rev[i])
+		if d < 0 {
+			return 0
 		}
+		v = v<<6 | uint64(d)
+	}
+	switch action {
+	case delAction, downAction:
+		v++
+	}
+
+	return v
+}
+
+// queueEvent queues the event for the event notifier, when the client is stopping, the event is kept
+// for the spool file. While the queue is full, the handler keeps beating.
+func (c *collection) queueEvent(m *kafkanotifier.EventMessage) {
+	c.arango.unsent.Add(1)
+	select {
+	case c.events <- m:
+		return
+	default:
+	}
+	c.heartbeat.While(func() {
 		select {
+		case c.events <- m:
 		case <-c.stop:
-			return
-		case <-ticker.C:
+			c.arango.unsent.Add(-1)
+			c.arango.spill(&spoolRecord{Event: m})
+		}
+	})
+}
+
+// richEvents returns true when events carry documents
+func (c *collection) richEvents() bool {
+	return c.arango.config.EventPayload == kafkanotifier.PayloadFull || c.arango.config.EventPayload == kafkanotifier.PayloadDiff
+}
+
+// changedFields returns sorted names of top level fields which differ between the documents,
+// fields present in only one of the documents are considered changed.
+func changedFields(oldDoc, newDoc json.RawMessage) []string {
+	o := make(map[string]json.RawMessage)
+	n := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(oldDoc, &o); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(newDoc, &n); err != nil {
+		return nil
+	}
+	fields := make([]string, 0)
+	for k, v := range n {
+		if k == "_rev" {
 			continue
 		}
+		if ov, ok := o[k]; !ok || !bytes.Equal(ov, v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range o {
+		if _, ok := n[k]; !ok && k != "_rev" {
+			fields = append(fields, k)
+		}
 	}
-	if err := c.arango.notifier.EventNotification(m); err != nil {
-		glog.Errorf("reliableNotifier for key: %s failed to send notification with error: %+v", r.key, err)
+	sort.Strings(fields)
+
+	return fields
+}
+
+// eventNotifier sends queued events one by one, preserving the order of changes
+func (c *collection) eventNotifier() {
+	defer c.arango.wg.Done()
+	for {
+		select {
+		case m := <-c.events:
+			// Notifiers propagate the trace context of the span to consumers of the event
+			ctx, span := tracing.Start(tracing.Extract(m.TraceContext), "notify "+c.properties.name, attribute.String("jalapeno.key", m.Key))
+			m.TraceContext = tracing.Inject(ctx)
+			err := c.arango.notifier.EventNotification(m)
+			tracing.End(span, err)
+			c.arango.unsent.Add(-1)
+			if err != nil {
+				glog.Errorf("failed to send notification for key: %s sequence: %d with error: %+v", m.Key, m.Sequence, err)
+				metrics.Notifications.WithLabelValues(c.arango.config.Database, c.properties.name, "failure").Inc()
+				continue
+			}
+			metrics.Notifications.WithLabelValues(c.arango.config.Database, c.properties.name, "success").Inc()
+		case <-c.stop:
+			return
+		}
 	}
 }
 
-func (c *collection) genericWorker(k string, o DBRecord, done chan *result, tokens chan struct{}) {
+func (c *collection) genericWorker(ctx context.Context, k string, o DBRecord, done chan *result, tokens chan struct{}) {
 	var err error
 	var action actionType
+	var meta driver.DocumentMeta
+	var newDoc, oldDoc json.RawMessage
+	ctx, span := tracing.StartDB(ctx, "write", c.properties.name, attribute.String("jalapeno.key", k))
 	defer func() {
+		span.SetAttributes(attribute.String("jalapeno.action", string(action)))
+		tracing.End(span, err)
 		<-tokens
-		done <- &result{object: o, key: k, action: action, err: err}
+		done <- &result{object: o, key: k, action: action, rev: meta.Rev, newDoc: newDoc, oldDoc: oldDoc, err: err}
 		if err == nil {
 			c.stats.total.Add(1)
+			metrics.MessagesPersisted.WithLabelValues(c.arango.config.Database, c.properties.name).Inc()
 		}
 		glog.V(6).Infof("done key: %s, type: %d total messages: %s", k, c.collectionType, c.stats.total.String())
 	}()
-	ctx := context.TODO()
+	var obj interface{}
+	obj, action, err = c.prepareRecord(k, o)
+	if err != nil {
+		return
+	}
+	switch action {
+	case "add":
+		start := time.Now()
+		var e error
+		meta, e = c.topicCollection.CreateDocument(c.returnDocs(ctx, &newDoc, nil), obj)
+		metrics.ObserveOperation(c.arango.config.Database, c.properties.name, "create", start)
+		if e != nil {
+			switch {
+			// The following 2 types of errors inidcate that the document by the key already
+			// exists, no need to fail but instead call Update of the document.
+			case driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoConflict):
+			case driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoUniqueConstraintViolated):
+			default:
+				err = e
+			}
+			start = time.Now()
+			meta, e = c.topicCollection.UpdateDocument(c.returnDocs(ctx, &newDoc, &oldDoc), k, obj)
+			metrics.ObserveOperation(c.arango.config.Database, c.properties.name, "update", start)
+			if e != nil {
+				err = e
+				break
+			}
+			// Fixing action in result message to the actual action occured
+			action = "update"
+		}
+	case "del":
+		start := time.Now()
+		var e error
+		meta, e = c.topicCollection.RemoveDocument(c.returnDocs(ctx, nil, &oldDoc), k)
+		metrics.ObserveOperation(c.arango.config.Database, c.properties.name, "remove", start)
+		if e != nil {
+			if !driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoDocumentNotFound) {
+				err = e
+			}
+		}
+	case "down":
+		start := time.Now()
+		var e error
+		meta, e = c.topicCollection.RemoveDocument(c.returnDocs(ctx, nil, &oldDoc), k)
+		metrics.ObserveOperation(c.arango.config.Database, c.properties.name, "remove", start)
+		if e != nil {
+			if !driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoDocumentNotFound) {
+				err = e
+			}
+		}
+	}
+}
+
+// returnDocs requests the database to return the document after and before the change when events carry documents
+func (c *collection) returnDocs(ctx context.Context, newDoc, oldDoc *json.RawMessage) context.Context {
+	if !c.richEvents() {
+		return ctx
+	}
+	if newDoc != nil {
+		ctx = driver.WithReturnNew(ctx, newDoc)
+	}
+	if oldDoc != nil {
+		ctx = driver.WithReturnOld(ctx, oldDoc)
+	}
+
+	return ctx
+}
+
+// prepareRecord recovers the message specific type of the record, sets the document's key and id
+// and returns the document along with the action requested by the message.
+func (c *collection) prepareRecord(k string, o DBRecord) (interface{}, actionType, error) {
 	var obj interface{}
 	var ok bool
+	var action actionType
 	switch c.collectionType {
 	case bmp.PeerStateChangeMsg:
 		obj, ok = o.(*peerStateChangeArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover peerStateChangeArangoMessage from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover peerStateChangeArangoMessage from DBRecord interface")
 		}
 		obj.(*peerStateChangeArangoMessage).Key = k
 		obj.(*peerStateChangeArangoMessage).ID = c.properties.name + "/" + k
@@ -317,8 +767,7 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.LSLinkMsg:
 		obj, ok = o.(*lsLinkArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover lsLinkArangoMessage from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover lsLinkArangoMessage from DBRecord interface")
 		}
 		obj.(*lsLinkArangoMessage).Key = k
 		obj.(*lsLinkArangoMessage).ID = c.properties.name + "/" + k
@@ -326,8 +775,7 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.LSNodeMsg:
 		obj, ok = o.(*lsNodeArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover lsNodeArangoMessage from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover lsNodeArangoMessage from DBRecord interface")
 		}
 		obj.(*lsNodeArangoMessage).Key = k
 		obj.(*lsNodeArangoMessage).ID = c.properties.name + "/" + k
@@ -335,8 +783,7 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.LSPrefixMsg:
 		obj, ok = o.(*lsPrefixArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover lsPrefixArangoMessage from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover lsPrefixArangoMessage from DBRecord interface")
 		}
 		obj.(*lsPrefixArangoMessage).Key = k
 		obj.(*lsPrefixArangoMessage).ID = c.properties.name + "/" + k
@@ -344,8 +791,7 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.LSSRv6SIDMsg:
 		obj, ok = o.(*lsSRv6SIDArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover lsSRv6SIDArangoMessage from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover lsSRv6SIDArangoMessage from DBRecord interface")
 		}
 		obj.(*lsSRv6SIDArangoMessage).Key = k
 		obj.(*lsSRv6SIDArangoMessage).ID = c.properties.name + "/" + k
@@ -357,12 +803,19 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.L3VPNV6Msg:
 		obj, ok = o.(*l3VPNArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover l3VPNArangoMessage from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover l3VPNArangoMessage from DBRecord interface")
 		}
 		obj.(*l3VPNArangoMessage).Key = k
 		obj.(*l3VPNArangoMessage).ID = c.properties.name + "/" + k
 		action = newAction(obj.(*l3VPNArangoMessage).Action)
+	case bmp.EVPNMsg:
+		obj, ok = o.(*evpnArangoMessage)
+		if !ok {
+			return nil, unknownAction, fmt.Errorf("failed to recover evpnArangoMessage from DBRecord interface")
+		}
+		obj.(*evpnArangoMessage).Key = k
+		obj.(*evpnArangoMessage).ID = c.properties.name + "/" + k
+		action = newAction(obj.(*evpnArangoMessage).Action)
 	case bmp.UnicastPrefixMsg:
 		fallthrough
 	case bmp.UnicastPrefixV4Msg:
@@ -370,8 +823,7 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.UnicastPrefixV6Msg:
 		obj, ok = o.(*unicastPrefixArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover unicastPrefixArangoMessage from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover unicastPrefixArangoMessage from DBRecord interface")
 		}
 		obj.(*unicastPrefixArangoMessage).Key = k
 		obj.(*unicastPrefixArangoMessage).ID = c.properties.name + "/" + k
@@ -383,8 +835,7 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.SRPolicyV6Msg:
 		obj, ok = o.(*srPolicyArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover SRPolicy from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover SRPolicy from DBRecord interface")
 		}
 		obj.(*srPolicyArangoMessage).Key = k
 		obj.(*srPolicyArangoMessage).ID = c.properties.name + "/" + k
@@ -396,47 +847,71 @@ func (c *collection) genericWorker(k string, o DBRecord, done chan *result, toke
 	case bmp.FlowspecV6Msg:
 		obj, ok = o.(*flowspecArangoMessage)
 		if !ok {
-			err = fmt.Errorf("failed to recover Flowspec Arango Message from DBRecord interface")
-			return
+			return nil, unknownAction, fmt.Errorf("failed to recover Flowspec Arango Message from DBRecord interface")
 		}
 		obj.(*flowspecArangoMessage).Key = k
 		obj.(*flowspecArangoMessage).ID = c.properties.name + "/" + k
 		action = newAction(obj.(*flowspecArangoMessage).Action)
 	default:
-		err = fmt.Errorf("unknown collection type %d", c.collectionType)
-		return
+		return nil, unknownAction, fmt.Errorf("unknown collection type %d", c.collectionType)
 	}
-	switch action {
-	case "add":
-		if _, e := c.topicCollection.CreateDocument(ctx, obj); e != nil {
-			switch {
-			// The following 2 types of errors inidcate that the document by the key already
-			// exists, no need to fail but instead call Update of the document.
-			case driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoConflict):
-			case driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoUniqueConstraintViolated):
-			default:
-				err = e
+	if r := c.arango.resync; r != nil {
+		switch rec := obj.(type) {
+		case *peerStateChangeArangoMessage:
+			// The generation is assigned once, a retried peer up keeps its generation
+			if action == addAction && rec.Generation == 0 {
+				rec.Generation = r.nextGeneration()
 			}
-			if _, e := c.topicCollection.UpdateDocument(ctx, k, obj); e != nil {
-				err = e
-				break
+		case resyncRecord:
+			if r.types[c.collectionType] && action != delAction {
+				r.stamp(rec)
 			}
-			// Fixing action in result message to the actual action occured
-			action = "update"
 		}
-	case "del":
-		if _, e := c.topicCollection.RemoveDocument(ctx, k); e != nil {
-			if !driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoDocumentNotFound) {
-				err = e
-			}
-		}
-	case "down":
-		if _, e := c.topicCollection.RemoveDocument(ctx, k); e != nil {
-			if !driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoDocumentNotFound) {
-				err = e
-			}
+	}
+
+	return obj, action, nil
+}
+
+// peerStateChanged starts the resynchronisation of the peer which came up, or removes documents
+// learned from the peer which went down.
+func (c *collection) peerStateChanged(p *peerStateChangeArangoMessage, action actionType) {
+	// The action of the result is update when the peer document already existed
+	up := newAction(p.Action) == addAction
+	if c.arango.resync != nil {
+		if up {
+			c.arango.resync.peerUp(p)
+		} else {
+			c.arango.resync.peerDown(p)
 		}
 	}
+	if len(c.arango.config.PeerDownCascade) != 0 && (action == delAction || action == downAction) {
+		c.arango.peerDownCascade(p)
+	}
+}
+
+// consumed postpones the removal of stale documents of the peer the record was learned from, the peer is
+// quiet when the handler receives no records from it, whatever time writing the records takes.
+func (c *collection) consumed(o DBRecord) {
+	if c.arango.resync == nil || !c.arango.resync.types[c.collectionType] {
+		return
+	}
+	if rec, ok := o.(resyncRecord); ok {
+		c.arango.resync.consumed(rec)
+	}
+}
+
+// endOfRIB returns the End-of-RIB record when the record signals End-of-RIB for a collection
+// which is resynchronised.
+func (c *collection) endOfRIB(o DBRecord) (resyncRecord, bool) {
+	if c.arango.resync == nil || !c.arango.resync.types[c.collectionType] {
+		return nil, false
+	}
+	u, ok := o.(*unicastPrefixArangoMessage)
+	if !ok || !u.IsEOR {
+		return nil, false
+	}
+
+	return u, true
 }
 
 func newDBRecord(msgData []byte, collectionType dbclient.CollectionType) (DBRecord, error) {
@@ -481,6 +956,12 @@ func newDBRecord(msgData []byte, collectionType dbclient.CollectionType) (DBReco
 			return nil, err
 		}
 		return &o, nil
+	case bmp.EVPNMsg:
+		var o evpnArangoMessage
+		if err := json.Unmarshal(msgData, &o); err != nil {
+			return nil, err
+		}
+		return &o, nil
 	case bmp.UnicastPrefixMsg:
 		fallthrough
 	case bmp.UnicastPrefixV4Msg:
@@ -500,6 +981,7 @@ func newDBRecord(msgData []byte, collectionType dbclient.CollectionType) (DBReco
 		if err := json.Unmarshal(msgData, &o); err != nil {
 			return nil, err
 		}
+		return &o, nil
 	case bmp.FlowspecMsg:
 		fallthrough
 	case bmp.FlowspecV4Msg:
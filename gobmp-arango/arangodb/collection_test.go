@@ -0,0 +1,208 @@
+package arangodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/deadletter"
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+func TestChangedFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		old    string
+		new    string
+		expect []string
+	}{
+		{
+			name:   "no changes",
+			old:    `{"_key":"1","_rev":"a","igp_metric":10}`,
+			new:    `{"_key":"1","_rev":"b","igp_metric":10}`,
+			expect: []string{},
+		},
+		{
+			name:   "changed, added and removed fields",
+			old:    `{"_key":"1","igp_metric":10,"te_metric":5,"sr_adjacency_sid":[{"sid":24000}]}`,
+			new:    `{"_key":"1","igp_metric":20,"te_metric":5,"max_link_bw":1000}`,
+			expect: []string{"igp_metric", "max_link_bw", "sr_adjacency_sid"},
+		},
+		{
+			name:   "nested field changed",
+			old:    `{"_key":"1","base_attrs":{"local_pref":100}}`,
+			new:    `{"_key":"1","base_attrs":{"local_pref":200}}`,
+			expect: []string{"base_attrs"},
+		},
+	}
+	for _, tt := range tests {
+		got := changedFields(json.RawMessage(tt.old), json.RawMessage(tt.new))
+		if !reflect.DeepEqual(got, tt.expect) {
+			t.Fatalf("%s: expected %v, actual %v", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    time.Duration
+		attempt int
+		expect  time.Duration
+	}{
+		{name: "first attempt", base: time.Second, attempt: 1, expect: time.Second},
+		{name: "doubles", base: time.Second, attempt: 4, expect: 8 * time.Second},
+		{name: "capped", base: time.Second, attempt: 10, expect: maxRetryBackoff},
+		{name: "base above cap", base: 2 * maxRetryBackoff, attempt: 2, expect: maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(tt.base, tt.attempt); got != tt.expect {
+			t.Errorf("%s: expected %s, actual %s", tt.name, tt.expect, got)
+		}
+	}
+}
+
+type fakePublisher struct {
+	fail    int
+	records []*deadletter.Record
+}
+
+func (p *fakePublisher) Publish(r *deadletter.Record) error {
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("broker unavailable")
+	}
+	p.records = append(p.records, r)
+	return nil
+}
+
+func TestDiscard(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher *fakePublisher
+		stop      bool
+		acked     bool
+		published int
+	}{
+		{name: "no dead letter topic", acked: true},
+		{name: "published", publisher: &fakePublisher{}, acked: true, published: 1},
+		{name: "published after a failure", publisher: &fakePublisher{fail: 1}, acked: true, published: 1},
+		{name: "stopped before published", publisher: &fakePublisher{fail: 1000}, stop: true},
+	}
+	for _, tt := range tests {
+		a := &arangoDB{config: Config{RetryBackoff: time.Millisecond}}
+		if tt.publisher != nil {
+			a.config.DeadLetter = tt.publisher
+		}
+		c := &collection{arango: a, stop: make(chan struct{})}
+		acked := false
+		c.discard("1", []byte(`{}`), errors.New("malformed"), 0, func() { acked = true })
+		if tt.stop {
+			close(c.stop)
+		}
+		a.wg.Wait()
+		if acked != tt.acked {
+			t.Errorf("%s: expected acked %t, actual %t", tt.name, tt.acked, acked)
+		}
+		if tt.publisher != nil && len(tt.publisher.records) != tt.published {
+			t.Errorf("%s: expected %d published records, actual %d", tt.name, tt.published, len(tt.publisher.records))
+		}
+	}
+}
+
+func TestRevSequence(t *testing.T) {
+	first := revSequence("_gXcVxtO---", updateAction)
+	tests := []struct {
+		name   string
+		rev    string
+		action actionType
+		expect uint64
+	}{
+		{name: "next revision", rev: "_gXcVxtO--_", action: updateAction, expect: first + 1},
+		{name: "removal", rev: "_gXcVxtO---", action: delAction, expect: first + 1},
+		{name: "later revision", rev: "_gXcVxtS---", action: addAction, expect: first + 4<<18},
+		{name: "no revision", rev: "", action: delAction, expect: 0},
+		{name: "invalid revision", rev: "_gXcV/tO---", action: updateAction, expect: 0},
+	}
+	if first == 0 {
+		t.Fatalf("expected a sequence number of a valid revision, actual 0")
+	}
+	for _, tt := range tests {
+		if got := revSequence(tt.rev, tt.action); got != tt.expect {
+			t.Fatalf("%s: expected %d, actual %d", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestQueueSize(t *testing.T) {
+	c := newTestCollection(nil)
+	c.queue = make(chan *queueMsg, 2)
+	c.arango.config.QueueSize = 2
+	c.arango.collections = map[dbclient.CollectionType]*collection{c.collectionType: c}
+	for i := 0; i < 2; i++ {
+		if err := c.arango.StoreMessage(c.collectionType, []byte("{}")); err != nil {
+			t.Fatalf("message %d: unexpected error: %+v", i, err)
+		}
+	}
+	if err := c.arango.StoreMessage(c.collectionType, []byte("{}")); !errors.Is(err, flowcontrol.ErrQueueFull) {
+		t.Fatalf("expected %v, actual %v", flowcontrol.ErrQueueFull, err)
+	}
+	// Acknowledging a message makes room for the next one
+	(<-c.queue).ack()
+	if err := c.arango.StoreMessage(c.collectionType, []byte("{}")); err != nil {
+		t.Fatalf("unexpected error after acknowledgement: %+v", err)
+	}
+}
+
+func TestStopPendingRetry(t *testing.T) {
+	db := &fakeDatabase{docs: map[string]bool{}, err: errors.New("connection refused")}
+	c := newTestHandler(db, make(chan struct{}))
+	c.arango.config.RetryBackoff = time.Hour
+	c.arango.wg.Add(1)
+	go func() {
+		defer c.arango.wg.Done()
+		c.genericHandler()
+	}()
+	storePrefix(t, c, "10.0.0.0", nil)
+	for {
+		db.lock.Lock()
+		n := len(db.queries)
+		db.lock.Unlock()
+		if n != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The failed write is scheduled for a retry in an hour
+	time.Sleep(20 * time.Millisecond)
+	stopped := make(chan error)
+	go func() { stopped <- c.arango.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop did not return while a retry was waiting for its backoff")
+	}
+}
+
+func TestLive(t *testing.T) {
+	a := &arangoDB{collections: map[dbclient.CollectionType]*collection{
+		bmp.LSNodeMsg: {properties: &collectionProperties{name: "ls_node"}, heartbeat: health.NewHeartbeat(time.Hour)},
+	}}
+	if err := a.Live(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	a.collections[bmp.LSLinkMsg] = &collection{properties: &collectionProperties{name: "ls_link"}, heartbeat: health.NewHeartbeat(0)}
+	a.collections[bmp.LSLinkMsg].heartbeat.Beat()
+	if err := a.Live(context.Background()); err == nil {
+		t.Fatalf("expected an error for a handler without heartbeat")
+	}
+}
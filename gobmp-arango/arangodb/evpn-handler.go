@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"strconv"
+
+	"github.com/sbezverk/gobmp/pkg/message"
+)
+
+type evpnArangoMessage struct {
+	*message.EVPNPrefix
+}
+
+func (e *evpnArangoMessage) MakeKey() string {
+	// Ethernet Tag ID is carried as 4 bytes in network order
+	var tag uint32
+	for _, b := range e.EthTag {
+		tag = tag<<8 | uint32(b)
+	}
+	// The evpn_prefix Key combines all fields identifying EVPN routes of any route type, fields which
+	// are not present in a specific route type are left empty. IP Address field carries the originating
+	// router's IP for Inclusive Multicast (type 3) and Ethernet Segment (type 4) routes, Nexthop identifies
+	// the originating PE of the route.
+	return strconv.Itoa(int(e.RouteType)) + "_" +
+		e.VPNRD + "_" +
+		e.ESI + "_" +
+		strconv.FormatUint(uint64(tag), 10) + "_" +
+		e.MAC + "_" +
+		e.IPAddress + "_" + strconv.Itoa(int(e.IPLength)) + "_" +
+		e.Nexthop
+}
@@ -25,7 +25,7 @@ func TestFIFO(t *testing.T) {
 			total: 1,
 			index: 1,
 			expect: &unicastPrefixArangoMessage{
-				&message.UnicastPrefix{
+				UnicastPrefix: &message.UnicastPrefix{
 					Sequence: 1,
 				},
 			},
@@ -35,7 +35,7 @@ func TestFIFO(t *testing.T) {
 			total: 2,
 			index: 1,
 			expect: &unicastPrefixArangoMessage{
-				&message.UnicastPrefix{
+				UnicastPrefix: &message.UnicastPrefix{
 					Sequence: 1,
 				},
 			},
@@ -45,7 +45,7 @@ func TestFIFO(t *testing.T) {
 			total: 2,
 			index: 2,
 			expect: &unicastPrefixArangoMessage{
-				&message.UnicastPrefix{
+				UnicastPrefix: &message.UnicastPrefix{
 					Sequence: 2,
 				},
 			},
@@ -55,7 +55,7 @@ func TestFIFO(t *testing.T) {
 			total: 3,
 			index: 2,
 			expect: &unicastPrefixArangoMessage{
-				&message.UnicastPrefix{
+				UnicastPrefix: &message.UnicastPrefix{
 					Sequence: 2,
 				},
 			},
@@ -65,7 +65,7 @@ func TestFIFO(t *testing.T) {
 			total: 3,
 			index: 3,
 			expect: &unicastPrefixArangoMessage{
-				&message.UnicastPrefix{
+				UnicastPrefix: &message.UnicastPrefix{
 					Sequence: 3,
 				},
 			},
@@ -75,7 +75,7 @@ func TestFIFO(t *testing.T) {
 			total: 100,
 			index: 50,
 			expect: &unicastPrefixArangoMessage{
-				&message.UnicastPrefix{
+				UnicastPrefix: &message.UnicastPrefix{
 					Sequence: 50,
 				},
 			},
@@ -85,7 +85,7 @@ func TestFIFO(t *testing.T) {
 		ff := newFIFO()
 		for i := 0; i < tt.total; i++ {
 			m := &unicastPrefixArangoMessage{
-				&message.UnicastPrefix{
+				UnicastPrefix: &message.UnicastPrefix{
 					Sequence: i + 1,
 				},
 			}
@@ -0,0 +1,368 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/golang/glog"
+)
+
+const (
+	// HistorySuffix is appended to the name of a collection to name its history collection
+	HistorySuffix = "_history"
+	// HistoryTimeFormat is the format of valid_from and valid_to attributes, the format has a fixed length,
+	// so timestamps compare as strings, and it is the ISO 8601 format used by AQL date functions.
+	HistoryTimeFormat = "2006-01-02T15:04:05.000Z"
+	// historyBatchSize defines the maximum number of changes written to a history collection by a single query
+	historyBatchSize = 500
+	// closeHistoryQuery closes the current version of documents which changed
+	closeHistoryQuery = "FOR c IN @changes " +
+		"FOR h IN @@history FILTER h.key == c.key AND h.valid_to == null " +
+		"UPDATE h WITH c.change IN @@history"
+	// insertHistoryQuery appends new versions of documents which were added or updated
+	insertHistoryQuery = "FOR v IN @versions INSERT v INTO @@history"
+	// snapshotQuery returns versions of documents which were valid at the time
+	snapshotQuery = "FOR h IN @@history " +
+		"FILTER h.valid_from <= @at AND (h.valid_to == null OR h.valid_to > @at) " +
+		"RETURN h.document"
+)
+
+// historyRecord is a change of a document, document is nil when the document was removed
+type historyRecord struct {
+	key      string
+	action   actionType
+	time     time.Time
+	document json.RawMessage
+}
+
+// historyVersion is a document of a history collection, it stores a version of the document along with
+// the period the version was valid, valid_to is null for the current version.
+type historyVersion struct {
+	Key       string          `json:"key"`
+	Action    actionType      `json:"action"`
+	ValidFrom string          `json:"valid_from"`
+	ValidTo   *string         `json:"valid_to"`
+	ExpireAt  *int64          `json:"expire_at,omitempty"`
+	Document  json.RawMessage `json:"document"`
+}
+
+// history appends changes of a collection to its history collection, changes are written in the order
+// they were persisted by a single goroutine.
+type history struct {
+	arango  *arangoDB
+	name    string
+	ttl     time.Duration
+	records chan *historyRecord
+}
+
+func newHistory(a *arangoDB, p *collectionProperties, ttl time.Duration) (*history, error) {
+	h := &history{
+		arango:  a,
+		name:    p.name + HistorySuffix,
+		ttl:     ttl,
+		records: make(chan *historyRecord, eventQueueSize),
+	}
+	if err := h.ensureCollection(p); err != nil {
+		return nil, fmt.Errorf("failed to ensure history collection %s with error: %w", h.name, err)
+	}
+
+	return h, nil
+}
+
+func (h *history) ensureCollection(p *collectionProperties) error {
+	ctx := context.TODO()
+	ci, err := h.arango.db.Collection(ctx, h.name)
+	if err != nil {
+		if !driver.IsArangoErrorWithErrorNum(err, driver.ErrArangoDataSourceNotFound) {
+			return err
+		}
+		// History is sharded and replicated as the collection it records
+		ci, err = h.arango.db.CreateCollection(ctx, h.name, &driver.CreateCollectionOptions{
+			NumberOfShards:    p.options.NumberOfShards,
+			ReplicationFactor: p.options.ReplicationFactor,
+			WriteConcern:      p.options.WriteConcern,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if _, _, err := ci.EnsurePersistentIndex(ctx, []string{"key", "valid_to"}, &driver.EnsurePersistentIndexOptions{
+		Name:         managedIndexPrefix + "key_valid_to",
+		InBackground: true,
+	}); err != nil {
+		return err
+	}
+	if _, _, err := ci.EnsurePersistentIndex(ctx, []string{"valid_from"}, &driver.EnsurePersistentIndexOptions{
+		Name:         managedIndexPrefix + "valid_from",
+		InBackground: true,
+	}); err != nil {
+		return err
+	}
+	// Versions expire at the time stored in expire_at, current versions carry no expire_at and never expire
+	if _, _, err := ci.EnsureTTLIndex(ctx, "expire_at", 0, &driver.EnsureTTLIndexOptions{
+		Name:         managedIndexPrefix + "expire_at",
+		InBackground: true,
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// recordHistory queues the change of the document for the history collection, when the database
+// did not return the changed document, the written record is used.
+func (c *collection) recordHistory(r *result) {
+	rec := &historyRecord{
+		key:    r.key,
+		action: r.action,
+		time:   time.Now(),
+	}
+	if r.action == addAction || r.action == updateAction {
+		if len(r.newDoc) != 0 {
+			rec.document = r.newDoc
+		} else {
+			b, err := json.Marshal(r.object)
+			if err != nil {
+				glog.Errorf("failed to marshal history of key: %s with error: %+v", r.key, err)
+				return
+			}
+			rec.document = b
+		}
+	}
+	c.history.queue(rec)
+}
+
+// queue queues the change for the writer, when the client is stopping, the change is kept for the spool file
+func (h *history) queue(rec *historyRecord) {
+	h.arango.unsent.Add(1)
+	select {
+	case h.records <- rec:
+	case <-h.arango.stop:
+		h.arango.unsent.Add(-1)
+		h.arango.spill(h.spoolRecord(rec))
+	}
+}
+
+// spoolRecord returns the spool file record of the change
+func (h *history) spoolRecord(rec *historyRecord) *spoolRecord {
+	return &spoolRecord{
+		History: &spoolHistory{
+			Collection: h.name,
+			Key:        rec.key,
+			Action:     rec.action,
+			Time:       rec.time,
+			Document:   rec.document,
+		},
+	}
+}
+
+// writer drains queued changes and writes them in batches, a failed batch is retried until it succeeds,
+// so the history of every document stays in order.
+func (h *history) writer() {
+	defer h.arango.wg.Done()
+	for {
+		var records []*historyRecord
+		select {
+		case rec := <-h.records:
+			records = append(records, rec)
+		case <-h.arango.stop:
+			return
+		}
+	drain:
+		for len(records) < historyBatchSize {
+			select {
+			case rec := <-h.records:
+				records = append(records, rec)
+			default:
+				break drain
+			}
+		}
+		closes, versions := h.changes(records)
+		for attempt := 1; ; attempt++ {
+			err := h.write(closes, versions)
+			if err == nil {
+				h.arango.unsent.Add(-int64(len(records)))
+				break
+			}
+			delay := retryBackoff(h.arango.config.RetryBackoff, attempt)
+			glog.Errorf("failed to write %d changes to history collection %s with error: %+v, retrying in %s", len(records), h.name, err, delay)
+			select {
+			case <-time.After(delay):
+			case <-h.arango.stop:
+				h.arango.unsent.Add(-int64(len(records)))
+				for _, rec := range records {
+					h.arango.spill(h.spoolRecord(rec))
+				}
+				return
+			}
+		}
+	}
+}
+
+// changes converts the changes into updates closing current versions stored in the history collection
+// and new versions to append. A version added and superseded within the same batch is closed before
+// it is written.
+func (h *history) changes(records []*historyRecord) ([]map[string]interface{}, []*historyVersion) {
+	closes := make([]map[string]interface{}, 0)
+	versions := make([]*historyVersion, 0)
+	// current stores versions of the batch which are still valid
+	current := make(map[string]*historyVersion)
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		ts := rec.time.UTC().Format(HistoryTimeFormat)
+		expireAt := h.expireAt(rec.time)
+		if v, ok := current[rec.key]; ok {
+			v.ValidTo = &ts
+			v.ExpireAt = expireAt
+			delete(current, rec.key)
+		} else if !seen[rec.key] {
+			change := map[string]interface{}{"valid_to": ts}
+			if expireAt != nil {
+				change["expire_at"] = *expireAt
+			}
+			closes = append(closes, map[string]interface{}{"key": rec.key, "change": change})
+		}
+		seen[rec.key] = true
+		if rec.document == nil {
+			continue
+		}
+		v := &historyVersion{
+			Key:       rec.key,
+			Action:    rec.action,
+			ValidFrom: ts,
+			Document:  rec.document,
+		}
+		versions = append(versions, v)
+		current[rec.key] = v
+	}
+
+	return closes, versions
+}
+
+// expireAt returns the time in seconds since epoch when a version closed at t expires, nil if versions never expire
+func (h *history) expireAt(t time.Time) *int64 {
+	if h.ttl <= 0 {
+		return nil
+	}
+	e := t.Add(h.ttl).Unix()
+
+	return &e
+}
+
+func (h *history) write(closes []map[string]interface{}, versions []*historyVersion) error {
+	ctx := context.TODO()
+	// A query can modify a collection only once, current versions are closed before new versions are appended
+	if len(closes) != 0 {
+		cursor, err := h.arango.db.Query(ctx, closeHistoryQuery, map[string]interface{}{
+			"@history": h.name,
+			"changes":  closes,
+		})
+		if err != nil {
+			return err
+		}
+		cursor.Close()
+	}
+	if len(versions) != 0 {
+		cursor, err := h.arango.db.Query(ctx, insertHistoryQuery, map[string]interface{}{
+			"@history": h.name,
+			"versions": versions,
+		})
+		if err != nil {
+			return err
+		}
+		cursor.Close()
+	}
+
+	return nil
+}
+
+// Snapshot returns documents of the collection as they were at the time, the collection must have been
+// recorded in its history collection, versions removed by the history TTL cannot be recovered.
+func (a *ArangoConn) Snapshot(ctx context.Context, collection string, at time.Time) ([]json.RawMessage, error) {
+	cursor, err := a.db.Query(ctx, snapshotQuery, map[string]interface{}{
+		"@history": collection + HistorySuffix,
+		"at":       at.UTC().Format(HistoryTimeFormat),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	docs := make([]json.RawMessage, 0)
+	for {
+		var doc json.RawMessage
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// GraphSnapshot returns documents of the graph's vertex and edge collections as they were at the time,
+// keyed by the collection name. Collections without a history collection are not included, history is
+// recorded by gobmp-arango only, so vertex and edge collections written by igp-graph and ip-graph, for
+// example igp_node, igpv4_graph and bgp_node, are missing from snapshots of their graphs.
+func (a *ArangoConn) GraphSnapshot(ctx context.Context, graph string, at time.Time) (map[string][]json.RawMessage, error) {
+	g, err := a.db.Graph(ctx, graph)
+	if err != nil {
+		return nil, err
+	}
+	vertices, err := g.VertexCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	edges, _, err := g.EdgeCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string][]json.RawMessage)
+	for _, c := range append(vertices, edges...) {
+		if _, ok := snapshot[c.Name()]; ok {
+			continue
+		}
+		exists, err := a.db.CollectionExists(ctx, c.Name()+HistorySuffix)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			glog.Warningf("collection %s of graph %s has no history, collections written by igp-graph and ip-graph are not recorded", c.Name(), graph)
+			continue
+		}
+		docs, err := a.Snapshot(ctx, c.Name(), at)
+		if err != nil {
+			return nil, err
+		}
+		snapshot[c.Name()] = docs
+	}
+
+	return snapshot, nil
+}
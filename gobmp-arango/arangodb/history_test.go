@@ -0,0 +1,93 @@
+package arangodb
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistoryChanges(t *testing.T) {
+	t0 := time.Date(2026, 2, 13, 2, 13, 0, 0, time.UTC)
+	doc := json.RawMessage(`{"_key":"1"}`)
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		records  []*historyRecord
+		closes   []string
+		versions []string
+		open     int
+	}{
+		{
+			name: "add",
+			records: []*historyRecord{
+				{key: "1", action: addAction, time: t0, document: doc},
+			},
+			closes:   []string{"1"},
+			versions: []string{"1"},
+			open:     1,
+		},
+		{
+			name: "del",
+			records: []*historyRecord{
+				{key: "1", action: delAction, time: t0},
+			},
+			closes: []string{"1"},
+		},
+		{
+			name: "add and update in the same batch",
+			ttl:  time.Hour,
+			records: []*historyRecord{
+				{key: "1", action: addAction, time: t0, document: doc},
+				{key: "2", action: addAction, time: t0, document: doc},
+				{key: "1", action: updateAction, time: t0.Add(time.Second), document: doc},
+			},
+			closes:   []string{"1", "2"},
+			versions: []string{"1", "2", "1"},
+			open:     2,
+		},
+		{
+			name: "del and add in the same batch",
+			records: []*historyRecord{
+				{key: "1", action: delAction, time: t0},
+				{key: "1", action: addAction, time: t0.Add(time.Second), document: doc},
+			},
+			closes:   []string{"1"},
+			versions: []string{"1"},
+			open:     1,
+		},
+	}
+	for _, tt := range tests {
+		h := &history{ttl: tt.ttl}
+		closes, versions := h.changes(tt.records)
+		if len(closes) != len(tt.closes) {
+			t.Fatalf("%s: expected %d closes, actual %d", tt.name, len(tt.closes), len(closes))
+		}
+		for i, c := range closes {
+			if c["key"] != tt.closes[i] {
+				t.Fatalf("%s: expected close of key %s, actual %v", tt.name, tt.closes[i], c["key"])
+			}
+			if _, ok := c["change"].(map[string]interface{})["expire_at"]; ok != (tt.ttl != 0) {
+				t.Fatalf("%s: unexpected expire_at of closed version", tt.name)
+			}
+		}
+		if len(versions) != len(tt.versions) {
+			t.Fatalf("%s: expected %d versions, actual %d", tt.name, len(tt.versions), len(versions))
+		}
+		open := 0
+		for i, v := range versions {
+			if v.Key != tt.versions[i] {
+				t.Fatalf("%s: expected version of key %s, actual %s", tt.name, tt.versions[i], v.Key)
+			}
+			if v.ValidTo == nil {
+				open++
+				continue
+			}
+			if *v.ValidTo < v.ValidFrom {
+				t.Fatalf("%s: version of key %s is valid to %s before it is valid from %s", tt.name, v.Key, *v.ValidTo, v.ValidFrom)
+			}
+		}
+		if open != tt.open {
+			t.Fatalf("%s: expected %d current versions, actual %d", tt.name, tt.open, open)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/golang/glog"
+)
+
+const (
+	// managedIndexPrefix prefixes names of indexes maintained by gobmp-arango, indexes without
+	// the prefix are created by operators or other applications and are never modified.
+	managedIndexPrefix = "jalapeno_"
+)
+
+// IndexSchema defines a persistent index of a collection
+type IndexSchema struct {
+	Name   string   `yaml:"name" json:"name"`
+	Fields []string `yaml:"fields" json:"fields"`
+	Unique bool     `yaml:"unique" json:"unique"`
+	Sparse bool     `yaml:"sparse" json:"sparse"`
+}
+
+type indexProperties struct {
+	name   string
+	fields []string
+	unique bool
+	sparse bool
+}
+
+var (
+	// defaultIndexes defines indexes created for collections fed by a message type, the fields
+	// are the ones processors filter on when they look up BMP records.
+	defaultIndexes = map[string][]IndexSchema{
+		"peer": {
+			{Fields: []string{"remote_ip", "remote_asn"}},
+			{Fields: []string{"remote_bgp_id"}},
+		},
+		"ls_node": {
+			{Fields: []string{"igp_router_id", "domain_id"}},
+			{Fields: []string{"router_id", "asn"}},
+			{Fields: []string{"protocol_id", "area_id"}},
+			peerIndex,
+		},
+		"ls_link": {
+			{Fields: []string{"igp_router_id", "domain_id"}},
+			{Fields: []string{"remote_igp_router_id", "domain_id"}},
+			{Fields: []string{"protocol_id", "area_id"}},
+			peerIndex,
+		},
+		"ls_prefix": {
+			{Fields: []string{"prefix", "prefix_len"}},
+			{Fields: []string{"igp_router_id", "domain_id"}},
+			{Fields: []string{"protocol_id", "area_id"}},
+			peerIndex,
+		},
+		"ls_srv6_sid": {
+			{Fields: []string{"igp_router_id", "domain_id"}},
+			peerIndex,
+		},
+		"unicast_prefix":    unicastIndexes,
+		"unicast_prefix_v4": unicastIndexes,
+		"unicast_prefix_v6": unicastIndexes,
+		"l3vpn":             {peerIndex},
+		"l3vpn_v4":          {peerIndex},
+		"l3vpn_v6":          {peerIndex},
+		"evpn":              {peerIndex},
+		"sr_policy":         {peerIndex},
+		"sr_policy_v4":      {peerIndex},
+		"sr_policy_v6":      {peerIndex},
+		"flowspec":          {peerIndex},
+		"flowspec_v4":       {peerIndex},
+		"flowspec_v6":       {peerIndex},
+	}
+	// peerIndex speeds up removal of documents learned from a peer which went down
+	peerIndex      = IndexSchema{Fields: []string{"peer_hash"}}
+	unicastIndexes = []IndexSchema{
+		{Fields: []string{"prefix", "prefix_len"}},
+		// peer_ip and router_ip identify the peer of documents which do not carry the peer hash
+		{Fields: []string{"peer_ip", "router_ip"}},
+		{Fields: []string{"peer_asn"}},
+		peerIndex,
+	}
+)
+
+// indexProperties returns indexes of the collection, built-in indexes of its message types come first
+// unless disabled, followed by indexes defined in the schema. Indexes with the same name are merged,
+// the schema definition wins.
+func (cs *CollectionSchema) indexProperties() ([]*indexProperties, error) {
+	var all []IndexSchema
+	if cs.DefaultIndexes == nil || *cs.DefaultIndexes {
+		for _, mt := range cs.MessageTypes {
+			all = append(all, defaultIndexes[mt]...)
+		}
+	}
+	all = append(all, cs.Indexes...)
+	indexes := make([]*indexProperties, 0, len(all))
+	names := make(map[string]int)
+	for _, is := range all {
+		if len(is.Fields) == 0 {
+			return nil, fmt.Errorf("collection %s has an index with no fields", cs.Name)
+		}
+		name := is.Name
+		if name == "" {
+			name = strings.ReplaceAll(strings.Join(is.Fields, "_"), ".", "_")
+		}
+		ip := &indexProperties{
+			name:   managedIndexPrefix + name,
+			fields: is.Fields,
+			unique: is.Unique,
+			sparse: is.Sparse,
+		}
+		if i, ok := names[ip.name]; ok {
+			indexes[i] = ip
+			continue
+		}
+		names[ip.name] = len(indexes)
+		indexes = append(indexes, ip)
+	}
+
+	return indexes, nil
+}
+
+// ensureIndexes reconciles managed indexes of the collection with its properties. Missing indexes are created,
+// indexes which definition has changed are re-created and indexes which are no longer defined are removed.
+func (a *arangoDB) ensureIndexes(ci driver.Collection, p *collectionProperties) error {
+	ctx := context.TODO()
+	existing, err := ci.Indexes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list indexes of collection %s with error: %w", p.name, err)
+	}
+	managed := make(map[string]driver.Index)
+	for _, idx := range existing {
+		if strings.HasPrefix(idx.UserName(), managedIndexPrefix) {
+			managed[idx.UserName()] = idx
+		}
+	}
+	for _, ip := range p.indexes {
+		if idx, ok := managed[ip.name]; ok {
+			delete(managed, ip.name)
+			if ip.matches(idx) {
+				glog.Infof("collection %s: index %s on %v is up to date", p.name, ip.name, ip.fields)
+				continue
+			}
+			glog.Infof("collection %s: index %s definition has changed, re-creating", p.name, ip.name)
+			if err := idx.Remove(ctx); err != nil {
+				return fmt.Errorf("failed to remove index %s of collection %s with error: %w", ip.name, p.name, err)
+			}
+		}
+		if _, _, err := ci.EnsurePersistentIndex(ctx, ip.fields, &driver.EnsurePersistentIndexOptions{
+			Name:         ip.name,
+			Unique:       ip.unique,
+			Sparse:       ip.sparse,
+			InBackground: true,
+		}); err != nil {
+			return fmt.Errorf("failed to create index %s of collection %s with error: %w", ip.name, p.name, err)
+		}
+		glog.Infof("collection %s: created index %s on %v unique: %t sparse: %t", p.name, ip.name, ip.fields, ip.unique, ip.sparse)
+	}
+	for name, idx := range managed {
+		glog.Infof("collection %s: index %s is no longer defined, removing", p.name, name)
+		if err := idx.Remove(ctx); err != nil {
+			return fmt.Errorf("failed to remove index %s of collection %s with error: %w", name, p.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (ip *indexProperties) matches(idx driver.Index) bool {
+	if idx.Type() != driver.PersistentIndex || idx.Unique() != ip.unique || idx.Sparse() != ip.sparse {
+		return false
+	}
+	fields := idx.Fields()
+	if len(fields) != len(ip.fields) {
+		return false
+	}
+	for i := range fields {
+		if fields[i] != ip.fields[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,69 @@
+package arangodb
+
+import (
+	"testing"
+)
+
+func TestIndexProperties(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name   string
+		schema CollectionSchema
+		expect []string
+		fail   bool
+	}{
+		{
+			name:   "built-in indexes",
+			schema: CollectionSchema{Name: "ls_srv6_sid", MessageTypes: []string{"ls_srv6_sid"}},
+			expect: []string{"jalapeno_igp_router_id_domain_id", "jalapeno_peer_hash"},
+		},
+		{
+			name: "built-in indexes extended",
+			schema: CollectionSchema{Name: "ls_srv6_sid", MessageTypes: []string{"ls_srv6_sid"}, Indexes: []IndexSchema{
+				{Fields: []string{"srv6_sid"}, Unique: true},
+			}},
+			expect: []string{"jalapeno_igp_router_id_domain_id", "jalapeno_peer_hash", "jalapeno_srv6_sid"},
+		},
+		{
+			name: "built-in index overridden",
+			schema: CollectionSchema{Name: "ls_srv6_sid", MessageTypes: []string{"ls_srv6_sid"}, Indexes: []IndexSchema{
+				{Name: "igp_router_id_domain_id", Fields: []string{"igp_router_id"}},
+			}},
+			expect: []string{"jalapeno_igp_router_id_domain_id", "jalapeno_peer_hash"},
+		},
+		{
+			name: "built-in indexes disabled",
+			schema: CollectionSchema{Name: "ls_srv6_sid", MessageTypes: []string{"ls_srv6_sid"}, DefaultIndexes: &disabled, Indexes: []IndexSchema{
+				{Fields: []string{"srv6_sid.sid"}},
+			}},
+			expect: []string{"jalapeno_srv6_sid_sid"},
+		},
+		{
+			name: "index without fields",
+			schema: CollectionSchema{Name: "ls_srv6_sid", MessageTypes: []string{"ls_srv6_sid"}, Indexes: []IndexSchema{
+				{Name: "empty"},
+			}},
+			fail: true,
+		},
+	}
+	for _, tt := range tests {
+		indexes, err := tt.schema.indexProperties()
+		if err != nil {
+			if !tt.fail {
+				t.Fatalf("%s: unexpected error: %+v", tt.name, err)
+			}
+			continue
+		}
+		if tt.fail {
+			t.Fatalf("%s: expected to fail but succeeded", tt.name)
+		}
+		if len(indexes) != len(tt.expect) {
+			t.Fatalf("%s: expected %d indexes, actual %d", tt.name, len(tt.expect), len(indexes))
+		}
+		for i, ip := range indexes {
+			if ip.name != tt.expect[i] {
+				t.Fatalf("%s: expected index %s, actual %s", tt.name, tt.expect[i], ip.name)
+			}
+		}
+	}
+}
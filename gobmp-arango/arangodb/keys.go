@@ -0,0 +1,422 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/golang/glog"
+)
+
+const (
+	// KeyStrategyDefault builds keys with the built-in key of the message type
+	KeyStrategyDefault = "default"
+	// KeyStrategyPrefix keeps one document per prefix, the last advertisement wins and a withdrawal
+	// from any peer removes the document.
+	KeyStrategyPrefix = "prefix"
+	// KeyStrategyPrefixPeer keeps one document per prefix per advertising peer
+	KeyStrategyPrefixPeer = "prefix_peer"
+	// KeyStrategyPrefixPeerNexthop keeps one document per prefix per advertising peer and next hop
+	KeyStrategyPrefixPeerNexthop = "prefix_peer_nexthop"
+	// KeyStrategyPrefixRouter keeps one document per prefix per monitored router
+	KeyStrategyPrefixRouter = "prefix_router"
+	// KeyStrategyPeerRouter keeps one document per peer per monitored router reporting it
+	KeyStrategyPeerRouter = "peer_router"
+	// maxKeyLength is the maximum length in bytes of an ArangoDB document key
+	maxKeyLength = 254
+	// keyChars are characters besides letters and digits which ArangoDB accepts in document keys, "%"
+	// is accepted too but it starts escaped characters of key values
+	keyChars = "_-:.@()+,=;$!*'"
+	// migrateBatchSize defines the number of documents re-keyed by a single query
+	migrateBatchSize = 500
+	// migrateInsertQuery stores documents under their new keys
+	migrateInsertQuery = "FOR m IN @moves " +
+		"INSERT MERGE(UNSET(m.doc, \"_id\", \"_rev\"), { _key: m.key }) INTO @@collection OPTIONS { overwriteMode: \"replace\" } " +
+		"RETURN NEW"
+	// migrateRemoveQuery removes documents stored under their old keys
+	migrateRemoveQuery = "FOR k IN @keys " +
+		"REMOVE k IN @@collection OPTIONS { ignoreErrors: true } " +
+		"RETURN OLD"
+)
+
+// KeySchema selects how keys of documents of a collection are built, either by a named strategy or by
+// a list of message fields whose values are joined with "_".
+type KeySchema struct {
+	Strategy string   `yaml:"strategy" json:"strategy"`
+	Fields   []string `yaml:"fields" json:"fields"`
+}
+
+var (
+	unicastPrefixTypes = []string{"unicast_prefix", "unicast_prefix_v4", "unicast_prefix_v6"}
+	l3vpnTypes         = []string{"l3vpn", "l3vpn_v4", "l3vpn_v6"}
+	// keyStrategies defines message fields building the key for each named strategy and message type
+	keyStrategies = map[string]map[string][]string{
+		KeyStrategyPrefix: strategyFields(
+			unicastPrefixTypes, []string{"prefix", "prefix_len"},
+			l3vpnTypes, []string{"vpn_rd", "prefix", "prefix_len"},
+		),
+		KeyStrategyPrefixPeer: strategyFields(
+			unicastPrefixTypes, []string{"prefix", "prefix_len", "peer_ip"},
+			l3vpnTypes, []string{"vpn_rd", "prefix", "prefix_len", "peer_ip"},
+		),
+		KeyStrategyPrefixPeerNexthop: strategyFields(
+			unicastPrefixTypes, []string{"prefix", "prefix_len", "peer_ip", "nexthop"},
+			l3vpnTypes, []string{"vpn_rd", "prefix", "prefix_len", "peer_ip", "nexthop"},
+		),
+		KeyStrategyPrefixRouter: strategyFields(
+			unicastPrefixTypes, []string{"prefix", "prefix_len", "router_ip"},
+			l3vpnTypes, []string{"vpn_rd", "prefix", "prefix_len", "router_ip"},
+		),
+		KeyStrategyPeerRouter: strategyFields(
+			[]string{"peer"}, []string{"remote_bgp_id", "remote_ip", "router_ip"},
+		),
+	}
+)
+
+// strategyFields maps message types to key fields, arguments are pairs of message types and their fields
+func strategyFields(pairs ...[]string) map[string][]string {
+	m := make(map[string][]string)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		for _, t := range pairs[i] {
+			m[t] = pairs[i+1]
+		}
+	}
+
+	return m
+}
+
+// KeyStrategies returns names of the supported key strategies
+func KeyStrategies() []string {
+	names := []string{KeyStrategyDefault}
+	for n := range keyStrategies {
+		names = append(names, n)
+	}
+	sort.Strings(names[1:])
+
+	return names
+}
+
+// keyFields validates the key schema of the collection and returns key fields for each of its message types,
+// a message type without fields uses its built-in key.
+func (cs *CollectionSchema) keyFields() (map[string][]string, error) {
+	if cs.Key == nil {
+		return nil, nil
+	}
+	if len(cs.Key.Fields) != 0 {
+		if cs.Key.Strategy != "" {
+			return nil, fmt.Errorf("collection %s key defines both strategy and fields", cs.Name)
+		}
+		fields := make(map[string][]string)
+		for _, mt := range cs.MessageTypes {
+			fields[mt] = cs.Key.Fields
+		}
+		return fields, nil
+	}
+	switch cs.Key.Strategy {
+	case "", KeyStrategyDefault:
+		return nil, nil
+	}
+	strategy, ok := keyStrategies[cs.Key.Strategy]
+	if !ok {
+		return nil, fmt.Errorf("collection %s has unknown key strategy %q, supported strategies are %v", cs.Name, cs.Key.Strategy, KeyStrategies())
+	}
+	fields := make(map[string][]string)
+	for _, mt := range cs.MessageTypes {
+		f, ok := strategy[mt]
+		if !ok {
+			return nil, fmt.Errorf("key strategy %q of collection %s does not support message type %s", cs.Key.Strategy, cs.Name, mt)
+		}
+		fields[mt] = f
+	}
+
+	return fields, nil
+}
+
+// makeKey returns the key of the document built from the message, the message is the original message
+// or a stored document.
+func (c *collection) makeKey(o DBRecord, msg []byte) (string, error) {
+	fields, ok := c.properties.keys[c.collectionType]
+	if !ok {
+		return o.MakeKey(), nil
+	}
+
+	return fieldsKey(fields, msg)
+}
+
+// fieldsKey joins values of the message fields with "_", a missing field contributes an empty value.
+// Characters ArangoDB does not accept in keys, such as "/" or spaces, are escaped as "%XX".
+func fieldsKey(fields []string, msg []byte) (string, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return "", err
+	}
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		raw, ok := m[f]
+		if !ok {
+			continue
+		}
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return "", err
+		}
+		switch v := v.(type) {
+		case nil:
+		case string:
+			values[i] = escapeKey(v)
+		case json.Number:
+			values[i] = v.String()
+		case bool:
+			values[i] = fmt.Sprintf("%t", v)
+		default:
+			return "", fmt.Errorf("key field %s is not a string, number or boolean", f)
+		}
+	}
+
+	key := strings.Join(values, "_")
+	if len(key) > maxKeyLength {
+		return "", fmt.Errorf("key %s is longer than %d bytes", key, maxKeyLength)
+	}
+
+	return key, nil
+}
+
+// escapeKey replaces characters ArangoDB does not accept in document keys with "%" and their hex code
+func escapeKey(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(keyChars, c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
+
+// RecordKeys builds document keys of messages the same way the database client does, it allows tools to
+// track the documents messages would create or remove without a database.
+type RecordKeys struct {
+	keys map[dbclient.CollectionType][]string
+}
+
+// NewRecordKeys returns keys of collections of the schema, DefaultSchema is used when the schema is nil
+func NewRecordKeys(s *Schema) (*RecordKeys, error) {
+	if s == nil {
+		s = DefaultSchema()
+	}
+	props, err := s.collectionProperties()
+	if err != nil {
+		return nil, err
+	}
+	r := &RecordKeys{
+		keys: make(map[dbclient.CollectionType][]string),
+	}
+	for t, p := range props {
+		if f, ok := p.keys[t]; ok {
+			r.keys[t] = f
+		}
+	}
+
+	return r, nil
+}
+
+// RecordKey returns the document key and the action of the message
+func (r *RecordKeys) RecordKey(msgType dbclient.CollectionType, msg []byte) (string, string, error) {
+	o, err := newDBRecord(msg, msgType)
+	if err != nil {
+		return "", "", err
+	}
+	var a struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(msg, &a); err != nil {
+		return "", "", err
+	}
+	key := o.MakeKey()
+	if fields, ok := r.keys[msgType]; ok {
+		if key, err = fieldsKey(fields, msg); err != nil {
+			return "", "", err
+		}
+	}
+
+	return key, string(newAction(a.Action)), nil
+}
+
+// migrateKeys re-keys documents of the collection which are stored under keys different from the keys
+// the collection builds now, events are sent for the removed and the re-keyed documents. Documents which
+// end up with the same key are merged, the last one read wins.
+func (c *collection) migrateKeys() error {
+	ctx := context.TODO()
+	cursor, err := c.arango.db.Query(ctx, "FOR d IN @@collection RETURN d", map[string]interface{}{
+		"@collection": c.properties.name,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	// written stores new keys, a document moved to the key of a document yet to be re-keyed must not be
+	// removed when the latter is moved.
+	written := make(map[string]bool)
+	moves := make([]map[string]interface{}, 0, migrateBatchSize)
+	total := 0
+	flush := func() error {
+		if len(moves) == 0 {
+			return nil
+		}
+		n, err := c.moveDocuments(ctx, moves, written)
+		total += n
+		moves = moves[:0]
+		return err
+	}
+	for {
+		var doc json.RawMessage
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return err
+		}
+		var meta driver.DocumentMeta
+		if err := json.Unmarshal(doc, &meta); err != nil {
+			return err
+		}
+		o, err := newDBRecord(doc, c.collectionType)
+		if err != nil {
+			glog.Warningf("collection %s: document %s cannot be re-keyed with error: %+v", c.properties.name, meta.Key, err)
+			continue
+		}
+		k, err := c.makeKey(o, doc)
+		if err != nil {
+			glog.Warningf("collection %s: document %s cannot be re-keyed with error: %+v", c.properties.name, meta.Key, err)
+			continue
+		}
+		if k == "" || k == meta.Key {
+			continue
+		}
+		moves = append(moves, map[string]interface{}{"old": meta.Key, "key": k, "doc": doc})
+		if len(moves) >= migrateBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if total != 0 {
+		glog.Infof("collection %s: re-keyed %d documents", c.properties.name, total)
+	}
+
+	return nil
+}
+
+// moveDocuments stores documents under their new keys and removes them from their old keys
+func (c *collection) moveDocuments(ctx context.Context, moves []map[string]interface{}, written map[string]bool) (int, error) {
+	// A query can modify a collection only once, documents are inserted before old keys are removed
+	cursor, err := c.arango.db.Query(ctx, migrateInsertQuery, map[string]interface{}{
+		"@collection": c.properties.name,
+		"moves":       moves,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	for {
+		var doc json.RawMessage
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return 0, err
+		}
+		written[meta.Key] = true
+		c.changed(ctx, &result{key: meta.Key, action: addAction, rev: meta.Rev, newDoc: doc})
+	}
+	keys := make([]string, 0, len(moves))
+	for _, m := range moves {
+		if old := m["old"].(string); !written[old] {
+			keys = append(keys, old)
+		}
+	}
+	rc, err := c.arango.db.Query(ctx, migrateRemoveQuery, map[string]interface{}{
+		"@collection": c.properties.name,
+		"keys":        keys,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	for {
+		var doc json.RawMessage
+		meta, err := rc.ReadDocument(ctx, &doc)
+		if err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return 0, err
+		}
+		c.changed(ctx, &result{key: meta.Key, action: delAction, rev: meta.Rev, oldDoc: doc})
+	}
+
+	return len(moves), nil
+}
+
+// migrateKeys re-keys documents of all collections, each collection is migrated once
+func (a *arangoDB) migrateKeys() error {
+	migrated := make(map[string]bool)
+	for _, t := range sortedTypes(a.collections) {
+		c := a.collections[t]
+		if migrated[c.properties.name] {
+			continue
+		}
+		migrated[c.properties.name] = true
+		if err := c.migrateKeys(); err != nil {
+			return fmt.Errorf("failed to migrate keys of collection %s with error: %w", c.properties.name, err)
+		}
+	}
+
+	return nil
+}
+
+func sortedTypes(collections map[dbclient.CollectionType]*collection) []dbclient.CollectionType {
+	types := make([]dbclient.CollectionType, 0, len(collections))
+	for t := range collections {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	return types
+}
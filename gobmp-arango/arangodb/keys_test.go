@@ -0,0 +1,141 @@
+package arangodb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema CollectionSchema
+		expect map[string][]string
+		fail   bool
+	}{
+		{
+			name:   "built-in key",
+			schema: CollectionSchema{Name: "unicast_prefix_v4", MessageTypes: []string{"unicast_prefix_v4"}},
+		},
+		{
+			name:   "default strategy",
+			schema: CollectionSchema{Name: "unicast_prefix_v4", MessageTypes: []string{"unicast_prefix_v4"}, Key: &KeySchema{Strategy: KeyStrategyDefault}},
+		},
+		{
+			name:   "prefix strategy",
+			schema: CollectionSchema{Name: "unicast_prefix", MessageTypes: []string{"unicast_prefix_v4", "unicast_prefix_v6"}, Key: &KeySchema{Strategy: KeyStrategyPrefix}},
+			expect: map[string][]string{
+				"unicast_prefix_v4": {"prefix", "prefix_len"},
+				"unicast_prefix_v6": {"prefix", "prefix_len"},
+			},
+		},
+		{
+			name:   "custom fields",
+			schema: CollectionSchema{Name: "peer", MessageTypes: []string{"peer"}, Key: &KeySchema{Fields: []string{"router_ip", "remote_ip"}}},
+			expect: map[string][]string{
+				"peer": {"router_ip", "remote_ip"},
+			},
+		},
+		{
+			name:   "unknown strategy",
+			schema: CollectionSchema{Name: "peer", MessageTypes: []string{"peer"}, Key: &KeySchema{Strategy: "random"}},
+			fail:   true,
+		},
+		{
+			name:   "strategy not supporting message type",
+			schema: CollectionSchema{Name: "ls_node", MessageTypes: []string{"ls_node"}, Key: &KeySchema{Strategy: KeyStrategyPrefix}},
+			fail:   true,
+		},
+		{
+			name:   "strategy and fields",
+			schema: CollectionSchema{Name: "peer", MessageTypes: []string{"peer"}, Key: &KeySchema{Strategy: KeyStrategyPeerRouter, Fields: []string{"remote_ip"}}},
+			fail:   true,
+		},
+	}
+	for _, tt := range tests {
+		fields, err := tt.schema.keyFields()
+		if err != nil {
+			if !tt.fail {
+				t.Fatalf("%s: unexpected error: %+v", tt.name, err)
+			}
+			continue
+		}
+		if tt.fail {
+			t.Fatalf("%s: expected to fail but succeeded", tt.name)
+		}
+		if len(fields) != len(tt.expect) {
+			t.Fatalf("%s: expected fields for %d message types, actual %d", tt.name, len(tt.expect), len(fields))
+		}
+		for mt, f := range tt.expect {
+			if len(fields[mt]) != len(f) {
+				t.Fatalf("%s: expected fields %v for %s, actual %v", tt.name, f, mt, fields[mt])
+			}
+			for i := range f {
+				if fields[mt][i] != f[i] {
+					t.Fatalf("%s: expected fields %v for %s, actual %v", tt.name, f, mt, fields[mt])
+				}
+			}
+		}
+	}
+}
+
+func TestFieldsKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		msg    string
+		expect string
+		fail   bool
+	}{
+		{
+			name:   "prefix",
+			fields: []string{"prefix", "prefix_len"},
+			msg:    `{"prefix":"10.0.0.0","prefix_len":24,"peer_ip":"192.168.0.1"}`,
+			expect: "10.0.0.0_24",
+		},
+		{
+			name:   "missing field",
+			fields: []string{"prefix", "prefix_len", "nexthop"},
+			msg:    `{"prefix":"10.0.0.0","prefix_len":24}`,
+			expect: "10.0.0.0_24_",
+		},
+		{
+			name:   "boolean field",
+			fields: []string{"prefix", "is_ipv4"},
+			msg:    `{"prefix":"10.0.0.0","is_ipv4":true}`,
+			expect: "10.0.0.0_true",
+		},
+		{
+			name:   "escaped characters",
+			fields: []string{"vpn_rd", "prefix", "prefix_len"},
+			msg:    `{"vpn_rd":"100:1 red/blue%","prefix":"2001:db8::","prefix_len":64}`,
+			expect: "100:1%20red%2Fblue%25_2001:db8::_64",
+		},
+		{
+			name:   "too long",
+			fields: []string{"prefix"},
+			msg:    `{"prefix":"` + strings.Repeat("a", maxKeyLength+1) + `"}`,
+			fail:   true,
+		},
+		{
+			name:   "object field",
+			fields: []string{"prefix", "base_attrs"},
+			msg:    `{"prefix":"10.0.0.0","base_attrs":{"local_pref":100}}`,
+			fail:   true,
+		},
+	}
+	for _, tt := range tests {
+		k, err := fieldsKey(tt.fields, []byte(tt.msg))
+		if err != nil {
+			if !tt.fail {
+				t.Fatalf("%s: unexpected error: %+v", tt.name, err)
+			}
+			continue
+		}
+		if tt.fail {
+			t.Fatalf("%s: expected to fail but succeeded", tt.name)
+		}
+		if k != tt.expect {
+			t.Fatalf("%s: expected key %s, actual %s", tt.name, tt.expect, k)
+		}
+	}
+}
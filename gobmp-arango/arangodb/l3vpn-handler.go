@@ -30,8 +30,18 @@ import (
 
 type l3VPNArangoMessage struct {
 	*message.L3VPNPrefix
+	// Generation is the generation of the peer the record was learned from
+	Generation int64 `json:"generation,omitempty"`
 }
 
 func (v *l3VPNArangoMessage) MakeKey() string {
 	return v.VPNRD + "_" + v.Prefix + "_" + strconv.Itoa(int(v.PrefixLen)) + "_" + v.Nexthop
 }
+
+func (v *l3VPNArangoMessage) peer() (string, string, string) {
+	return v.PeerHash, v.PeerIP, v.RouterIP
+}
+
+func (v *l3VPNArangoMessage) setGeneration(g int64) {
+	v.Generation = g
+}
@@ -31,6 +31,8 @@ import (
 
 type lsLinkArangoMessage struct {
 	*message.LSLink
+	// Generation is the generation of the peer the record was learned from
+	Generation int64 `json:"generation,omitempty"`
 }
 
 func (l *lsLinkArangoMessage) MakeKey() string {
@@ -72,3 +74,11 @@ func (l *lsLinkArangoMessage) MakeKey() string {
 
 	return strconv.Itoa(int(l.ProtocolID)) + "_" + strconv.Itoa(int(l.DomainID)) + "_" + strconv.Itoa(mtid) + "_" + l.AreaID + "_" + routerID + "_" + localID + "_" + remoteRouterID + "_" + remoteID
 }
+
+func (l *lsLinkArangoMessage) peer() (string, string, string) {
+	return l.PeerHash, l.PeerIP, l.RouterIP
+}
+
+func (l *lsLinkArangoMessage) setGeneration(g int64) {
+	l.Generation = g
+}
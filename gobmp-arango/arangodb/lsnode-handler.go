@@ -31,6 +31,8 @@ import (
 
 type lsNodeArangoMessage struct {
 	*message.LSNode
+	// Generation is the generation of the peer the record was learned from
+	Generation int64 `json:"generation,omitempty"`
 }
 
 func (n *lsNodeArangoMessage) MakeKey() string {
@@ -42,3 +44,11 @@ func (n *lsNodeArangoMessage) MakeKey() string {
 	// to create unique Keys for DB entries in multi-area / multi-topology scenarios
 	return strconv.Itoa(int(n.ProtocolID)) + "_" + strconv.Itoa(int(n.DomainID)) + "_" + areaID + "_" + n.IGPRouterID
 }
+
+func (n *lsNodeArangoMessage) peer() (string, string, string) {
+	return n.PeerHash, n.PeerIP, n.RouterIP
+}
+
+func (n *lsNodeArangoMessage) setGeneration(g int64) {
+	n.Generation = g
+}
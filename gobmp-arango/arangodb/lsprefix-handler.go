@@ -30,6 +30,8 @@ import (
 
 type lsPrefixArangoMessage struct {
 	*message.LSPrefix
+	// Generation is the generation of the peer the record was learned from
+	Generation int64 `json:"generation,omitempty"`
 }
 
 func (p *lsPrefixArangoMessage) MakeKey() string {
@@ -47,3 +49,11 @@ func (p *lsPrefixArangoMessage) MakeKey() string {
 		p.Prefix + "_" + strconv.Itoa(int(p.PrefixLen)) + "_" +
 		p.IGPRouterID
 }
+
+func (p *lsPrefixArangoMessage) peer() (string, string, string) {
+	return p.PeerHash, p.PeerIP, p.RouterIP
+}
+
+func (p *lsPrefixArangoMessage) setGeneration(g int64) {
+	p.Generation = g
+}
@@ -30,8 +30,18 @@ import (
 
 type lsSRv6SIDArangoMessage struct {
 	*message.LSSRv6SID
+	// Generation is the generation of the peer the record was learned from
+	Generation int64 `json:"generation,omitempty"`
 }
 
 func (s *lsSRv6SIDArangoMessage) MakeKey() string {
 	return strconv.Itoa(int(s.DomainID)) + "_" + s.IGPRouterID + "_" + s.SRv6SID
 }
+
+func (s *lsSRv6SIDArangoMessage) peer() (string, string, string) {
+	return s.PeerHash, s.PeerIP, s.RouterIP
+}
+
+func (s *lsSRv6SIDArangoMessage) setGeneration(g int64) {
+	s.Generation = g
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/golang/glog"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+	"go.uber.org/atomic"
+)
+
+const (
+	// peerSweepQuery removes documents learned from the BMP peer, a peer is identified by its hash,
+	// or by its address along with the address of the router reporting it.
+	peerSweepQuery = "FOR d IN @@collection " +
+		"FILTER (@peer_hash != \"\" AND d.peer_hash == @peer_hash) OR (d.peer_ip == @peer_ip AND d.router_ip == @router_ip) " +
+		"REMOVE d IN @@collection OPTIONS { ignoreErrors: true } " +
+		"RETURN OLD"
+	// peerGenerationSweepQuery removes documents learned from the BMP peer which were not re-advertised
+	// since the peer came up, such documents carry no generation or a generation older than the peer's one.
+	peerGenerationSweepQuery = "FOR d IN @@collection " +
+		"FILTER (@peer_hash != \"\" AND d.peer_hash == @peer_hash) OR (d.peer_ip == @peer_ip AND d.router_ip == @router_ip) " +
+		"FILTER d.generation == null OR d.generation < @generation " +
+		"REMOVE d IN @@collection OPTIONS { ignoreErrors: true } " +
+		"RETURN OLD"
+	// sweepQueueSize defines the number of sweeps a collection handler holds before requesting more blocks
+	sweepQueueSize = 64
+)
+
+var (
+	// DefaultPeerDownCascade lists message types whose documents are removed when the peer
+	// they were learned from goes down. Only collections keyed by the peer can be swept, documents
+	// of other collections, such as ls_node or l3vpn prefixes, are shared by all peers advertising
+	// them and removing them when one of the peers goes down would lose the ones of other peers.
+	DefaultPeerDownCascade = []dbclient.CollectionType{
+		bmp.UnicastPrefixMsg,
+		bmp.UnicastPrefixV4Msg,
+		bmp.UnicastPrefixV6Msg,
+	}
+	// peerKeys lists message types whose built-in keys include the address of the peer
+	peerKeys = map[dbclient.CollectionType]bool{
+		bmp.UnicastPrefixMsg:   true,
+		bmp.UnicastPrefixV4Msg: true,
+		bmp.UnicastPrefixV6Msg: true,
+	}
+)
+
+// peerFilter identifies documents learned from a BMP peer, when generation is not 0, only documents
+// of older generations are selected.
+type peerFilter struct {
+	hash       string
+	peerIP     string
+	routerIP   string
+	generation int64
+}
+
+// keyedByPeer returns true when documents of the message type are keyed by the peer they were learned from,
+// either by the built-in key or by the key fields of the collection.
+func (p *collectionProperties) keyedByPeer(t dbclient.CollectionType) bool {
+	fields, ok := p.keys[t]
+	if !ok {
+		return peerKeys[t]
+	}
+	for _, f := range fields {
+		if f == "peer_ip" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPeerDownCascade validates that collections of the cascade message types are keyed by the peer
+func (a *arangoDB) checkPeerDownCascade() error {
+	for _, t := range a.config.PeerDownCascade {
+		c, ok := a.collections[t]
+		if !ok {
+			continue
+		}
+		if !c.properties.keyedByPeer(t) {
+			return fmt.Errorf("documents of message type %s cannot be removed when their peer goes down, collection %s is not keyed by the peer", dbclient.TopicName(t), c.properties.name)
+		}
+	}
+
+	return nil
+}
+
+// sweep removes documents learned from a peer from a collection, it is requested from handlers of all message
+// types feeding the collection. The documents are removed when all handlers have written the records they
+// received before the sweep, so a change still waiting for its write is not written after the removal.
+// A handler stops taking new records once it receives an exclusive sweep, so documents the peer advertises
+// after the request are kept, sweeps of older generations leave newly stamped documents in place anyway.
+type sweep struct {
+	filter    peerFilter
+	handlers  []*collection
+	exclusive bool
+	// waiting counts handlers which are still writing records received before the sweep
+	waiting atomic.Int32
+}
+
+// newSweep returns a sweep of the collection fed by the handlers
+func newSweep(f peerFilter, handlers []*collection, exclusive bool) *sweep {
+	s := &sweep{
+		filter:    f,
+		handlers:  handlers,
+		exclusive: exclusive,
+	}
+	s.waiting.Store(int32(len(handlers)))
+
+	return s
+}
+
+// pendingSweep is a sweep waiting in a collection handler for records received up to seq to be persisted
+type pendingSweep struct {
+	*sweep
+	seq     uint64
+	arrived bool
+}
+
+// arrive is called by a handler which has written records received before the sweep, the last handler to
+// arrive removes the documents, then every handler is notified and takes new records again.
+func (s *sweep) arrive() {
+	if s.waiting.Dec() != 0 {
+		return
+	}
+	c := s.handlers[0]
+	c.arango.wg.Add(1)
+	go func() {
+		defer c.arango.wg.Done()
+		n, err := c.sweepPeer(&s.filter)
+		if err != nil {
+			glog.Errorf("failed to remove documents of peer %s from collection %s with error: %+v", s.filter.peerIP, c.properties.name, err)
+		} else if n != 0 {
+			glog.Infof("removed %d documents of peer %s from collection %s", n, s.filter.peerIP, c.properties.name)
+		}
+		for _, h := range s.handlers {
+			select {
+			case h.swept <- s:
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// peerDownCascade requests the removal of documents of the cascade collections learned from the peer which
+// went down, a delete event is sent for every removed document.
+func (a *arangoDB) peerDownCascade(p *peerStateChangeArangoMessage) {
+	glog.Infof("peer %s router: %s went down, removing documents learned from the peer", p.RemoteIP, p.RouterIP)
+	f := peerFilter{hash: p.Hash, peerIP: p.RemoteIP, routerIP: p.RouterIP}
+	// Several message types can feed the same collection, each collection is swept once
+	swept := make(map[string]bool)
+	for _, t := range a.config.PeerDownCascade {
+		c, ok := a.collections[t]
+		if !ok || swept[c.properties.name] {
+			continue
+		}
+		swept[c.properties.name] = true
+		a.requestSweep(newSweep(f, a.handlers(c.properties.name), true))
+	}
+}
+
+// handlers returns collection handlers of all message types feeding the collection
+func (a *arangoDB) handlers(name string) []*collection {
+	handlers := make([]*collection, 0, 1)
+	for _, c := range a.collections {
+		if c.properties.name == name {
+			handlers = append(handlers, c)
+		}
+	}
+
+	return handlers
+}
+
+// requestSweep hands the sweep to its handlers
+func (a *arangoDB) requestSweep(s *sweep) {
+	for _, c := range s.handlers {
+		select {
+		case c.sweeps <- s:
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// sweepPeer removes documents of the collection selected by the peer filter and returns the number of removed documents
+func (c *collection) sweepPeer(f *peerFilter) (int, error) {
+	ctx := context.TODO()
+	query := peerSweepQuery
+	bindVars := map[string]interface{}{
+		"@collection": c.properties.name,
+		"peer_hash":   f.hash,
+		"peer_ip":     f.peerIP,
+		"router_ip":   f.routerIP,
+	}
+	if f.generation != 0 {
+		query = peerGenerationSweepQuery
+		bindVars["generation"] = f.generation
+	}
+	cursor, err := c.arango.db.Query(ctx, query, bindVars)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	n := 0
+	for {
+		var doc json.RawMessage
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return n, err
+		}
+		var meta driver.DocumentMeta
+		if err := json.Unmarshal(doc, &meta); err != nil || meta.Key == "" {
+			continue
+		}
+		n++
+		c.changed(ctx, &result{key: meta.Key, action: delAction, rev: meta.Rev, oldDoc: doc})
+	}
+
+	return n, nil
+}
@@ -0,0 +1,149 @@
+package arangodb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+	"github.com/sbezverk/gobmp/pkg/message"
+)
+
+func TestKeyedByPeer(t *testing.T) {
+	tests := []struct {
+		name   string
+		t      dbclient.CollectionType
+		keys   []string
+		expect bool
+	}{
+		{
+			name:   "built-in unicast key",
+			t:      bmp.UnicastPrefixV4Msg,
+			expect: true,
+		},
+		{
+			name:   "built-in ls_node key",
+			t:      bmp.LSNodeMsg,
+			expect: false,
+		},
+		{
+			name:   "built-in l3vpn key",
+			t:      bmp.L3VPNV4Msg,
+			expect: false,
+		},
+		{
+			name:   "custom key with the peer",
+			t:      bmp.LSNodeMsg,
+			keys:   []string{"igp_router_id", "peer_ip"},
+			expect: true,
+		},
+		{
+			name:   "custom unicast key without the peer",
+			t:      bmp.UnicastPrefixV4Msg,
+			keys:   []string{"prefix", "prefix_len"},
+			expect: false,
+		},
+	}
+	for _, tt := range tests {
+		p := &collectionProperties{}
+		if tt.keys != nil {
+			p.keys = map[dbclient.CollectionType][]string{tt.t: tt.keys}
+		}
+		if actual := p.keyedByPeer(tt.t); actual != tt.expect {
+			t.Errorf("%s: expected %t, actual %t", tt.name, tt.expect, actual)
+		}
+	}
+}
+
+func TestDefaultPeerDownCascade(t *testing.T) {
+	props, err := DefaultSchema().collectionProperties()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	for _, mt := range DefaultPeerDownCascade {
+		if !props[mt].keyedByPeer(mt) {
+			t.Errorf("collection %s is not keyed by the peer", props[mt].name)
+		}
+	}
+	a := &arangoDB{
+		config:      Config{PeerDownCascade: []dbclient.CollectionType{bmp.LSNodeMsg}},
+		collections: map[dbclient.CollectionType]*collection{bmp.LSNodeMsg: {properties: props[bmp.LSNodeMsg]}},
+	}
+	if err := a.checkPeerDownCascade(); err == nil {
+		t.Errorf("expected an error for a collection which is not keyed by the peer")
+	}
+}
+
+// newTestHandler returns a collection whose handler writes records in batches to the database
+func newTestHandler(db *fakeDatabase, stop chan struct{}) *collection {
+	c := newTestCollection(db)
+	c.queue = make(chan *queueMsg, 8)
+	c.retry = make(chan *result)
+	c.sweeps = make(chan *sweep, sweepQueueSize)
+	c.swept = make(chan *sweep, sweepQueueSize)
+	c.heartbeat = health.NewHeartbeat(health.DefaultHeartbeatTimeout)
+	c.stop = stop
+	c.arango.stop = stop
+	c.arango.config = Config{BatchSize: 2, BatchInterval: time.Millisecond, QueueSize: 8}
+	c.arango.collections = map[dbclient.CollectionType]*collection{c.collectionType: c}
+
+	return c
+}
+
+// storePrefix stores a prefix learned from peer 10.0.0.2 of router 10.0.0.1
+func storePrefix(t *testing.T, c *collection, prefix string, ack func()) {
+	t.Helper()
+	msg := fmt.Sprintf(`{"action":"add","prefix":%q,"prefix_len":24,"peer_hash":"hash","peer_ip":"10.0.0.2","router_ip":"10.0.0.1"}`, prefix)
+	if err := c.arango.StoreMessageWithAck(c.collectionType, []byte(msg), ack); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+}
+
+func TestPeerDownCascadeOrder(t *testing.T) {
+	db := &fakeDatabase{docs: map[string]bool{}, gate: make(chan struct{})}
+	stop := make(chan struct{})
+	defer close(stop)
+	c := newTestHandler(db, stop)
+	c.arango.config.PeerDownCascade = DefaultPeerDownCascade
+	go c.genericHandler()
+
+	acks := make(chan string, 2)
+	store := func(prefix string) {
+		storePrefix(t, c, prefix, func() { acks <- prefix })
+	}
+	// The first prefix is taken by the handler and its write is held by the database
+	store("10.0.0.0")
+	for len(c.queue) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.arango.peerDownCascade(&peerStateChangeArangoMessage{
+		PeerStateChange: &message.PeerStateChange{RemoteIP: "10.0.0.2", RouterIP: "10.0.0.1"},
+	})
+	for len(c.sweeps) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The second prefix is advertised after the peer came up again, it must be kept
+	store("10.0.1.0")
+	time.Sleep(20 * time.Millisecond)
+	if len(c.queue) != 1 {
+		t.Fatalf("expected the record received after the sweep to wait in the queue")
+	}
+	for i := 0; i < 2; i++ {
+		db.gate <- struct{}{}
+		select {
+		case <-acks:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("record %d was not acknowledged", i)
+		}
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if len(db.queries) != 3 || db.queries[1] != peerSweepQuery {
+		t.Fatalf("expected the sweep between the writes, actual queries: %v", db.queries)
+	}
+	if len(db.docs) != 1 || !db.docs["10.0.1.0_24_10.0.0.2"] {
+		t.Errorf("expected only the prefix advertised after the sweep, actual: %v", db.docs)
+	}
+}
@@ -28,6 +28,8 @@ import (
 
 type peerStateChangeArangoMessage struct {
 	*message.PeerStateChange
+	// Generation is assigned when the peer comes up, records learned from the peer are stamped with it
+	Generation int64 `json:"generation,omitempty"`
 }
 
 func (p *peerStateChangeArangoMessage) MakeKey() string {
@@ -0,0 +1,347 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/golang/glog"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+const (
+	// DefaultResyncQuietPeriod defines how long a peer which came up must stay silent before documents
+	// it did not re-advertise are removed.
+	DefaultResyncQuietPeriod = 2 * time.Minute
+	// peerGenerationsQuery returns peers which were assigned a generation
+	peerGenerationsQuery = "FOR p IN @@collection FILTER p.generation != null RETURN p"
+)
+
+var (
+	// DefaultResync lists message types whose documents are stamped with the generation of the peer
+	// they were learned from and removed when the peer re-establishes without re-advertising them.
+	// As with DefaultPeerDownCascade, only collections keyed by the peer can be resynchronised, a document
+	// shared by several peers would be removed when one of them does not re-advertise it.
+	DefaultResync = []dbclient.CollectionType{
+		bmp.UnicastPrefixMsg,
+		bmp.UnicastPrefixV4Msg,
+		bmp.UnicastPrefixV6Msg,
+	}
+	// generationTypes lists message types whose records carry the generation of their peer
+	generationTypes = []dbclient.CollectionType{
+		bmp.UnicastPrefixMsg,
+		bmp.UnicastPrefixV4Msg,
+		bmp.UnicastPrefixV6Msg,
+		bmp.L3VPNMsg,
+		bmp.L3VPNV4Msg,
+		bmp.L3VPNV6Msg,
+		bmp.LSNodeMsg,
+		bmp.LSLinkMsg,
+		bmp.LSPrefixMsg,
+		bmp.LSSRv6SIDMsg,
+	}
+)
+
+// resyncRecord is implemented by records which can be stamped with the generation of their peer
+type resyncRecord interface {
+	// peer returns the hash and the address of the peer, along with the address of the router reporting it
+	peer() (string, string, string)
+	setGeneration(int64)
+}
+
+// peerGeneration tracks the resynchronisation of a peer which came up
+type peerGeneration struct {
+	filter peerFilter
+	// timer fires when the peer has been quiet for the quiet period, nil once the peer has been swept
+	timer *time.Timer
+}
+
+// resync implements mark-and-sweep of documents learned from BMP peers. When a peer comes up, it is
+// assigned a new generation, documents learned from the peer are stamped with it and once the peer
+// signals End-of-RIB or stays quiet for the quiet period, documents of older generations are removed.
+type resync struct {
+	arango *arangoDB
+	types  map[dbclient.CollectionType]bool
+	quiet  time.Duration
+	sync.Mutex
+	// peers stores peers by their address and by the peer hash learned from the records
+	peers map[string]*peerGeneration
+	last  int64
+	// stopped is set when the client stops, expiring quiet periods are ignored afterwards
+	stopped bool
+}
+
+func newResync(a *arangoDB, types []dbclient.CollectionType, quiet time.Duration) (*resync, error) {
+	supported := make(map[dbclient.CollectionType]bool)
+	for _, t := range generationTypes {
+		supported[t] = true
+	}
+	r := &resync{
+		arango: a,
+		types:  make(map[dbclient.CollectionType]bool),
+		quiet:  quiet,
+		peers:  make(map[string]*peerGeneration),
+	}
+	for _, t := range types {
+		if !supported[t] {
+			return nil, fmt.Errorf("message type %d does not support resynchronisation", t)
+		}
+		if c, ok := a.collections[t]; ok && !c.properties.keyedByPeer(t) {
+			return nil, fmt.Errorf("message type %s cannot be resynchronised, collection %s is not keyed by the peer", dbclient.TopicName(t), c.properties.name)
+		}
+		r.types[t] = true
+	}
+	if r.quiet <= 0 {
+		r.quiet = DefaultResyncQuietPeriod
+	}
+
+	return r, nil
+}
+
+func addressKey(peerIP, routerIP string) string {
+	return routerIP + "_" + peerIP
+}
+
+func hashKey(hash string) string {
+	return "hash_" + hash
+}
+
+// nextGeneration returns a generation greater than any generation returned before
+func (r *resync) nextGeneration() int64 {
+	r.Lock()
+	defer r.Unlock()
+	g := time.Now().UnixNano()
+	if g <= r.last {
+		g = r.last + 1
+	}
+	r.last = g
+
+	return g
+}
+
+// load restores generations of peers stored in the peer collection, so records learned after a restart
+// are stamped with the generation of the running session.
+func (r *resync) load() error {
+	c, ok := r.arango.collections[bmp.PeerStateChangeMsg]
+	if !ok {
+		return nil
+	}
+	ctx := context.TODO()
+	cursor, err := r.arango.db.Query(ctx, peerGenerationsQuery, map[string]interface{}{
+		"@collection": c.properties.name,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for {
+		var p peerStateChangeArangoMessage
+		if _, err := cursor.ReadDocument(ctx, &p); err != nil {
+			if driver.IsNoMoreDocuments(err) {
+				break
+			}
+			return err
+		}
+		r.register(&p, false)
+	}
+
+	return nil
+}
+
+// peerUp records the generation assigned to the peer and starts waiting for the peer to settle
+func (r *resync) peerUp(p *peerStateChangeArangoMessage) {
+	glog.Infof("peer %s router: %s came up with generation %d", p.RemoteIP, p.RouterIP, p.Generation)
+	r.register(p, true)
+}
+
+func (r *resync) register(p *peerStateChangeArangoMessage, sweep bool) {
+	r.Lock()
+	defer r.Unlock()
+	if p.Generation > r.last {
+		r.last = p.Generation
+	}
+	r.remove(p)
+	pg := &peerGeneration{
+		filter: peerFilter{hash: p.Hash, peerIP: p.RemoteIP, routerIP: p.RouterIP, generation: p.Generation},
+	}
+	if sweep {
+		pg.timer = time.AfterFunc(r.quiet, func() { r.quietPeriodExpired(pg) })
+	}
+	r.peers[addressKey(p.RemoteIP, p.RouterIP)] = pg
+	if p.Hash != "" {
+		r.peers[hashKey(p.Hash)] = pg
+	}
+}
+
+// peerDown forgets the peer, documents learned from the peer are no longer stamped
+func (r *resync) peerDown(p *peerStateChangeArangoMessage) {
+	r.Lock()
+	defer r.Unlock()
+	r.remove(p)
+}
+
+// remove must be called with the lock held
+func (r *resync) remove(p *peerStateChangeArangoMessage) {
+	pg, ok := r.peers[addressKey(p.RemoteIP, p.RouterIP)]
+	if !ok {
+		return
+	}
+	if pg.timer != nil {
+		pg.timer.Stop()
+	}
+	for k, v := range r.peers {
+		if v == pg {
+			delete(r.peers, k)
+		}
+	}
+}
+
+// lookup returns the peer the record was learned from, the peer hash is learned from records
+// carrying both the hash and the address, since End-of-RIB records carry only the hash.
+// lookup must be called with the lock held.
+func (r *resync) lookup(rec resyncRecord) *peerGeneration {
+	hash, peerIP, routerIP := rec.peer()
+	if hash != "" {
+		if pg, ok := r.peers[hashKey(hash)]; ok {
+			return pg
+		}
+	}
+	pg, ok := r.peers[addressKey(peerIP, routerIP)]
+	if !ok {
+		return nil
+	}
+	if hash != "" {
+		if pg.filter.hash == "" {
+			pg.filter.hash = hash
+		}
+		r.peers[hashKey(hash)] = pg
+	}
+
+	return pg
+}
+
+// stamp sets the generation of the record's peer
+func (r *resync) stamp(rec resyncRecord) {
+	r.Lock()
+	defer r.Unlock()
+	pg := r.lookup(rec)
+	if pg == nil {
+		return
+	}
+	rec.setGeneration(pg.filter.generation)
+}
+
+// consumed postpones the sweep of a peer which is still re-advertising its routes, it is called when
+// a collection handler receives a record of the peer.
+func (r *resync) consumed(rec resyncRecord) {
+	r.Lock()
+	defer r.Unlock()
+	pg := r.lookup(rec)
+	if pg == nil || pg.timer == nil {
+		return
+	}
+	pg.timer.Reset(r.quiet)
+}
+
+// endOfRIB requests the removal of documents of older generations from the collection which received
+// End-of-RIB, other collections fed by the peer are swept when the peer becomes quiet.
+func (r *resync) endOfRIB(c *collection, rec resyncRecord) {
+	r.Lock()
+	pg := r.lookup(rec)
+	var f peerFilter
+	if pg != nil {
+		f = pg.filter
+	}
+	r.Unlock()
+	if pg == nil {
+		return
+	}
+	glog.Infof("End-of-RIB received from peer %s router: %s for collection %s", f.peerIP, f.routerIP, c.properties.name)
+	s := newSweep(f, r.arango.handlers(c.properties.name), false)
+	// The handler receiving End-of-RIB is one of the handlers the sweep is requested from
+	r.arango.wg.Add(1)
+	go func() {
+		defer r.arango.wg.Done()
+		r.arango.requestSweep(s)
+	}()
+}
+
+// stop stops quiet period timers, the client waits for sweeps requested by quiet periods which expired before
+func (r *resync) stop() {
+	r.Lock()
+	defer r.Unlock()
+	r.stopped = true
+	for _, pg := range r.peers {
+		if pg.timer != nil {
+			pg.timer.Stop()
+		}
+	}
+}
+
+func (r *resync) quietPeriodExpired(pg *peerGeneration) {
+	r.Lock()
+	if r.stopped || pg.timer == nil || r.peers[addressKey(pg.filter.peerIP, pg.filter.routerIP)] != pg {
+		// The peer went down or came up again
+		r.Unlock()
+		return
+	}
+	if r.queued() {
+		// Records waiting for the handlers may come from the peer, it is not known to be quiet
+		pg.timer.Reset(r.quiet)
+		r.Unlock()
+		return
+	}
+	pg.timer = nil
+	f := pg.filter
+	// Adding to the wait group under the lock orders it before the client waits for it
+	r.arango.wg.Add(1)
+	r.Unlock()
+	defer r.arango.wg.Done()
+	glog.Infof("peer %s router: %s is quiet, removing documents of generations older than %d", f.peerIP, f.routerIP, f.generation)
+	// Several message types can feed the same collection, each collection is swept once
+	swept := make(map[string]bool)
+	for t := range r.types {
+		c, ok := r.arango.collections[t]
+		if !ok || swept[c.properties.name] {
+			continue
+		}
+		swept[c.properties.name] = true
+		r.arango.requestSweep(newSweep(f, r.arango.handlers(c.properties.name), false))
+	}
+}
+
+// queued returns true when records of the resynchronised message types wait in the queue of their handler
+func (r *resync) queued() bool {
+	for t := range r.types {
+		if c, ok := r.arango.collections[t]; ok && len(c.queue) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
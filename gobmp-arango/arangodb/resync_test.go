@@ -0,0 +1,153 @@
+package arangodb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+	"github.com/sbezverk/gobmp/pkg/message"
+)
+
+func TestResyncStamp(t *testing.T) {
+	r, err := newResync(&arangoDB{}, DefaultResync, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	p := &peerStateChangeArangoMessage{
+		PeerStateChange: &message.PeerStateChange{RemoteIP: "10.0.0.2", RouterIP: "10.0.0.1"},
+		Generation:      r.nextGeneration(),
+	}
+	r.peerUp(p)
+	defer r.peerDown(p)
+	tests := []struct {
+		name   string
+		record *unicastPrefixArangoMessage
+		expect int64
+	}{
+		{
+			name:   "unknown peer",
+			record: &unicastPrefixArangoMessage{UnicastPrefix: &message.UnicastPrefix{PeerIP: "10.0.0.3", RouterIP: "10.0.0.1", PeerHash: "other"}},
+		},
+		{
+			name:   "peer by address",
+			record: &unicastPrefixArangoMessage{UnicastPrefix: &message.UnicastPrefix{PeerIP: "10.0.0.2", RouterIP: "10.0.0.1", PeerHash: "hash"}},
+			expect: p.Generation,
+		},
+		{
+			name:   "peer by learned hash",
+			record: &unicastPrefixArangoMessage{UnicastPrefix: &message.UnicastPrefix{PeerHash: "hash", IsEOR: true}},
+			expect: p.Generation,
+		},
+	}
+	for _, tt := range tests {
+		r.stamp(tt.record)
+		if tt.record.Generation != tt.expect {
+			t.Fatalf("%s: expected generation %d, actual %d", tt.name, tt.expect, tt.record.Generation)
+		}
+	}
+	if g := r.nextGeneration(); g <= p.Generation {
+		t.Fatalf("expected generation greater than %d, actual %d", p.Generation, g)
+	}
+	r.peerDown(p)
+	if len(r.peers) != 0 {
+		t.Fatalf("expected no peers after peer down, actual %d", len(r.peers))
+	}
+}
+
+func TestNewResyncUnsupportedType(t *testing.T) {
+	if _, err := newResync(&arangoDB{}, []dbclient.CollectionType{bmp.EVPNMsg}, 0); err == nil {
+		t.Fatalf("expected to fail for a message type without peer generation")
+	}
+	props, err := DefaultSchema().collectionProperties()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	a := &arangoDB{collections: map[dbclient.CollectionType]*collection{bmp.LSNodeMsg: {properties: props[bmp.LSNodeMsg]}}}
+	if _, err := newResync(a, []dbclient.CollectionType{bmp.LSNodeMsg}, 0); err == nil {
+		t.Fatalf("expected to fail for a collection which is not keyed by the peer")
+	}
+	for _, mt := range DefaultResync {
+		if !props[mt].keyedByPeer(mt) {
+			t.Errorf("collection %s is not keyed by the peer", props[mt].name)
+		}
+	}
+}
+
+func TestEndOfRIBOrder(t *testing.T) {
+	db := &fakeDatabase{docs: map[string]bool{}, gate: make(chan struct{})}
+	stop := make(chan struct{})
+	defer close(stop)
+	c := newTestHandler(db, stop)
+	r, err := newResync(c.arango, DefaultResync, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	c.arango.resync = r
+	p := &peerStateChangeArangoMessage{
+		PeerStateChange: &message.PeerStateChange{RemoteIP: "10.0.0.2", RouterIP: "10.0.0.1", Hash: "hash"},
+		Generation:      r.nextGeneration(),
+	}
+	r.peerUp(p)
+	defer r.peerDown(p)
+	go c.genericHandler()
+
+	acked := make(chan struct{}, 1)
+	storePrefix(t, c, "10.0.0.0", func() { acked <- struct{}{} })
+	if err := c.arango.StoreMessage(c.collectionType, []byte(`{"action":"add","is_eor":true,"peer_hash":"hash"}`)); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// The prefix re-advertised before End-of-RIB is written before stale documents are removed
+	time.Sleep(20 * time.Millisecond)
+	db.gate <- struct{}{}
+	<-acked
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		db.lock.Lock()
+		queries := append([]string{}, db.queries...)
+		db.lock.Unlock()
+		if len(queries) == 2 {
+			if queries[1] != peerGenerationSweepQuery {
+				t.Fatalf("expected the sweep after the write, actual queries: %v", queries)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the write and the sweep, actual queries: %v", queries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQuietPeriod(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	c := newTestHandler(&fakeDatabase{docs: map[string]bool{}}, stop)
+	r, err := newResync(c.arango, DefaultResync, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	c.arango.resync = r
+	p := &peerStateChangeArangoMessage{
+		PeerStateChange: &message.PeerStateChange{RemoteIP: "10.0.0.2", RouterIP: "10.0.0.1"},
+		Generation:      r.nextGeneration(),
+	}
+	// A record waits for the handler, the peer is not quiet until the record is received
+	c.queue <- &queueMsg{}
+	r.peerUp(p)
+	defer r.peerDown(p)
+	select {
+	case <-c.sweeps:
+		t.Fatalf("peer was swept while its records wait in the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-c.queue
+	select {
+	case s := <-c.sweeps:
+		if s.filter.generation != p.Generation || s.exclusive {
+			t.Errorf("expected a sweep of generations older than %d, actual %+v", p.Generation, s.filter)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("peer was not swept once quiet")
+	}
+}
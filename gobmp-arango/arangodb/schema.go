@@ -0,0 +1,234 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"fmt"
+	"os"
+
+	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	documentCollection = "document"
+	vertexCollection   = "vertex"
+)
+
+// Schema defines collections maintained by gobmp-arango and BMP message types feeding each collection.
+// A schema file is YAML or JSON, for example:
+//
+//	collections:
+//	  - name: ls_node
+//	    type: vertex
+//	    shards: 3
+//	    replication_factor: 2
+//	    message_types: [ls_node]
+//	    indexes:
+//	      - fields: [router_id]
+//	        sparse: true
+//	  - name: unicast_prefix_v4
+//	    type: document
+//	    write_concern: 2
+//	    key_options:
+//	      type: traditional
+//	      allow_user_keys: true
+//	    message_types: [unicast_prefix_v4]
+//	    key:
+//	      strategy: prefix
+//	  - name: unicast_prefix_v6
+//	    message_types: [unicast_prefix_v6]
+//	    key:
+//	      fields: [prefix, prefix_len, router_ip, peer_ip]
+//
+// Message types are named after gobmp.parsed.* topics, a message type which is not listed
+// in any collection is not stored. Indexes extend the built-in indexes of the collection message
+// types, set default_indexes to false to maintain only the listed ones. Document keys are built by
+// a named key strategy or from a list of message fields, see KeyStrategies.
+type Schema struct {
+	Collections []CollectionSchema `yaml:"collections" json:"collections"`
+}
+
+// CollectionSchema defines properties of a single collection
+type CollectionSchema struct {
+	Name              string      `yaml:"name" json:"name"`
+	Type              string      `yaml:"type" json:"type"`
+	NumberOfShards    int         `yaml:"shards" json:"shards"`
+	ReplicationFactor int         `yaml:"replication_factor" json:"replication_factor"`
+	WriteConcern      int         `yaml:"write_concern" json:"write_concern"`
+	KeyOptions        *KeyOptions `yaml:"key_options" json:"key_options"`
+	MessageTypes      []string    `yaml:"message_types" json:"message_types"`
+	// DefaultIndexes enables built-in indexes of the collection message types, enabled when not set
+	DefaultIndexes *bool         `yaml:"default_indexes" json:"default_indexes"`
+	Indexes        []IndexSchema `yaml:"indexes" json:"indexes"`
+	// Key selects how document keys are built, built-in keys of the message types are used when not set
+	Key *KeySchema `yaml:"key" json:"key"`
+}
+
+// KeyOptions defines the key generator of a collection
+type KeyOptions struct {
+	Type          string `yaml:"type" json:"type"`
+	AllowUserKeys *bool  `yaml:"allow_user_keys" json:"allow_user_keys"`
+	Increment     int    `yaml:"increment" json:"increment"`
+	Offset        int    `yaml:"offset" json:"offset"`
+}
+
+var (
+	// messageTypes maps names used in the schema to BMP message types
+	messageTypes = map[string]dbclient.CollectionType{
+		"peer":              bmp.PeerStateChangeMsg,
+		"ls_link":           bmp.LSLinkMsg,
+		"ls_node":           bmp.LSNodeMsg,
+		"ls_prefix":         bmp.LSPrefixMsg,
+		"ls_srv6_sid":       bmp.LSSRv6SIDMsg,
+		"l3vpn":             bmp.L3VPNMsg,
+		"l3vpn_v4":          bmp.L3VPNV4Msg,
+		"l3vpn_v6":          bmp.L3VPNV6Msg,
+		"evpn":              bmp.EVPNMsg,
+		"unicast_prefix":    bmp.UnicastPrefixMsg,
+		"unicast_prefix_v4": bmp.UnicastPrefixV4Msg,
+		"unicast_prefix_v6": bmp.UnicastPrefixV6Msg,
+		"sr_policy":         bmp.SRPolicyMsg,
+		"sr_policy_v4":      bmp.SRPolicyV4Msg,
+		"sr_policy_v6":      bmp.SRPolicyV6Msg,
+		"flowspec":          bmp.FlowspecMsg,
+		"flowspec_v4":       bmp.FlowspecV4Msg,
+		"flowspec_v6":       bmp.FlowspecV6Msg,
+	}
+)
+
+// DefaultSchema returns the schema used when no schema file is provided
+func DefaultSchema() *Schema {
+	return &Schema{
+		Collections: []CollectionSchema{
+			{Name: "peer", Type: documentCollection, MessageTypes: []string{"peer"}},
+			{Name: "ls_link", Type: documentCollection, MessageTypes: []string{"ls_link"}},
+			{Name: "ls_node", Type: vertexCollection, MessageTypes: []string{"ls_node"}},
+			{Name: "ls_prefix", Type: documentCollection, MessageTypes: []string{"ls_prefix"}},
+			{Name: "ls_srv6_sid", Type: documentCollection, MessageTypes: []string{"ls_srv6_sid"}},
+			{Name: "l3vpn_prefix", Type: documentCollection, MessageTypes: []string{"l3vpn"}},
+			{Name: "l3vpn_v4_prefix", Type: vertexCollection, MessageTypes: []string{"l3vpn_v4"}},
+			{Name: "l3vpn_v6_prefix", Type: vertexCollection, MessageTypes: []string{"l3vpn_v6"}},
+			{Name: "evpn_prefix", Type: documentCollection, MessageTypes: []string{"evpn"}},
+			{Name: "unicast_prefix", Type: documentCollection, MessageTypes: []string{"unicast_prefix"}},
+			{Name: "unicast_prefix_v4", Type: documentCollection, MessageTypes: []string{"unicast_prefix_v4"}},
+			{Name: "unicast_prefix_v6", Type: documentCollection, MessageTypes: []string{"unicast_prefix_v6"}},
+			{Name: "sr_policy", Type: documentCollection, MessageTypes: []string{"sr_policy"}},
+			{Name: "sr_policy_v4", Type: documentCollection, MessageTypes: []string{"sr_policy_v4"}},
+			{Name: "sr_policy_v6", Type: documentCollection, MessageTypes: []string{"sr_policy_v6"}},
+			{Name: "flowspec", Type: documentCollection, MessageTypes: []string{"flowspec"}},
+			{Name: "flowspec_v4", Type: documentCollection, MessageTypes: []string{"flowspec_v4"}},
+			{Name: "flowspec_v6", Type: documentCollection, MessageTypes: []string{"flowspec_v6"}},
+		},
+	}
+}
+
+// LoadSchema reads and validates a YAML or JSON schema file
+func LoadSchema(fn string) (*Schema, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{}
+	// YAML is a superset of JSON, the same decoder handles both formats
+	if err := yaml.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("failed to parse schema file %s with error: %w", fn, err)
+	}
+	if _, err := s.collectionProperties(); err != nil {
+		return nil, fmt.Errorf("invalid schema file %s: %w", fn, err)
+	}
+
+	return s, nil
+}
+
+// collectionProperties validates the schema and returns properties of collections keyed by the message type
+// feeding the collection.
+func (s *Schema) collectionProperties() (map[dbclient.CollectionType]*collectionProperties, error) {
+	props := make(map[dbclient.CollectionType]*collectionProperties)
+	names := make(map[string]bool)
+	for _, cs := range s.Collections {
+		var err error
+		if cs.Name == "" {
+			return nil, fmt.Errorf("collection name cannot be empty")
+		}
+		if names[cs.Name] {
+			return nil, fmt.Errorf("collection %s is defined more than once", cs.Name)
+		}
+		names[cs.Name] = true
+		var isVertex bool
+		switch cs.Type {
+		case "", documentCollection:
+		case vertexCollection:
+			isVertex = true
+		default:
+			return nil, fmt.Errorf("collection %s has unknown type %q, supported types are %q and %q", cs.Name, cs.Type, documentCollection, vertexCollection)
+		}
+		if len(cs.MessageTypes) == 0 {
+			return nil, fmt.Errorf("collection %s has no message types", cs.Name)
+		}
+		p := &collectionProperties{
+			name:     cs.Name,
+			isVertex: isVertex,
+			options: &driver.CreateCollectionOptions{
+				NumberOfShards:    cs.NumberOfShards,
+				ReplicationFactor: cs.ReplicationFactor,
+				WriteConcern:      cs.WriteConcern,
+			},
+		}
+		if p.indexes, err = cs.indexProperties(); err != nil {
+			return nil, err
+		}
+		keyFields, err := cs.keyFields()
+		if err != nil {
+			return nil, err
+		}
+		if cs.KeyOptions != nil {
+			p.options.KeyOptions = &driver.CollectionKeyOptions{
+				Type:             driver.KeyGeneratorType(cs.KeyOptions.Type),
+				AllowUserKeysPtr: cs.KeyOptions.AllowUserKeys,
+				Increment:        cs.KeyOptions.Increment,
+				Offset:           cs.KeyOptions.Offset,
+			}
+		}
+		for _, mt := range cs.MessageTypes {
+			t, ok := messageTypes[mt]
+			if !ok {
+				return nil, fmt.Errorf("collection %s has unknown message type %s", cs.Name, mt)
+			}
+			if e, ok := props[t]; ok {
+				return nil, fmt.Errorf("message type %s feeds both %s and %s collections", mt, e.name, cs.Name)
+			}
+			if f, ok := keyFields[mt]; ok {
+				if p.keys == nil {
+					p.keys = make(map[dbclient.CollectionType][]string)
+				}
+				p.keys[t] = f
+			}
+			props[t] = p
+		}
+	}
+
+	return props, nil
+}
@@ -0,0 +1,102 @@
+package arangodb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+func TestDefaultSchema(t *testing.T) {
+	props, err := DefaultSchema().collectionProperties()
+	if err != nil {
+		t.Fatalf("default schema is invalid: %+v", err)
+	}
+	if p := props[bmp.EVPNMsg]; p == nil || p.name != "evpn_prefix" {
+		t.Errorf("expected evpn messages to feed evpn_prefix collection, actual %+v", p)
+	}
+	if p := props[bmp.LSNodeMsg]; p == nil || !p.isVertex {
+		t.Errorf("expected ls_node messages to feed a vertex collection, actual %+v", p)
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{
+			name: "yaml",
+			schema: `collections:
+  - name: ls_node
+    type: vertex
+    shards: 3
+    message_types: [ls_node]
+  - name: prefixes
+    message_types: [unicast_prefix_v4, unicast_prefix_v6]
+`,
+		},
+		{
+			name:   "json",
+			schema: `{"collections":[{"name":"peer","type":"document","message_types":["peer"]}]}`,
+		},
+		{
+			name:   "bad yaml",
+			schema: "collections:\n  - name: [ls_node\n",
+			err:    "failed to parse schema file",
+		},
+		{
+			name:   "unknown message type",
+			schema: "collections:\n  - name: ls_node\n    message_types: [ls_vertex]\n",
+			err:    "unknown message type ls_vertex",
+		},
+		{
+			name:   "duplicate collection",
+			schema: "collections:\n  - name: ls_node\n    message_types: [ls_node]\n  - name: ls_node\n    message_types: [ls_link]\n",
+			err:    "collection ls_node is defined more than once",
+		},
+		{
+			name:   "message type feeding two collections",
+			schema: "collections:\n  - name: ls_node\n    message_types: [ls_node]\n  - name: nodes\n    message_types: [ls_node]\n",
+			err:    "message type ls_node feeds both ls_node and nodes collections",
+		},
+		{
+			name:   "unknown collection type",
+			schema: "collections:\n  - name: ls_node\n    type: edge\n    message_types: [ls_node]\n",
+			err:    "unknown type",
+		},
+		{
+			name:   "no message types",
+			schema: "collections:\n  - name: ls_node\n",
+			err:    "has no message types",
+		},
+		{
+			name:   "no name",
+			schema: "collections:\n  - message_types: [ls_node]\n",
+			err:    "collection name cannot be empty",
+		},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		fn := filepath.Join(dir, fmt.Sprintf("schema-%d.yaml", i))
+		if err := os.WriteFile(fn, []byte(tt.schema), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		s, err := LoadSchema(fn)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %+v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: expected error containing %q, actual %v", tt.name, tt.err, err)
+		case tt.err == "" && len(s.Collections) == 0:
+			t.Errorf("%s: expected collections in the schema", tt.name)
+		}
+	}
+	if _, err := LoadSchema(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("expected an error loading a missing schema file")
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package arangodb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	"github.com/golang/glog"
+)
+
+// spoolRecord is a line of the spool file, it carries either an event which has not been sent or
+// a change which has not been written to its history collection when the client stopped.
+type spoolRecord struct {
+	Event   *kafkanotifier.EventMessage `json:"event,omitempty"`
+	History *spoolHistory               `json:"history,omitempty"`
+}
+
+// spoolHistory is a historyRecord of the history collection
+type spoolHistory struct {
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Action     actionType      `json:"action"`
+	Time       time.Time       `json:"time"`
+	Document   json.RawMessage `json:"document,omitempty"`
+}
+
+// Drain waits until queued messages are persisted, their events are sent and their changes are
+// written to history collections, it implements dbclient.Drainer.
+func (a *arangoDB) Drain(ctx context.Context) error {
+	if err := dbclient.WaitDrained(ctx, func() bool {
+		return a.pending.Load() == 0 && a.unsent.Load() == 0
+	}); err != nil {
+		return fmt.Errorf("%d messages are not persisted, %d events and history records are not sent: %w",
+			a.pending.Load(), a.unsent.Load(), err)
+	}
+
+	return nil
+}
+
+// spill keeps the record which could not be queued because the client is stopping, spilled
+// records are written to the spool file when the client stops.
+func (a *arangoDB) spill(r *spoolRecord) {
+	a.spilledLock.Lock()
+	defer a.spilledLock.Unlock()
+	a.spilled = append(a.spilled, r)
+}
+
+// leftovers returns events and history records which have not been sent, it must be called once
+// the goroutines sending them have returned.
+func (a *arangoDB) leftovers() []*spoolRecord {
+	a.spilledLock.Lock()
+	records := a.spilled
+	a.spilled = nil
+	a.spilledLock.Unlock()
+	for _, c := range a.collections {
+	events:
+		for {
+			select {
+			case m := <-c.events:
+				records = append(records, &spoolRecord{Event: m})
+			default:
+				break events
+			}
+		}
+	}
+	for _, h := range a.histories {
+	history:
+		for {
+			select {
+			case rec := <-h.records:
+				records = append(records, h.spoolRecord(rec))
+			default:
+				break history
+			}
+		}
+	}
+
+	return records
+}
+
+// writeSpool stores the records in the spool file, the file is replaced by the next stop, or
+// removed when there is nothing to store.
+func (a *arangoDB) writeSpool(records []*spoolRecord) error {
+	if len(records) == 0 {
+		if err := os.Remove(a.config.SpoolFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	f, err := os.Create(a.config.SpoolFile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	glog.Infof("Stored %d events and history records in spool file %s", len(records), a.config.SpoolFile)
+
+	return f.Close()
+}
+
+// readSpool returns records stored in the spool file by the previous stop
+func (a *arangoDB) readSpool() ([]*spoolRecord, error) {
+	f, err := os.Open(a.config.SpoolFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	records := make([]*spoolRecord, 0)
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		r := &spoolRecord{}
+		if err := dec.Decode(r); err != nil {
+			return nil, fmt.Errorf("failed to decode spool file %s with error: %w", a.config.SpoolFile, err)
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// replaySpool queues records stored by the previous stop before any new message is processed,
+// so events and history keep the order of changes, the spool file is removed once records are queued.
+func (a *arangoDB) replaySpool() error {
+	if a.config.SpoolFile == "" {
+		return nil
+	}
+	records, err := a.readSpool()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	glog.Infof("Replaying %d events and history records from spool file %s", len(records), a.config.SpoolFile)
+	histories := make(map[string]*history)
+	for _, h := range a.histories {
+		histories[h.name] = h
+	}
+	for _, r := range records {
+		switch {
+		case r.Event != nil:
+			c, ok := a.collections[r.Event.TopicType]
+			if !ok || !a.notifyCompletion {
+				glog.Warningf("dropping spooled event for key: %s of type %d, events are not sent", r.Event.Key, r.Event.TopicType)
+				continue
+			}
+			c.queueEvent(r.Event)
+		case r.History != nil:
+			h, ok := histories[r.History.Collection]
+			if !ok {
+				glog.Warningf("dropping spooled change of key: %s, history collection %s is not configured", r.History.Key, r.History.Collection)
+				continue
+			}
+			h.queue(&historyRecord{
+				key:      r.History.Key,
+				action:   r.History.Action,
+				time:     r.History.Time,
+				document: r.History.Document,
+			})
+		}
+	}
+
+	return os.Remove(a.config.SpoolFile)
+}
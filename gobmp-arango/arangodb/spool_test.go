@@ -0,0 +1,110 @@
+package arangodb
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+// newSpoolDB returns a client with a collection sending events and a history collection, the goroutines
+// sending them are not started so queued records stay in the channels.
+func newSpoolDB(spoolFile string) *arangoDB {
+	a := &arangoDB{
+		config:           Config{SpoolFile: spoolFile},
+		stop:             make(chan struct{}),
+		collections:      make(map[dbclient.CollectionType]*collection),
+		notifyCompletion: true,
+	}
+	h := &history{arango: a, name: "ls_node" + HistorySuffix, records: make(chan *historyRecord, eventQueueSize)}
+	a.histories = []*history{h}
+	a.collections[bmp.LSNodeMsg] = &collection{
+		stop:           a.stop,
+		arango:         a,
+		collectionType: bmp.LSNodeMsg,
+		properties:     &collectionProperties{name: "ls_node"},
+		events:         make(chan *kafkanotifier.EventMessage, eventQueueSize),
+		history:        h,
+	}
+
+	return a
+}
+
+func TestSpool(t *testing.T) {
+	t0 := time.Date(2026, 10, 18, 2, 13, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		events  []string
+		changes []string
+		spilled bool
+	}{
+		{
+			name: "nothing to spool",
+		},
+		{
+			name:    "queued events and history",
+			events:  []string{"1", "2"},
+			changes: []string{"1", "2", "3"},
+		},
+		{
+			name:    "records queued after stop",
+			events:  []string{"1"},
+			changes: []string{"1"},
+			spilled: true,
+		},
+	}
+	for _, tt := range tests {
+		fn := filepath.Join(t.TempDir(), "spool.jsonl")
+		a := newSpoolDB(fn)
+		c := a.collections[bmp.LSNodeMsg]
+		if tt.spilled {
+			close(a.stop)
+		}
+		for i, k := range tt.events {
+			c.queueEvent(&kafkanotifier.EventMessage{TopicType: bmp.LSNodeMsg, Key: k, Sequence: uint64(i + 1), Action: string(addAction)})
+		}
+		for _, k := range tt.changes {
+			c.history.queue(&historyRecord{key: k, action: addAction, time: t0, document: json.RawMessage(`{"_key":"` + k + `"}`)})
+		}
+		if err := a.writeSpool(a.leftovers()); err != nil {
+			t.Fatalf("%s: failed to write spool with error: %+v", tt.name, err)
+		}
+		if _, err := os.Stat(fn); (err == nil) != (len(tt.events)+len(tt.changes) != 0) {
+			t.Fatalf("%s: unexpected spool file state, error: %v", tt.name, err)
+		}
+		b := newSpoolDB(fn)
+		if err := b.replaySpool(); err != nil {
+			t.Fatalf("%s: failed to replay spool with error: %+v", tt.name, err)
+		}
+		if _, err := os.Stat(fn); !os.IsNotExist(err) {
+			t.Fatalf("%s: spool file was not removed after replay", tt.name)
+		}
+		rc := b.collections[bmp.LSNodeMsg]
+		if len(rc.events) != len(tt.events) {
+			t.Fatalf("%s: expected %d events, actual %d", tt.name, len(tt.events), len(rc.events))
+		}
+		for i, k := range tt.events {
+			m := <-rc.events
+			if m.Key != k || m.Sequence != uint64(i+1) {
+				t.Fatalf("%s: expected event of key %s sequence %d, actual %s sequence %d", tt.name, k, i+1, m.Key, m.Sequence)
+			}
+		}
+		if len(rc.history.records) != len(tt.changes) {
+			t.Fatalf("%s: expected %d history records, actual %d", tt.name, len(tt.changes), len(rc.history.records))
+		}
+		for _, k := range tt.changes {
+			rec := <-rc.history.records
+			if rec.key != k || !rec.time.Equal(t0) || string(rec.document) != `{"_key":"`+k+`"}` {
+				t.Fatalf("%s: unexpected history record %+v", tt.name, rec)
+			}
+		}
+		if n := b.unsent.Load(); n != int64(len(tt.events)+len(tt.changes)) {
+			t.Fatalf("%s: expected %d unsent records, actual %d", tt.name, len(tt.events)+len(tt.changes), n)
+		}
+	}
+}
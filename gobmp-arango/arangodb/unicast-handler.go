@@ -30,9 +30,19 @@ import (
 
 type unicastPrefixArangoMessage struct {
 	*message.UnicastPrefix
+	// Generation is the generation of the peer the record was learned from
+	Generation int64 `json:"generation,omitempty"`
 }
 
 func (u *unicastPrefixArangoMessage) MakeKey() string {
 	return u.Prefix + "_" + strconv.Itoa(int(u.PrefixLen)) + "_" + u.PeerIP
 	//return u.Prefix + "_" + strconv.Itoa(int(u.PrefixLen)) + "_" + u.PeerIP + "_" + u.Nexthop
 }
+
+func (u *unicastPrefixArangoMessage) peer() (string, string, string) {
+	return u.PeerHash, u.PeerIP, u.RouterIP
+}
+
+func (u *unicastPrefixArangoMessage) setGeneration(g int64) {
+	u.Generation = g
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+)
+
+// Record defines a single line of a capture file, Message carries the gobmp message exactly
+// as it was received from the topic.
+type Record struct {
+	Topic          string                  `json:"topic"`
+	CollectionType dbclient.CollectionType `json:"collection_type"`
+	Timestamp      time.Time               `json:"timestamp"`
+	Message        json.RawMessage         `json:"message"`
+}
+
+// Writer writes records to a JSON lines file, when the file reaches its maximum size, it is
+// rotated to <file>.1, the previous <file>.1 to <file>.2 and so on, up to the maximum number of files.
+type Writer struct {
+	sync.Mutex
+	fn       string
+	maxSize  int64
+	maxFiles int
+	size     int64
+	f        *os.File
+	w        *bufio.Writer
+}
+
+// NewWriter opens the capture file for appending, maxSize of 0 disables rotation
+func NewWriter(fn string, maxSize int64, maxFiles int) (*Writer, error) {
+	w := &Writer{
+		fn:       fn,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.fn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.w = bufio.NewWriter(f)
+	w.size = fi.Size()
+
+	return nil
+}
+
+// Write appends the record to the capture file
+func (w *Writer) Write(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	w.Lock()
+	defer w.Unlock()
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(b)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.w.Write(b)
+	w.size += int64(n)
+
+	return err
+}
+
+// Flush writes buffered records to the capture file
+func (w *Writer) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.w.Flush()
+}
+
+// Close flushes buffered records and closes the capture file
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+
+	return w.f.Close()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	if w.maxFiles > 0 {
+		// The oldest file is dropped, the rest shift by one
+		os.Remove(fmt.Sprintf("%s.%d", w.fn, w.maxFiles))
+		for i := w.maxFiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", w.fn, i), fmt.Sprintf("%s.%d", w.fn, i+1))
+		}
+		if err := os.Rename(w.fn, w.fn+".1"); err != nil {
+			return err
+		}
+	} else {
+		if err := os.Remove(w.fn); err != nil {
+			return err
+		}
+	}
+
+	return w.open()
+}
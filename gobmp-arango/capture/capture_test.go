@@ -0,0 +1,111 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+func TestWriterRotation(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "capture.jsonl")
+	r := &Record{
+		Topic:          "gobmp.parsed.ls_node",
+		CollectionType: bmp.LSNodeMsg,
+		Timestamp:      time.Unix(0, 0).UTC(),
+		Message:        json.RawMessage(`{"action":"add"}`),
+	}
+	b, _ := json.Marshal(r)
+	lineSize := int64(len(b) + 1)
+	// Each file holds 2 records, 2 rotated files are kept
+	w, err := NewWriter(fn, lineSize*2, 2)
+	if err != nil {
+		t.Fatalf("failed to create writer with error: %+v", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("failed to write record with error: %+v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close writer with error: %+v", err)
+	}
+	for fn, expect := range map[string]int{fn: 1, fn + ".1": 2, fn + ".2": 2} {
+		if n := countLines(t, fn); n != expect {
+			t.Fatalf("file %s: expected %d records, actual %d", fn, expect, n)
+		}
+	}
+	if _, err := os.Stat(fn + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("file %s.3 should not exist", fn)
+	}
+}
+
+func countLines(t *testing.T, fn string) int {
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open %s with error: %+v", fn, err)
+	}
+	defer f.Close()
+	n := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r Record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("file %s has invalid record: %+v", fn, err)
+		}
+		n++
+	}
+
+	return n
+}
+
+func TestReader(t *testing.T) {
+	input := `{"topic":"gobmp.parsed.ls_node","timestamp":"2022-01-01T00:00:00Z","message":{"action":"add"}}
+
+{"collection_type":` + strconv.Itoa(bmp.PeerStateChangeMsg) + `,"message":{"action":"up"}}
+`
+	r := NewReader(strings.NewReader(input))
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatalf("failed to read the first record with error: %+v", err)
+	}
+	if rec.CollectionType != bmp.LSNodeMsg {
+		t.Fatalf("expected collection type %d, actual %d", bmp.LSNodeMsg, rec.CollectionType)
+	}
+	rec, err = r.Next()
+	if err != nil {
+		t.Fatalf("failed to read the second record with error: %+v", err)
+	}
+	if rec.Topic != "gobmp.parsed.peer" || !rec.Timestamp.IsZero() {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, actual %+v", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, fn := range []string{"capture.jsonl", "capture.jsonl.1", "capture.jsonl.2", "other.jsonl"} {
+		if err := os.WriteFile(filepath.Join(dir, fn), nil, 0644); err != nil {
+			t.Fatalf("failed to create %s with error: %+v", fn, err)
+		}
+	}
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("failed to list files with error: %+v", err)
+	}
+	expect := []string{"capture.jsonl.2", "capture.jsonl.1", "capture.jsonl", "other.jsonl"}
+	for i, fn := range files {
+		if filepath.Base(fn) != expect[i] {
+			t.Fatalf("expected files %v, actual %v", expect, files)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+)
+
+const (
+	// maxLineSize defines the longest record a reader accepts
+	maxLineSize = 16 * 1024 * 1024
+)
+
+// Reader reads records from a JSON lines capture
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// NewReader returns a reader of records from r
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	return &Reader{s: s}
+}
+
+// Next returns the next record, io.EOF is returned when there are no more records. The collection type
+// of the record is resolved from its topic, the collection type is used only for records without a topic.
+func (r *Reader) Next() (*Record, error) {
+	for r.s.Scan() {
+		r.line++
+		b := r.s.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(b, rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if rec.Topic != "" {
+			t, ok := dbclient.TopicType(rec.Topic)
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown topic %s", r.line, rec.Topic)
+			}
+			rec.CollectionType = t
+		} else if rec.Topic = dbclient.TopicName(rec.CollectionType); rec.Topic == "" {
+			return nil, fmt.Errorf("line %d: record has neither topic nor known collection type", r.line)
+		}
+		if len(rec.Message) == 0 {
+			return nil, fmt.Errorf("line %d: record has no message", r.line)
+		}
+		return rec, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// Files returns capture files found at the path in the order they were written. When the path is
+// a directory, all its files are returned, rotated files <file>.N precede <file>, higher N first.
+func Files(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		bi, ni := rotation(files[i])
+		bj, nj := rotation(files[j])
+		if bi != bj {
+			return bi < bj
+		}
+		return ni > nj
+	})
+
+	return files, nil
+}
+
+// rotation splits the rotated file name into the name of the file and the rotation number
+func rotation(fn string) (string, int) {
+	i := strings.LastIndex(fn, ".")
+	if i == -1 {
+		return fn, 0
+	}
+	n, err := strconv.Atoi(fn[i+1:])
+	if err != nil || n <= 0 {
+		return fn, 0
+	}
+
+	return fn[:i], n
+}
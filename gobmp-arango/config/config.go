@@ -0,0 +1,265 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package config loads the settings of every binary from a YAML file, environment variables and
+// command line flags. Settings are named after the flags, environment variables override the file
+// and flags override both, so Kubernetes manifests and Helm values are uniform across processors.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrefix prefixes environment variables of settings, "--database-server" is set by JALAPENO_DATABASE_SERVER
+	EnvPrefix = "JALAPENO_"
+	// Redacted replaces values of secrets printed by "--print-config"
+	Redacted = "<redacted>"
+
+	fileFlag  = "config"
+	printFlag = "print-config"
+)
+
+// Parse parses the command line of the process, see Load
+func Parse(required ...string) error {
+	return Load(flag.CommandLine, os.Args[1:], os.LookupEnv, required...)
+}
+
+// Load registers "--config" and "--print-config" flags, parses the arguments and sets the flags
+// which were not passed in the arguments from environment variables or the configuration file.
+// Flags spelled with underscores instead of dashes, for example "--batch_size", are accepted in
+// the arguments, the environment and the file. Load fails when one of the required settings is empty.
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool), required ...string) error {
+	if fs.Lookup(fileFlag) == nil {
+		fs.String(fileFlag, "", "YAML file with settings named after the flags, "+EnvPrefix+"<FLAG> environment variables override the file and flags override both")
+	}
+	if fs.Lookup(printFlag) == nil {
+		fs.Bool(printFlag, false, "when true, the effective configuration is printed with secrets redacted and the binary exits")
+	}
+	if err := fs.Parse(normalizeArgs(fs, args)); err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	fn := fs.Lookup(fileFlag).Value.String()
+	if v, ok := lookupEnv(EnvName(fileFlag)); ok && !set[fileFlag] {
+		fn = v
+	}
+	for name := range set {
+		if isSecret(name) {
+			fmt.Fprintf(fs.Output(), "warning: \"--%s\" is visible in process listings, set %s or use a credentials source instead\n", name, EnvName(name))
+		}
+	}
+	settings, err := readFile(fs, fn)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == fileFlag {
+			return
+		}
+		v, ok := lookupEnv(EnvName(f.Name))
+		src := "environment variable " + EnvName(f.Name)
+		if !ok {
+			v, ok = settings[f.Name]
+			src = fn
+		}
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q of %q in %s: %v", v, f.Name, src, err))
+		}
+	})
+	for _, name := range required {
+		if f := fs.Lookup(name); f != nil && f.Value.String() == "" {
+			errs = append(errs, fmt.Sprintf("%q is required, set it in the configuration file, %s or \"--%s\"", name, EnvName(name), name))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// EnvName returns the name of the environment variable of the flag
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// PrintRequested returns true when "--print-config" is set
+func PrintRequested(fs *flag.FlagSet) bool {
+	f := fs.Lookup(printFlag)
+	return f != nil && f.Value.String() == "true"
+}
+
+// PrintIfRequested prints the configuration of the command line to the standard output and exits
+// when "--print-config" is set.
+func PrintIfRequested() {
+	if !PrintRequested(flag.CommandLine) {
+		return
+	}
+	if err := Print(flag.CommandLine, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to print the configuration with error: %+v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Print writes the effective value of every flag as a YAML configuration file, values of secrets
+// are redacted.
+func Print(fs *flag.FlagSet, w io.Writer) error {
+	settings := make(map[string]interface{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == fileFlag || f.Name == printFlag {
+			return
+		}
+		if isSecret(f.Name) && f.Value.String() != "" {
+			settings[f.Name] = Redacted
+			return
+		}
+		settings[f.Name] = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			switch v := g.Get().(type) {
+			case bool, int, int64, uint, uint64, float64:
+				settings[f.Name] = v
+			case time.Duration:
+				settings[f.Name] = v.String()
+			}
+		}
+	})
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+	if err := e.Encode(settings); err != nil {
+		return err
+	}
+
+	return e.Close()
+}
+
+// readFile returns the settings of the configuration file by flag names, lists are joined with
+// commas as flags taking lists expect them.
+func readFile(fs *flag.FlagSet, fn string) (map[string]string, error) {
+	settings := make(map[string]string)
+	if fn == "" {
+		return settings, nil
+	}
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %s with error: %w", fn, err)
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file %s with error: %w", fn, err)
+	}
+	for k, v := range m {
+		name := lookupName(fs, k)
+		if name == "" {
+			return nil, fmt.Errorf("unknown setting %q in configuration file %s", k, fn)
+		}
+		switch v := v.(type) {
+		case string:
+			// Printed configurations are valid files, their redacted secrets are provided otherwise
+			if v != Redacted {
+				settings[name] = v
+			}
+		case nil:
+			settings[name] = ""
+		case []interface{}:
+			s := make([]string, len(v))
+			for i, e := range v {
+				s[i] = fmt.Sprint(e)
+			}
+			settings[name] = strings.Join(s, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("setting %q in configuration file %s must be a value or a list", k, fn)
+		default:
+			settings[name] = fmt.Sprint(v)
+		}
+	}
+
+	return settings, nil
+}
+
+// normalizeArgs replaces underscores with dashes in names of flags which are not defined with underscores
+func normalizeArgs(fs *flag.FlagSet, args []string) []string {
+	n := make([]string, len(args))
+	copy(n, args)
+	for i, a := range n {
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		dashes := "-"
+		if strings.HasPrefix(a, "--") {
+			dashes = "--"
+		}
+		name, value, hasValue := strings.Cut(a[len(dashes):], "=")
+		if fs.Lookup(name) != nil {
+			continue
+		}
+		if name = lookupName(fs, name); name == "" {
+			continue
+		}
+		n[i] = dashes + name
+		if hasValue {
+			n[i] += "=" + value
+		}
+	}
+
+	return n
+}
+
+// lookupName returns the name of the flag spelled with dashes or underscores, empty when no flag matches
+func lookupName(fs *flag.FlagSet, name string) string {
+	for _, n := range []string{name, strings.ReplaceAll(name, "_", "-"), strings.ReplaceAll(name, "-", "_")} {
+		if fs.Lookup(n) != nil {
+			return n
+		}
+	}
+
+	return ""
+}
+
+// isSecret returns true for flags holding a password, a token or a secret rather than a file storing it
+func isSecret(name string) bool {
+	for _, s := range []string{"-pass", "-password", "-token", "-secret"} {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type settings struct {
+	server    string
+	pass      string
+	batchSize int
+	lanes     bool
+	drain     time.Duration
+}
+
+func newFlagSet(s *settings) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	fs.StringVar(&s.server, "database-server", "", "")
+	fs.StringVar(&s.pass, "database-pass", "", "")
+	fs.IntVar(&s.batchSize, "batch-size", 1000, "")
+	fs.BoolVar(&s.lanes, "priority-lanes", true, "")
+	fs.DurationVar(&s.drain, "drain-timeout", 30*time.Second, "")
+
+	return fs
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(file, []byte("database-server: [http://a:8529, http://b:8529]\nbatch_size: 500\ndrain-timeout: 1m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(bad, []byte("database-servers: http://a:8529\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		required []string
+		expect   settings
+		fail     bool
+	}{
+		{
+			name:   "defaults",
+			expect: settings{batchSize: 1000, lanes: true, drain: 30 * time.Second},
+		},
+		{
+			name:   "file",
+			args:   []string{"--config", file},
+			expect: settings{server: "http://a:8529,http://b:8529", batchSize: 500, lanes: true, drain: time.Minute},
+		},
+		{
+			name:   "file from environment",
+			env:    map[string]string{"JALAPENO_CONFIG": file},
+			expect: settings{server: "http://a:8529,http://b:8529", batchSize: 500, lanes: true, drain: time.Minute},
+		},
+		{
+			name:   "environment overrides file",
+			args:   []string{"--config=" + file},
+			env:    map[string]string{"JALAPENO_BATCH_SIZE": "200", "JALAPENO_PRIORITY_LANES": "false"},
+			expect: settings{server: "http://a:8529,http://b:8529", batchSize: 200, drain: time.Minute},
+		},
+		{
+			name:   "flags override environment and file",
+			args:   []string{"--config", file, "--batch_size=100", "-database-pass", "secret"},
+			env:    map[string]string{"JALAPENO_BATCH_SIZE": "200"},
+			expect: settings{server: "http://a:8529,http://b:8529", pass: "secret", batchSize: 100, lanes: true, drain: time.Minute},
+		},
+		{
+			name: "unknown setting in file",
+			args: []string{"--config", bad},
+			fail: true,
+		},
+		{
+			name: "invalid environment variable",
+			env:  map[string]string{"JALAPENO_BATCH_SIZE": "many"},
+			fail: true,
+		},
+		{
+			name:     "missing required setting",
+			required: []string{"database-server"},
+			fail:     true,
+		},
+	}
+	for _, tt := range tests {
+		var s settings
+		fs := newFlagSet(&s)
+		err := Load(fs, tt.args, func(k string) (string, bool) {
+			v, ok := tt.env[k]
+			return v, ok
+		}, tt.required...)
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("%s: expected to fail but succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed with error: %+v", tt.name, err)
+		}
+		if s != tt.expect {
+			t.Fatalf("%s: expected %+v, actual %+v", tt.name, tt.expect, s)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var s settings
+	fs := newFlagSet(&s)
+	if err := Load(fs, []string{"--database-pass=secret", "--batch-size=10", "--print-config"}, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatal(err)
+	}
+	if !PrintRequested(fs) {
+		t.Fatal("print was not requested")
+	}
+	var b bytes.Buffer
+	if err := Print(fs, &b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "secret") {
+		t.Fatalf("password is not redacted:\n%s", b.String())
+	}
+	// The printed configuration is loaded back as a configuration file
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["batch-size"] != 10 || m["database-pass"] != Redacted || m["drain-timeout"] != "30s" || m["priority-lanes"] != true {
+		t.Fatalf("unexpected configuration %+v", m)
+	}
+	fn := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(fn, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var r settings
+	if err := Load(newFlagSet(&r), []string{"--config", fn}, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatal(err)
+	}
+	if r.batchSize != 10 || r.pass != "" || r.drain != 30*time.Second || !r.lanes {
+		t.Fatalf("unexpected settings %+v", r)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package credentials supplies user names and passwords to ArangoDB and Kafka clients. Credentials
+// are static, read from environment variables or read from files which are watched, so clients
+// re-authenticate without a restart when mounted Kubernetes secrets rotate.
+package credentials
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// SourceStatic uses the user name and password of the configuration
+	SourceStatic = "static"
+	// SourceFile reads the user name and password from files and reads them again when the files change
+	SourceFile = "file"
+	// SourceEnv reads the user name and password from environment variables
+	SourceEnv = "env"
+	// DefaultUserFile defines the name of file containing the database user name
+	DefaultUserFile = "./credentials/.username"
+	// DefaultPassFile defines the name of file containing the database password
+	DefaultPassFile = "./credentials/.password"
+	// DefaultUserEnv and DefaultPassEnv are the environment variables of the env source
+	DefaultUserEnv = "DATABASE_USER"
+	DefaultPassEnv = "DATABASE_PASS"
+	// DefaultInterval defines how often watched files are checked for changes
+	DefaultInterval = 10 * time.Second
+	// MaxLength defines maximum length of a credential file, it holds a user name, a password or a secret
+	MaxLength = 4096
+)
+
+// Credentials is a user name and a password, a token or a secret is carried as the password
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// Provider supplies credentials, providers of rotating credentials return the new ones after they change
+type Provider interface {
+	Credentials() (Credentials, error)
+}
+
+// Config selects the provider of database credentials
+type Config struct {
+	// Source is one of static, file or env, when not set the user name and password of the
+	// configuration are used if both are set and credential files otherwise
+	Source string
+	// UserFile and PassFile are files storing the user name and password of the file source
+	UserFile string
+	PassFile string
+	// UserEnv and PassEnv are environment variables storing the user name and password of the env source
+	UserEnv string
+	PassEnv string
+	// Interval defines how often credential files are checked for changes, DefaultInterval when 0
+	Interval time.Duration
+}
+
+// RegisterFlags registers command line flags of the configuration, every binary uses the same flags
+func RegisterFlags(c *Config) {
+	flag.StringVar(&c.Source, "credentials-source", "", "source of database credentials: static (\"--database-user\" and \"--database-pass\"), file (watched files) or env, static when both are set and file otherwise")
+	flag.StringVar(&c.UserFile, "credentials-user-file", DefaultUserFile, "file storing the database user name, it is read again when it changes")
+	flag.StringVar(&c.PassFile, "credentials-pass-file", DefaultPassFile, "file storing the database password, it is read again when it changes")
+	flag.StringVar(&c.UserEnv, "credentials-user-env", DefaultUserEnv, "environment variable storing the database user name")
+	flag.StringVar(&c.PassEnv, "credentials-pass-env", DefaultPassEnv, "environment variable storing the database password")
+	flag.DurationVar(&c.Interval, "credentials-reload-interval", DefaultInterval, "how often credential files are checked for changes")
+}
+
+// Provider returns the provider selected by the configuration, user and password are the static credentials
+func (c *Config) Provider(user, password string) (Provider, error) {
+	source := c.Source
+	if source == "" {
+		source = SourceFile
+		if user != "" && password != "" {
+			source = SourceStatic
+		}
+	}
+	switch source {
+	case SourceStatic:
+		if user == "" || password == "" {
+			return nil, fmt.Errorf("static credentials require both user name and password")
+		}
+		return NewStatic(user, password), nil
+	case SourceFile:
+		return NewFile(orDefault(c.UserFile, DefaultUserFile), orDefault(c.PassFile, DefaultPassFile), c.Interval)
+	case SourceEnv:
+		return NewEnv(orDefault(c.UserEnv, DefaultUserEnv), orDefault(c.PassEnv, DefaultPassEnv))
+	default:
+		return nil, fmt.Errorf("unknown credentials source %q, supported sources are %s, %s and %s", source, SourceStatic, SourceFile, SourceEnv)
+	}
+}
+
+func orDefault(v, d string) string {
+	if v == "" {
+		return d
+	}
+
+	return v
+}
+
+type static Credentials
+
+// NewStatic returns a provider of credentials which never change
+func NewStatic(user, password string) Provider {
+	return static{User: user, Password: password}
+}
+
+func (s static) Credentials() (Credentials, error) {
+	return Credentials(s), nil
+}
+
+type env struct {
+	userVar string
+	passVar string
+}
+
+// NewEnv returns a provider reading the user name and password from environment variables
+func NewEnv(userVar, passVar string) (Provider, error) {
+	e := &env{userVar: userVar, passVar: passVar}
+	if _, err := e.Credentials(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *env) Credentials() (Credentials, error) {
+	c := Credentials{User: os.Getenv(e.userVar), Password: os.Getenv(e.passVar)}
+	if c.User == "" || c.Password == "" {
+		return Credentials{}, fmt.Errorf("environment variables %s and %s must both be set", e.userVar, e.passVar)
+	}
+
+	return c, nil
+}
+
+// stamp identifies the version of a file, Kubernetes replaces files of rotated secrets
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// File is a provider reading credentials from files, files are checked for changes at most once per
+// interval and read again when they change.
+type File struct {
+	userFile string
+	passFile string
+	interval time.Duration
+	now      func() time.Time
+
+	sync.Mutex
+	checked time.Time
+	stamps  [2]stamp
+	creds   Credentials
+}
+
+// NewFile returns a provider reading the user name and password from files, the user file is optional
+// for providers of a token or a secret.
+func NewFile(userFile, passFile string, interval time.Duration) (*File, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	f := &File{
+		userFile: userFile,
+		passFile: passFile,
+		interval: interval,
+		now:      time.Now,
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Credentials returns the credentials, files which changed since the last check are read again. When
+// the files cannot be read while the secret is being replaced, the previous credentials are returned.
+func (f *File) Credentials() (Credentials, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.now().Sub(f.checked) < f.interval {
+		return f.creds, nil
+	}
+	if err := f.reload(); err != nil {
+		glog.Warningf("failed to reload credentials, previous credentials are used, error: %+v", err)
+	}
+
+	return f.creds, nil
+}
+
+func (f *File) reload() error {
+	f.checked = f.now()
+	var stamps [2]stamp
+	for i, fn := range []string{f.userFile, f.passFile} {
+		if fn == "" {
+			continue
+		}
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return err
+		}
+		stamps[i] = stamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	if stamps == f.stamps {
+		return nil
+	}
+	var c Credentials
+	var err error
+	if f.userFile != "" {
+		if c.User, err = readFile(f.userFile); err != nil {
+			return err
+		}
+	}
+	if c.Password, err = readFile(f.passFile); err != nil {
+		return err
+	}
+	if f.creds != (Credentials{}) && c != f.creds {
+		glog.Infof("credentials in %s changed, clients re-authenticate", f.passFile)
+	}
+	f.stamps, f.creds = stamps, c
+
+	return nil
+}
+
+// readFile returns the content of the file without surrounding white spaces, so files mounted from
+// secrets may end with a new line.
+func readFile(fn string) (string, error) {
+	if fn == "" {
+		return "", fmt.Errorf("file is not set")
+	}
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > MaxLength {
+		return "", fmt.Errorf("length of data %d in %s exceeds maximum acceptable length: %d", len(b), fn, MaxLength)
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", fmt.Errorf("file %s is empty", fn)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,124 @@
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProvider(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "user")
+	pass := filepath.Join(dir, "pass")
+	os.WriteFile(user, []byte("root\n"), 0600)
+	os.WriteFile(pass, []byte("secret\n"), 0600)
+	t.Setenv("TEST_USER", "envuser")
+	t.Setenv("TEST_PASS", "envpass")
+	tests := []struct {
+		name     string
+		config   Config
+		user     string
+		password string
+		expect   Credentials
+		fail     bool
+	}{
+		{
+			name:     "static when both are set",
+			config:   Config{UserFile: user, PassFile: pass},
+			user:     "admin",
+			password: "admin",
+			expect:   Credentials{User: "admin", Password: "admin"},
+		},
+		{
+			name:   "files when password is not set",
+			config: Config{UserFile: user, PassFile: pass},
+			user:   "admin",
+			expect: Credentials{User: "root", Password: "secret"},
+		},
+		{
+			name:   "environment",
+			config: Config{Source: SourceEnv, UserEnv: "TEST_USER", PassEnv: "TEST_PASS"},
+			expect: Credentials{User: "envuser", Password: "envpass"},
+		},
+		{
+			name:   "missing environment variable",
+			config: Config{Source: SourceEnv, UserEnv: "TEST_USER", PassEnv: "TEST_MISSING"},
+			fail:   true,
+		},
+		{
+			name:   "static without password",
+			config: Config{Source: SourceStatic},
+			user:   "admin",
+			fail:   true,
+		},
+		{
+			name:   "missing file",
+			config: Config{UserFile: user, PassFile: filepath.Join(dir, "missing")},
+			fail:   true,
+		},
+		{
+			name:   "unknown source",
+			config: Config{Source: "vault"},
+			fail:   true,
+		},
+	}
+	for _, tt := range tests {
+		p, err := tt.config.Provider(tt.user, tt.password)
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("%s: expected to fail but succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed with error: %+v", tt.name, err)
+		}
+		c, err := p.Credentials()
+		if err != nil {
+			t.Fatalf("%s: failed to get credentials with error: %+v", tt.name, err)
+		}
+		if c != tt.expect {
+			t.Fatalf("%s: expected %+v, actual %+v", tt.name, tt.expect, c)
+		}
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "user")
+	pass := filepath.Join(dir, "pass")
+	os.WriteFile(user, []byte("root"), 0600)
+	os.WriteFile(pass, []byte("old"), 0600)
+	f, err := NewFile(user, pass, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create provider with error: %+v", err)
+	}
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	// The secret is replaced, the new password has a different size
+	os.Remove(pass)
+	steps := []struct {
+		name    string
+		after   time.Duration
+		content string
+		expect  string
+	}{
+		{name: "file is missing while it is replaced", after: 2 * time.Minute, expect: "old"},
+		{name: "file is not checked before the interval", after: 30 * time.Second, content: "rotated", expect: "old"},
+		{name: "rotated file is read", after: time.Minute, expect: "rotated"},
+	}
+	for _, s := range steps {
+		if s.content != "" {
+			os.WriteFile(pass, []byte(s.content), 0600)
+		}
+		now = now.Add(s.after)
+		c, err := f.Credentials()
+		if err != nil {
+			t.Fatalf("%s: failed with error: %+v", s.name, err)
+		}
+		if c.User != "root" || c.Password != s.expect {
+			t.Fatalf("%s: expected password %q, actual %+v", s.name, s.expect, c)
+		}
+	}
+}
@@ -22,7 +22,12 @@
 
 package dbclient
 
-import "github.com/sbezverk/gobmp/pkg/bmp"
+import (
+	"context"
+	"time"
+
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
 
 // DB defines required methods for a database client to support
 type DB interface {
@@ -47,6 +52,7 @@ const (
 	L3VPN           CollectionType = bmp.L3VPNMsg
 	L3VPNV4         CollectionType = bmp.L3VPNV4Msg
 	L3VPNV6         CollectionType = bmp.L3VPNV6Msg
+	EVPN            CollectionType = bmp.EVPNMsg
 	UnicastPrefix   CollectionType = bmp.UnicastPrefixMsg
 	UnicastPrefixV4 CollectionType = bmp.UnicastPrefixV4Msg
 	UnicastPrefixV6 CollectionType = bmp.UnicastPrefixV6Msg
@@ -57,3 +63,60 @@ const (
 	FlowspecV4      CollectionType = bmp.FlowspecV4Msg
 	FlowspecV6      CollectionType = bmp.FlowspecV6Msg
 )
+
+// AckDB defines an optional method for a database client which is able to report back
+// when a message has been fully processed, either persisted or permanently discarded.
+// Messengers use it to commit a consumed message only after its document was stored.
+type AckDB interface {
+	StoreMessageWithAck(msgType CollectionType, msg []byte, ack func()) error
+}
+
+// TraceDB defines an optional method for a database client which records the processing of a message
+// as spans of the trace carried by the context, ack is handled as by AckDB.
+type TraceDB interface {
+	StoreMessageWithContext(ctx context.Context, msgType CollectionType, msg []byte, ack func()) error
+}
+
+// Store hands the message to the database client with the trace context and ack, when the client
+// does not implement TraceDB or AckDB, ack is called as soon as the client accepted the message.
+func Store(ctx context.Context, db DB, msgType CollectionType, msg []byte, ack func()) error {
+	if t, ok := db.(TraceDB); ok {
+		return t.StoreMessageWithContext(ctx, msgType, msg, ack)
+	}
+	if a, ok := db.(AckDB); ok && ack != nil {
+		return a.StoreMessageWithAck(msgType, msg, ack)
+	}
+	if err := db.StoreMessage(msgType, msg); err != nil {
+		return err
+	}
+	if ack != nil {
+		ack()
+	}
+
+	return nil
+}
+
+// Drainer defines an optional method for a database client which is able to complete the processing
+// of accepted messages before it stops. Drain returns once every accepted message was processed and
+// acknowledged, or with the context's error when the context is done first.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// drainPollInterval defines how often WaitDrained checks the drained condition
+const drainPollInterval = 50 * time.Millisecond
+
+// WaitDrained waits until drained returns true or the context is done
+func WaitDrained(ctx context.Context, drained func() bool) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for !drained() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package dbclient
+
+import (
+	"github.com/sbezverk/gobmp/pkg/bmp"
+	"github.com/sbezverk/gobmp/pkg/kafka"
+)
+
+var (
+	// topicTypes maps gobmp topics to the collection type of messages they carry
+	topicTypes = map[string]CollectionType{
+		kafka.PeerTopic:              bmp.PeerStateChangeMsg,
+		kafka.UnicastMessageTopic:    bmp.UnicastPrefixMsg,
+		kafka.UnicastMessageV4Topic:  bmp.UnicastPrefixV4Msg,
+		kafka.UnicastMessageV6Topic:  bmp.UnicastPrefixV6Msg,
+		kafka.LSNodeMessageTopic:     bmp.LSNodeMsg,
+		kafka.LSLinkMessageTopic:     bmp.LSLinkMsg,
+		kafka.L3vpnMessageTopic:      bmp.L3VPNMsg,
+		kafka.L3vpnMessageV4Topic:    bmp.L3VPNV4Msg,
+		kafka.L3vpnMessageV6Topic:    bmp.L3VPNV6Msg,
+		kafka.LSPrefixMessageTopic:   bmp.LSPrefixMsg,
+		kafka.LSSRv6SIDMessageTopic:  bmp.LSSRv6SIDMsg,
+		kafka.EVPNMessageTopic:       bmp.EVPNMsg,
+		kafka.SRPolicyMessageTopic:   bmp.SRPolicyMsg,
+		kafka.SRPolicyMessageV4Topic: bmp.SRPolicyV4Msg,
+		kafka.SRPolicyMessageV6Topic: bmp.SRPolicyV6Msg,
+		kafka.FlowspecMessageTopic:   bmp.FlowspecMsg,
+		kafka.FlowspecMessageV4Topic: bmp.FlowspecV4Msg,
+		kafka.FlowspecMessageV6Topic: bmp.FlowspecV6Msg,
+	}
+)
+
+// TopicName returns the name of the gobmp topic carrying messages of the collection type
+func TopicName(t CollectionType) string {
+	for topic, tt := range topicTypes {
+		if tt == t {
+			return topic
+		}
+	}
+
+	return ""
+}
+
+// TopicType returns the collection type of messages carried by the gobmp topic
+func TopicType(topic string) (CollectionType, bool) {
+	t, ok := topicTypes[topic]
+
+	return t, ok
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package deadletter
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/golang/glog"
+	"github.com/sbezverk/gobmp/pkg/tools"
+)
+
+const (
+	// DefaultTopic defines the name of the topic where records which could not be stored are published
+	DefaultTopic = "gobmp.dead_letter"
+)
+
+// Record defines a message which gobmp-arango failed to store, along with the reason of the failure.
+// Payload carries the original message exactly as it was received from the original topic.
+type Record struct {
+	Topic          string                  `json:"topic"`
+	CollectionType dbclient.CollectionType `json:"collection_type"`
+	Key            string                  `json:"key,omitempty"`
+	Error          string                  `json:"error"`
+	Attempts       int                     `json:"attempts"`
+	Timestamp      time.Time               `json:"timestamp"`
+	Payload        []byte                  `json:"payload"`
+}
+
+// Publisher defines a method to publish a dead-lettered record
+type Publisher interface {
+	Publish(*Record) error
+}
+
+type publisher struct {
+	topic    string
+	producer sarama.SyncProducer
+}
+
+// NewKafkaPublisher returns a dead letter publisher, the topic is created if it does not exist.
+// security configures TLS and SASL of connections to the brokers, nil connects in plaintext.
+func NewKafkaPublisher(kafkaSrv string, topic string, security *kafkaclient.Config) (Publisher, error) {
+	glog.Infof("Initializing dead letter publisher for topic: %s", topic)
+	brokers := strings.Split(kafkaSrv, ",")
+	for _, b := range brokers {
+		if err := tools.HostAddrValidator(b); err != nil {
+			return nil, err
+		}
+	}
+	config := sarama.NewConfig()
+	config.ClientID = "gobmp-arango-dead-letter"
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Version = sarama.V2_6_0_0
+	if err := security.Apply(config); err != nil {
+		return nil, err
+	}
+
+	if err := ensureTopic(brokers, config, topic); err != nil {
+		return nil, err
+	}
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &publisher{
+		topic:    topic,
+		producer: producer,
+	}, nil
+}
+
+func (p *publisher) Publish(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, _, err = p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(r.Key),
+		Value: sarama.ByteEncoder(b),
+	})
+
+	return err
+}
+
+func ensureTopic(brokers []string, config *sarama.Config, topic string) error {
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	err = admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}, false)
+	if err != nil {
+		if e, ok := err.(*sarama.TopicError); ok && e.Err == sarama.ErrTopicAlreadyExists {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
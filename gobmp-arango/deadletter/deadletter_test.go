@@ -0,0 +1,71 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+func TestPublish(t *testing.T) {
+	record := &Record{
+		Topic:          "gobmp.parsed.ls_link",
+		CollectionType: bmp.LSLinkMsg,
+		Key:            "1_0_0_0.0.0.0_10.0.0.1",
+		Error:          "document too large",
+		Attempts:       11,
+		Timestamp:      time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		Payload:        []byte(`{"action":"add"}`),
+	}
+	check := func(m *sarama.ProducerMessage) error {
+		if m.Topic != DefaultTopic {
+			return errors.New("unexpected topic " + m.Topic)
+		}
+		key, _ := m.Key.Encode()
+		if string(key) != record.Key {
+			return errors.New("unexpected key " + string(key))
+		}
+		b, _ := m.Value.Encode()
+		got := &Record{}
+		if err := json.Unmarshal(b, got); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got, record) {
+			return errors.New("unexpected record " + string(b))
+		}
+		return nil
+	}
+	tests := []struct {
+		name    string
+		expect  func(*mocks.SyncProducer)
+		wantErr bool
+	}{
+		{
+			name: "published",
+			expect: func(p *mocks.SyncProducer) {
+				p.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(check)
+			},
+		},
+		{
+			name: "broker failure",
+			expect: func(p *mocks.SyncProducer) {
+				p.ExpectSendMessageWithMessageCheckerFunctionAndFail(check, errors.New("broker unavailable"))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		producer := mocks.NewSyncProducer(t, nil)
+		tt.expect(producer)
+		p := &publisher{topic: DefaultTopic, producer: producer}
+		if err := p.Publish(record); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %t, actual %v", tt.name, tt.wantErr, err)
+		}
+		producer.Close()
+	}
+}
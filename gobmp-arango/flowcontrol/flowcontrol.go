@@ -0,0 +1,431 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package flowcontrol bounds the number of messages consumed from Kafka and not yet handed to the
+// database client. Messages are queued in lanes, so link-state and peer messages are not stuck behind a
+// flood of unicast prefixes. Every message type of a lane is drained by its own dispatcher, a type whose
+// database collection is slow does not hold up other types of the lane. When a lane fills up
+// to its high watermark, Kafka partitions feeding the lane are paused until the lane drains to its
+// low watermark.
+package flowcontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultQueueSize defines the number of messages a lane holds when the queue size is not configured
+	DefaultQueueSize = 10000
+	// LanePriority carries peer and link-state messages when priority lanes are enabled
+	LanePriority = "priority"
+	// LaneBulk carries all other messages when priority lanes are enabled
+	LaneBulk = "bulk"
+	// LaneDefault carries all messages when priority lanes are disabled
+	LaneDefault = "default"
+)
+
+var (
+	// ErrQueueFull is returned, or wrapped, by database clients which cannot accept the message at the moment,
+	// the message is retried with backoff until it is accepted.
+	ErrQueueFull = errors.New("queue is full")
+	// ErrStopped is returned for messages received after the controller was stopped
+	ErrStopped = errors.New("flow control stopped")
+	// retryInterval defines the initial delay before a message rejected with ErrQueueFull is retried
+	retryInterval = 10 * time.Millisecond
+	// maxRetryInterval caps the delay between retries of a message rejected with ErrQueueFull
+	maxRetryInterval = time.Second
+	// priorityTypes lists message types carried by the priority lane
+	priorityTypes = map[dbclient.CollectionType]bool{
+		bmp.PeerStateChangeMsg: true,
+		bmp.LSNodeMsg:          true,
+		bmp.LSLinkMsg:          true,
+		bmp.LSPrefixMsg:        true,
+		bmp.LSSRv6SIDMsg:       true,
+	}
+)
+
+var (
+	laneDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "jalapeno",
+		Subsystem: "flow_control",
+		Name:      "lane_depth",
+		Help:      "Number of messages queued in the lane and not yet handed to the database client.",
+	}, []string{"lane"})
+	lanePaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "jalapeno",
+		Subsystem: "flow_control",
+		Name:      "lane_paused",
+		Help:      "1 when Kafka partitions feeding the lane are paused.",
+	}, []string{"lane"})
+	lanePauses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "jalapeno",
+		Subsystem: "flow_control",
+		Name:      "lane_pauses_total",
+		Help:      "Number of times the lane reached its high watermark.",
+	}, []string{"lane"})
+	laneRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "jalapeno",
+		Subsystem: "flow_control",
+		Name:      "lane_retries_total",
+		Help:      "Number of messages the database client rejected because its queue was full.",
+	}, []string{"lane"})
+)
+
+// Config defines the size and watermarks of every lane
+type Config struct {
+	// QueueSize is the maximum number of messages queued in a lane
+	QueueSize int
+	// HighWatermark is the lane depth pausing the lane's Kafka partitions, 80% of the queue size by default
+	HighWatermark int
+	// LowWatermark is the lane depth resuming paused Kafka partitions, 50% of the queue size by default
+	LowWatermark int
+	// PriorityLanes separates peer and link-state messages from the rest of messages
+	PriorityLanes bool
+}
+
+// Pauser is implemented by messengers which can stop and resume fetching messages of the given types
+type Pauser interface {
+	Pause(types []dbclient.CollectionType)
+	Resume(types []dbclient.CollectionType)
+	// PauseAll stops fetching messages of all types, it is called when the messenger is about to stop
+	PauseAll()
+}
+
+// LaneOf returns the lane carrying messages of the type
+func LaneOf(t dbclient.CollectionType, priorityLanes bool) string {
+	if !priorityLanes {
+		return LaneDefault
+	}
+	if priorityTypes[t] {
+		return LanePriority
+	}
+
+	return LaneBulk
+}
+
+type item struct {
+	ctx     context.Context
+	msgType dbclient.CollectionType
+	msg     []byte
+	ack     func()
+	// span records the time the message waits in the lane
+	span trace.Span
+}
+
+type lane struct {
+	name string
+	// slots bounds the number of messages queued in the lane whatever their type, a slot is taken
+	// when a message is queued and released when the dispatcher of its type takes it
+	slots chan struct{}
+	sync.Mutex
+	// queues stores a queue of every message type of the lane, partitions of all types are paused together
+	queues map[dbclient.CollectionType]chan *item
+	paused bool
+}
+
+func (l *lane) typeList() []dbclient.CollectionType {
+	types := make([]dbclient.CollectionType, 0, len(l.queues))
+	for t := range l.queues {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	return types
+}
+
+// Controller queues messages in lanes and hands them to the database client, it implements dbclient.Srv,
+// dbclient.DB, dbclient.AckDB and dbclient.TraceDB, so it can be placed between any messenger and database client.
+type Controller struct {
+	config Config
+	db     dbclient.DB
+	lanes  map[string]*lane
+	pauser Pauser
+	// pending counts messages queued in lanes or being handed to the database client
+	pending atomic.Int64
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	// lock protects stopped, dispatchers are started when the first message of their type is queued
+	// and must not be started once Stop waits for them
+	lock    sync.Mutex
+	stopped bool
+}
+
+// New returns a controller handing messages to the database client
+func New(config Config, db dbclient.DB) (*Controller, error) {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.HighWatermark == 0 {
+		config.HighWatermark = max(config.QueueSize*8/10, 1)
+	}
+	if config.LowWatermark == 0 {
+		config.LowWatermark = config.QueueSize / 2
+	}
+	if config.HighWatermark > config.QueueSize {
+		return nil, fmt.Errorf("high watermark %d exceeds queue size %d", config.HighWatermark, config.QueueSize)
+	}
+	if config.LowWatermark < 0 || config.LowWatermark >= config.HighWatermark {
+		return nil, fmt.Errorf("low watermark %d must be lower than high watermark %d", config.LowWatermark, config.HighWatermark)
+	}
+	c := &Controller{
+		config: config,
+		db:     db,
+		lanes:  make(map[string]*lane),
+		stop:   make(chan struct{}),
+	}
+	names := []string{LaneDefault}
+	if config.PriorityLanes {
+		names = []string{LanePriority, LaneBulk}
+	}
+	for _, n := range names {
+		c.lanes[n] = &lane{
+			name:   n,
+			slots:  make(chan struct{}, config.QueueSize),
+			queues: make(map[dbclient.CollectionType]chan *item),
+		}
+	}
+
+	return c, nil
+}
+
+// SetPauser sets the messenger paused when a lane reaches its high watermark, it must be called
+// before messages are stored.
+func (c *Controller) SetPauser(p Pauser) {
+	c.pauser = p
+}
+
+// Start starts the controller, dispatchers are started as messages of their types are queued
+func (c *Controller) Start() error {
+	glog.Infof("Flow control started, lanes: %d, queue size: %d, watermarks: %d/%d", len(c.lanes), c.config.QueueSize, c.config.HighWatermark, c.config.LowWatermark)
+
+	return nil
+}
+
+// Stop stops dispatchers, queued messages are not acknowledged and are consumed again after a restart
+func (c *Controller) Stop() error {
+	c.lock.Lock()
+	c.stopped = true
+	close(c.stop)
+	c.lock.Unlock()
+	c.wg.Wait()
+
+	return nil
+}
+
+// Drain stops fetching messages and waits until queued messages are handed to the database client,
+// then, if the database client implements dbclient.Drainer, until the client processed them. Messages
+// which were not processed when the context is done are not acknowledged, so their offsets are not
+// committed and they are consumed again after a restart.
+func (c *Controller) Drain(ctx context.Context) error {
+	if c.pauser != nil {
+		c.pauser.PauseAll()
+	}
+	glog.Infof("Draining %d queued messages", c.pending.Load())
+	if err := dbclient.WaitDrained(ctx, func() bool { return c.pending.Load() == 0 }); err != nil {
+		return fmt.Errorf("%d messages were not handed to the database client: %w", c.pending.Load(), err)
+	}
+	if d, ok := c.db.(dbclient.Drainer); ok {
+		return d.Drain(ctx)
+	}
+
+	return nil
+}
+
+// Check reports saturated lanes, a lane is saturated from reaching its high watermark until it
+// drains to its low watermark. It implements health.Checker.
+func (c *Controller) Check(context.Context) error {
+	names := make([]string, 0, len(c.lanes))
+	for n := range c.lanes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	saturated := make([]string, 0)
+	for _, n := range names {
+		l := c.lanes[n]
+		l.Lock()
+		if l.paused {
+			saturated = append(saturated, fmt.Sprintf("%s (%d/%d)", n, len(l.slots), c.config.QueueSize))
+		}
+		l.Unlock()
+	}
+	if len(saturated) != 0 {
+		return fmt.Errorf("saturated lanes, Kafka partitions are paused: %s", strings.Join(saturated, ", "))
+	}
+
+	return nil
+}
+
+// GetInterface returns the controller as the database client of messengers
+func (c *Controller) GetInterface() dbclient.DB {
+	return c
+}
+
+// StoreMessage queues the message, it blocks while the lane of the message is full
+func (c *Controller) StoreMessage(msgType dbclient.CollectionType, msg []byte) error {
+	return c.StoreMessageWithAck(msgType, msg, nil)
+}
+
+// StoreMessageWithAck queues the message, ack is called once the database client acknowledged the message,
+// or right after the database client accepted it, if the client does not support acknowledgements.
+func (c *Controller) StoreMessageWithAck(msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	return c.StoreMessageWithContext(context.Background(), msgType, msg, ack)
+}
+
+// StoreMessageWithContext queues the message, the time it waits in the lane is recorded as a span of
+// the trace carried by the context, ack is handled as by StoreMessageWithAck.
+func (c *Controller) StoreMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	l := c.lanes[LaneOf(msgType, c.config.PriorityLanes)]
+	_, span := tracing.Start(ctx, "flow-control queue", attribute.String("jalapeno.lane", l.name))
+	c.pending.Add(1)
+	select {
+	case l.slots <- struct{}{}:
+	case <-c.stop:
+		c.pending.Add(-1)
+		tracing.End(span, ErrStopped)
+		return ErrStopped
+	}
+	l.Lock()
+	defer l.Unlock()
+	q, ok := l.queues[msgType]
+	if !ok {
+		// A queue holds as many messages as the lane, the lane's slot is already taken so queuing never blocks
+		q = make(chan *item, c.config.QueueSize)
+		if !c.startDispatcher(l, q) {
+			<-l.slots
+			c.pending.Add(-1)
+			tracing.End(span, ErrStopped)
+			return ErrStopped
+		}
+		l.queues[msgType] = q
+	}
+	q <- &item{ctx: ctx, msgType: msgType, msg: msg, ack: ack, span: span}
+	depth := len(l.slots)
+	laneDepth.WithLabelValues(l.name).Set(float64(depth))
+	if !l.paused && depth >= c.config.HighWatermark {
+		l.paused = true
+		lanePaused.WithLabelValues(l.name).Set(1)
+		lanePauses.WithLabelValues(l.name).Inc()
+		glog.Warningf("lane %s reached high watermark with %d messages, pausing consumption", l.name, depth)
+		if c.pauser != nil {
+			c.pauser.Pause(l.typeList())
+		}
+	}
+
+	return nil
+}
+
+// startDispatcher starts the dispatcher of the queue, it returns false if the controller was stopped
+func (c *Controller) startDispatcher(l *lane, q chan *item) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return false
+	}
+	c.wg.Add(1)
+	go c.dispatcher(l, q)
+
+	return true
+}
+
+// dispatcher hands messages of a single type to the database client in the order they were queued
+func (c *Controller) dispatcher(l *lane, q chan *item) {
+	defer c.wg.Done()
+	for {
+		select {
+		case it := <-q:
+			<-l.slots
+			it.span.End()
+			c.resume(l)
+			c.dispatch(l, it)
+			c.pending.Add(-1)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// resume resumes consumption of the lane's messages once the lane drained to its low watermark
+func (c *Controller) resume(l *lane) {
+	depth := len(l.slots)
+	laneDepth.WithLabelValues(l.name).Set(float64(depth))
+	l.Lock()
+	defer l.Unlock()
+	if !l.paused || depth > c.config.LowWatermark {
+		return
+	}
+	l.paused = false
+	lanePaused.WithLabelValues(l.name).Set(0)
+	glog.Infof("lane %s drained to low watermark with %d messages, resuming consumption", l.name, depth)
+	if c.pauser != nil {
+		c.pauser.Resume(l.typeList())
+	}
+}
+
+// dispatch hands the message to the database client, retrying it while the client's queue is full
+func (c *Controller) dispatch(l *lane, it *item) {
+	delay := retryInterval
+	for {
+		err := c.store(it)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrStopped) {
+			// The message was not processed, it is consumed again after a restart
+			return
+		}
+		if !errors.Is(err, ErrQueueFull) {
+			glog.Errorf("failed to store message of type %d with error: %+v", it.msgType, err)
+			// The message was rejected and will never be acknowledged by the database client
+			if it.ack != nil {
+				it.ack()
+			}
+			return
+		}
+		laneRetries.WithLabelValues(l.name).Inc()
+		select {
+		case <-time.After(delay):
+		case <-c.stop:
+			return
+		}
+		delay = min(2*delay, maxRetryInterval)
+	}
+}
+
+func (c *Controller) store(it *item) error {
+	return dbclient.Store(it.ctx, c.db, it.msgType, it.msg, it.ack)
+}
@@ -0,0 +1,257 @@
+package flowcontrol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+type blockingDB struct {
+	release chan struct{}
+	sync.Mutex
+	stored int
+}
+
+func (db *blockingDB) StoreMessage(dbclient.CollectionType, []byte) error {
+	<-db.release
+	db.Lock()
+	defer db.Unlock()
+	db.stored++
+	return nil
+}
+
+type fullDB struct {
+	rejects int
+	sync.Mutex
+	stored int
+}
+
+func (db *fullDB) StoreMessage(dbclient.CollectionType, []byte) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.rejects > 0 {
+		db.rejects--
+		return fmt.Errorf("update %w", ErrQueueFull)
+	}
+	db.stored++
+	return nil
+}
+
+type pauser struct {
+	sync.Mutex
+	calls []string
+}
+
+func (p *pauser) Pause(types []dbclient.CollectionType) {
+	p.Lock()
+	defer p.Unlock()
+	p.calls = append(p.calls, fmt.Sprintf("pause %v", types))
+}
+
+func (p *pauser) Resume(types []dbclient.CollectionType) {
+	p.Lock()
+	defer p.Unlock()
+	p.calls = append(p.calls, fmt.Sprintf("resume %v", types))
+}
+
+func (p *pauser) PauseAll() {
+	p.Lock()
+	defer p.Unlock()
+	p.calls = append(p.calls, "pause all")
+}
+
+func (p *pauser) get() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string{}, p.calls...)
+}
+
+func TestLaneOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgType  dbclient.CollectionType
+		priority bool
+		expect   string
+	}{
+		{name: "peer", msgType: bmp.PeerStateChangeMsg, priority: true, expect: LanePriority},
+		{name: "ls_link", msgType: bmp.LSLinkMsg, priority: true, expect: LanePriority},
+		{name: "unicast_prefix_v4", msgType: bmp.UnicastPrefixV4Msg, priority: true, expect: LaneBulk},
+		{name: "l3vpn", msgType: bmp.L3VPNMsg, priority: true, expect: LaneBulk},
+		{name: "peer without priority lanes", msgType: bmp.PeerStateChangeMsg, priority: false, expect: LaneDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LaneOf(tt.msgType, tt.priority); got != tt.expect {
+				t.Errorf("expected lane %s, got %s", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestNewInvalidWatermarks(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "high above queue size", config: Config{QueueSize: 10, HighWatermark: 11}},
+		{name: "low equal to high", config: Config{QueueSize: 10, HighWatermark: 5, LowWatermark: 5}},
+		{name: "negative low", config: Config{QueueSize: 10, HighWatermark: 5, LowWatermark: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config, &fullDB{}); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	db := &blockingDB{release: make(chan struct{})}
+	c, err := New(Config{QueueSize: 4, HighWatermark: 3, LowWatermark: 1, PriorityLanes: true}, db)
+	if err != nil {
+		t.Fatalf("failed to create controller with error: %+v", err)
+	}
+	p := &pauser{}
+	c.SetPauser(p)
+	c.Start()
+	defer c.Stop()
+	// The dispatcher takes the first message and blocks in the database client, three more fill the lane
+	// up to its high watermark.
+	for i := 0; i < 4; i++ {
+		if err := c.StoreMessage(bmp.UnicastPrefixV4Msg, []byte("{}")); err != nil {
+			t.Fatalf("failed to store message with error: %+v", err)
+		}
+		if i == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	// The priority lane is not affected by the full bulk lane
+	if err := c.StoreMessage(bmp.LSNodeMsg, []byte("{}")); err != nil {
+		t.Fatalf("failed to store message with error: %+v", err)
+	}
+	if calls := p.get(); len(calls) != 1 || calls[0] != fmt.Sprintf("pause %v", []dbclient.CollectionType{bmp.UnicastPrefixV4Msg}) {
+		t.Fatalf("expected the bulk lane to be paused, got calls: %v", calls)
+	}
+	for i := 0; i < 5; i++ {
+		db.release <- struct{}{}
+	}
+	if calls := p.get(); len(calls) != 2 || calls[1] != fmt.Sprintf("resume %v", []dbclient.CollectionType{bmp.UnicastPrefixV4Msg}) {
+		t.Fatalf("expected the bulk lane to be resumed, got calls: %v", calls)
+	}
+}
+
+// slowDB blocks messages of its slow type until they are released
+type slowDB struct {
+	slow    dbclient.CollectionType
+	release chan struct{}
+	stored  chan dbclient.CollectionType
+}
+
+func (db *slowDB) StoreMessage(t dbclient.CollectionType, _ []byte) error {
+	if t == db.slow {
+		<-db.release
+	}
+	db.stored <- t
+	return nil
+}
+
+func TestSlowTypeDoesNotBlockLane(t *testing.T) {
+	db := &slowDB{slow: bmp.UnicastPrefixV4Msg, release: make(chan struct{}), stored: make(chan dbclient.CollectionType, 4)}
+	c, err := New(Config{QueueSize: 10}, db)
+	if err != nil {
+		t.Fatalf("failed to create controller with error: %+v", err)
+	}
+	c.Start()
+	defer c.Stop()
+	for _, mt := range []dbclient.CollectionType{bmp.UnicastPrefixV4Msg, bmp.UnicastPrefixV4Msg, bmp.UnicastPrefixV6Msg} {
+		if err := c.StoreMessage(mt, []byte("{}")); err != nil {
+			t.Fatalf("failed to store message with error: %+v", err)
+		}
+	}
+	select {
+	case mt := <-db.stored:
+		if mt != bmp.UnicastPrefixV6Msg {
+			t.Fatalf("expected message of type %d to be stored first, got %d", bmp.UnicastPrefixV6Msg, mt)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("message of a different type is held up by the slow type")
+	}
+	close(db.release)
+	for i := 0; i < 2; i++ {
+		if mt := <-db.stored; mt != bmp.UnicastPrefixV4Msg {
+			t.Fatalf("expected message of type %d, got %d", bmp.UnicastPrefixV4Msg, mt)
+		}
+	}
+}
+
+func TestQueueFullRetry(t *testing.T) {
+	retryInterval = time.Millisecond
+	db := &fullDB{rejects: 3}
+	c, err := New(Config{QueueSize: 10}, db)
+	if err != nil {
+		t.Fatalf("failed to create controller with error: %+v", err)
+	}
+	c.Start()
+	defer c.Stop()
+	acked := make(chan struct{})
+	if err := c.StoreMessageWithAck(bmp.UnicastPrefixV4Msg, []byte("{}"), func() { close(acked) }); err != nil {
+		t.Fatalf("failed to store message with error: %+v", err)
+	}
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Fatalf("message rejected with a full queue was not retried")
+	}
+	db.Lock()
+	defer db.Unlock()
+	if db.stored != 1 {
+		t.Errorf("expected 1 stored message, got %d", db.stored)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	db := &blockingDB{release: make(chan struct{})}
+	c, err := New(Config{QueueSize: 10}, db)
+	if err != nil {
+		t.Fatalf("failed to create controller with error: %+v", err)
+	}
+	p := &pauser{}
+	c.SetPauser(p)
+	c.Start()
+	defer c.Stop()
+	for i := 0; i < 3; i++ {
+		if err := c.StoreMessage(bmp.UnicastPrefixV4Msg, []byte("{}")); err != nil {
+			t.Fatalf("failed to store message with error: %+v", err)
+		}
+	}
+	// Messages blocked in the database client are not drained before the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Drain(ctx); err == nil {
+		t.Fatalf("expected drain to time out")
+	}
+	if calls := p.get(); len(calls) == 0 || calls[0] != "pause all" {
+		t.Fatalf("expected all partitions to be paused, got calls: %v", calls)
+	}
+	go func() {
+		for i := 0; i < 3; i++ {
+			db.release <- struct{}{}
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Drain(ctx); err != nil {
+		t.Fatalf("failed to drain with error: %+v", err)
+	}
+	db.Lock()
+	defer db.Unlock()
+	if db.stored != 3 {
+		t.Errorf("expected 3 stored messages, got %d", db.stored)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package flowcontrol
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// OffsetTracker keeps track of the messages of a single partition which have been handed
+// to the database client but not yet persisted. Messages of different keys are processed
+// concurrently and can complete out of order, the tracker marks the offset only up to the
+// oldest message which is still in flight, so a restart never skips an unprocessed message.
+type OffsetTracker struct {
+	sync.Mutex
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+	// pending stores offsets in the order they were received
+	pending []int64
+	// done stores offsets which have been acknowledged but cannot be marked yet
+	done map[int64]bool
+}
+
+// NewOffsetTracker returns a tracker marking offsets of the partition in the session
+func NewOffsetTracker(session sarama.ConsumerGroupSession, topic string, partition int32) *OffsetTracker {
+	return &OffsetTracker{
+		session:   session,
+		topic:     topic,
+		partition: partition,
+		pending:   make([]int64, 0),
+		done:      make(map[int64]bool),
+	}
+}
+
+// Add registers the offset of a message which is about to be sent to the database client.
+func (t *OffsetTracker) Add(offset int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+// Ack acknowledges the offset and marks in the session the highest offset below which
+// all messages have been processed.
+func (t *OffsetTracker) Ack(offset int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.done[offset] = true
+	mark := int64(-1)
+	for len(t.pending) != 0 && t.done[t.pending[0]] {
+		mark = t.pending[0]
+		delete(t.done, mark)
+		t.pending = t.pending[1:]
+	}
+	if mark != -1 {
+		// Kafka expects the offset of the next message to read
+		t.session.MarkOffset(t.topic, t.partition, mark+1, "")
+	}
+}
+
+// InFlight returns a number of messages which have not been acknowledged yet.
+func (t *OffsetTracker) InFlight() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.pending)
+}
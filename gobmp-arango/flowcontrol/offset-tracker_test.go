@@ -0,0 +1,82 @@
+package flowcontrol
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+type markSession struct {
+	sarama.ConsumerGroupSession
+	marked int64
+}
+
+func (s *markSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.marked = offset
+}
+
+func TestOffsetTracker(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets []int64
+		acks    []int64
+		expect  int64
+	}{
+		{
+			name:    "nothing acknowledged",
+			offsets: []int64{10, 11, 12},
+			acks:    []int64{},
+			expect:  0,
+		},
+		{
+			name:    "acknowledged in order",
+			offsets: []int64{10, 11, 12},
+			acks:    []int64{10, 11},
+			expect:  12,
+		},
+		{
+			name:    "oldest still in flight",
+			offsets: []int64{10, 11, 12},
+			acks:    []int64{12, 11},
+			expect:  0,
+		},
+		{
+			name:    "oldest acknowledged last",
+			offsets: []int64{10, 11, 12},
+			acks:    []int64{12, 11, 10},
+			expect:  13,
+		},
+		{
+			name:    "gap in offsets",
+			offsets: []int64{10, 15, 20},
+			acks:    []int64{15, 10},
+			expect:  16,
+		},
+	}
+	for _, tt := range tests {
+		s := &markSession{}
+		tr := NewOffsetTracker(s, "test", 0)
+		for _, o := range tt.offsets {
+			tr.Add(o)
+		}
+		for _, o := range tt.acks {
+			tr.Ack(o)
+		}
+		if s.marked != tt.expect {
+			t.Fatalf("%s: expected marked offset %d, actual %d", tt.name, tt.expect, s.marked)
+		}
+		if tr.InFlight() != len(tt.offsets)-int(countMarked(tt.offsets, s.marked)) {
+			t.Fatalf("%s: unexpected number of messages in flight %d", tt.name, tr.InFlight())
+		}
+	}
+}
+
+func countMarked(offsets []int64, marked int64) int64 {
+	var n int64
+	for _, o := range offsets {
+		if o < marked {
+			n++
+		}
+	}
+	return n
+}
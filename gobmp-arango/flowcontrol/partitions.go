@@ -0,0 +1,120 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package flowcontrol
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/golang/glog"
+)
+
+// Partitions tracks partitions claimed by a consumer group member and implements Pauser for Kafka
+// messengers. Partitions are paused by topic, a partition claimed after a rebalance is paused when
+// its topic is paused.
+type Partitions struct {
+	group sarama.ConsumerGroup
+	sync.Mutex
+	claimed map[string]map[int32]bool
+	paused  map[string]bool
+	// all is set once all partitions are paused, partitions are not resumed after that
+	all bool
+}
+
+// NewPartitions returns a tracker of partitions claimed by the consumer group
+func NewPartitions(group sarama.ConsumerGroup) *Partitions {
+	return &Partitions{
+		group:   group,
+		claimed: make(map[string]map[int32]bool),
+		paused:  make(map[string]bool),
+	}
+}
+
+// Claim records the partition claimed by a consumer group session
+func (p *Partitions) Claim(topic string, partition int32) {
+	p.Lock()
+	defer p.Unlock()
+	if p.claimed[topic] == nil {
+		p.claimed[topic] = make(map[int32]bool)
+	}
+	p.claimed[topic][partition] = true
+	if p.all || p.paused[topic] {
+		p.group.Pause(map[string][]int32{topic: {partition}})
+	}
+}
+
+// Release forgets the partition when its claim ends
+func (p *Partitions) Release(topic string, partition int32) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.claimed[topic], partition)
+}
+
+// Pause stops fetching from partitions of topics carrying messages of the types
+func (p *Partitions) Pause(types []dbclient.CollectionType) {
+	p.Lock()
+	defer p.Unlock()
+	partitions := p.topics(types, true)
+	glog.V(5).Infof("pausing partitions: %+v", partitions)
+	p.group.Pause(partitions)
+}
+
+// Resume resumes fetching from partitions of topics carrying messages of the types
+func (p *Partitions) Resume(types []dbclient.CollectionType) {
+	p.Lock()
+	defer p.Unlock()
+	if p.all {
+		return
+	}
+	partitions := p.topics(types, false)
+	glog.V(5).Infof("resuming partitions: %+v", partitions)
+	p.group.Resume(partitions)
+}
+
+// PauseAll stops fetching from all partitions for the rest of the consumer group's life
+func (p *Partitions) PauseAll() {
+	p.Lock()
+	defer p.Unlock()
+	p.all = true
+	glog.Infof("pausing all partitions")
+	p.group.PauseAll()
+}
+
+// topics marks topics of the types paused or resumed and returns their claimed partitions,
+// it must be called with the lock held.
+func (p *Partitions) topics(types []dbclient.CollectionType, paused bool) map[string][]int32 {
+	partitions := make(map[string][]int32)
+	for _, t := range types {
+		topic := dbclient.TopicName(t)
+		if topic == "" {
+			continue
+		}
+		p.paused[topic] = paused
+		for partition := range p.claimed[topic] {
+			partitions[topic] = append(partitions[topic], partition)
+		}
+	}
+
+	return partitions
+}
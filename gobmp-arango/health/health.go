@@ -0,0 +1,319 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package health serves liveness on /healthz and readiness on /readyz. Readiness is the result of
+// checks registered by the components of a binary, such as database connectivity, Kafka consumer group
+// membership, completion of the initial load and queue saturation, /readyz returns every check's
+// result as JSON for operators. Liveness is the result of heartbeats of the processing loops, a binary
+// whose loop is stuck is restarted, while slow dependencies only affect readiness.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultCheckTimeout defines how long a readiness check may take before it is considered failed
+	DefaultCheckTimeout = 5 * time.Second
+	// StatusOK reports a passing check or a ready binary
+	StatusOK = "ok"
+	// StatusFailing reports a failing check or a binary which is not ready
+	StatusFailing = "failing"
+	// HeartbeatInterval defines how often a processing loop beats while it waits for work
+	HeartbeatInterval = 10 * time.Second
+	// DefaultHeartbeatTimeout defines how long a processing loop may go without a heartbeat before
+	// the binary is not alive
+	DefaultHeartbeatTimeout = 2 * time.Minute
+)
+
+// Checker is implemented by components reporting their readiness, nil means ready
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckFunc is a function implementing Checker
+type CheckFunc func(ctx context.Context) error
+
+// Check calls the function
+func (f CheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// State is a Checker reporting the readiness set by its component, for example the progress of
+// the initial load or the membership in a consumer group
+type State struct {
+	sync.RWMutex
+	err error
+}
+
+// NewState returns a state which is not ready for the reason
+func NewState(reason string) *State {
+	return &State{err: fmt.Errorf("%s", reason)}
+}
+
+// Ready marks the component ready
+func (s *State) Ready() {
+	s.Lock()
+	defer s.Unlock()
+	s.err = nil
+}
+
+// NotReady marks the component not ready for the reason
+func (s *State) NotReady(format string, args ...interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = fmt.Errorf(format, args...)
+}
+
+// Check returns nil when the component is ready and the reason otherwise, it implements Checker
+func (s *State) Check(context.Context) error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.err
+}
+
+// Liveness is implemented by components whose processing loops report heartbeats, nil means alive
+type Liveness interface {
+	Live(ctx context.Context) error
+}
+
+// Heartbeat is a Checker reporting whether a processing loop is alive, the loop beats on every
+// iteration and at least every HeartbeatInterval while it waits for work or for a slow dependency.
+// A loop which has not started yet, for example while the initial load is running, is alive.
+type Heartbeat struct {
+	timeout time.Duration
+	// last is the time of the last beat in nanoseconds, 0 until the loop starts
+	last atomic.Int64
+}
+
+// NewHeartbeat returns a heartbeat which fails when the started loop did not beat for the timeout
+func NewHeartbeat(timeout time.Duration) *Heartbeat {
+	return &Heartbeat{timeout: timeout}
+}
+
+// Beat records that the loop is alive
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// While calls f and beats every HeartbeatInterval until it returns, the loop calls it while it waits
+// for a slow dependency, such as the database, which affects readiness but must not fail liveness.
+// A nil heartbeat only calls f.
+func (h *Heartbeat) While(f func()) {
+	h.while(f, HeartbeatInterval)
+}
+
+func (h *Heartbeat) while(f func(), interval time.Duration) {
+	if h == nil {
+		f()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			h.Beat()
+		}
+	}
+}
+
+// Check returns an error when the loop did not beat for the timeout, it implements Checker
+func (h *Heartbeat) Check(context.Context) error {
+	last := h.last.Load()
+	if last == 0 {
+		return nil
+	}
+	if since := time.Since(time.Unix(0, last)); since > h.timeout {
+		return fmt.Errorf("no heartbeat for %s", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// Result is the result of a single check
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// Report is the body of /healthz and /readyz responses
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks,omitempty"`
+}
+
+type check struct {
+	name    string
+	checker Checker
+}
+
+// Server serves /healthz and /readyz
+type Server struct {
+	srv     *http.Server
+	timeout time.Duration
+	sync.RWMutex
+	checks []check
+	// live holds liveness checks
+	live []check
+}
+
+// NewServer returns a server of health endpoints on the port
+func NewServer(port int) *Server {
+	s := &Server{timeout: DefaultCheckTimeout}
+	s.srv = &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: s.Handler(),
+	}
+
+	return s
+}
+
+// Register adds a readiness check, checks may be registered after the server started, so components
+// which take long to start report their progress.
+func (s *Server) Register(name string, c Checker) {
+	s.Lock()
+	defer s.Unlock()
+	s.checks = append(s.checks, check{name: name, checker: c})
+}
+
+// RegisterLiveness adds a liveness check, usually heartbeats of the processing loops of a component
+func (s *Server) RegisterLiveness(name string, c Checker) {
+	s.Lock()
+	defer s.Unlock()
+	s.live = append(s.live, check{name: name, checker: c})
+}
+
+// Handler returns the handler of /healthz and /readyz
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		// Slow dependencies only affect readiness, liveness fails when a processing loop is stuck
+		respond(w, s.Live(r.Context()))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, s.Ready(r.Context()))
+	})
+
+	return mux
+}
+
+// Live runs all liveness checks concurrently, the binary is alive when all of them pass
+func (s *Server) Live(ctx context.Context) *Report {
+	s.RLock()
+	checks := make([]check, len(s.live))
+	copy(checks, s.live)
+	s.RUnlock()
+
+	return s.run(ctx, checks)
+}
+
+// Ready runs all checks concurrently, the binary is ready when all of them pass
+func (s *Server) Ready(ctx context.Context) *Report {
+	s.RLock()
+	checks := make([]check, len(s.checks))
+	copy(checks, s.checks)
+	s.RUnlock()
+
+	return s.run(ctx, checks)
+}
+
+func (s *Server) run(ctx context.Context, checks []check) *Report {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	report := &Report{Status: StatusOK, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			start := time.Now()
+			r := Result{Name: c.name, Status: StatusOK}
+			if err := c.checker.Check(ctx); err != nil {
+				r.Status = StatusFailing
+				r.Error = err.Error()
+			}
+			r.Duration = time.Since(start).Seconds()
+			report.Checks[i] = r
+		}(i, c)
+	}
+	wg.Wait()
+	for _, r := range report.Checks {
+		if r.Status != StatusOK {
+			report.Status = StatusFailing
+		}
+	}
+
+	return report
+}
+
+func respond(w http.ResponseWriter, report *Report) {
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	write(w, code, report)
+}
+
+func write(w http.ResponseWriter, code int, report *Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		glog.Errorf("failed to write health report with error: %+v", err)
+	}
+}
+
+// Start starts serving health endpoints
+func (s *Server) Start() {
+	glog.Infof("Starting health server on %s", s.srv.Addr)
+	go func() {
+		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("health server failed with error: %+v", err)
+		}
+	}()
+}
+
+// Stop stops the health server
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		glog.Errorf("failed to stop health server with error: %+v", err)
+	}
+}
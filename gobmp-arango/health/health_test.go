@@ -0,0 +1,156 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	loading := NewState("loading")
+	tests := []struct {
+		name   string
+		setup  func()
+		checks map[string]Checker
+		code   int
+		failed []string
+	}{
+		{
+			name: "no checks",
+			code: http.StatusOK,
+		},
+		{
+			name: "state not ready",
+			checks: map[string]Checker{
+				"loading": loading,
+				"kafka":   CheckFunc(func(context.Context) error { return nil }),
+			},
+			code:   http.StatusServiceUnavailable,
+			failed: []string{"loading"},
+		},
+		{
+			name:  "state ready",
+			setup: loading.Ready,
+			checks: map[string]Checker{
+				"loading": loading,
+				"kafka":   CheckFunc(func(context.Context) error { return nil }),
+			},
+			code: http.StatusOK,
+		},
+		{
+			name: "failing check",
+			checks: map[string]Checker{
+				"arangodb": CheckFunc(func(context.Context) error { return fmt.Errorf("connection refused") }),
+			},
+			code:   http.StatusServiceUnavailable,
+			failed: []string{"arangodb"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			s := NewServer(0)
+			for name, c := range tt.checks {
+				s.Register(name, c)
+			}
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.code {
+				t.Fatalf("expected code %d, got %d", tt.code, rec.Code)
+			}
+			var report Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("failed to decode report with error: %+v", err)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("expected %d checks, got %d", len(tt.checks), len(report.Checks))
+			}
+			failed := make(map[string]bool)
+			for _, r := range report.Checks {
+				if r.Status != StatusOK {
+					failed[r.Name] = true
+				}
+			}
+			if len(failed) != len(tt.failed) {
+				t.Fatalf("expected failing checks %v, got %+v", tt.failed, report.Checks)
+			}
+			for _, name := range tt.failed {
+				if !failed[name] {
+					t.Fatalf("expected check %s to fail, got %+v", name, report.Checks)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	beating := NewHeartbeat(time.Minute)
+	beating.Beat()
+	stuck := NewHeartbeat(time.Minute)
+	stuck.last.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	tests := []struct {
+		name  string
+		ready Checker
+		live  Checker
+		code  int
+	}{
+		{
+			name:  "readiness is ignored",
+			ready: CheckFunc(func(context.Context) error { return fmt.Errorf("connection refused") }),
+			code:  http.StatusOK,
+		},
+		{
+			name: "loop not started",
+			live: NewHeartbeat(time.Minute),
+			code: http.StatusOK,
+		},
+		{
+			name: "beating loop",
+			live: beating,
+			code: http.StatusOK,
+		},
+		{
+			name: "stuck loop",
+			live: stuck,
+			code: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(0)
+			if tt.ready != nil {
+				s.Register("arangodb", tt.ready)
+			}
+			if tt.live != nil {
+				s.RegisterLiveness("handler", tt.live)
+			}
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != tt.code {
+				t.Fatalf("expected code %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestWhile(t *testing.T) {
+	h := NewHeartbeat(25 * time.Millisecond)
+	h.last.Store(time.Now().Add(-time.Minute).UnixNano())
+	// The loop waits for a slow dependency longer than the timeout, it stays alive
+	h.while(func() { time.Sleep(100 * time.Millisecond) }, time.Millisecond)
+	if err := h.Check(context.Background()); err != nil {
+		t.Errorf("expected the waiting loop to be alive, got %+v", err)
+	}
+	var nilHeartbeat *Heartbeat
+	called := false
+	nilHeartbeat.While(func() { called = true })
+	if !called {
+		t.Errorf("expected a nil heartbeat to call the function")
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package kafkaclient holds the security configuration shared by all Kafka consumers and producers,
+// TLS with an optional client certificate and SASL authentication with credentials read from files.
+package kafkaclient
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"hash"
+	"os"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+)
+
+const (
+	// SASLPlain selects SASL/PLAIN authentication
+	SASLPlain = "PLAIN"
+	// SASLSCRAMSHA256 selects SASL/SCRAM-SHA-256 authentication
+	SASLSCRAMSHA256 = "SCRAM-SHA-256"
+	// SASLSCRAMSHA512 selects SASL/SCRAM-SHA-512 authentication
+	SASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// Config defines how Kafka clients connect to the brokers, the zero value connects in plaintext
+// without authentication.
+type Config struct {
+	// TLS enables TLS, it is enabled as well when a CA bundle or a client certificate is set
+	TLS bool
+	// CAFile is a PEM bundle of CAs verifying the brokers, system CAs are used when not set
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented to the brokers
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables the verification of the brokers' certificates
+	InsecureSkipVerify bool
+	// SASLMechanism is one of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, SASL is disabled when not set
+	SASLMechanism string
+	// SASLUserFile and SASLPasswordFile are files storing the SASL user name and password, SCRAM
+	// picks up rotated credentials, PLAIN keeps the credentials read when the client is created
+	SASLUserFile     string
+	SASLPasswordFile string
+
+	// provider watches the SASL files, it is shared by all clients of the configuration
+	once     sync.Once
+	provider credentials.Provider
+	err      error
+}
+
+// RegisterFlags registers command line flags of the configuration, every binary uses the same flags
+func RegisterFlags(c *Config) {
+	flag.BoolVar(&c.TLS, "kafka-tls", false, "when true, connections to Kafka brokers use TLS")
+	flag.StringVar(&c.CAFile, "kafka-ca-file", "", "PEM bundle of CAs verifying Kafka brokers, enables TLS, system CAs are used when not set")
+	flag.StringVar(&c.CertFile, "kafka-cert-file", "", "PEM client certificate presented to Kafka brokers, enables TLS")
+	flag.StringVar(&c.KeyFile, "kafka-key-file", "", "PEM private key of the client certificate")
+	flag.BoolVar(&c.InsecureSkipVerify, "kafka-tls-skip-verify", false, "when true, certificates of Kafka brokers are not verified")
+	flag.StringVar(&c.SASLMechanism, "kafka-sasl-mechanism", "", "SASL mechanism authenticating to Kafka brokers: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, SASL is disabled when not set")
+	flag.StringVar(&c.SASLUserFile, "kafka-sasl-user-file", "", "file storing the SASL user name, SCRAM picks up a rotated user name, PLAIN requires a restart")
+	flag.StringVar(&c.SASLPasswordFile, "kafka-sasl-password-file", "", "file storing the SASL password, SCRAM picks up a rotated password, PLAIN requires a restart")
+}
+
+// Apply sets TLS and SASL parameters of the sarama configuration, a nil configuration leaves it unchanged
+func (c *Config) Apply(sc *sarama.Config) error {
+	if c == nil {
+		return nil
+	}
+	if c.TLS || c.CAFile != "" || c.CertFile != "" {
+		t, err := c.tlsConfig()
+		if err != nil {
+			return err
+		}
+		sc.Net.TLS.Enable = true
+		sc.Net.TLS.Config = t
+	}
+	var h func() hash.Hash
+	switch c.SASLMechanism {
+	case "":
+		return nil
+	case SASLPlain:
+		sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLSCRAMSHA256:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		h = sha256.New
+	case SASLSCRAMSHA512:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		h = sha512.New
+	default:
+		return fmt.Errorf("unknown SASL mechanism %q, supported mechanisms are %s, %s and %s", c.SASLMechanism, SASLPlain, SASLSCRAMSHA256, SASLSCRAMSHA512)
+	}
+	if c.SASLUserFile == "" {
+		return fmt.Errorf("failed to read SASL user name with error: file is not set")
+	}
+	// The files are watched, SCRAM exchanges of new connections and of re-authentications use
+	// rotated credentials, sarama sends the PLAIN user name and password of its configuration so
+	// PLAIN clients keep the credentials read when they are created
+	provider, err := c.saslProvider()
+	if err != nil {
+		return err
+	}
+	creds, err := provider.Credentials()
+	if err != nil {
+		return fmt.Errorf("failed to read SASL credentials with error: %w", err)
+	}
+	if h != nil {
+		sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return newSCRAMClient(h, provider) }
+	}
+	sc.Net.SASL.Enable = true
+	sc.Net.SASL.Handshake = true
+	sc.Net.SASL.User = creds.User
+	sc.Net.SASL.Password = creds.Password
+	if sc.Version.IsAtLeast(sarama.V1_0_0_0) {
+		sc.Net.SASL.Version = sarama.SASLHandshakeV1
+	}
+
+	return nil
+}
+
+// NewConfig returns a sarama configuration with the security parameters applied
+func (c *Config) NewConfig() (*sarama.Config, error) {
+	sc := sarama.NewConfig()
+	if err := c.Apply(sc); err != nil {
+		return nil, err
+	}
+
+	return sc, nil
+}
+
+// saslProvider returns the provider of SASL credentials, the files are watched once however
+// many clients apply the configuration
+func (c *Config) saslProvider() (credentials.Provider, error) {
+	c.once.Do(func() {
+		c.provider, c.err = credentials.NewFile(c.SASLUserFile, c.SASLPasswordFile, credentials.DefaultInterval)
+		if c.err != nil {
+			c.err = fmt.Errorf("failed to read SASL credentials with error: %w", c.err)
+		}
+	})
+
+	return c.provider, c.err
+}
+
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	t := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Kafka CA bundle with error: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Kafka CA bundle %s", c.CAFile)
+		}
+		t.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("both Kafka client certificate and key must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Kafka client certificate with error: %w", err)
+		}
+		t.Certificates = []tls.Certificate{cert}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,133 @@
+package kafkaclient
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+)
+
+// TestSCRAMClient runs the SCRAM-SHA-256 exchange of RFC 7677
+func TestSCRAMClient(t *testing.T) {
+	c := newSCRAMClient(sha256.New, nil)
+	c.nonce = func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatalf("failed to begin exchange with error: %+v", err)
+	}
+	steps := []struct {
+		challenge string
+		expect    string
+	}{
+		{
+			challenge: "",
+			expect:    "n,,n=user,r=rOprNGfwEbeRWgbNEkqO",
+		},
+		{
+			challenge: "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			expect:    "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		},
+		{
+			challenge: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+			expect:    "",
+		},
+	}
+	for i, s := range steps {
+		got, err := c.Step(s.challenge)
+		if err != nil {
+			t.Fatalf("step %d failed with error: %+v", i, err)
+		}
+		if got != s.expect {
+			t.Fatalf("step %d: expected %q, got %q", i, s.expect, got)
+		}
+	}
+	if !c.Done() {
+		t.Errorf("expected the exchange to be done")
+	}
+}
+
+func TestSCRAMClientBadServerSignature(t *testing.T) {
+	c := newSCRAMClient(sha256.New, nil)
+	c.nonce = func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }
+	c.Begin("user", "pencil", "")
+	c.Step("")
+	c.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if _, err := c.Step("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err == nil {
+		t.Errorf("expected server signature mismatch, got nil")
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "user")
+	pass := filepath.Join(dir, "pass")
+	os.WriteFile(user, []byte("gobmp\n"), 0600)
+	os.WriteFile(pass, []byte("secret\n"), 0600)
+	tests := []struct {
+		name      string
+		config    *Config
+		tls       bool
+		mechanism sarama.SASLMechanism
+		fail      bool
+	}{
+		{name: "nil config", config: nil},
+		{name: "plaintext", config: &Config{}},
+		{name: "tls", config: &Config{TLS: true}, tls: true},
+		{name: "scram", config: &Config{TLS: true, SASLMechanism: SASLSCRAMSHA512, SASLUserFile: user, SASLPasswordFile: pass}, tls: true, mechanism: sarama.SASLTypeSCRAMSHA512},
+		{name: "plain", config: &Config{SASLMechanism: SASLPlain, SASLUserFile: user, SASLPasswordFile: pass}, mechanism: sarama.SASLTypePlaintext},
+		{name: "unknown mechanism", config: &Config{SASLMechanism: "GSSAPI"}, fail: true},
+		{name: "missing password", config: &Config{SASLMechanism: SASLPlain, SASLUserFile: user}, fail: true},
+		{name: "missing CA bundle", config: &Config{CAFile: filepath.Join(dir, "ca.pem")}, fail: true},
+		{name: "certificate without key", config: &Config{CertFile: filepath.Join(dir, "cert.pem")}, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := sarama.NewConfig()
+			sc.Version = sarama.V2_6_0_0
+			err := tt.config.Apply(sc)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed with error: %+v", err)
+			}
+			if sc.Net.TLS.Enable != tt.tls {
+				t.Errorf("expected TLS %t, got %t", tt.tls, sc.Net.TLS.Enable)
+			}
+			if tt.mechanism == "" {
+				return
+			}
+			if !sc.Net.SASL.Enable || sc.Net.SASL.Mechanism != tt.mechanism || sc.Net.SASL.User != "gobmp" || sc.Net.SASL.Password != "secret" {
+				t.Errorf("unexpected SASL configuration: %+v", sc.Net.SASL)
+			}
+			if err := sc.Validate(); err != nil {
+				t.Errorf("invalid configuration with error: %+v", err)
+			}
+		})
+	}
+}
+
+func TestApplySharesProvider(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "user")
+	pass := filepath.Join(dir, "pass")
+	os.WriteFile(user, []byte("gobmp\n"), 0600)
+	os.WriteFile(pass, []byte("secret\n"), 0600)
+	c := &Config{SASLMechanism: SASLSCRAMSHA256, SASLUserFile: user, SASLPasswordFile: pass}
+	var providers []credentials.Provider
+	for i := 0; i < 2; i++ {
+		sc := sarama.NewConfig()
+		if err := c.Apply(sc); err != nil {
+			t.Fatalf("failed with error: %+v", err)
+		}
+		providers = append(providers, sc.Net.SASL.SCRAMClientGeneratorFunc().(*scramClient).credentials)
+	}
+	if providers[0] == nil || providers[0] != providers[1] {
+		t.Errorf("expected clients to share one provider, got %p and %p", providers[0], providers[1])
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kafkaclient
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/credentials"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scramClient implements the client side of the SCRAM exchange defined by RFC 5802 without channel
+// binding, user names and passwords are used as they are, without SASLprep normalization.
+type scramClient struct {
+	hash  func() hash.Hash
+	nonce func() (string, error)
+	// credentials, when set, replaces the user name and password passed to Begin
+	credentials credentials.Provider
+	// step is the number of messages received from the server
+	step     int
+	user     string
+	password string
+	authzID  string
+	gs2      string
+	// clientNonce and clientFirstBare are kept to verify the server's messages
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+	done            bool
+}
+
+func newSCRAMClient(h func() hash.Hash, p credentials.Provider) *scramClient {
+	return &scramClient{
+		hash:        h,
+		nonce:       randomNonce,
+		credentials: p,
+	}
+}
+
+func randomNonce() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// saslName escapes "=" and "," of the user name as RFC 5802 requires
+func saslName(s string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s)
+}
+
+// Begin prepares the exchange, it implements sarama.SCRAMClient
+func (s *scramClient) Begin(user, password, authzID string) error {
+	if s.credentials != nil {
+		c, err := s.credentials.Credentials()
+		if err != nil {
+			return err
+		}
+		user, password = c.User, c.Password
+	}
+	s.user, s.password, s.authzID = user, password, authzID
+	s.step = 0
+	s.done = false
+
+	return nil
+}
+
+// Step returns the response to the server's challenge, it implements sarama.SCRAMClient
+func (s *scramClient) Step(challenge string) (string, error) {
+	defer func() { s.step++ }()
+	switch s.step {
+	case 0:
+		return s.clientFirst()
+	case 1:
+		return s.clientFinal(challenge)
+	case 2:
+		s.done = true
+		return "", s.verifyServerFinal(challenge)
+	}
+
+	return "", fmt.Errorf("unexpected SCRAM challenge after the exchange completed")
+}
+
+// Done returns true when the exchange completed, it implements sarama.SCRAMClient
+func (s *scramClient) Done() bool {
+	return s.done
+}
+
+func (s *scramClient) clientFirst() (string, error) {
+	nonce, err := s.nonce()
+	if err != nil {
+		return "", err
+	}
+	s.clientNonce = nonce
+	s.gs2 = "n,,"
+	if s.authzID != "" {
+		s.gs2 = "n,a=" + saslName(s.authzID) + ","
+	}
+	s.clientFirstBare = "n=" + saslName(s.user) + ",r=" + nonce
+
+	return s.gs2 + s.clientFirstBare, nil
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	attrs := parseAttributes(serverFirst)
+	if e, ok := attrs["e"]; ok {
+		return "", fmt.Errorf("SCRAM server error: %s", e)
+	}
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return "", fmt.Errorf("SCRAM server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt with error: %w", err)
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 {
+		return "", fmt.Errorf("invalid SCRAM iteration count %q", attrs["i"])
+	}
+	salted := pbkdf2.Key([]byte(s.password), salt, iterations, s.hash().Size(), s.hash)
+	clientKey := s.hmac(salted, "Client Key")
+	h := s.hash()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+	clientFinalBare := "c=" + base64.StdEncoding.EncodeToString([]byte(s.gs2)) + ",r=" + nonce
+	authMessage := s.clientFirstBare + "," + serverFirst + "," + clientFinalBare
+	clientSignature := s.hmac(storedKey, authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	s.serverSignature = s.hmac(s.hmac(salted, "Server Key"), authMessage)
+
+	return clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verifyServerFinal(serverFinal string) error {
+	attrs := parseAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM server error: %s", e)
+	}
+	v, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil {
+		return fmt.Errorf("invalid SCRAM server signature with error: %w", err)
+	}
+	if !hmac.Equal(v, s.serverSignature) {
+		return fmt.Errorf("SCRAM server signature does not match")
+	}
+
+	return nil
+}
+
+func (s *scramClient) hmac(key []byte, msg string) []byte {
+	m := hmac.New(s.hash, key)
+	m.Write([]byte(msg))
+
+	return m.Sum(nil)
+}
+
+// parseAttributes returns attributes of a SCRAM message, values may contain "="
+func parseAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, a := range strings.Split(msg, ",") {
+		if len(a) < 2 || a[1] != '=' {
+			continue
+		}
+		attrs[a[:1]] = a[2:]
+	}
+
+	return attrs
+}
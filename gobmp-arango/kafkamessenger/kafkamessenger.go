@@ -23,12 +23,19 @@
 package kafkamessenger
 
 import (
-	"math/rand"
-	"strconv"
+	"context"
+	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/stats"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 	"github.com/sbezverk/gobmp/pkg/tools"
@@ -85,99 +92,279 @@ type Srv interface {
 	Stop() error
 }
 
+const (
+	// consumerGroupID defines the consumer group all gobmp-arango instances join, partitions of
+	// gobmp.parsed.* topics are distributed between the members of the group.
+	consumerGroupID = "gobmp-arango"
+)
+
+var (
+	// topicsCheckInterval defines how often the list of topics available at the broker is
+	// checked, if a new gobmp.parsed.* topic appears, the consumer group session is restarted
+	// to include it.
+	topicsCheckInterval = 30 * time.Second
+	// consumeRetryInterval defines a delay before re-joining the consumer group after a failure
+	consumeRetryInterval = 1 * time.Second
+)
+
 type kafka struct {
-	stopCh  chan struct{}
-	brokers []string
-	db      dbclient.DB
-	config  *sarama.Config
-	master  sarama.Consumer
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+	brokers    []string
+	db         dbclient.DB
+	config     *sarama.Config
+	client     sarama.Client
+	consumer   sarama.ConsumerGroup
+	partitions *flowcontrol.Partitions
+	// member reports membership in the consumer group
+	member *health.State
 }
 
-// NewKafkaMessenger returns an instance of a kafka consumer acting as a messenger server
-func NewKafkaMessenger(kafkaSrv string, db dbclient.DB) (Srv, error) {
+// NewKafkaMessenger returns an instance of a kafka consumer acting as a messenger server, security
+// configures TLS and SASL of connections to the brokers, nil connects in plaintext.
+func NewKafkaMessenger(kafkaSrv string, db dbclient.DB, security *kafkaclient.Config) (Srv, error) {
 	glog.Infof("NewKafkaMessenger")
-	if err := tools.HostAddrValidator(kafkaSrv); err != nil {
-		return nil, err
+	brokers := strings.Split(kafkaSrv, ",")
+	for _, b := range brokers {
+		if err := tools.HostAddrValidator(b); err != nil {
+			return nil, err
+		}
 	}
 
 	config := sarama.NewConfig()
-	config.ClientID = "gobmp-arango-consumer" + "_" + strconv.Itoa(rand.Intn(1000))
+	config.ClientID = "gobmp-arango-consumer"
+	config.Version = sarama.V2_6_0_0
+	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	// When the group has no committed offset, the whole topic is consumed to build the complete state
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Group.Session.Timeout = 10 * time.Second
+	config.Consumer.Group.Heartbeat.Interval = 3 * time.Second
 	config.Consumer.Return.Errors = true
-	config.Version = sarama.V0_11_0_0
-
-	brokers := []string{kafkaSrv}
+	config.Consumer.Offsets.Retry.Max = 3
+	if err := security.Apply(config); err != nil {
+		return nil, err
+	}
 
-	// Create new consumer
-	master, err := sarama.NewConsumer(brokers, config)
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumerGroupFromClient(consumerGroupID, client)
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 	k := &kafka{
-		stopCh: make(chan struct{}),
-		config: config,
-		master: master,
-		db:     db,
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+		brokers:    brokers,
+		config:     config,
+		client:     client,
+		consumer:   consumer,
+		partitions: flowcontrol.NewPartitions(consumer),
+		db:         db,
+		member:     health.NewState("consumer group is not joined"),
 	}
 
 	return k, nil
 }
 
 func (k *kafka) Start() error {
-	// Starting readers for each topic name and type defined in topics map
-	for topicName, topicType := range topics {
-		go k.topicReader(topicType, topicName)
-	}
+	glog.Infof("Starting Kafka messenger, group: %s", consumerGroupID)
+	go k.consume()
+	go func() {
+		for err := range k.consumer.Errors() {
+			glog.Errorf("Kafka consumer error: %+v", err)
+		}
+	}()
 
 	return nil
 }
 
+// Stop leaves the consumer group, offsets of acknowledged messages are committed when the consumer
+// group session ends, so Stop waits for the session to end before closing the consumer group.
 func (k *kafka) Stop() error {
 	close(k.stopCh)
-	k.master.Close()
-	return nil
+	<-k.doneCh
+	if err := k.consumer.Close(); err != nil {
+		glog.Errorf("failed to close Kafka consumer group with error: %+v", err)
+	}
+	return k.client.Close()
+}
+
+// Check reports whether the messenger is a member of the consumer group, it implements health.Checker
+func (k *kafka) Check(ctx context.Context) error {
+	return k.member.Check(ctx)
+}
+
+// Pause stops fetching messages of the types, it implements flowcontrol.Pauser
+func (k *kafka) Pause(types []dbclient.CollectionType) {
+	k.partitions.Pause(types)
+}
+
+// Resume resumes fetching messages of the types, it implements flowcontrol.Pauser
+func (k *kafka) Resume(types []dbclient.CollectionType) {
+	k.partitions.Resume(types)
 }
 
-func (k *kafka) topicReader(topicType dbclient.CollectionType, topicName string) {
-	ticker := time.NewTicker(200 * time.Millisecond)
+// PauseAll stops fetching all messages, it implements flowcontrol.Pauser
+func (k *kafka) PauseAll() {
+	k.partitions.PauseAll()
+}
+
+// consume joins the consumer group and keeps re-joining it after every rebalance, failure or
+// change of the set of topics available at the broker, until the stop signal is received.
+func (k *kafka) consume() {
+	defer close(k.doneCh)
 	for {
-		// Loop until either a topic becomes available at the broker or stop signal is received
-		partitions, err := k.master.Partitions(topicName)
-		if nil != err {
-			glog.Errorf("fail to get partitions for the topic %s with error: %+v", topicName, err)
+		available := k.availableTopics()
+		if len(available) == 0 {
+			glog.Errorf("none of gobmp.parsed.* topics is available at the broker, retrying...")
 			select {
-			case <-ticker.C:
+			case <-time.After(consumeRetryInterval):
+				continue
 			case <-k.stopCh:
 				return
 			}
-			continue
 		}
-		// Loop until either a topic's partition becomes consumable or stop signal is received
-		consumer, err := k.master.ConsumePartition(topicName, partitions[0], sarama.OffsetOldest)
-		if nil != err {
-			glog.Errorf("fail to consume partition for the topic %s with error: %+v", topicName, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		go k.watchTopics(ctx, cancel, available)
+		glog.Infof("Joining consumer group %s for topics: %v", consumerGroupID, available)
+		err := k.consumer.Consume(ctx, available, &handler{db: k.db, partitions: k.partitions, member: k.member})
+		cancel()
+		select {
+		case <-k.stopCh:
+			return
+		default:
+		}
+		if err != nil {
+			glog.Errorf("failed to consume from Kafka with error: %+v", err)
 			select {
-			case <-ticker.C:
+			case <-time.After(consumeRetryInterval):
 			case <-k.stopCh:
 				return
 			}
-			continue
 		}
-		glog.Infof("Starting Kafka reader for topic: %s", topicName)
-		for {
-			select {
-			case msg := <-consumer.Messages():
-				if msg == nil {
-					continue
+	}
+}
+
+// availableTopics returns a sorted list of subscribed topics which exist at the broker.
+func (k *kafka) availableTopics() []string {
+	if err := k.client.RefreshMetadata(); err != nil {
+		glog.Errorf("failed to refresh Kafka metadata with error: %+v", err)
+	}
+	existing, err := k.client.Topics()
+	if err != nil {
+		glog.Errorf("failed to get the list of Kafka topics with error: %+v", err)
+		return nil
+	}
+	available := make([]string, 0, len(topics))
+	for _, t := range existing {
+		if _, ok := topics[t]; ok {
+			available = append(available, t)
+		}
+	}
+	sort.Strings(available)
+
+	return available
+}
+
+// watchTopics cancels the consumer group session when the set of available topics changes.
+func (k *kafka) watchTopics(ctx context.Context, cancel context.CancelFunc, current []string) {
+	ticker := time.NewTicker(topicsCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if len(k.availableTopics()) != len(current) {
+				glog.Infof("the set of available topics has changed, restarting consumer group session")
+				cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		case <-k.stopCh:
+			cancel()
+			return
+		}
+	}
+}
+
+// handler implements sarama.ConsumerGroupHandler
+type handler struct {
+	db         dbclient.DB
+	partitions *flowcontrol.Partitions
+	member     *health.State
+}
+
+// Setup is called when a consumer group session starts
+func (h *handler) Setup(session sarama.ConsumerGroupSession) error {
+	glog.Infof("Consumer group session started, member: %s, claims: %+v", session.MemberID(), session.Claims())
+	h.member.Ready()
+	return nil
+}
+
+// Cleanup is called when a consumer group session ends
+func (h *handler) Cleanup(sarama.ConsumerGroupSession) error {
+	glog.V(5).Infof("Consumer group session ended")
+	h.member.NotReady("consumer group session ended, re-joining the group")
+	return nil
+}
+
+// ConsumeClaim processes messages of a single partition. When the database client supports
+// acknowledgements, the offset of a message is marked only after the document has been persisted,
+// otherwise the message is marked as soon as it has been handed to the database client.
+func (h *handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	topicType, ok := topics[claim.Topic()]
+	if !ok {
+		glog.Errorf("claim for unexpected topic: %s", claim.Topic())
+		return nil
+	}
+	glog.Infof("Starting Kafka reader for topic: %s partition: %d from offset: %d", claim.Topic(), claim.Partition(), claim.InitialOffset())
+	h.partitions.Claim(claim.Topic(), claim.Partition())
+	defer h.partitions.Release(claim.Topic(), claim.Partition())
+	_, isAckDB := h.db.(dbclient.AckDB)
+	_, isTraceDB := h.db.(dbclient.TraceDB)
+	tracker := flowcontrol.NewOffsetTracker(session, claim.Topic(), claim.Partition())
+	defer func() {
+		if n := tracker.InFlight(); n != 0 {
+			glog.Infof("topic: %s partition: %d released with %d messages in flight", claim.Topic(), claim.Partition(), n)
+		}
+	}()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			// The high watermark is the offset of the next message to be produced
+			stats.SetConsumerLag(msg.Topic, msg.Partition, claim.HighWaterMarkOffset()-msg.Offset-1)
+			// The span ends once the message is handed over, its processing is recorded by child spans
+			ctx, span := tracing.Consume(msg)
+			if !isAckDB && !isTraceDB {
+				err := h.db.StoreMessage(topicType, msg.Value)
+				if err != nil {
+					glog.Errorf("failed to store message from topic: %s partition: %d offset: %d with error: %+v", msg.Topic, msg.Partition, msg.Offset, err)
 				}
-				k.db.StoreMessage(topicType, msg.Value)
-			case consumerError := <-consumer.Errors():
-				if consumerError == nil {
-					break
+				tracing.End(span, err)
+				session.MarkMessage(msg, "")
+				continue
+			}
+			offset := msg.Offset
+			tracker.Add(offset)
+			err := dbclient.Store(ctx, h.db, topicType, msg.Value, func() { tracker.Ack(offset) })
+			tracing.End(span, err)
+			if err != nil {
+				if errors.Is(err, flowcontrol.ErrStopped) {
+					// The message was not processed, its offset must not be committed
+					return nil
 				}
-				glog.Errorf("error %+v for topic: %s, partition: %s ", consumerError.Err, string(consumerError.Topic), string(consumerError.Partition))
-			case <-k.stopCh:
-				return
+				glog.Errorf("failed to store message from topic: %s partition: %d offset: %d with error: %+v", msg.Topic, msg.Partition, msg.Offset, err)
+				// The message was rejected and will never be acknowledged by the database client
+				tracker.Ack(offset)
 			}
+		case <-session.Context().Done():
+			return nil
 		}
 	}
 }
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kafkanotifier
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Broadcaster delivers events to in-process subscribers over Go channels
+type Broadcaster struct {
+	sync.RWMutex
+	subscribers map[chan *EventMessage]struct{}
+}
+
+// NewBroadcaster returns a broadcaster without subscribers, events sent before the first
+// subscription are dropped.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subscribers: make(map[chan *EventMessage]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving events and a function cancelling the subscription.
+// An event is dropped for a subscriber whose channel buffer is full, so a slow subscriber
+// does not block the producer nor other subscribers.
+func (b *Broadcaster) Subscribe(buffer int) (<-chan *EventMessage, func()) {
+	ch := make(chan *EventMessage, buffer)
+	b.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.Unlock()
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			b.Lock()
+			delete(b.subscribers, ch)
+			b.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// EventNotification sends the event to every subscriber
+func (b *Broadcaster) EventNotification(msg *EventMessage) error {
+	b.RLock()
+	defer b.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			glog.Warningf("subscriber is not keeping up, dropping event for %s sequence: %d", msg.ID, msg.Sequence)
+		}
+	}
+
+	return nil
+}
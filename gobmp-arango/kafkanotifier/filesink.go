@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kafkanotifier
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// FileEvent defines a line written by the file notifier
+type FileEvent struct {
+	Topic string `json:"topic"`
+	*EventMessage
+}
+
+type fileSink struct {
+	sync.Mutex
+	f *os.File
+}
+
+// NewFileNotifier returns a notifier appending every event as a line of JSON to the file
+func NewFileNotifier(fn string) (Event, error) {
+	glog.Infof("Initializing file events notifier for %s", fn)
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) EventNotification(msg *EventMessage) error {
+	topic, err := EventTopic(msg.TopicType)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&FileEvent{Topic: topic, EventMessage: msg})
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	_, err = s.f.Write(append(b, '\n'))
+
+	return err
+}
@@ -32,6 +32,8 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 )
@@ -90,11 +92,39 @@ var (
 	}
 )
 
+// EventPayload defines how much of the changed document is carried by an event
+type EventPayload string
+
+const (
+	// PayloadNone events carry only the key, the revision and the action
+	PayloadNone EventPayload = "none"
+	// PayloadFull events carry the new document and the previous document on updates and deletes
+	PayloadFull EventPayload = "full"
+	// PayloadDiff events carry the new document and names of changed fields on updates,
+	// and the previous document on deletes
+	PayloadDiff EventPayload = "diff"
+)
+
+// EventMessage describes a change of a document of the Database. Events of all databases, for example of
+// tenants, are sent to the same topics, consumers use Database to tell documents with the same ID apart.
+// Rev is the revision returned by the database for the change,
+// Sequence is derived from Rev, it increases with every change of the key, consumers use it to discard stale or reordered events.
+// Document, Previous and ChangedFields are set only when the producer is configured with a rich payload,
+// on deletes Previous carries the last known document.
 type EventMessage struct {
-	TopicType dbclient.CollectionType
-	Key       string `json:"_key"`
-	ID        string `json:"_id"`
-	Action    string `json:"action"`
+	TopicType     dbclient.CollectionType
+	Database      string          `json:"database,omitempty"`
+	Key           string          `json:"_key"`
+	ID            string          `json:"_id"`
+	Rev           string          `json:"_rev,omitempty"`
+	Sequence      uint64          `json:"sequence,omitempty"`
+	Action        string          `json:"action"`
+	Document      json.RawMessage `json:"document,omitempty"`
+	Previous      json.RawMessage `json:"previous,omitempty"`
+	ChangedFields []string        `json:"changed_fields,omitempty"`
+	// TraceContext carries the trace context of the change to notifiers, Kafka notifiers propagate it
+	// in record headers
+	TraceContext map[string]string `json:"-"`
 }
 
 type Event interface {
@@ -108,49 +138,61 @@ type notifier struct {
 }
 
 func (n *notifier) EventNotification(msg *EventMessage) error {
-	switch msg.TopicType {
+	topic, err := EventTopic(msg.TopicType)
+	if err != nil {
+		return err
+	}
+
+	return n.triggerNotification(topic, msg)
+}
+
+// EventTopic returns the name of the events topic of the collection type
+func EventTopic(t dbclient.CollectionType) (string, error) {
+	switch t {
 	case bmp.PeerStateChangeMsg:
-		return n.triggerNotification(PeerEventTopic, msg)
+		return PeerEventTopic, nil
 	case bmp.UnicastPrefixMsg:
-		return n.triggerNotification(UnicastPrefixEventTopic, msg)
+		return UnicastPrefixEventTopic, nil
 	case bmp.UnicastPrefixV4Msg:
-		return n.triggerNotification(UnicastPrefixV4EventTopic, msg)
+		return UnicastPrefixV4EventTopic, nil
 	case bmp.UnicastPrefixV6Msg:
-		return n.triggerNotification(UnicastPrefixV6EventTopic, msg)
+		return UnicastPrefixV6EventTopic, nil
 	case bmp.LSNodeMsg:
-		return n.triggerNotification(LSNodeEventTopic, msg)
+		return LSNodeEventTopic, nil
 	case bmp.LSLinkMsg:
-		return n.triggerNotification(LSLinkEventTopic, msg)
+		return LSLinkEventTopic, nil
 	case bmp.L3VPNMsg:
-		return n.triggerNotification(L3VPNEventTopic, msg)
+		return L3VPNEventTopic, nil
 	case bmp.L3VPNV4Msg:
-		return n.triggerNotification(L3VPNV4EventTopic, msg)
+		return L3VPNV4EventTopic, nil
 	case bmp.L3VPNV6Msg:
-		return n.triggerNotification(L3VPNV6EventTopic, msg)
+		return L3VPNV6EventTopic, nil
 	case bmp.LSPrefixMsg:
-		return n.triggerNotification(LSPrefixEventTopic, msg)
+		return LSPrefixEventTopic, nil
 	case bmp.LSSRv6SIDMsg:
-		return n.triggerNotification(LSSRv6SIDEventTopic, msg)
+		return LSSRv6SIDEventTopic, nil
 	case bmp.EVPNMsg:
-		return n.triggerNotification(EVPNEventTopic, msg)
+		return EVPNEventTopic, nil
 	case bmp.SRPolicyMsg:
-		return n.triggerNotification(SRPolicyEventTopic, msg)
+		return SRPolicyEventTopic, nil
 	case bmp.SRPolicyV4Msg:
-		return n.triggerNotification(SRPolicyV4EventTopic, msg)
+		return SRPolicyV4EventTopic, nil
 	case bmp.SRPolicyV6Msg:
-		return n.triggerNotification(SRPolicyV6EventTopic, msg)
+		return SRPolicyV6EventTopic, nil
 	case bmp.FlowspecMsg:
-		return n.triggerNotification(SRPolicyEventTopic, msg)
+		return FlowspecEventTopic, nil
 	case bmp.FlowspecV4Msg:
-		return n.triggerNotification(SRPolicyV4EventTopic, msg)
+		return FlowspecV4EventTopic, nil
 	case bmp.FlowspecV6Msg:
-		return n.triggerNotification(SRPolicyV6EventTopic, msg)
+		return FlowspecV6EventTopic, nil
 	}
 
-	return fmt.Errorf("unknown topic type %d", msg.TopicType)
+	return "", fmt.Errorf("unknown topic type %d", t)
 }
 
-func NewKafkaNotifier(kafkaSrv string) (Event, error) {
+// NewKafkaNotifier returns a notifier publishing events to Kafka, security configures TLS and SASL
+// of connections to the broker, nil connects in plaintext.
+func NewKafkaNotifier(kafkaSrv string, security *kafkaclient.Config) (Event, error) {
 	glog.Infof("Initializing Kafka events producer client")
 	if err := validator(kafkaSrv); err != nil {
 		glog.Errorf("Failed to validate Kafka server address %s with error: %+v", kafkaSrv, err)
@@ -159,6 +201,9 @@ func NewKafkaNotifier(kafkaSrv string) (Event, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	config.Version = sarama.V0_11_0_0
+	if err := security.Apply(config); err != nil {
+		return nil, err
+	}
 
 	br := sarama.NewBroker(kafkaSrv)
 	if err := br.Open(config); err != nil {
@@ -195,11 +240,14 @@ func (n *notifier) triggerNotification(topic string, msg *EventMessage) error {
 	k = []byte(msg.Key)
 	m := sarama.ByteEncoder{}
 	m, _ = json.Marshal(msg)
-	_, _, err := n.producer.SendMessage(&sarama.ProducerMessage{
+	pm := &sarama.ProducerMessage{
 		Topic: topic,
 		Key:   k,
 		Value: m,
-	})
+	}
+	span := tracing.Produce(tracing.Extract(msg.TraceContext), pm)
+	_, _, err := n.producer.SendMessage(pm)
+	tracing.End(span, err)
 
 	return err
 }
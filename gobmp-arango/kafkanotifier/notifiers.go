@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kafkanotifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+)
+
+const (
+	// KafkaNotifier publishes events to Kafka topics
+	KafkaNotifier = "kafka"
+	// ChannelNotifier delivers events to in-process subscribers
+	ChannelNotifier = "channel"
+	// WebhookNotifier posts events to an HTTP endpoint
+	WebhookNotifier = "webhook"
+	// FileNotifier appends events to a JSON lines file
+	FileNotifier = "file"
+)
+
+// Config defines the notifier backend and its parameters
+type Config struct {
+	// Kind is one of KafkaNotifier, ChannelNotifier, WebhookNotifier or FileNotifier
+	Kind string
+	// Target is the Kafka server for KafkaNotifier, the url for WebhookNotifier and the file name for FileNotifier
+	Target string
+	// MaxRetries and RetryBackoff control retries of WebhookNotifier
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Kafka configures TLS and SASL of KafkaNotifier connections
+	Kafka *kafkaclient.Config
+}
+
+// NewNotifier returns an events notifier of the configured kind
+func NewNotifier(config Config) (Event, error) {
+	switch config.Kind {
+	case "", KafkaNotifier:
+		return NewKafkaNotifier(config.Target, config.Kafka)
+	case ChannelNotifier:
+		return NewBroadcaster(), nil
+	case WebhookNotifier:
+		return NewWebhookNotifier(config.Target, config.MaxRetries, config.RetryBackoff)
+	case FileNotifier:
+		return NewFileNotifier(config.Target)
+	}
+
+	return nil, fmt.Errorf("unknown notifier %q, supported notifiers are %s, %s, %s and %s", config.Kind, KafkaNotifier, ChannelNotifier, WebhookNotifier, FileNotifier)
+}
@@ -0,0 +1,110 @@
+package kafkanotifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+var testEvent = &EventMessage{
+	TopicType: bmp.LSLinkMsg,
+	Key:       "1",
+	ID:        "ls_link/1",
+	Sequence:  1,
+	Action:    "add",
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+	ch1, cancel1 := b.Subscribe(1)
+	ch2, cancel2 := b.Subscribe(1)
+	defer cancel2()
+	if err := b.EventNotification(testEvent); err != nil {
+		t.Fatalf("failed to send event with error: %+v", err)
+	}
+	for _, ch := range []<-chan *EventMessage{ch1, ch2} {
+		if m := <-ch; m.ID != testEvent.ID {
+			t.Fatalf("expected event for %s, actual %s", testEvent.ID, m.ID)
+		}
+	}
+	cancel1()
+	if _, ok := <-ch1; ok {
+		t.Fatalf("channel of cancelled subscription should be closed")
+	}
+	// The buffer of the second subscriber is full after the second event, the third is dropped
+	b.EventNotification(testEvent)
+	b.EventNotification(testEvent)
+	if len(ch2) != 1 {
+		t.Fatalf("expected 1 buffered event, actual %d", len(ch2))
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(TopicHeader) != LSLinkEventTopic {
+			t.Errorf("expected topic header %s, actual %s", LSLinkEventTopic, r.Header.Get(TopicHeader))
+		}
+		// The first two requests fail
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	n, err := NewWebhookNotifier(srv.URL, 3, time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to create webhook notifier with error: %+v", err)
+	}
+	if err := n.EventNotification(testEvent); err != nil {
+		t.Fatalf("failed to send event with error: %+v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 requests, actual %d", calls)
+	}
+	n, _ = NewWebhookNotifier(srv.URL, 0, time.Millisecond)
+	atomic.StoreInt32(&calls, 0)
+	if err := n.EventNotification(testEvent); err == nil {
+		t.Fatalf("expected to fail without retries")
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "events.jsonl")
+	n, err := NewFileNotifier(fn)
+	if err != nil {
+		t.Fatalf("failed to create file notifier with error: %+v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := n.EventNotification(testEvent); err != nil {
+			t.Fatalf("failed to send event with error: %+v", err)
+		}
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open events file with error: %+v", err)
+	}
+	defer f.Close()
+	lines := 0
+	for s := bufio.NewScanner(f); s.Scan(); lines++ {
+		e := &FileEvent{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			t.Fatalf("failed to decode event with error: %+v", err)
+		}
+		if e.Topic != LSLinkEventTopic || e.Key != testEvent.Key {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+	if lines != 2 {
+		t.Fatalf("expected 2 events, actual %d", lines)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kafkanotifier
+
+import "sync"
+
+// SequenceFilter tracks the last sequence number seen for each document, consumers use it
+// to drop events which arrive after a newer event of the same document. A document is forgotten
+// when its removal is accepted, so the filter holds only documents which exist.
+type SequenceFilter struct {
+	sync.Mutex
+	last map[string]uint64
+}
+
+// NewSequenceFilter returns an empty sequence filter
+func NewSequenceFilter() *SequenceFilter {
+	return &SequenceFilter{
+		last: make(map[string]uint64),
+	}
+}
+
+// Accept returns false if an event with the same or higher sequence number has already been
+// accepted for the document id. Events without a sequence number are always accepted, removed
+// is true for events of the document's removal.
+func (f *SequenceFilter) Accept(id string, sequence uint64, removed bool) bool {
+	if sequence == 0 {
+		return true
+	}
+	f.Lock()
+	defer f.Unlock()
+	if sequence <= f.last[id] {
+		return false
+	}
+	if removed {
+		delete(f.last, id)
+	} else {
+		f.last[id] = sequence
+	}
+
+	return true
+}
+
+// Len returns the number of documents tracked by the filter
+func (f *SequenceFilter) Len() int {
+	f.Lock()
+	defer f.Unlock()
+
+	return len(f.last)
+}
@@ -0,0 +1,32 @@
+package kafkanotifier
+
+import "testing"
+
+func TestSequenceFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		sequence uint64
+		removed  bool
+		expect   bool
+	}{
+		{name: "first event", id: "ls_node/1", sequence: 10, expect: true},
+		{name: "newer event", id: "ls_node/1", sequence: 12, expect: true},
+		{name: "stale event", id: "ls_node/1", sequence: 11, expect: false},
+		{name: "duplicate event", id: "ls_node/1", sequence: 12, expect: false},
+		{name: "other document", id: "ls_node/2", sequence: 11, expect: true},
+		{name: "no sequence", id: "ls_node/1", sequence: 0, expect: true},
+		{name: "stale removal", id: "ls_node/2", sequence: 10, removed: true, expect: false},
+		{name: "removal", id: "ls_node/2", sequence: 12, removed: true, expect: true},
+		{name: "added after removal", id: "ls_node/2", sequence: 12, expect: true},
+	}
+	f := NewSequenceFilter()
+	for _, tt := range tests {
+		if got := f.Accept(tt.id, tt.sequence, tt.removed); got != tt.expect {
+			t.Fatalf("%s: expected %t, actual %t", tt.name, tt.expect, got)
+		}
+	}
+	if n := f.Len(); n != 2 {
+		t.Fatalf("expected 2 tracked documents, actual %d", n)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kafkanotifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// TopicHeader carries the name of the events topic of a webhook request
+	TopicHeader = "X-Jalapeno-Topic"
+
+	defaultWebhookTimeout = 10 * time.Second
+	maxWebhookBackoff     = 30 * time.Second
+)
+
+type webhook struct {
+	url        string
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+// NewWebhookNotifier returns a notifier which POSTs every event as JSON to the url. A request failing
+// with a network error, 429 or 5xx status is retried up to maxRetries times, the delay starts
+// with backoff and doubles with every attempt.
+func NewWebhookNotifier(url string, maxRetries int, backoff time.Duration) (Event, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook url cannot be empty")
+	}
+	glog.Infof("Initializing webhook events notifier for %s", url)
+
+	return &webhook{
+		url:        url,
+		client:     &http.Client{Timeout: defaultWebhookTimeout},
+		maxRetries: maxRetries,
+		backoff:    backoff,
+	}, nil
+}
+
+func (w *webhook) EventNotification(msg *EventMessage) error {
+	topic, err := EventTopic(msg.TopicType)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	delay := w.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(topic, b, msg.TraceContext)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.maxRetries {
+			return err
+		}
+		glog.V(5).Infof("webhook request for %s failed with error: %+v, retrying in %s", msg.ID, err, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxWebhookBackoff {
+			delay = maxWebhookBackoff
+		}
+	}
+}
+
+// post sends the event with the trace context headers and returns whether a failed request can be retried
+func (w *webhook) post(topic string, b []byte, traceContext map[string]string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TopicHeader, topic)
+	for k, v := range traceContext {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+}
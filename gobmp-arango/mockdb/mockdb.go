@@ -23,20 +23,73 @@
 package mockdb
 
 import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangodb"
+	"github.com/cisco-open/jalapeno/gobmp-arango/capture"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
 	"github.com/golang/glog"
-	"github.com/sbezverk/gobmp/pkg/message"
 )
 
+const (
+	flushInterval = time.Second
+)
+
+// Config holds the configuration of the mock database client
+type Config struct {
+	// CaptureFile is the JSON lines file where received messages are recorded, no messages are
+	// recorded when it is not set.
+	CaptureFile string
+	// MaxFileSize is the size in bytes at which the capture file is rotated, 0 disables rotation
+	MaxFileSize int64
+	// MaxFiles is the number of rotated capture files to keep
+	MaxFiles int
+	// KeepView enables the in-memory view of documents the collections would contain
+	KeepView bool
+	// ViewFile, if set, is where the view is written when the client stops
+	ViewFile string
+	// Schema defines key strategies of the collections in the view, when nil DefaultSchema is used
+	Schema *arangodb.Schema
+}
+
+// Viewer defines a method returning the documents a collection would contain, keyed by the document key
+type Viewer interface {
+	Documents(msgType dbclient.CollectionType) map[string]json.RawMessage
+}
+
 type mockDB struct {
-	stop chan struct{}
+	stop    chan struct{}
+	config  Config
+	capture *capture.Writer
+	keys    *arangodb.RecordKeys
+	sync.Mutex
+	view map[dbclient.CollectionType]map[string]json.RawMessage
 	dbclient.DB
 }
 
 // NewDBSrvClient returns an instance of a mock DB server client process
-func NewDBSrvClient() (dbclient.Srv, error) {
+func NewDBSrvClient(config Config) (dbclient.Srv, error) {
 	m := &mockDB{
-		stop: make(chan struct{}),
+		stop:   make(chan struct{}),
+		config: config,
+		view:   make(map[dbclient.CollectionType]map[string]json.RawMessage),
+	}
+	if config.KeepView {
+		keys, err := arangodb.NewRecordKeys(config.Schema)
+		if err != nil {
+			return nil, err
+		}
+		m.keys = keys
+	}
+	if config.CaptureFile != "" {
+		w, err := capture.NewWriter(config.CaptureFile, config.MaxFileSize, config.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		m.capture = w
 	}
 	m.DB = m
 
@@ -45,11 +98,25 @@ func NewDBSrvClient() (dbclient.Srv, error) {
 
 func (m *mockDB) Start() error {
 	glog.Info("Starting Mock DB Client")
+	if m.capture != nil {
+		glog.Infof("Recording received messages to %s", m.config.CaptureFile)
+		go m.flusher()
+	}
 	return nil
 }
 
 func (m *mockDB) Stop() error {
 	close(m.stop)
+	if m.capture != nil {
+		if err := m.capture.Close(); err != nil {
+			glog.Errorf("failed to close capture file %s with error: %+v", m.config.CaptureFile, err)
+		}
+	}
+	if m.config.KeepView && m.config.ViewFile != "" {
+		if err := m.writeView(); err != nil {
+			glog.Errorf("failed to write view file %s with error: %+v", m.config.ViewFile, err)
+		}
+	}
 
 	return nil
 }
@@ -59,58 +126,88 @@ func (m *mockDB) GetInterface() dbclient.DB {
 }
 
 func (m *mockDB) StoreMessage(msgType dbclient.CollectionType, msg []byte) error {
-	// switch msgType {
-	// case bmp.PeerStateChangeMsg:
-	// 	p, ok := msg.(*message.PeerStateChange)
-	// 	if !ok {
-	// 		return fmt.Errorf("malformed PeerStateChange message")
-	// 	}
-	// 	m.peerChangeHandler(p)
-	// case bmp.UnicastPrefixMsg:
-	// 	un, ok := msg.(*message.UnicastPrefix)
-	// 	if !ok {
-	// 		return fmt.Errorf("malformed UnicastPrefix message")
-	// 	}
-	// 	m.unicastPrefixHandler(un)
-	// case bmp.LSNodeMsg:
-	// 	ln, ok := msg.(*message.LSNode)
-	// 	if !ok {
-	// 		return fmt.Errorf("malformed LSNode message")
-	// 	}
-	// 	m.lsNodeHandler(ln)
-	// case bmp.LSLinkMsg:
-	// 	ll, ok := msg.(*message.LSLink)
-	// 	if !ok {
-	// 		return fmt.Errorf("malformed LSLink message")
-	// 	}
-	// 	m.lsLinkHandler(ll)
-	// case bmp.L3VPNMsg:
-	// 	l3, ok := msg.(*message.L3VPNPrefix)
-	// 	if !ok {
-	// 		return fmt.Errorf("malformed L3VPN message")
-	// 	}
-	// 	m.l3vpnPrefixHandler(l3)
-	// }
+	if m.capture != nil {
+		if err := m.capture.Write(&capture.Record{
+			Topic:          dbclient.TopicName(msgType),
+			CollectionType: msgType,
+			Timestamp:      time.Now(),
+			Message:        msg,
+		}); err != nil {
+			glog.Errorf("failed to record message of type %d with error: %+v", msgType, err)
+		}
+	}
+	if m.config.KeepView {
+		m.updateView(msgType, msg)
+	}
 
 	return nil
 }
 
-func (m *mockDB) peerChangeHandler(obj *message.PeerStateChange) {
-	glog.V(5).Infof("peer change handler")
+// updateView applies the message to the view the same way the database client applies it to the collection
+func (m *mockDB) updateView(msgType dbclient.CollectionType, msg []byte) {
+	key, action, err := m.keys.RecordKey(msgType, msg)
+	if err != nil {
+		glog.Errorf("failed to get the key of message of type %d with error: %+v", msgType, err)
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	c, ok := m.view[msgType]
+	if !ok {
+		c = make(map[string]json.RawMessage)
+		m.view[msgType] = c
+	}
+	switch action {
+	case "del":
+		fallthrough
+	case "down":
+		delete(c, key)
+	default:
+		// Message buffer can be reused by the messenger, keeping a copy
+		c[key] = append(json.RawMessage{}, msg...)
+	}
+	glog.V(6).Infof("view of type %d: %s key: %s documents: %d", msgType, action, key, len(c))
 }
 
-func (m *mockDB) unicastPrefixHandler(obj *message.UnicastPrefix) {
-	glog.V(5).Infof("unicast prefix handler")
-}
+// Documents returns a copy of documents of the collection fed by messages of the type
+func (m *mockDB) Documents(msgType dbclient.CollectionType) map[string]json.RawMessage {
+	m.Lock()
+	defer m.Unlock()
+	docs := make(map[string]json.RawMessage, len(m.view[msgType]))
+	for k, v := range m.view[msgType] {
+		docs[k] = v
+	}
 
-func (m *mockDB) lsNodeHandler(obj *message.LSNode) {
-	glog.V(5).Infof("LS Node handler")
+	return docs
 }
 
-func (m *mockDB) lsLinkHandler(obj *message.LSLink) {
-	glog.V(5).Infof("LS Link handler")
+func (m *mockDB) writeView() error {
+	m.Lock()
+	view := make(map[string]map[string]json.RawMessage, len(m.view))
+	for t, c := range m.view {
+		view[dbclient.TopicName(t)] = c
+	}
+	b, err := json.MarshalIndent(view, "", "  ")
+	m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(m.config.ViewFile, b, 0644)
 }
 
-func (m *mockDB) l3vpnPrefixHandler(obj *message.L3VPNPrefix) {
-	glog.V(5).Infof("L3VPNPrefix handler")
+// flusher periodically flushes the capture file, so it can be inspected while messages are recorded
+func (m *mockDB) flusher() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.capture.Flush(); err != nil {
+				glog.Errorf("failed to flush capture file %s with error: %+v", m.config.CaptureFile, err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
 }
@@ -0,0 +1,76 @@
+package mockdb
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangodb"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+var (
+	nodeAdd = []byte(`{"action":"add","router_ip":"192.168.9.9","peer_ip":"192.168.8.8","igp_router_id":"0000.0000.0008","router_id":"192.168.8.8","domain_id":0,"protocol_id":2,"area_id":"49.0901"}`)
+	nodeDel = []byte(`{"action":"del","router_ip":"192.168.9.9","peer_ip":"192.168.8.8","igp_router_id":"0000.0000.0008","router_id":"192.168.8.8","domain_id":0,"protocol_id":2,"area_id":"49.0901"}`)
+)
+
+func TestMockDBView(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "capture.jsonl")
+	srv, err := NewDBSrvClient(Config{CaptureFile: fn, KeepView: true})
+	if err != nil {
+		t.Fatalf("failed to create mock database with error: %+v", err)
+	}
+	db := srv.GetInterface()
+	v := db.(Viewer)
+	for _, msg := range [][]byte{nodeAdd, nodeAdd} {
+		if err := db.StoreMessage(bmp.LSNodeMsg, msg); err != nil {
+			t.Fatalf("failed to store message with error: %+v", err)
+		}
+	}
+	if n := len(v.Documents(bmp.LSNodeMsg)); n != 1 {
+		t.Fatalf("expected 1 document after duplicate adds, actual %d", n)
+	}
+	if err := db.StoreMessage(bmp.LSNodeMsg, nodeDel); err != nil {
+		t.Fatalf("failed to store message with error: %+v", err)
+	}
+	if n := len(v.Documents(bmp.LSNodeMsg)); n != 0 {
+		t.Fatalf("expected no documents after delete, actual %d", n)
+	}
+	if err := srv.Stop(); err != nil {
+		t.Fatalf("failed to stop mock database with error: %+v", err)
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open capture file with error: %+v", err)
+	}
+	defer f.Close()
+	n := 0
+	for s := bufio.NewScanner(f); s.Scan(); {
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 recorded messages, actual %d", n)
+	}
+}
+
+func TestMockDBViewKeys(t *testing.T) {
+	schema := &arangodb.Schema{Collections: []arangodb.CollectionSchema{
+		{Name: "unicast_prefix_v4", MessageTypes: []string{"unicast_prefix_v4"}, Key: &arangodb.KeySchema{Strategy: arangodb.KeyStrategyPrefix}},
+	}}
+	srv, err := NewDBSrvClient(Config{KeepView: true, Schema: schema})
+	if err != nil {
+		t.Fatalf("failed to create mock database with error: %+v", err)
+	}
+	db := srv.GetInterface()
+	for _, peer := range []string{"192.168.8.8", "192.168.9.9"} {
+		msg := []byte(`{"action":"add","prefix":"10.0.0.0","prefix_len":24,"peer_ip":"` + peer + `","nexthop":"` + peer + `"}`)
+		if err := db.StoreMessage(bmp.UnicastPrefixV4Msg, msg); err != nil {
+			t.Fatalf("failed to store message with error: %+v", err)
+		}
+	}
+	docs := db.(Viewer).Documents(bmp.UnicastPrefixV4Msg)
+	if _, ok := docs["10.0.0.0_24"]; !ok || len(docs) != 1 {
+		t.Fatalf("expected a single document with key 10.0.0.0_24, actual %d documents", len(docs))
+	}
+}
@@ -23,63 +23,128 @@
 package mockmessenger
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/messenger"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 )
 
-// Srv defines required method of a processor server
-type Srv interface {
-	Start() error
-	Stop() error
+const (
+	// maxMessageSize defines the longest message a fixture file can carry
+	maxMessageSize = 16 * 1024 * 1024
+)
+
+var (
+	// fixtureExtensions lists extensions stripped from a fixture file name to get the topic name
+	fixtureExtensions = []string{".json", ".jsonl", ".txt"}
+	// topicOrder defines the order fixture files are played in, so peers and nodes are stored
+	// before links and prefixes referring to them.
+	topicOrder = []dbclient.CollectionType{
+		bmp.PeerStateChangeMsg,
+		bmp.LSNodeMsg,
+		bmp.LSLinkMsg,
+		bmp.LSPrefixMsg,
+		bmp.LSSRv6SIDMsg,
+		bmp.UnicastPrefixMsg,
+		bmp.UnicastPrefixV4Msg,
+		bmp.UnicastPrefixV6Msg,
+		bmp.L3VPNMsg,
+		bmp.L3VPNV4Msg,
+		bmp.L3VPNV6Msg,
+		bmp.EVPNMsg,
+		bmp.SRPolicyMsg,
+		bmp.SRPolicyV4Msg,
+		bmp.SRPolicyV6Msg,
+		bmp.FlowspecMsg,
+		bmp.FlowspecV4Msg,
+		bmp.FlowspecV6Msg,
+	}
+)
+
+// Config holds the configuration of the mock messenger
+type Config struct {
+	// Dir is the directory with fixture files, each file is named after the topic its messages
+	// come from, for example gobmp.parsed.ls_node.json, and carries one message per line.
+	Dir string
+	// Loop, when true, restarts playing the fixtures once all files have been played
+	Loop bool
+	// Interval is the delay between messages
+	Interval time.Duration
 }
 
-type mockMsg struct {
-	added bool
-	msgs  [2][]byte
+type fixture struct {
+	fn      string
+	msgType dbclient.CollectionType
 }
 
 type mockMessenger struct {
-	store map[int]*mockMsg
-	stop  chan struct{}
-	db    dbclient.DB
+	config   Config
+	fixtures []*fixture
+	stop     chan struct{}
+	db       dbclient.DB
 }
 
-// NewMockMessenger returns an instance of a mock messenger acting as a messenger server
-func NewMockMessenger(db dbclient.DB) (Srv, error) {
+// NewMockMessenger returns an instance of a mock messenger playing messages from fixture files
+func NewMockMessenger(config Config, db dbclient.DB) (messenger.Srv, error) {
+	fixtures, err := loadFixtures(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
 	return &mockMessenger{
-		db:   db,
-		stop: make(chan struct{}),
-		store: map[int]*mockMsg{
-			bmp.PeerStateChangeMsg: {
-				added: false,
-				msgs: [2][]byte{
-					[]byte(`{"action":"up","router_hash":"5efeb5cc6a67ccead6660f7c43e7a8c3","remote_bgp_id":"192.168.8.8","router_ip":"192.168.9.9","timestamp":"Apr  4 14:08:33.000006","remote_asn":5070,"remote_ip":"192.168.8.8","peer_rd":"0:0","remote_port":37772,"local_asn":5070,"local_ip":"192.168.9.9","local_port":179,"local_bgp_id":"192.168.9.9","adv_cap":"MPBGP (1) : afi=1 safi=1 : Unicast IPv4 , MPBGP (1) : afi=1 safi=4 : MPLS Labels IPv4 , MPBGP (1) : afi=1 safi=128 : MPLS-labeled VPN IPv4 , MPBGP (1) : afi=2 safi=1 : Unicast IPv6 , MPBGP (1) : afi=16388 safi=71 : BGP-LS BGP-LS , MPBGP (1) : afi=1 safi=73 :  IPv4 , Route Refresh Old (128), Route Refresh (2), 4 Octet ASN (65), ADD-PATH (69), Extended Next Hop Encoding (5)","recv_cap":"MPBGP (1) : afi=1 safi=1 : Unicast IPv4 , MPBGP (1) : afi=1 safi=4 : MPLS Labels IPv4 , MPBGP (1) : afi=1 safi=128 : MPLS-labeled VPN IPv4 , MPBGP (1) : afi=2 safi=1 : Unicast IPv6 , MPBGP (1) : afi=16388 safi=71 : BGP-LS BGP-LS , Route Refresh Old (128), Route Refresh (2), 4 Octet ASN (65), ADD-PATH (69), Extended Next Hop Encoding (5)","remote_holddown":180,"adv_holddown":180,"is_ipv4":true}`),
-					[]byte(`{"action":"down","router_hash":"5efeb5cc6a67ccead6660f7c43e7a8c3","remote_bgp_id":"192.168.8.8","router_ip":"192.168.9.9","timestamp":"Apr  4 14:08:33.000006","remote_asn":5070,"remote_ip":"192.168.8.8","peer_rd":"0:0","remote_port":37772,"local_asn":5070,"local_ip":"192.168.9.9","local_port":179,"local_bgp_id":"192.168.9.9","adv_cap":"MPBGP (1) : afi=1 safi=1 : Unicast IPv4 , MPBGP (1) : afi=1 safi=4 : MPLS Labels IPv4 , MPBGP (1) : afi=1 safi=128 : MPLS-labeled VPN IPv4 , MPBGP (1) : afi=2 safi=1 : Unicast IPv6 , MPBGP (1) : afi=16388 safi=71 : BGP-LS BGP-LS , MPBGP (1) : afi=1 safi=73 :  IPv4 , Route Refresh Old (128), Route Refresh (2), 4 Octet ASN (65), ADD-PATH (69), Extended Next Hop Encoding (5)","recv_cap":"MPBGP (1) : afi=1 safi=1 : Unicast IPv4 , MPBGP (1) : afi=1 safi=4 : MPLS Labels IPv4 , MPBGP (1) : afi=1 safi=128 : MPLS-labeled VPN IPv4 , MPBGP (1) : afi=2 safi=1 : Unicast IPv6 , MPBGP (1) : afi=16388 safi=71 : BGP-LS BGP-LS , Route Refresh Old (128), Route Refresh (2), 4 Octet ASN (65), ADD-PATH (69), Extended Next Hop Encoding (5)","remote_holddown":180,"adv_holddown":180,"is_ipv4":true}`),
-				},
-			},
-			bmp.LSNodeMsg: {
-				added: false,
-				msgs: [2][]byte{
-					[]byte(`{"action":"add","router_hash":"5efeb5cc6a67ccead6660f7c43e7a8c3","router_ip":"192.168.9.9","base_attr_hash":"4933686d17a7b5b935252efb2cd41f16","peer_hash":"222065841d3956cc669453a009daf611","peer_ip":"192.168.8.8","peer_asn":5070,"timestamp":"Apr  4 13:55:34.000485","igp_router_id":"0000.0000.0008","router_id":"192.168.8.8","asn":5070,"mt_id":"0,2","isis_area_id":"49.0901","protocol":"IS-IS Level 2","nexthop":"192.168.8.8","local_pref":100,"name":"xrv9k-r1","ls_sr_capabilities":"80 64000:[1 134 160] ","sr_algorithm":[0,1],"sr_local_block":"00 1000:[0 58 152] ","srv6_capabilities_tlv":"0000","node_msd":"1:10"}`),
-					[]byte(`{"action":"del","router_hash":"5efeb5cc6a67ccead6660f7c43e7a8c3","router_ip":"192.168.9.9","base_attr_hash":"4933686d17a7b5b935252efb2cd41f16","peer_hash":"222065841d3956cc669453a009daf611","peer_ip":"192.168.8.8","peer_asn":5070,"timestamp":"Apr  4 13:55:34.000485","igp_router_id":"0000.0000.0008","router_id":"192.168.8.8","asn":5070,"mt_id":"0,2","isis_area_id":"49.0901","protocol":"IS-IS Level 2","nexthop":"192.168.8.8","local_pref":100,"name":"xrv9k-r1","ls_sr_capabilities":"80 64000:[1 134 160] ","sr_algorithm":[0,1],"sr_local_block":"00 1000:[0 58 152] ","srv6_capabilities_tlv":"0000","node_msd":"1:10"}`),
-				},
-			},
-			bmp.LSLinkMsg: {
-				added: false,
-				msgs: [2][]byte{
-					[]byte(`{"action":"add","router_hash":"5efeb5cc6a67ccead6660f7c43e7a8c3","router_ip":"192.168.9.9","base_attr_hash":"4933686d17a7b5b935252efb2cd41f16","peer_hash":"222065841d3956cc669453a009daf611","peer_ip":"192.168.8.8","peer_asn":5070,"timestamp":"Apr  4 13:55:34.000485","igp_router_id":"0000.0000.0009","router_id":"192.168.9.9","protocol":"IS-IS Level 2","local_pref":100,"nexthop":"192.168.8.8","igp_metric":1,"remote_node_hash":"b0ca71813b4508962008be1bb3b73d8d","local_node_hash":"ae68e174edda04ddf80610d2bec9c522","remote_igp_router_id":"0000.0000.0008","remote_router_id":"192.168.8.8","local_node_asn":5070,"remote_node_asn":5070}`),
-					[]byte(`{"action":"del","router_hash":"5efeb5cc6a67ccead6660f7c43e7a8c3","router_ip":"192.168.9.9","base_attr_hash":"4933686d17a7b5b935252efb2cd41f16","peer_hash":"222065841d3956cc669453a009daf611","peer_ip":"192.168.8.8","peer_asn":5070,"timestamp":"Apr  4 13:55:34.000485","igp_router_id":"0000.0000.0009","router_id":"192.168.9.9","protocol":"IS-IS Level 2","local_pref":100,"nexthop":"192.168.8.8","igp_metric":1,"remote_node_hash":"b0ca71813b4508962008be1bb3b73d8d","local_node_hash":"ae68e174edda04ddf80610d2bec9c522","remote_igp_router_id":"0000.0000.0008","remote_router_id":"192.168.8.8","local_node_asn":5070,"remote_node_asn":5070}`),
-				},
-			},
-		},
+		config:   config,
+		fixtures: fixtures,
+		db:       db,
+		stop:     make(chan struct{}),
 	}, nil
 }
 
+// loadFixtures returns fixture files of the directory in the order they should be played
+func loadFixtures(dir string) ([]*fixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	order := make(map[dbclient.CollectionType]int, len(topicOrder))
+	for i, t := range topicOrder {
+		order[t] = i
+	}
+	fixtures := make([]*fixture, 0)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		topic := e.Name()
+		for _, ext := range fixtureExtensions {
+			topic = strings.TrimSuffix(topic, ext)
+		}
+		t, ok := dbclient.TopicType(topic)
+		if !ok {
+			glog.Warningf("skipping file %s, its name does not match any topic", e.Name())
+			continue
+		}
+		fixtures = append(fixtures, &fixture{fn: filepath.Join(dir, e.Name()), msgType: t})
+	}
+	if len(fixtures) == 0 {
+		return nil, fmt.Errorf("no fixture files found in %s", dir)
+	}
+	sort.SliceStable(fixtures, func(i, j int) bool {
+		return order[fixtures[i].msgType] < order[fixtures[j].msgType]
+	})
+
+	return fixtures, nil
+}
+
 func (m *mockMessenger) Start() error {
-	glog.Infof("Starting mock messenger server")
+	glog.Infof("Starting mock messenger with fixtures from %s", m.config.Dir)
 	go m.messenger()
 
 	return nil
@@ -92,58 +157,57 @@ func (m *mockMessenger) Stop() error {
 }
 
 func (m *mockMessenger) messenger() {
-	peer := time.NewTicker(time.Second * 5)
-	lsNode := time.NewTicker(time.Second * 2)
-	lsLink := time.NewTicker(time.Second * 1)
-	// unicastPrefix := time.NewTicker(time.Second * 30)
-	defer func() {
-		peer.Stop()
-		lsNode.Stop()
-		lsLink.Stop()
-		// unicastPrefix.Stop()
-	}()
-	for {
-		select {
-		case <-peer.C:
-			if m.store[bmp.PeerStateChangeMsg].added {
-				glog.V(5).Infof("peer delete")
-				m.db.StoreMessage(bmp.PeerStateChangeMsg, m.store[bmp.PeerStateChangeMsg].msgs[1])
-				m.store[bmp.PeerStateChangeMsg].added = false
-			} else {
-				glog.V(5).Infof("peer add")
-				m.db.StoreMessage(bmp.PeerStateChangeMsg, m.store[bmp.PeerStateChangeMsg].msgs[0])
-				m.store[bmp.PeerStateChangeMsg].added = true
-			}
-		case <-lsNode.C:
-			if m.store[bmp.LSNodeMsg].added {
-				glog.V(5).Infof("ls node delete")
-				m.db.StoreMessage(bmp.LSNodeMsg, m.store[bmp.LSNodeMsg].msgs[1])
-				m.store[bmp.LSNodeMsg].added = false
-			} else {
-				glog.V(5).Infof("ls node add")
-				m.db.StoreMessage(bmp.LSNodeMsg, m.store[bmp.LSNodeMsg].msgs[0])
-				m.store[bmp.LSNodeMsg].added = true
+	for pass := 1; ; pass++ {
+		total := 0
+		for _, f := range m.fixtures {
+			n, err := m.play(f)
+			total += n
+			if err != nil {
+				glog.Errorf("failed to play fixture %s with error: %+v", f.fn, err)
 			}
-		case <-lsLink.C:
-			if m.store[bmp.LSLinkMsg].added {
-				glog.V(5).Infof("ls link delete")
-				m.db.StoreMessage(bmp.LSLinkMsg, m.store[bmp.LSLinkMsg].msgs[1])
-				m.store[bmp.LSLinkMsg].added = false
-			} else {
-				glog.V(5).Infof("ls link add")
-				m.db.StoreMessage(bmp.LSLinkMsg, m.store[bmp.LSLinkMsg].msgs[0])
-				m.store[bmp.LSLinkMsg].added = true
+			select {
+			case <-m.stop:
+				return
+			default:
 			}
-		// case <-unicastPrefix.C:
-		// 	if m.store[bmp.UnicastPrefixMsg].added {
-		// 		m.db.StoreMessage(bmp.UnicastPrefixMsg, m.store[bmp.UnicastPrefixMsg].msgs[1])
-		// 		m.store[bmp.UnicastPrefixMsg].added = false
-		// 	} else {
-		// 		m.db.StoreMessage(bmp.UnicastPrefixMsg, m.store[bmp.UnicastPrefixMsg].msgs[0])
-		// 		m.store[bmp.UnicastPrefixMsg].added = true
-		// 	}
-		case <-m.stop:
+		}
+		glog.Infof("mock messenger pass %d played %d messages", pass, total)
+		if !m.config.Loop {
 			return
 		}
 	}
 }
+
+// play sends every message of the fixture file to the database client and returns the number of messages sent
+func (m *mockMessenger) play(f *fixture) (int, error) {
+	file, err := os.Open(f.fn)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	s.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	n := 0
+	for s.Scan() {
+		b := s.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		// Scanner reuses its buffer, the database client gets its own copy of the message
+		msg := make([]byte, len(b))
+		copy(msg, b)
+		if err := m.db.StoreMessage(f.msgType, msg); err != nil {
+			glog.Errorf("failed to store message of type %d with error: %+v", f.msgType, err)
+		}
+		n++
+		if m.config.Interval > 0 {
+			select {
+			case <-time.After(m.config.Interval):
+			case <-m.stop:
+				return n, nil
+			}
+		}
+	}
+
+	return n, s.Err()
+}
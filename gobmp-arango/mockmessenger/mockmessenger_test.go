@@ -0,0 +1,56 @@
+package mockmessenger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/sbezverk/gobmp/pkg/bmp"
+)
+
+type recordDB struct {
+	types chan dbclient.CollectionType
+}
+
+func (r *recordDB) StoreMessage(msgType dbclient.CollectionType, msg []byte) error {
+	r.types <- msgType
+	return nil
+}
+
+func TestMockMessenger(t *testing.T) {
+	db := &recordDB{types: make(chan dbclient.CollectionType, 100)}
+	m, err := NewMockMessenger(Config{Dir: "testdata"}, db)
+	if err != nil {
+		t.Fatalf("failed to create mock messenger with error: %+v", err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatalf("failed to start mock messenger with error: %+v", err)
+	}
+	defer m.Stop()
+	expect := []dbclient.CollectionType{
+		bmp.PeerStateChangeMsg, bmp.PeerStateChangeMsg,
+		bmp.LSNodeMsg, bmp.LSNodeMsg,
+		bmp.LSLinkMsg, bmp.LSLinkMsg,
+	}
+	for i, e := range expect {
+		select {
+		case mt := <-db.types:
+			if mt != e {
+				t.Fatalf("message %d: expected type %d, actual %d", i, e, mt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+	select {
+	case mt := <-db.types:
+		t.Fatalf("unexpected message of type %d after the end of fixtures", mt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNoFixtures(t *testing.T) {
+	if _, err := NewMockMessenger(Config{Dir: t.TempDir()}, &recordDB{}); err == nil {
+		t.Fatalf("expected to fail on a directory without fixtures")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package stats
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	namespace = "gobmp_arango"
+)
+
+var (
+	// MessagesReceived counts messages received for each collection
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Number of messages received for the collection.",
+	}, []string{"database", "collection"})
+	// MessagesPersisted counts records successfully written to each collection
+	MessagesPersisted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_persisted_total",
+		Help:      "Number of records written to the collection.",
+	}, []string{"database", "collection"})
+	// MessagesFailed counts messages which failed to parse and failed write attempts
+	MessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Number of failures by reason: parse, write or dead_letter.",
+	}, []string{"database", "collection", "reason"})
+	// QueueDepth reports records accepted by the collection handler and not yet persisted
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of records accepted by the collection and not yet persisted.",
+	}, []string{"database", "collection"})
+	// BacklogDepth reports records waiting for the previous record of the same key
+	BacklogDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backlog_depth",
+		Help:      "Number of records waiting for the previous record with the same key to be persisted.",
+	}, []string{"database", "collection"})
+	// WorkersInUse reports the number of workers writing to each collection
+	WorkersInUse = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_in_use",
+		Help:      "Number of workers currently writing to the collection.",
+	}, []string{"database", "collection"})
+	// WorkersLimit reports the maximum number of concurrent workers per collection
+	WorkersLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_limit",
+		Help:      "Maximum number of concurrent workers per collection.",
+	})
+	// OperationDuration tracks the latency of database operations
+	OperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_operation_duration_seconds",
+		Help:      "Latency of ArangoDB operations by collection and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"database", "collection", "operation"})
+	// Notifications counts topology change notifications by result
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Number of change notifications sent, by result: success or failure.",
+	}, []string{"database", "collection", "result"})
+	// TenantMessages counts messages routed to each tenant database, messages matching no tenant are counted
+	// with an empty tenant.
+	TenantMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_messages_total",
+		Help:      "Number of messages routed to the tenant, messages matching no tenant have an empty tenant.",
+	}, []string{"tenant"})
+	// ConsumerLag reports the number of messages not yet consumed from each topic partition
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Number of messages behind the high watermark, sum by topic for the topic lag.",
+	}, []string{"topic", "partition"})
+)
+
+// ObserveOperation records the latency of the database operation started at start
+func ObserveOperation(database, collection, operation string, start time.Time) {
+	OperationDuration.WithLabelValues(database, collection, operation).Observe(time.Since(start).Seconds())
+}
+
+// SetConsumerLag records the lag of the topic partition
+func SetConsumerLag(topic string, partition int32, lag int64) {
+	if lag < 0 {
+		lag = 0
+	}
+	ConsumerLag.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
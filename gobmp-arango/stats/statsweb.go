@@ -22,23 +22,54 @@
 
 package stats
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Srv defines methods of the server exposing gobmp-arango metrics
 type Srv interface {
 	Start()
 	Stop()
 }
 
 type statsSrv struct {
+	srv *http.Server
 }
 
+// Start starts serving metrics in Prometheus format on /metrics
 func (s *statsSrv) Start() {
-
+	glog.Infof("Starting metrics server on %s", s.srv.Addr)
+	go func() {
+		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("metrics server failed with error: %+v", err)
+		}
+	}()
 }
 
+// Stop stops the metrics server
 func (s *statsSrv) Stop() {
-
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		glog.Errorf("failed to stop metrics server with error: %+v", err)
+	}
 }
 
-func NewStatsWebSrv() Srv {
+// NewStatsWebSrv returns a server exposing gobmp-arango metrics on the port
+func NewStatsWebSrv(port int) Srv {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 
-	return &statsSrv{}
+	return &statsSrv{
+		srv: &http.Server{
+			Addr:    fmt.Sprintf(":%d", port),
+			Handler: mux,
+		},
+	}
 }
@@ -0,0 +1,190 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/deadletter"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	metrics "github.com/cisco-open/jalapeno/gobmp-arango/stats"
+	"github.com/golang/glog"
+)
+
+// dbSrv is a database server storing messages into the database server of the message tenant
+type dbSrv struct {
+	router     *Router
+	srvs       map[string]dbclient.Srv
+	deadLetter deadletter.Publisher
+}
+
+// NewDBSrv returns a database server routing messages to the database servers of the tenants,
+// srvs maps tenant names to their database servers. Messages which cannot be parsed are passed to
+// the default tenant, without a default tenant they are published to the dead letter publisher,
+// which may be nil.
+func NewDBSrv(router *Router, srvs map[string]dbclient.Srv, dlq deadletter.Publisher) dbclient.Srv {
+	return &dbSrv{
+		router:     router,
+		srvs:       srvs,
+		deadLetter: dlq,
+	}
+}
+
+func (d *dbSrv) Start() error {
+	for t, s := range d.srvs {
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("failed to start database client of tenant %s with error: %w", t, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *dbSrv) Stop() error {
+	for t, s := range d.srvs {
+		if err := s.Stop(); err != nil {
+			glog.Errorf("failed to stop database client of tenant %s with error: %+v", t, err)
+		}
+	}
+
+	return nil
+}
+
+// Check reports whether database servers of all tenants are ready, it implements health.Checker
+func (d *dbSrv) Check(ctx context.Context) error {
+	names := make([]string, 0, len(d.srvs))
+	for t := range d.srvs {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	for _, t := range names {
+		c, ok := d.srvs[t].(health.Checker)
+		if !ok {
+			continue
+		}
+		if err := c.Check(ctx); err != nil {
+			return fmt.Errorf("tenant %s: %w", t, err)
+		}
+	}
+
+	return nil
+}
+
+// Live reports whether database servers of all tenants are processing messages, it implements health.Liveness
+func (d *dbSrv) Live(ctx context.Context) error {
+	names := make([]string, 0, len(d.srvs))
+	for t := range d.srvs {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	for _, t := range names {
+		l, ok := d.srvs[t].(health.Liveness)
+		if !ok {
+			continue
+		}
+		if err := l.Live(ctx); err != nil {
+			return fmt.Errorf("tenant %s: %w", t, err)
+		}
+	}
+
+	return nil
+}
+
+// Drain waits until database servers of all tenants processed queued messages
+func (d *dbSrv) Drain(ctx context.Context) error {
+	for t, s := range d.srvs {
+		drainer, ok := s.GetInterface().(dbclient.Drainer)
+		if !ok {
+			continue
+		}
+		if err := drainer.Drain(ctx); err != nil {
+			return fmt.Errorf("failed to drain database client of tenant %s with error: %w", t, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *dbSrv) GetInterface() dbclient.DB {
+	return d
+}
+
+func (d *dbSrv) StoreMessage(msgType dbclient.CollectionType, msg []byte) error {
+	return d.StoreMessageWithAck(msgType, msg, nil)
+}
+
+// StoreMessageWithAck passes the message to the database client of the message tenant, messages
+// which match no tenant are dropped and acknowledged. A message which cannot be parsed is passed to
+// the default tenant whose client dead-letters it, without a default tenant it is dead-lettered and
+// acknowledged once the dead letter record is published.
+func (d *dbSrv) StoreMessageWithAck(msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	return d.StoreMessageWithContext(context.Background(), msgType, msg, ack)
+}
+
+// StoreMessageWithContext passes the message with its trace context to the database client of the
+// message tenant, ack is handled as by StoreMessageWithAck.
+func (d *dbSrv) StoreMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	t, err := d.router.Route(msg)
+	if err != nil && t == "" {
+		return d.discard(msgType, msg, err, ack)
+	}
+	if err != nil {
+		glog.Errorf("failed to route message of type %d with error: %+v, passing it to default tenant %s", msgType, err, t)
+	}
+	metrics.TenantMessages.WithLabelValues(t).Inc()
+	s, ok := d.srvs[t]
+	if !ok {
+		glog.V(5).Infof("message of type %d matches no tenant, dropping it", msgType)
+		if ack != nil {
+			ack()
+		}
+		return nil
+	}
+
+	return dbclient.Store(ctx, s.GetInterface(), msgType, msg, ack)
+}
+
+// discard dead-letters a message which cannot be routed and acknowledges it, the message is dropped
+// when no dead letter publisher is configured.
+func (d *dbSrv) discard(msgType dbclient.CollectionType, msg []byte, err error, ack func()) error {
+	if d.deadLetter == nil {
+		glog.Errorf("dropping message of type %d which cannot be routed, error: %+v, payload: %s", msgType, err, string(msg))
+	} else if e := d.deadLetter.Publish(&deadletter.Record{
+		Topic:          dbclient.TopicName(msgType),
+		CollectionType: msgType,
+		Error:          err.Error(),
+		Timestamp:      time.Now(),
+		Payload:        msg,
+	}); e != nil {
+		return fmt.Errorf("failed to publish dead letter record of message which cannot be routed with error: %w", e)
+	}
+	if ack != nil {
+		ack()
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config defines tenants and rules mapping BMP messages to the tenant databases. A config file is YAML
+// or JSON, for example:
+//
+//	default: shared
+//	tenants:
+//	  - name: acme
+//	    database: acme
+//	    rules:
+//	      - router_ips: [10.1.0.0/16, 192.168.1.1]
+//	      - peer_asns: [65001]
+//	        rds: ["65001:100"]
+//	  - name: shared
+//	    database: jalapeno
+//
+// Tenants and their rules are evaluated in order, the first matching rule selects the tenant. Messages
+// matching no rule are stored in the default tenant database, or dropped when no default is set.
+type Config struct {
+	Default string   `yaml:"default" json:"default"`
+	Tenants []Tenant `yaml:"tenants" json:"tenants"`
+}
+
+// Tenant defines the database of a tenant and rules selecting its messages
+type Tenant struct {
+	Name     string `yaml:"name" json:"name"`
+	Database string `yaml:"database" json:"database"`
+	Rules    []Rule `yaml:"rules" json:"rules"`
+}
+
+// Rule matches a message when all of its non empty conditions match, a condition matches when
+// the message matches any of its values.
+type Rule struct {
+	// RouterIPs lists addresses or prefixes of BMP routers
+	RouterIPs []string `yaml:"router_ips" json:"router_ips"`
+	// PeerASNs lists ASNs of BGP peers
+	PeerASNs []uint32 `yaml:"peer_asns" json:"peer_asns"`
+	// RDs lists route distinguishers of VPN routes or of peers
+	RDs []string `yaml:"rds" json:"rds"`
+	// CollectorIDs lists identifiers of collectors, stored in collector_id attribute of messages
+	CollectorIDs []string `yaml:"collector_ids" json:"collector_ids"`
+}
+
+// Load reads and validates a YAML or JSON tenant config file
+func Load(fn string) (*Config, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	// YAML is a superset of JSON, the same decoder handles both formats
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse tenant config file %s with error: %w", fn, err)
+	}
+	if _, err := NewRouter(c); err != nil {
+		return nil, fmt.Errorf("invalid tenant config file %s: %w", fn, err)
+	}
+
+	return c, nil
+}
+
+type rule struct {
+	tenant     string
+	routers    []netip.Prefix
+	asns       map[uint32]bool
+	rds        map[string]bool
+	collectors map[string]bool
+}
+
+// Router selects the tenant of a message
+type Router struct {
+	rules []*rule
+	def   string
+}
+
+// NewRouter validates the config and returns the router of messages to tenants
+func NewRouter(c *Config) (*Router, error) {
+	r := &Router{def: c.Default}
+	names := make(map[string]bool)
+	databases := make(map[string]string)
+	for _, t := range c.Tenants {
+		if t.Name == "" {
+			return nil, fmt.Errorf("tenant name cannot be empty")
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("tenant %s is defined more than once", t.Name)
+		}
+		names[t.Name] = true
+		if t.Database == "" {
+			return nil, fmt.Errorf("tenant %s has no database", t.Name)
+		}
+		if n, ok := databases[t.Database]; ok {
+			return nil, fmt.Errorf("tenants %s and %s share database %s", n, t.Name, t.Database)
+		}
+		databases[t.Database] = t.Name
+		for i, tr := range t.Rules {
+			ru, err := newRule(t.Name, &tr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d of tenant %s: %w", i, t.Name, err)
+			}
+			r.rules = append(r.rules, ru)
+		}
+	}
+	if r.def != "" && !names[r.def] {
+		return nil, fmt.Errorf("default tenant %s is not defined", r.def)
+	}
+
+	return r, nil
+}
+
+func newRule(tenant string, tr *Rule) (*rule, error) {
+	ru := &rule{
+		tenant:     tenant,
+		asns:       make(map[uint32]bool),
+		rds:        make(map[string]bool),
+		collectors: make(map[string]bool),
+	}
+	for _, s := range tr.RouterIPs {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		ru.routers = append(ru.routers, p)
+	}
+	for _, asn := range tr.PeerASNs {
+		ru.asns[asn] = true
+	}
+	for _, rd := range tr.RDs {
+		ru.rds[rd] = true
+	}
+	for _, id := range tr.CollectorIDs {
+		ru.collectors[id] = true
+	}
+	if len(ru.routers) == 0 && len(ru.asns) == 0 && len(ru.rds) == 0 && len(ru.collectors) == 0 {
+		return nil, fmt.Errorf("rule has no conditions")
+	}
+
+	return ru, nil
+}
+
+// parsePrefix parses an address or a prefix, an address is a prefix of the address length
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// attributes are message attributes rules match on, peer messages carry the peer ASN as remote_asn
+type attributes struct {
+	RouterIP    string `json:"router_ip"`
+	PeerASN     uint32 `json:"peer_asn"`
+	RemoteASN   uint32 `json:"remote_asn"`
+	VPNRD       string `json:"vpn_rd"`
+	PeerRD      string `json:"peer_rd"`
+	CollectorID string `json:"collector_id"`
+}
+
+func (ru *rule) matches(a *attributes) bool {
+	if len(ru.routers) != 0 {
+		addr, err := netip.ParseAddr(a.RouterIP)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		found := false
+		for _, p := range ru.routers {
+			if p.Contains(addr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(ru.asns) != 0 {
+		asn := a.PeerASN
+		if asn == 0 {
+			asn = a.RemoteASN
+		}
+		if !ru.asns[asn] {
+			return false
+		}
+	}
+	if len(ru.rds) != 0 && !ru.rds[a.VPNRD] && !ru.rds[a.PeerRD] {
+		return false
+	}
+	if len(ru.collectors) != 0 && !ru.collectors[a.CollectorID] {
+		return false
+	}
+
+	return true
+}
+
+// Route returns the tenant of the message, an empty tenant when the message matches no rule
+// and no default tenant is configured. A message which cannot be parsed is routed to the default
+// tenant along with the error.
+func (r *Router) Route(msg []byte) (string, error) {
+	a := &attributes{}
+	if err := json.Unmarshal(msg, a); err != nil {
+		return r.def, err
+	}
+	for _, ru := range r.rules {
+		if ru.matches(a) {
+			return ru.tenant, nil
+		}
+	}
+
+	return r.def, nil
+}
@@ -0,0 +1,171 @@
+package tenant
+
+import (
+	"testing"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/deadletter"
+)
+
+func TestRoute(t *testing.T) {
+	r, err := NewRouter(&Config{
+		Default: "shared",
+		Tenants: []Tenant{
+			{
+				Name:     "acme",
+				Database: "acme",
+				Rules: []Rule{
+					{RouterIPs: []string{"10.1.0.0/16", "2001:db8::1"}},
+					{PeerASNs: []uint32{65001}, RDs: []string{"65001:100"}},
+				},
+			},
+			{
+				Name:     "globex",
+				Database: "globex",
+				Rules: []Rule{
+					{CollectorIDs: []string{"collector-2"}},
+				},
+			},
+			{Name: "shared", Database: "jalapeno"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	tests := []struct {
+		name   string
+		msg    string
+		expect string
+		fail   bool
+	}{
+		{
+			name:   "router prefix",
+			msg:    `{"router_ip":"10.1.2.3","peer_asn":65000}`,
+			expect: "acme",
+		},
+		{
+			name:   "router address",
+			msg:    `{"router_ip":"2001:db8::1"}`,
+			expect: "acme",
+		},
+		{
+			name:   "peer asn and vpn rd",
+			msg:    `{"router_ip":"10.2.0.1","peer_asn":65001,"vpn_rd":"65001:100"}`,
+			expect: "acme",
+		},
+		{
+			name:   "peer remote asn and peer rd",
+			msg:    `{"router_ip":"10.2.0.1","remote_asn":65001,"peer_rd":"65001:100"}`,
+			expect: "acme",
+		},
+		{
+			name:   "peer asn without rd",
+			msg:    `{"router_ip":"10.2.0.1","peer_asn":65001}`,
+			expect: "shared",
+		},
+		{
+			name:   "collector",
+			msg:    `{"router_ip":"10.2.0.1","collector_id":"collector-2"}`,
+			expect: "globex",
+		},
+		{
+			name:   "unparseable message",
+			msg:    `{"router_ip":`,
+			expect: "shared",
+			fail:   true,
+		},
+	}
+	for _, tt := range tests {
+		tenant, err := r.Route([]byte(tt.msg))
+		if (err != nil) != tt.fail {
+			t.Fatalf("%s: expected failure %t, actual error: %+v", tt.name, tt.fail, err)
+		}
+		if tenant != tt.expect {
+			t.Fatalf("%s: expected tenant %s, actual %s", tt.name, tt.expect, tenant)
+		}
+	}
+}
+
+func TestNewRouterInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+	}{
+		{
+			name:   "missing database",
+			config: &Config{Tenants: []Tenant{{Name: "acme"}}},
+		},
+		{
+			name:   "shared database",
+			config: &Config{Tenants: []Tenant{{Name: "acme", Database: "bmp"}, {Name: "globex", Database: "bmp"}}},
+		},
+		{
+			name:   "rule without conditions",
+			config: &Config{Tenants: []Tenant{{Name: "acme", Database: "acme", Rules: []Rule{{}}}}},
+		},
+		{
+			name:   "invalid router prefix",
+			config: &Config{Tenants: []Tenant{{Name: "acme", Database: "acme", Rules: []Rule{{RouterIPs: []string{"10.1.0.0/33"}}}}}},
+		},
+		{
+			name:   "unknown default",
+			config: &Config{Default: "shared", Tenants: []Tenant{{Name: "acme", Database: "acme"}}},
+		},
+	}
+	for _, tt := range tests {
+		if _, err := NewRouter(tt.config); err == nil {
+			t.Fatalf("%s: expected to fail but succeeded", tt.name)
+		}
+	}
+}
+
+type testDB struct {
+	msgs int
+}
+
+func (db *testDB) Start() error              { return nil }
+func (db *testDB) Stop() error               { return nil }
+func (db *testDB) GetInterface() dbclient.DB { return db }
+func (db *testDB) StoreMessage(dbclient.CollectionType, []byte) error {
+	db.msgs++
+	return nil
+}
+
+type testPublisher struct {
+	records []*deadletter.Record
+}
+
+func (p *testPublisher) Publish(r *deadletter.Record) error {
+	p.records = append(p.records, r)
+	return nil
+}
+
+func TestDBSrv(t *testing.T) {
+	r, err := NewRouter(&Config{
+		Tenants: []Tenant{
+			{Name: "acme", Database: "acme", Rules: []Rule{{PeerASNs: []uint32{65001}}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	acme := &testDB{}
+	dlq := &testPublisher{}
+	srv := NewDBSrv(r, map[string]dbclient.Srv{"acme": acme}, dlq)
+	acks := 0
+	db := srv.GetInterface().(dbclient.AckDB)
+	for _, msg := range []string{`{"peer_asn":65001}`, `{"peer_asn":65002}`, `{"peer_asn":`} {
+		if err := db.StoreMessageWithAck(dbclient.UnicastPrefixV4, []byte(msg), func() { acks++ }); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+	if acme.msgs != 1 {
+		t.Fatalf("expected 1 message stored for the tenant, actual %d", acme.msgs)
+	}
+	if len(dlq.records) != 1 || string(dlq.records[0].Payload) != `{"peer_asn":` {
+		t.Fatalf("expected the unparseable message to be dead-lettered, actual %+v", dlq.records)
+	}
+	if acks != 3 {
+		t.Fatalf("expected 3 acknowledged messages, actual %d", acks)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2022 Cisco Systems, Inc. and its affiliates
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     * Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// The contents of this file are licensed under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with the
+// License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package tracing records OpenTelemetry spans of the processing of BMP messages. Trace context travels
+// with every message, in Kafka record headers between processes and in the queues between goroutines
+// of a process, so a single BMP update can be followed from the gobmp.parsed.* topic through events
+// topics to the graph collections built by downstream processors.
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables the export of spans, trace context is still propagated
+	ExporterNone = "none"
+	// ExporterOTLP exports spans to an OpenTelemetry collector over OTLP/HTTP
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to the standard output, it is meant for local runs
+	ExporterStdout = "stdout"
+	// instrumentationName names the tracer of all processors
+	instrumentationName = "github.com/cisco-open/jalapeno"
+	// shutdownTimeout defines how long Stop waits for pending spans to be exported
+	shutdownTimeout = 5 * time.Second
+)
+
+// Config defines where spans are exported and which share of traces is recorded
+type Config struct {
+	// Exporter is one of none, otlp or stdout
+	Exporter string
+	// Endpoint is the host:port or the URL of the OTLP/HTTP collector, when not set the exporter uses
+	// OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318.
+	Endpoint string
+	// Insecure sends spans to the collector over plain HTTP
+	Insecure bool
+	// SampleRatio is the share of traces started by the processor which are recorded, traces started
+	// upstream are recorded when the upstream processor recorded them.
+	SampleRatio float64
+}
+
+// RegisterFlags registers command line flags of the configuration, every binary uses the same flags
+func RegisterFlags(c *Config) {
+	flag.StringVar(&c.Exporter, "tracing-exporter", ExporterNone, "exporter of OpenTelemetry spans: none, otlp or stdout, trace context is propagated in Kafka headers even when spans are not exported")
+	flag.StringVar(&c.Endpoint, "tracing-endpoint", "", "host:port or URL of the OTLP/HTTP collector, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318 is used when not set")
+	flag.BoolVar(&c.Insecure, "tracing-insecure", false, "when true, spans are sent to the OTLP collector over plain HTTP")
+	flag.Float64Var(&c.SampleRatio, "tracing-sample-ratio", 1, "share of traces started by the processor which are recorded, between 0 and 1")
+}
+
+// Init installs the W3C trace context propagator and, unless the exporter is none, a tracer provider
+// exporting spans of the service. The returned function flushes pending spans and stops the exporter.
+func Init(c *Config, service string) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing sample ratio %g is not between 0 and 1", c.SampleRatio)
+	}
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch c.Exporter {
+	case "", ExporterNone:
+		return func() {}, nil
+	case ExporterOTLP:
+		opts := make([]otlptracehttp.Option, 0)
+		if c.Endpoint != "" {
+			if strings.HasPrefix(c.Endpoint, "http://") || strings.HasPrefix(c.Endpoint, "https://") {
+				opts = append(opts, otlptracehttp.WithEndpointURL(c.Endpoint))
+			} else {
+				opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint))
+			}
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, supported exporters are %s, %s and %s", c.Exporter, ExporterNone, ExporterOTLP, ExporterStdout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s span exporter with error: %w", c.Exporter, err)
+	}
+	host, _ := os.Hostname()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler(c.SampleRatio)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(service),
+			semconv.ServiceInstanceID(host),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	glog.Infof("Exporting spans of %s with %s exporter, sample ratio: %g", service, c.Exporter, c.SampleRatio)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			glog.Errorf("failed to flush spans with error: %+v", err)
+		}
+	}, nil
+}
+
+// sampler samples the ratio of traces, traces derived from records without trace context have a parent
+// which is not sampled, the ratio of the trace ID samples them in the same way in every processor
+func sampler(ratio float64) sdktrace.Sampler {
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio),
+		sdktrace.WithRemoteParentNotSampled(sdktrace.TraceIDRatioBased(ratio)))
+}
+
+// Start starts a span, child of the span carried by the context, a nil context starts a new trace
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartDB starts a span of a database operation on the collection
+func StartDB(ctx context.Context, operation, collection string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attrs = append(attrs,
+		semconv.DBSystemKey.String("arangodb"),
+		semconv.DBOperationName(operation),
+		semconv.DBCollectionName(collection),
+	)
+
+	return otel.Tracer(instrumentationName).Start(ctx, operation+" "+collection, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, as the status of the span and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Consume starts the span processing a consumed Kafka record, the span is a child of the span which
+// produced the record when the record carries trace context in its headers. Records published by gobmp
+// carry none, their trace is derived from the record's position so that every processor consuming
+// the same record joins the same trace.
+func Consume(msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), consumerHeaders(msg.Headers))
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, recordSpanContext(msg))
+	}
+
+	return otel.Tracer(instrumentationName).Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationName("process"),
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(int(msg.Partition))),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+		))
+}
+
+// recordSpanContext returns the span context standing for the producer of a record without trace context
+func recordSpanContext(msg *sarama.ConsumerMessage) trace.SpanContext {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)))
+	var tid trace.TraceID
+	var sid trace.SpanID
+	copy(tid[:], sum[:16])
+	copy(sid[:], sum[16:24])
+
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, Remote: true})
+}
+
+// Produce starts the span publishing a record to the topic and injects its context into the record's headers
+func Produce(ctx context.Context, msg *sarama.ProducerMessage) trace.Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, msg.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationName("publish"),
+			semconv.MessagingDestinationName(msg.Topic),
+		))
+	otel.GetTextMapPropagator().Inject(ctx, &producerHeaders{msg: msg})
+
+	return span
+}
+
+// Inject returns the trace context as a map, it carries the context with messages which are not
+// Kafka records, such as events queued for the notifier.
+func Inject(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	c := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, c)
+	if len(c) == 0 {
+		return nil
+	}
+
+	return c
+}
+
+// Extract returns the context carrying the trace context stored in the map by Inject
+func Extract(carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(carrier))
+}
+
+// consumerHeaders reads trace context from headers of a consumed record
+type consumerHeaders []*sarama.RecordHeader
+
+func (h consumerHeaders) Get(key string) string {
+	for _, r := range h {
+		if r != nil && string(r.Key) == key {
+			return string(r.Value)
+		}
+	}
+
+	return ""
+}
+
+// Set is not used, trace context is only read from consumed records
+func (h consumerHeaders) Set(string, string) {}
+
+func (h consumerHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for _, r := range h {
+		if r != nil {
+			keys = append(keys, string(r.Key))
+		}
+	}
+
+	return keys
+}
+
+// producerHeaders writes trace context to headers of a produced record, existing headers of the same
+// key are replaced
+type producerHeaders struct {
+	msg *sarama.ProducerMessage
+}
+
+func (h *producerHeaders) Get(key string) string {
+	for _, r := range h.msg.Headers {
+		if string(r.Key) == key {
+			return string(r.Value)
+		}
+	}
+
+	return ""
+}
+
+func (h *producerHeaders) Set(key, value string) {
+	for i, r := range h.msg.Headers {
+		if string(r.Key) == key {
+			h.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	h.msg.Headers = append(h.msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (h *producerHeaders) Keys() []string {
+	keys := make([]string, 0, len(h.msg.Headers))
+	for _, r := range h.msg.Headers {
+		keys = append(keys, string(r.Key))
+	}
+
+	return keys
+}
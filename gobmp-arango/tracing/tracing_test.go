@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setup(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	if _, err := Init(&Config{Exporter: ExporterNone, SampleRatio: 1}, "test"); err != nil {
+		t.Fatalf("Init() failed with error: %+v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler(1)), sdktrace.WithSpanProcessor(recorder)))
+
+	return recorder
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name:   "none",
+			config: Config{Exporter: ExporterNone, SampleRatio: 1},
+		},
+		{
+			name:   "stdout",
+			config: Config{Exporter: ExporterStdout, SampleRatio: 0.5},
+		},
+		{
+			name:    "unknown exporter",
+			config:  Config{Exporter: "zipkin", SampleRatio: 1},
+			wantErr: true,
+		},
+		{
+			name:    "invalid sample ratio",
+			config:  Config{Exporter: ExporterStdout, SampleRatio: 2},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop, err := Init(&tt.config, "test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Init() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil {
+				stop()
+			}
+		})
+	}
+}
+
+func TestKafkaHeaders(t *testing.T) {
+	recorder := setup(t)
+
+	ctx, parent := Start(context.Background(), "transform")
+	pm := &sarama.ProducerMessage{Topic: "gobmp.parsed.ls_link_events"}
+	Produce(ctx, pm).End()
+	parent.End()
+
+	cm := &sarama.ConsumerMessage{Topic: pm.Topic}
+	for _, h := range pm.Headers {
+		cm.Headers = append(cm.Headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	_, span := Consume(cm)
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	publish, consume := spans[0], spans[2]
+	if publish.SpanKind() != trace.SpanKindProducer || consume.SpanKind() != trace.SpanKindConsumer {
+		t.Errorf("got span kinds %s and %s, want producer and consumer", publish.SpanKind(), consume.SpanKind())
+	}
+	if consume.SpanContext().TraceID() != publish.SpanContext().TraceID() {
+		t.Errorf("consumer span is in trace %s, want %s", consume.SpanContext().TraceID(), publish.SpanContext().TraceID())
+	}
+	if consume.Parent().SpanID() != publish.SpanContext().SpanID() {
+		t.Errorf("consumer span has parent %s, want %s", consume.Parent().SpanID(), publish.SpanContext().SpanID())
+	}
+}
+
+func TestRecordWithoutTraceContext(t *testing.T) {
+	recorder := setup(t)
+
+	for i := 0; i < 2; i++ {
+		_, span := Consume(&sarama.ConsumerMessage{Topic: "gobmp.parsed.ls_link", Partition: 1, Offset: 42})
+		span.End()
+	}
+	_, span := Consume(&sarama.ConsumerMessage{Topic: "gobmp.parsed.ls_link", Partition: 1, Offset: 43})
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	if spans[0].SpanContext().TraceID() != spans[1].SpanContext().TraceID() {
+		t.Errorf("consumers of the same record are in traces %s and %s", spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	}
+	if spans[0].SpanContext().TraceID() == spans[2].SpanContext().TraceID() {
+		t.Errorf("consumers of different records are in the same trace %s", spans[0].SpanContext().TraceID())
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	setup(t)
+
+	if carrier := Inject(context.Background()); carrier != nil {
+		t.Errorf("Inject() of a context without a span = %v, want nil", carrier)
+	}
+	ctx, span := StartDB(context.Background(), "write", "ls_link")
+	defer span.End()
+	got := trace.SpanContextFromContext(Extract(Inject(ctx)))
+	if got.TraceID() != span.SpanContext().TraceID() || got.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("Extract() = %s/%s, want %s/%s", got.TraceID(), got.SpanID(), span.SpanContext().TraceID(), span.SpanContext().SpanID())
+	}
+}
@@ -24,11 +24,10 @@ package arangodb
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 
 	driver "github.com/arangodb/go-driver"
-	"github.com/arangodb/go-driver/http"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/golang/glog"
 )
 
@@ -44,22 +43,13 @@ type ArangoConfig struct {
 	User     string
 	Password string
 	Database string
+	// Connection configures TLS, authentication and timeouts, nil connects over HTTP with basic authentication
+	Connection *arangoclient.Config
 }
 
 // NewArango creates a new ArangoDB connection
 func NewArango(config ArangoConfig) (*ArangoConn, error) {
-	conn, err := http.NewConnection(http.ConnectionConfig{
-		Endpoints: []string{config.URL},
-		TLSConfig: &tls.Config{InsecureSkipVerify: true},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP connection: %w", err)
-	}
-
-	client, err := driver.NewClient(driver.ClientConfig{
-		Connection:     conn,
-		Authentication: driver.BasicAuthentication(config.User, config.Password),
-	})
+	client, err := config.Connection.NewClient(config.URL, config.User, config.Password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create ArangoDB client: %w", err)
 	}
@@ -71,18 +61,9 @@ func NewArango(config ArangoConfig) (*ArangoConn, error) {
 	}
 
 	// Get or create database
-	db, err := client.Database(ctx, config.Database)
+	db, err := arangoclient.Database(ctx, client, config.Database, true)
 	if err != nil {
-		if driver.IsNotFoundGeneral(err) {
-			// Database doesn't exist, create it
-			db, err = client.CreateDatabase(ctx, config.Database, nil)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create database %s: %w", config.Database, err)
-			}
-			glog.Infof("Created database: %s", config.Database)
-		} else {
-			return nil, fmt.Errorf("failed to access database %s: %w", config.Database, err)
-		}
+		return nil, fmt.Errorf("failed to access database %s: %w", config.Database, err)
 	}
 
 	glog.Infof("Connected to ArangoDB: %s, database: %s", config.URL, config.Database)
@@ -109,3 +90,8 @@ func (ac *ArangoConn) Close() error {
 	glog.Info("ArangoDB connection closed")
 	return nil
 }
+
+// Check reports whether the database is reachable, it implements health.Checker
+func (ac *ArangoConn) Check(ctx context.Context) error {
+	return arangoclient.Ping(ctx, ac.db)
+}
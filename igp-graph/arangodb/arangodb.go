@@ -25,13 +25,15 @@ package arangodb
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
 	"github.com/golang/glog"
-	"github.com/sbezverk/gobmp/pkg/tools"
 )
 
 // Config holds the configuration for the IGP Graph processor
@@ -40,6 +42,7 @@ type Config struct {
 	User              string
 	Password          string
 	Database          string
+	Connection        *arangoclient.Config
 	LSPrefix          string
 	LSLink            string
 	LSSRv6SID         string
@@ -79,19 +82,24 @@ type arangoDB struct {
 	// Control
 	stop     chan struct{}
 	notifier kafkanotifier.Event
+	// pending counts messages queued for processing and not yet processed
+	pending atomic.Int64
+	// loading reports progress of the initial load
+	loading *health.State
 }
 
 // NewDBSrvClient creates a new unified IGP Graph database client
 func NewDBSrvClient(config Config) (dbclient.Srv, error) {
-	if err := tools.URLAddrValidation(config.URL); err != nil {
+	if err := config.Connection.Validate(config.URL); err != nil {
 		return nil, err
 	}
 
 	arangoConn, err := NewArango(ArangoConfig{
-		URL:      config.URL,
-		User:     config.User,
-		Password: config.Password,
-		Database: config.Database,
+		URL:        config.URL,
+		User:       config.User,
+		Password:   config.Password,
+		Database:   config.Database,
+		Connection: config.Connection,
 	})
 	if err != nil {
 		return nil, err
@@ -101,6 +109,7 @@ func NewDBSrvClient(config Config) (dbclient.Srv, error) {
 		config:   config,
 		stop:     make(chan struct{}),
 		notifier: config.Notifier,
+		loading:  health.NewState("initial IGP topology data is not loaded"),
 	}
 	arango.DB = arango
 	arango.ArangoConn = arangoConn
@@ -265,6 +274,7 @@ func (a *arangoDB) ensureGraph(graphName, vertexCollection string) (driver.Graph
 
 func (a *arangoDB) Start() error {
 	if err := a.loadInitialData(); err != nil {
+		a.loading.NotReady("initial load failed: %v", err)
 		return fmt.Errorf("failed to load initial data: %w", err)
 	}
 
@@ -280,6 +290,7 @@ func (a *arangoDB) Start() error {
 		return fmt.Errorf("failed to start update coordinator: %w", err)
 	}
 
+	a.loading.Ready()
 	glog.Info("IGP Graph processor started successfully")
 	go a.monitor()
 
@@ -304,6 +315,21 @@ func (a *arangoDB) Stop() error {
 	return nil
 }
 
+// Check reports readiness of the processor, it is ready once the initial load and deduplication
+// completed and the database is reachable. It implements health.Checker.
+func (a *arangoDB) Check(ctx context.Context) error {
+	if err := a.loading.Check(ctx); err != nil {
+		return err
+	}
+
+	return a.ArangoConn.Check(ctx)
+}
+
+// Live reports whether the update processors are processing updates, it implements health.Liveness
+func (a *arangoDB) Live(ctx context.Context) error {
+	return a.updateCoordinator.Live(ctx)
+}
+
 func (a *arangoDB) GetInterface() dbclient.DB {
 	return a.DB
 }
@@ -316,16 +342,49 @@ func (a *arangoDB) StoreMessage(msgType dbclient.CollectionType, msg []byte) err
 	return a.updateCoordinator.ProcessMessage(msgType, msg)
 }
 
+// StoreMessageWithAck queues the message for processing, ack is called once the message has been processed
+func (a *arangoDB) StoreMessageWithAck(msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	return a.StoreMessageWithContext(context.Background(), msgType, msg, ack)
+}
+
+// StoreMessageWithContext queues the message for processing in the trace carried by the context,
+// it implements dbclient.TraceDB
+func (a *arangoDB) StoreMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	a.pending.Add(1)
+	err := a.updateCoordinator.ProcessMessageWithContext(ctx, msgType, msg, func() {
+		a.pending.Add(-1)
+		if ack != nil {
+			ack()
+		}
+	})
+	if err != nil {
+		a.pending.Add(-1)
+	}
+
+	return err
+}
+
+// Drain waits until queued messages are processed, it implements dbclient.Drainer
+func (a *arangoDB) Drain(ctx context.Context) error {
+	if err := dbclient.WaitDrained(ctx, func() bool { return a.pending.Load() == 0 }); err != nil {
+		return fmt.Errorf("%d messages are not processed: %w", a.pending.Load(), err)
+	}
+
+	return nil
+}
+
 func (a *arangoDB) loadInitialData() error {
 	glog.Info("Loading initial IGP topology data...")
 	ctx := context.TODO()
 
 	// Load initial nodes
+	a.loading.NotReady("loading IGP nodes")
 	if err := a.loadInitialNodes(ctx); err != nil {
 		return fmt.Errorf("failed to load initial nodes: %w", err)
 	}
 
 	// Run deduplication BEFORE processing links to avoid orphaned references
+	a.loading.NotReady("deduplicating IGP nodes and prefixes")
 	if err := a.runDeduplication(); err != nil {
 		return fmt.Errorf("failed to deduplicate IGP nodes: %w", err)
 	}
@@ -336,16 +395,19 @@ func (a *arangoDB) loadInitialData() error {
 	}
 
 	// Load initial links (after deduplication)
+	a.loading.NotReady("loading IGP links")
 	if err := a.loadInitialLinks(ctx); err != nil {
 		return fmt.Errorf("failed to load initial links: %w", err)
 	}
 
 	// Load initial SRv6 SIDs
+	a.loading.NotReady("loading SRv6 SIDs")
 	if err := a.loadInitialSRv6SIDs(ctx); err != nil {
 		return fmt.Errorf("failed to load initial SRv6 SIDs: %w", err)
 	}
 
 	// Load initial prefixes
+	a.loading.NotReady("loading IGP prefixes")
 	if err := a.loadInitialPrefixes(ctx); err != nil {
 		return fmt.Errorf("failed to load initial prefixes: %w", err)
 	}
@@ -22,13 +22,18 @@
 
 package arangodb
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+)
 
 // Common errors used throughout the IGP graph processor
 var (
 	ErrProcessorNotStarted = errors.New("processor not started")
-	ErrProcessorStopped    = errors.New("processor stopped")
-	ErrQueueFull           = errors.New("operation queue is full")
+	ErrProcessorStopped    = fmt.Errorf("processor %w", flowcontrol.ErrStopped)
+	ErrQueueFull           = fmt.Errorf("operation %w", flowcontrol.ErrQueueFull)
 	ErrInvalidOperation    = errors.New("invalid operation")
 	ErrNodeNotFound        = errors.New("node not found")
 	ErrLinkNotFound        = errors.New("link not found")
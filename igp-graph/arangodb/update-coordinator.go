@@ -26,13 +26,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// retryBackoff is the delay before the first retry of a failed update, it doubles with every attempt
+	retryBackoff = 500 * time.Millisecond
+	// maxRetryBackoff caps the delay between retries of a failed update
+	maxRetryBackoff = 30 * time.Second
+	// maxRetries limits retries of an update failing with a transient error, it is dropped afterwards
+	maxRetries = 10
 )
 
 // UpdateCoordinator manages incoming topology changes and coordinates updates
@@ -41,10 +57,13 @@ type UpdateCoordinator struct {
 	batchSize int
 
 	// Processing channels
-	nodeUpdates   chan *kafkanotifier.EventMessage
-	linkUpdates   chan *kafkanotifier.EventMessage
-	prefixUpdates chan *kafkanotifier.EventMessage
-	srv6Updates   chan *kafkanotifier.EventMessage
+	nodeUpdates   chan *update
+	linkUpdates   chan *update
+	prefixUpdates chan *update
+	srv6Updates   chan *update
+
+	// heartbeats report that the processors are processing updates
+	heartbeats map[string]*health.Heartbeat
 
 	// Control
 	stop    chan struct{}
@@ -52,19 +71,102 @@ type UpdateCoordinator struct {
 	started bool
 }
 
+// update is a topology change queued for processing
+type update struct {
+	// ctx carries the trace context of the message
+	ctx   context.Context
+	event *kafkanotifier.EventMessage
+	// ack, if not nil, is called once the change has been processed
+	ack func()
+}
+
+// start starts the span of processing the change in the trace of the message
+func (u *update) start(kind string) (context.Context, trace.Span) {
+	return tracing.Start(u.ctx, "process IGP "+kind+" update",
+		attribute.String("jalapeno.key", u.event.Key),
+		attribute.String("jalapeno.action", u.event.Action))
+}
+
+// done acknowledges the processed change
+func (u *update) done() {
+	if u.ack != nil {
+		u.ack()
+	}
+}
+
+// process processes the change and acknowledges it, a change failing with a transient database or network
+// error is retried with backoff up to maxRetries times. A change which fails permanently, or still fails after
+// the last retry, is logged and acknowledged so it does not block the following ones. The processor's
+// heartbeat is not renewed while the change is retried, the processor beats again once the change is handled.
+// When the coordinator stops, a change which has not been processed is not acknowledged and is consumed again
+// after a restart.
+func (uc *UpdateCoordinator) process(u *update, kind string, f func(context.Context, *kafkanotifier.EventMessage) error) {
+	delay := retryBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, span := u.start(kind)
+		err := f(ctx, u.event)
+		tracing.End(span, err)
+		if err == nil {
+			u.done()
+			return
+		}
+		if !arangoclient.Transient(err) {
+			glog.Errorf("Failed to process %s update %s, dropping it: %v", kind, u.event.Key, err)
+			u.done()
+			return
+		}
+		if attempt == maxRetries {
+			glog.Errorf("Failed to process %s update %s after %d retries, dropping it: %v", kind, u.event.Key, maxRetries, err)
+			u.done()
+			return
+		}
+		glog.Errorf("Failed to process %s update %s, retrying in %s: %v", kind, u.event.Key, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-uc.stop:
+			return
+		}
+		if delay *= 2; delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+	}
+}
+
 // NewUpdateCoordinator creates a new update coordinator
 func NewUpdateCoordinator(db *arangoDB, batchSize int) *UpdateCoordinator {
 	return &UpdateCoordinator{
 		db:            db,
 		batchSize:     batchSize,
-		nodeUpdates:   make(chan *kafkanotifier.EventMessage, batchSize*2),
-		linkUpdates:   make(chan *kafkanotifier.EventMessage, batchSize*2),
-		prefixUpdates: make(chan *kafkanotifier.EventMessage, batchSize*2),
-		srv6Updates:   make(chan *kafkanotifier.EventMessage, batchSize*2),
+		nodeUpdates:   make(chan *update, batchSize*2),
+		linkUpdates:   make(chan *update, batchSize*2),
+		prefixUpdates: make(chan *update, batchSize*2),
+		srv6Updates:   make(chan *update, batchSize*2),
 		stop:          make(chan struct{}),
+		heartbeats: map[string]*health.Heartbeat{
+			"node":   health.NewHeartbeat(health.DefaultHeartbeatTimeout),
+			"link":   health.NewHeartbeat(health.DefaultHeartbeatTimeout),
+			"prefix": health.NewHeartbeat(health.DefaultHeartbeatTimeout),
+			"SRv6":   health.NewHeartbeat(health.DefaultHeartbeatTimeout),
+		},
 	}
 }
 
+// Live reports whether the processors are processing updates, it implements health.Liveness
+func (uc *UpdateCoordinator) Live(ctx context.Context) error {
+	names := make([]string, 0, len(uc.heartbeats))
+	for name := range uc.heartbeats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := uc.heartbeats[name].Check(ctx); err != nil {
+			return fmt.Errorf("%s update processor: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
 // Start begins the update coordination
 func (uc *UpdateCoordinator) Start() error {
 	if uc.started {
@@ -100,6 +202,18 @@ func (uc *UpdateCoordinator) Stop() {
 
 // ProcessMessage processes an incoming raw BMP message and routes it to the appropriate handler
 func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg []byte) error {
+	return uc.ProcessMessageWithAck(msgType, msg, nil)
+}
+
+// ProcessMessageWithAck routes the message to the appropriate handler, ack is called once the message
+// has been processed, when an error is returned, the message was not queued and ack is not called.
+func (uc *UpdateCoordinator) ProcessMessageWithAck(msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	return uc.ProcessMessageWithContext(context.Background(), msgType, msg, ack)
+}
+
+// ProcessMessageWithContext routes the message to the appropriate handler, the processing is recorded as
+// a span of the trace carried by the context, ack is handled as by ProcessMessageWithAck.
+func (uc *UpdateCoordinator) ProcessMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
 	if !uc.started {
 		return ErrProcessorNotStarted
 	}
@@ -119,12 +233,13 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 	}
 
 	glog.V(8).Infof("Processing BMP message: type=%d, key=%s, action=%s", msgType, event.Key, event.Action)
+	u := &update{ctx: ctx, event: event, ack: ack}
 
 	// Route message to appropriate channel
 	switch msgType {
 	case bmp.LSNodeMsg:
 		select {
-		case uc.nodeUpdates <- event:
+		case uc.nodeUpdates <- u:
 			return nil
 		case <-uc.stop:
 			return ErrProcessorStopped
@@ -134,7 +249,7 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 
 	case bmp.LSLinkMsg:
 		select {
-		case uc.linkUpdates <- event:
+		case uc.linkUpdates <- u:
 			return nil
 		case <-uc.stop:
 			return ErrProcessorStopped
@@ -144,7 +259,7 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 
 	case bmp.LSPrefixMsg:
 		select {
-		case uc.prefixUpdates <- event:
+		case uc.prefixUpdates <- u:
 			return nil
 		case <-uc.stop:
 			return ErrProcessorStopped
@@ -154,7 +269,7 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 
 	case bmp.LSSRv6SIDMsg:
 		select {
-		case uc.srv6Updates <- event:
+		case uc.srv6Updates <- u:
 			return nil
 		case <-uc.stop:
 			return ErrProcessorStopped
@@ -164,6 +279,7 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 
 	default:
 		glog.V(5).Infof("Unsupported message type: %d", msgType)
+		u.done()
 		return nil
 	}
 }
@@ -171,17 +287,19 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 func (uc *UpdateCoordinator) nodeUpdateProcessor() {
 	defer uc.wg.Done()
 	glog.V(6).Info("Node update processor started")
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
+		uc.heartbeats["node"].Beat()
 		select {
+		case <-ticker.C:
 		case <-uc.stop:
 			glog.V(6).Info("Node update processor stopped")
 			return
 
-		case event := <-uc.nodeUpdates:
-			if err := uc.processNodeUpdate(event); err != nil {
-				glog.Errorf("Failed to process node update %s: %v", event.Key, err)
-			}
+		case u := <-uc.nodeUpdates:
+			uc.process(u, "node", uc.processNodeUpdate)
 		}
 	}
 }
@@ -189,17 +307,19 @@ func (uc *UpdateCoordinator) nodeUpdateProcessor() {
 func (uc *UpdateCoordinator) linkUpdateProcessor() {
 	defer uc.wg.Done()
 	glog.V(6).Info("Link update processor started")
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
+		uc.heartbeats["link"].Beat()
 		select {
+		case <-ticker.C:
 		case <-uc.stop:
 			glog.V(6).Info("Link update processor stopped")
 			return
 
-		case event := <-uc.linkUpdates:
-			if err := uc.processLinkUpdate(event); err != nil {
-				glog.Errorf("Failed to process link update %s: %v", event.Key, err)
-			}
+		case u := <-uc.linkUpdates:
+			uc.process(u, "link", uc.processLinkUpdate)
 		}
 	}
 }
@@ -207,17 +327,19 @@ func (uc *UpdateCoordinator) linkUpdateProcessor() {
 func (uc *UpdateCoordinator) prefixUpdateProcessor() {
 	defer uc.wg.Done()
 	glog.V(6).Info("Prefix update processor started")
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
+		uc.heartbeats["prefix"].Beat()
 		select {
+		case <-ticker.C:
 		case <-uc.stop:
 			glog.V(6).Info("Prefix update processor stopped")
 			return
 
-		case event := <-uc.prefixUpdates:
-			if err := uc.processPrefixUpdate(event); err != nil {
-				glog.Errorf("Failed to process prefix update %s: %v", event.Key, err)
-			}
+		case u := <-uc.prefixUpdates:
+			uc.process(u, "prefix", uc.processPrefixUpdate)
 		}
 	}
 }
@@ -225,23 +347,25 @@ func (uc *UpdateCoordinator) prefixUpdateProcessor() {
 func (uc *UpdateCoordinator) srv6UpdateProcessor() {
 	defer uc.wg.Done()
 	glog.V(6).Info("SRv6 update processor started")
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
+		uc.heartbeats["SRv6"].Beat()
 		select {
+		case <-ticker.C:
 		case <-uc.stop:
 			glog.V(6).Info("SRv6 update processor stopped")
 			return
 
-		case event := <-uc.srv6Updates:
-			if err := uc.processSRv6Update(event); err != nil {
-				glog.Errorf("Failed to process SRv6 update %s: %v", event.Key, err)
-			}
+		case u := <-uc.srv6Updates:
+			uc.process(u, "SRv6", uc.processSRv6Update)
 		}
 	}
 }
 
 // Individual update processors - now with real implementation
-func (uc *UpdateCoordinator) processNodeUpdate(event *kafkanotifier.EventMessage) error {
+func (uc *UpdateCoordinator) processNodeUpdate(ctx context.Context, event *kafkanotifier.EventMessage) error {
 	// Validate event message
 	if event == nil {
 		return fmt.Errorf("event message is nil")
@@ -254,8 +378,6 @@ func (uc *UpdateCoordinator) processNodeUpdate(event *kafkanotifier.EventMessage
 
 	glog.V(7).Infof("Processing node update: %s action: %s ID: %s", event.Key, event.Action, event.ID)
 
-	ctx := context.TODO()
-
 	switch event.Action {
 	case "del":
 		// Handle node deletion
@@ -271,7 +393,7 @@ func (uc *UpdateCoordinator) processNodeUpdate(event *kafkanotifier.EventMessage
 	}
 }
 
-func (uc *UpdateCoordinator) processLinkUpdate(event *kafkanotifier.EventMessage) error {
+func (uc *UpdateCoordinator) processLinkUpdate(ctx context.Context, event *kafkanotifier.EventMessage) error {
 	// Validate event message
 	if event == nil {
 		return fmt.Errorf("event message is nil")
@@ -284,8 +406,6 @@ func (uc *UpdateCoordinator) processLinkUpdate(event *kafkanotifier.EventMessage
 
 	glog.V(7).Infof("Processing link update: %s action: %s ID: %s", event.Key, event.Action, event.ID)
 
-	ctx := context.TODO()
-
 	switch event.Action {
 	case "del":
 		// Handle link deletion
@@ -301,11 +421,9 @@ func (uc *UpdateCoordinator) processLinkUpdate(event *kafkanotifier.EventMessage
 	}
 }
 
-func (uc *UpdateCoordinator) processPrefixUpdate(event *kafkanotifier.EventMessage) error {
+func (uc *UpdateCoordinator) processPrefixUpdate(ctx context.Context, event *kafkanotifier.EventMessage) error {
 	glog.V(7).Infof("Processing prefix update: %s action: %s", event.Key, event.Action)
 
-	ctx := context.TODO()
-
 	switch event.Action {
 	case "del":
 		return uc.processPrefixDeletion(ctx, event.Key)
@@ -446,11 +564,9 @@ func (uc *UpdateCoordinator) cleanupDeletedPrefix(ctx context.Context, key strin
 	return nil
 }
 
-func (uc *UpdateCoordinator) processSRv6Update(event *kafkanotifier.EventMessage) error {
+func (uc *UpdateCoordinator) processSRv6Update(ctx context.Context, event *kafkanotifier.EventMessage) error {
 	glog.V(7).Infof("Processing SRv6 update: %s action: %s", event.Key, event.Action)
 
-	ctx := context.TODO()
-
 	switch event.Action {
 	case "del":
 		// Read SRv6 SID data to get router ID for removal
@@ -551,7 +667,10 @@ func (uc *UpdateCoordinator) processLinkAddUpdate(ctx context.Context, key, acti
 	}
 
 	// Process the link using the same logic as initial loading
-	if err := uc.db.processInitialLink(ctx, linkData); err != nil {
+	wctx, span := tracing.Start(ctx, "write IGP link edges", attribute.String("jalapeno.key", key))
+	err = uc.db.processInitialLink(wctx, linkData)
+	tracing.End(span, err)
+	if err != nil {
 		return fmt.Errorf("failed to process link %s: %w", key, err)
 	}
 
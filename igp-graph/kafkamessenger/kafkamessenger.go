@@ -25,28 +25,39 @@ package kafkamessenger
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 )
 
 // KafkaMessenger handles Kafka message consumption for the IGP graph processor
 type KafkaMessenger struct {
-	consumer sarama.ConsumerGroup
-	dbSrv    dbclient.DB
-	stop     chan struct{}
-	topics   []string
-	brokers  []string
-	groupID  string
+	consumer   sarama.ConsumerGroup
+	dbSrv      dbclient.DB
+	partitions *flowcontrol.Partitions
+	stop       chan struct{}
+	done       chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	topics     []string
+	brokers    []string
+	groupID    string
+	member     *health.State
 }
 
-// NewKafkaMessenger creates a new Kafka messenger for IGP graph processing
-func NewKafkaMessenger(kafkaConn string, dbSrv dbclient.DB) (*KafkaMessenger, error) {
+// NewKafkaMessenger creates a new Kafka messenger for IGP graph processing, security configures
+// TLS and SASL of connections to the brokers, nil connects in plaintext.
+func NewKafkaMessenger(kafkaConn string, dbSrv dbclient.DB, security *kafkaclient.Config) (*KafkaMessenger, error) {
 	if err := validateConnection(kafkaConn); err != nil {
 		return nil, err
 	}
@@ -70,6 +81,9 @@ func NewKafkaMessenger(kafkaConn string, dbSrv dbclient.DB) (*KafkaMessenger, er
 	config.Consumer.MaxProcessingTime = 1 * time.Second
 	config.Consumer.Return.Errors = true
 	config.Consumer.Offsets.Retry.Max = 3
+	if err := security.Apply(config); err != nil {
+		return nil, err
+	}
 
 	groupID := "igp-graph-processor"
 	consumer, err := sarama.NewConsumerGroup(brokers, groupID, config)
@@ -77,13 +91,22 @@ func NewKafkaMessenger(kafkaConn string, dbSrv dbclient.DB) (*KafkaMessenger, er
 		return nil, err
 	}
 
+	// The context ends the consumer group session when the messenger stops, offsets are committed
+	// when the session ends.
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &KafkaMessenger{
-		consumer: consumer,
-		dbSrv:    dbSrv,
-		stop:     make(chan struct{}),
-		topics:   topics,
-		brokers:  brokers,
-		groupID:  groupID,
+		consumer:   consumer,
+		dbSrv:      dbSrv,
+		partitions: flowcontrol.NewPartitions(consumer),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		topics:     topics,
+		brokers:    brokers,
+		groupID:    groupID,
+		member:     health.NewState("consumer group is not joined"),
 	}, nil
 }
 
@@ -93,7 +116,7 @@ func (k *KafkaMessenger) Start() {
 		k.groupID, k.topics)
 
 	go func() {
-		defer k.consumer.Close()
+		defer close(k.done)
 
 		for {
 			select {
@@ -101,10 +124,9 @@ func (k *KafkaMessenger) Start() {
 				glog.Info("Kafka messenger stopping...")
 				return
 			default:
-				ctx := context.Background()
-				handler := &MessageHandler{dbSrv: k.dbSrv}
+				handler := &MessageHandler{dbSrv: k.dbSrv, partitions: k.partitions, member: k.member}
 
-				if err := k.consumer.Consume(ctx, k.topics, handler); err != nil {
+				if err := k.consumer.Consume(k.ctx, k.topics, handler); err != nil {
 					glog.Errorf("Error consuming from Kafka: %v", err)
 					time.Sleep(1 * time.Second)
 				}
@@ -120,32 +142,65 @@ func (k *KafkaMessenger) Start() {
 	}()
 }
 
-// Stop stops the Kafka messenger
+// Stop stops the Kafka messenger, it waits for the consumer group session to end, so offsets
+// of processed messages are committed before the consumer group is closed.
 func (k *KafkaMessenger) Stop() {
 	glog.Info("Stopping Kafka messenger...")
 	close(k.stop)
-	time.Sleep(100 * time.Millisecond) // Give time for graceful shutdown
+	k.cancel()
+	<-k.done
+	if err := k.consumer.Close(); err != nil {
+		glog.Errorf("Error closing Kafka consumer: %v", err)
+	}
+}
+
+// Check reports whether the messenger is a member of the consumer group, it implements health.Checker
+func (k *KafkaMessenger) Check(ctx context.Context) error {
+	return k.member.Check(ctx)
+}
+
+// Pause stops fetching messages of the types, it implements flowcontrol.Pauser
+func (k *KafkaMessenger) Pause(types []dbclient.CollectionType) {
+	k.partitions.Pause(types)
+}
+
+// Resume resumes fetching messages of the types, it implements flowcontrol.Pauser
+func (k *KafkaMessenger) Resume(types []dbclient.CollectionType) {
+	k.partitions.Resume(types)
+}
+
+// PauseAll stops fetching all messages, it implements flowcontrol.Pauser
+func (k *KafkaMessenger) PauseAll() {
+	k.partitions.PauseAll()
 }
 
 // MessageHandler implements sarama.ConsumerGroupHandler
 type MessageHandler struct {
-	dbSrv dbclient.DB
+	dbSrv      dbclient.DB
+	partitions *flowcontrol.Partitions
+	member     *health.State
 }
 
 // Setup is called when a consumer group session starts
 func (h *MessageHandler) Setup(sarama.ConsumerGroupSession) error {
 	glog.V(5).Info("IGP graph consumer group session setup")
+	h.member.Ready()
 	return nil
 }
 
 // Cleanup is called when a consumer group session ends
 func (h *MessageHandler) Cleanup(sarama.ConsumerGroupSession) error {
 	glog.V(5).Info("IGP graph consumer group session cleanup")
+	h.member.NotReady("consumer group session ended, re-joining the group")
 	return nil
 }
 
-// ConsumeClaim processes messages from a partition
+// ConsumeClaim processes messages from a partition, the offset of a message is marked once
+// the message has been processed.
 func (h *MessageHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	h.partitions.Claim(claim.Topic(), claim.Partition())
+	defer h.partitions.Release(claim.Topic(), claim.Partition())
+	tracker := flowcontrol.NewOffsetTracker(session, claim.Topic(), claim.Partition())
 	for {
 		select {
 		case message := <-claim.Messages():
@@ -153,21 +208,30 @@ func (h *MessageHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim
 				return nil
 			}
 
-			if err := h.processMessage(message); err != nil {
+			offset := message.Offset
+			tracker.Add(offset)
+			// The span ends once the message is handed over, its processing is recorded by child spans
+			ctx, span := tracing.Consume(message)
+			err := h.processMessage(ctx, message, func() { tracker.Ack(offset) })
+			tracing.End(span, err)
+			if err != nil {
+				if errors.Is(err, flowcontrol.ErrStopped) {
+					// The message was not processed, it is consumed again after a restart
+					return nil
+				}
 				glog.Errorf("Failed to process message from topic %s, partition %d, offset %d: %v",
 					message.Topic, message.Partition, message.Offset, err)
 				// Continue processing other messages even if one fails
+				tracker.Ack(offset)
 			}
 
-			session.MarkMessage(message, "")
-
 		case <-session.Context().Done():
 			return nil
 		}
 	}
 }
 
-func (h *MessageHandler) processMessage(message *sarama.ConsumerMessage) error {
+func (h *MessageHandler) processMessage(ctx context.Context, message *sarama.ConsumerMessage, ack func()) error {
 	glog.V(9).Infof("Processing message from topic: %s, partition: %d, offset: %d",
 		message.Topic, message.Partition, message.Offset)
 
@@ -184,6 +248,7 @@ func (h *MessageHandler) processMessage(message *sarama.ConsumerMessage) error {
 		msgType = bmp.LSSRv6SIDMsg
 	default:
 		glog.V(5).Infof("Ignoring message from unsupported topic: %s", message.Topic)
+		ack()
 		return nil
 	}
 
@@ -210,7 +275,7 @@ func (h *MessageHandler) processMessage(message *sarama.ConsumerMessage) error {
 	}
 
 	// Store the processed message
-	return h.dbSrv.StoreMessage(msgType, processedMessage)
+	return dbclient.Store(ctx, h.dbSrv, msgType, processedMessage, ack)
 }
 
 func validateConnection(kafkaConn string) error {
@@ -24,11 +24,10 @@ package arangodb
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 
 	driver "github.com/arangodb/go-driver"
-	arangohttp "github.com/arangodb/go-driver/http"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/golang/glog"
 )
 
@@ -38,6 +37,8 @@ type ArangoConfig struct {
 	User     string
 	Password string
 	Database string
+	// Connection configures TLS, authentication and timeouts, nil connects over HTTP with basic authentication
+	Connection *arangoclient.Config
 }
 
 // ArangoConn represents an ArangoDB connection
@@ -50,28 +51,14 @@ type ArangoConn struct {
 func NewArango(config ArangoConfig) (*ArangoConn, error) {
 	ctx := context.TODO()
 
-	// Create HTTP connection with TLS support
-	conn, err := arangohttp.NewConnection(arangohttp.ConnectionConfig{
-		Endpoints: []string{config.URL},
-		TLSConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP connection: %w", err)
-	}
-
-	// Create client with authentication
-	client, err := driver.NewClient(driver.ClientConfig{
-		Connection:     conn,
-		Authentication: driver.BasicAuthentication(config.User, config.Password),
-	})
+	// Create client with TLS and authentication
+	client, err := config.Connection.NewClient(config.URL, config.User, config.Password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create ArangoDB client: %w", err)
 	}
 
 	// Connect to database
-	db, err := client.Database(ctx, config.Database)
+	db, err := arangoclient.Database(ctx, client, config.Database, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database %s: %w", config.Database, err)
 	}
@@ -160,3 +147,8 @@ func (a *ArangoConn) EnsureCollection(ctx context.Context, name string, isEdge b
 
 	return a.CreateCollection(ctx, name, options)
 }
+
+// Check reports whether the database is reachable, it implements health.Checker
+func (a *ArangoConn) Check(ctx context.Context) error {
+	return arangoclient.Ping(ctx, a.db)
+}
@@ -26,13 +26,14 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	driver "github.com/arangodb/go-driver"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
 	"github.com/golang/glog"
-	"github.com/sbezverk/gobmp/pkg/tools"
 )
 
 type arangoDB struct {
@@ -64,6 +65,11 @@ type arangoDB struct {
 	stop    chan struct{}
 	started bool
 	mu      sync.RWMutex
+	// pending counts messages queued for processing and not yet processed
+	pending atomic.Int64
+	// loading reports progress of the initial load, reconciliation the result of the last IGP reconciliation
+	loading        *health.State
+	reconciliation health.State
 
 	// Event notifier
 	notifier kafkanotifier.Event
@@ -71,15 +77,16 @@ type arangoDB struct {
 
 // NewDBSrvClient returns an instance of a DB server client process
 func NewDBSrvClient(config Config, notifier kafkanotifier.Event) (dbclient.Srv, error) {
-	if err := tools.URLAddrValidation(config.DatabaseServer); err != nil {
+	if err := config.Connection.Validate(config.DatabaseServer); err != nil {
 		return nil, err
 	}
 
 	arangoConn, err := NewArango(ArangoConfig{
-		URL:      config.DatabaseServer,
-		User:     config.User,
-		Password: config.Password,
-		Database: config.Database,
+		URL:        config.DatabaseServer,
+		User:       config.User,
+		Password:   config.Password,
+		Database:   config.Database,
+		Connection: config.Connection,
 	})
 	if err != nil {
 		return nil, err
@@ -90,6 +97,7 @@ func NewDBSrvClient(config Config, notifier kafkanotifier.Event) (dbclient.Srv,
 		config:     config,
 		stop:       make(chan struct{}),
 		notifier:   notifier,
+		loading:    health.NewState("initial IP topology data is not loaded"),
 	}
 	arango.DB = arango
 
@@ -220,6 +228,7 @@ func (a *arangoDB) Start() error {
 
 	// Load initial data
 	if err := a.loadInitialData(); err != nil {
+		a.loading.NotReady("initial load failed: %v", err)
 		return fmt.Errorf("failed to load initial data: %w", err)
 	}
 
@@ -244,6 +253,7 @@ func (a *arangoDB) Start() error {
 	go a.monitor()
 
 	a.started = true
+	a.loading.Ready()
 	glog.Info("IP Graph processor started successfully")
 
 	return nil
@@ -281,6 +291,24 @@ func (a *arangoDB) Stop() error {
 	return nil
 }
 
+// Check reports readiness of the processor, it is ready once the initial load completed, the last
+// IGP reconciliation succeeded and the database is reachable. It implements health.Checker.
+func (a *arangoDB) Check(ctx context.Context) error {
+	if err := a.loading.Check(ctx); err != nil {
+		return err
+	}
+	if err := a.reconciliation.Check(ctx); err != nil {
+		return err
+	}
+
+	return a.ArangoConn.Check(ctx)
+}
+
+// Live reports whether the update processors are processing updates, it implements health.Liveness
+func (a *arangoDB) Live(ctx context.Context) error {
+	return a.updateCoordinator.Live(ctx)
+}
+
 func (a *arangoDB) GetInterface() dbclient.DB {
 	return a.DB
 }
@@ -292,16 +320,52 @@ func (a *arangoDB) StoreMessage(msgType dbclient.CollectionType, msg []byte) err
 	return a.updateCoordinator.ProcessMessage(msgType, msg)
 }
 
+// StoreMessageWithAck queues the message for processing, ack is called once the message has been processed
+func (a *arangoDB) StoreMessageWithAck(msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	return a.StoreMessageWithContext(context.Background(), msgType, msg, ack)
+}
+
+// StoreMessageWithContext queues the message for processing in the trace carried by the context,
+// it implements dbclient.TraceDB
+func (a *arangoDB) StoreMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	if a.updateCoordinator == nil {
+		return ErrProcessorNotStarted
+	}
+	a.pending.Add(1)
+	err := a.updateCoordinator.ProcessMessageWithContext(ctx, msgType, msg, func() {
+		a.pending.Add(-1)
+		if ack != nil {
+			ack()
+		}
+	})
+	if err != nil {
+		a.pending.Add(-1)
+	}
+
+	return err
+}
+
+// Drain waits until queued messages are processed, it implements dbclient.Drainer
+func (a *arangoDB) Drain(ctx context.Context) error {
+	if err := dbclient.WaitDrained(ctx, func() bool { return a.pending.Load() == 0 }); err != nil {
+		return fmt.Errorf("%d messages are not processed: %w", a.pending.Load(), err)
+	}
+
+	return nil
+}
+
 func (a *arangoDB) loadInitialData() error {
 	glog.Info("Loading initial IP topology data...")
 	ctx := context.TODO()
 
 	// Step 1: Copy IGP graph data to IP graphs
+	a.loading.NotReady("copying IGP graph data to IP graphs")
 	if err := a.copyIGPGraphData(ctx); err != nil {
 		return fmt.Errorf("failed to copy IGP graph data: %w", err)
 	}
 
 	// Step 2: Load existing BGP data
+	a.loading.NotReady("loading BGP peers, classifying and deduplicating BGP prefixes")
 	if err := a.loadInitialBGPData(ctx); err != nil {
 		return fmt.Errorf("failed to load initial BGP data: %w", err)
 	}
@@ -1066,8 +1130,8 @@ func (a *arangoDB) monitor() {
 			// Log performance statistics
 			if a.batchProcessor != nil {
 				stats := a.batchProcessor.GetStats()
-				processedCount := stats.Processed.Load()
-				pendingCount := stats.Pending.Load()
+				processedCount := stats.Processed
+				pendingCount := stats.Pending
 				glog.V(5).Infof("Batch processor stats: processed=%d, pending=%d",
 					processedCount, pendingCount)
 			}
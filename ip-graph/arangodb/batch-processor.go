@@ -53,10 +53,10 @@ type BatchProcessor struct {
 	pending   atomic.Int64
 }
 
-// BatchStats represents batch processor statistics
+// BatchStats represents a snapshot of batch processor statistics
 type BatchStats struct {
-	Processed atomic.Int64
-	Pending   atomic.Int64
+	Processed int64
+	Pending   int64
 }
 
 // NewBatchProcessor creates a new batch processor
@@ -156,10 +156,10 @@ func (bp *BatchProcessor) SubmitPrefixOperation(op *BatchOperation) error {
 
 // GetStats returns current processing statistics
 func (bp *BatchProcessor) GetStats() BatchStats {
-	var stats BatchStats
-	stats.Processed.Store(bp.processed.Load())
-	stats.Pending.Store(bp.pending.Load())
-	return stats
+	return BatchStats{
+		Processed: bp.processed.Load(),
+		Pending:   bp.pending.Load(),
+	}
 }
 
 func (bp *BatchProcessor) nodeWorker() {
@@ -301,7 +301,7 @@ func (bp *BatchProcessor) flushTimer() {
 			// Periodic flush statistics logging
 			stats := bp.GetStats()
 			glog.V(6).Infof("Batch processor stats: processed=%d, pending=%d",
-				stats.Processed.Load(), stats.Pending.Load())
+				stats.Processed, stats.Pending)
 		}
 	}
 }
@@ -22,17 +22,22 @@
 
 package arangodb
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+)
 
 var (
 	// ErrProcessorNotStarted indicates the processor has not been started
 	ErrProcessorNotStarted = errors.New("processor not started")
 
 	// ErrProcessorStopped indicates the processor has been stopped
-	ErrProcessorStopped = errors.New("processor stopped")
+	ErrProcessorStopped = fmt.Errorf("processor %w", flowcontrol.ErrStopped)
 
-	// ErrQueueFull indicates the processing queue is full
-	ErrQueueFull = errors.New("processing queue is full")
+	// ErrQueueFull indicates the processing queue is full, the message is retried by flow control
+	ErrQueueFull = fmt.Errorf("processing %w", flowcontrol.ErrQueueFull)
 
 	// ErrInvalidMessage indicates an invalid message was received
 	ErrInvalidMessage = errors.New("invalid message")
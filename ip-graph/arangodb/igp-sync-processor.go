@@ -24,11 +24,14 @@ package arangodb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // IGPSyncProcessor handles syncing IGP topology changes from igpv4_graph/igpv6_graph
@@ -91,16 +94,21 @@ func (isp *IGPSyncProcessor) syncIGPLinkUpdate(ctx context.Context, linkKey, act
 		targetCollection = isp.db.ipv6Graph
 	}
 
+	ctx, span := tracing.StartDB(ctx, "sync", targetCollection.Name(), attribute.String("jalapeno.key", linkKey))
+	var err error
 	switch action {
 	case "del":
-		return isp.syncLinkDeletion(ctx, linkKey, targetCollection, graphVersion)
+		err = isp.syncLinkDeletion(ctx, linkKey, targetCollection, graphVersion)
 
 	case "add", "update":
-		return isp.syncLinkAddUpdate(ctx, linkKey, sourceCollection, targetCollection, graphVersion)
+		err = isp.syncLinkAddUpdate(ctx, linkKey, sourceCollection, targetCollection, graphVersion)
 
 	default:
-		return fmt.Errorf("unknown action: %s", action)
+		err = fmt.Errorf("unknown action: %s", action)
 	}
+	tracing.End(span, err)
+
+	return err
 }
 
 // syncLinkDeletion removes an IGP link from the full topology graph
@@ -268,29 +276,45 @@ func (isp *IGPSyncProcessor) StopReconciliation() {
 	close(isp.stopCh)
 }
 
-// reconcile performs a full reconciliation of IGP topology
+// reconcile performs a full reconciliation of IGP topology, failures of the cycle are reported by
+// the readiness of the processor until a cycle succeeds
 func (isp *IGPSyncProcessor) reconcile() error {
-	ctx := context.Background()
+	// Edges missed by the real-time sync only appear once a cycle copies them, the root span of the
+	// cycle makes such delays visible in traces
+	ctx, span := tracing.Start(context.Background(), "reconcile IGP topology")
 
 	glog.V(7).Info("Starting IGP topology reconciliation cycle...")
+	var errs []error
 
 	// Step 1: Add missing edges (edges in igpvX_graph but not in ipvX_graph)
 	if err := isp.reconcileIPv4Edges(ctx); err != nil {
 		glog.Errorf("Failed to reconcile IPv4 IGP edges: %v", err)
+		errs = append(errs, fmt.Errorf("IPv4 edges: %w", err))
 	}
 
 	if err := isp.reconcileIPv6Edges(ctx); err != nil {
 		glog.Errorf("Failed to reconcile IPv6 IGP edges: %v", err)
+		errs = append(errs, fmt.Errorf("IPv6 edges: %w", err))
 	}
 
 	// Step 2: Remove stale edges (IGP edges in ipvX_graph but not in igpvX_graph)
 	if err := isp.removeStaleIPv4Edges(ctx); err != nil {
 		glog.Errorf("Failed to remove stale IPv4 IGP edges: %v", err)
+		errs = append(errs, fmt.Errorf("stale IPv4 edges: %w", err))
 	}
 
 	if err := isp.removeStaleIPv6Edges(ctx); err != nil {
 		glog.Errorf("Failed to remove stale IPv6 IGP edges: %v", err)
+		errs = append(errs, fmt.Errorf("stale IPv6 edges: %w", err))
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		isp.db.reconciliation.NotReady("last IGP reconciliation failed: %v", err)
+	} else {
+		isp.db.reconciliation.Ready()
 	}
+	tracing.End(span, err)
 
 	glog.V(7).Info("IGP topology reconciliation cycle completed")
 	return nil
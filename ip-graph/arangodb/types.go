@@ -23,6 +23,7 @@
 package arangodb
 
 import (
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/sbezverk/gobmp/pkg/base"
 	"github.com/sbezverk/gobmp/pkg/bgp"
 	"github.com/sbezverk/gobmp/pkg/bgpls"
@@ -36,6 +37,8 @@ type Config struct {
 	User           string
 	Password       string
 	Database       string
+	// Connection configures TLS, authentication and timeouts of database connections
+	Connection *arangoclient.Config
 	// IGP source collections
 	IGPv4Graph string
 	IGPv6Graph string
@@ -26,11 +26,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// retryBackoff is the delay before the first retry of a failed message, it doubles with every attempt
+	retryBackoff = 500 * time.Millisecond
+	// maxRetryBackoff caps the delay between retries of a failed message
+	maxRetryBackoff = 30 * time.Second
+	// maxRetries limits retries of a message failing with a transient error, it is dropped afterwards
+	maxRetries = 10
 )
 
 // UpdateCoordinator coordinates real-time updates from Kafka messages
@@ -42,6 +58,9 @@ type UpdateCoordinator struct {
 	bgpUpdates    chan *ProcessingMessage
 	prefixUpdates chan *ProcessingMessage
 
+	// heartbeats report that the processors are processing updates
+	heartbeats map[string]*health.Heartbeat
+
 	// Control
 	stop    chan struct{}
 	wg      sync.WaitGroup
@@ -56,6 +75,62 @@ type ProcessingMessage struct {
 	Action string
 	ID     string
 	Data   map[string]interface{}
+	// ctx carries the trace context of the message
+	ctx context.Context
+	// ack, if not nil, is called once the message has been processed
+	ack func()
+}
+
+// start starts the span of processing the message in the trace of the message
+func (m *ProcessingMessage) start(kind string) (context.Context, trace.Span) {
+	return tracing.Start(m.ctx, "process "+kind+" update",
+		attribute.String("jalapeno.key", m.Key),
+		attribute.String("jalapeno.action", m.Action))
+}
+
+// done acknowledges the processed message
+func (m *ProcessingMessage) done() {
+	if m.ack != nil {
+		m.ack()
+	}
+}
+
+// process processes the message and acknowledges it, a message failing with a transient database or network
+// error is retried with backoff up to maxRetries times. A message which fails permanently, or still fails after
+// the last retry, is logged and acknowledged so it does not block the following ones. The processor's
+// heartbeat is not renewed while the message is retried, the processor beats again once the message is handled.
+// When the coordinator stops, a message which has not been processed is not acknowledged and is consumed again
+// after a restart.
+func (uc *UpdateCoordinator) process(msg *ProcessingMessage, kind string, f func(context.Context) error) {
+	delay := retryBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, span := msg.start(kind)
+		err := f(ctx)
+		tracing.End(span, err)
+		if err == nil {
+			msg.done()
+			return
+		}
+		if !arangoclient.Transient(err) {
+			glog.Errorf("Failed to process %s update %s, dropping it: %v", kind, msg.Key, err)
+			msg.done()
+			return
+		}
+		if attempt == maxRetries {
+			glog.Errorf("Failed to process %s update %s after %d retries, dropping it: %v", kind, msg.Key, maxRetries, err)
+			msg.done()
+			return
+		}
+		glog.Errorf("Failed to process %s update %s, retrying in %s: %v", kind, msg.Key, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-uc.stop:
+			return
+		}
+		if delay *= 2; delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+	}
 }
 
 // NewUpdateCoordinator creates a new update coordinator
@@ -66,7 +141,28 @@ func NewUpdateCoordinator(db *arangoDB) *UpdateCoordinator {
 		bgpUpdates:    make(chan *ProcessingMessage, 1000),
 		prefixUpdates: make(chan *ProcessingMessage, 1000),
 		stop:          make(chan struct{}),
+		heartbeats: map[string]*health.Heartbeat{
+			"IGP":        health.NewHeartbeat(health.DefaultHeartbeatTimeout),
+			"BGP peer":   health.NewHeartbeat(health.DefaultHeartbeatTimeout),
+			"BGP prefix": health.NewHeartbeat(health.DefaultHeartbeatTimeout),
+		},
+	}
+}
+
+// Live reports whether the processors are processing updates, it implements health.Liveness
+func (uc *UpdateCoordinator) Live(ctx context.Context) error {
+	names := make([]string, 0, len(uc.heartbeats))
+	for name := range uc.heartbeats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := uc.heartbeats[name].Check(ctx); err != nil {
+			return fmt.Errorf("%s update processor: %w", name, err)
+		}
 	}
+
+	return nil
 }
 
 // Start starts the update coordinator
@@ -108,6 +204,18 @@ func (uc *UpdateCoordinator) Stop() {
 
 // ProcessMessage processes a raw BMP message
 func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg []byte) error {
+	return uc.ProcessMessageWithAck(msgType, msg, nil)
+}
+
+// ProcessMessageWithAck processes a raw BMP message, ack is called once the message has been processed,
+// when an error is returned, the message was not queued and ack is not called.
+func (uc *UpdateCoordinator) ProcessMessageWithAck(msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	return uc.ProcessMessageWithContext(context.Background(), msgType, msg, ack)
+}
+
+// ProcessMessageWithContext processes a raw BMP message as ProcessMessageWithAck, the processing is
+// recorded as a span of the trace carried by the context.
+func (uc *UpdateCoordinator) ProcessMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
 	if !uc.started {
 		return ErrProcessorNotStarted
 	}
@@ -125,6 +233,8 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 		Action: getBMPAction(bmpData),
 		ID:     getBMPID(bmpData, msgType),
 		Data:   bmpData,
+		ctx:    ctx,
+		ack:    ack,
 	}
 
 	glog.V(8).Infof("Processing BMP message: type=%d, key=%s, action=%s", msgType, procMsg.Key, procMsg.Action)
@@ -169,59 +279,72 @@ func (uc *UpdateCoordinator) ProcessMessage(msgType dbclient.CollectionType, msg
 
 	default:
 		glog.V(5).Infof("Unknown message type: %d", msgType)
+		procMsg.done()
 		return nil
 	}
 }
 
 func (uc *UpdateCoordinator) igpUpdateWorker() {
 	defer uc.wg.Done()
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
+		uc.heartbeats["IGP"].Beat()
 		select {
+		case <-ticker.C:
 		case <-uc.stop:
 			return
 
 		case msg := <-uc.igpUpdates:
-			if err := uc.processIGPUpdate(msg); err != nil {
-				glog.Errorf("Failed to process IGP update %s: %v", msg.Key, err)
-			}
+			uc.process(msg, "IGP", func(ctx context.Context) error {
+				return uc.processIGPUpdate(ctx, msg)
+			})
 		}
 	}
 }
 
 func (uc *UpdateCoordinator) bgpUpdateWorker() {
 	defer uc.wg.Done()
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
+		uc.heartbeats["BGP peer"].Beat()
 		select {
+		case <-ticker.C:
 		case <-uc.stop:
 			return
 
 		case msg := <-uc.bgpUpdates:
-			if err := uc.processBGPUpdate(msg); err != nil {
-				glog.Errorf("Failed to process BGP update %s: %v", msg.Key, err)
-			}
+			uc.process(msg, "BGP peer", func(context.Context) error {
+				return uc.processBGPUpdate(msg)
+			})
 		}
 	}
 }
 
 func (uc *UpdateCoordinator) prefixUpdateWorker() {
 	defer uc.wg.Done()
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
+		uc.heartbeats["BGP prefix"].Beat()
 		select {
+		case <-ticker.C:
 		case <-uc.stop:
 			return
 
 		case msg := <-uc.prefixUpdates:
-			if err := uc.processPrefixUpdate(msg); err != nil {
-				glog.Errorf("Failed to process prefix update %s: %v", msg.Key, err)
-			}
+			uc.process(msg, "BGP prefix", func(context.Context) error {
+				return uc.processPrefixUpdate(msg)
+			})
 		}
 	}
 }
 
-func (uc *UpdateCoordinator) processIGPUpdate(msg *ProcessingMessage) error {
+func (uc *UpdateCoordinator) processIGPUpdate(ctx context.Context, msg *ProcessingMessage) error {
 	glog.V(7).Infof("Processing IGP update: %s action: %s", msg.Key, msg.Action)
 
 	// Sync changes from igpv4_graph/igpv6_graph (maintained by igp-graph processor)
@@ -231,13 +354,13 @@ func (uc *UpdateCoordinator) processIGPUpdate(msg *ProcessingMessage) error {
 
 	switch msg.Type {
 	case bmp.LSNodeMsg:
-		return igpSync.syncIGPNodeUpdate(context.TODO(), msg.Key, msg.Action)
+		return igpSync.syncIGPNodeUpdate(ctx, msg.Key, msg.Action)
 	case bmp.LSLinkMsg:
-		return uc.processIGPLinkUpdate(msg, igpSync)
+		return uc.processIGPLinkUpdate(ctx, msg, igpSync)
 	case bmp.LSPrefixMsg:
-		return igpSync.syncIGPPrefixUpdate(context.TODO(), msg.Key, msg.Action)
+		return igpSync.syncIGPPrefixUpdate(ctx, msg.Key, msg.Action)
 	case bmp.LSSRv6SIDMsg:
-		return igpSync.syncIGPSRv6Update(context.TODO(), msg.Key, msg.Action)
+		return igpSync.syncIGPSRv6Update(ctx, msg.Key, msg.Action)
 	}
 
 	return nil
@@ -274,7 +397,7 @@ func (uc *UpdateCoordinator) processPrefixUpdate(msg *ProcessingMessage) error {
 }
 
 // IGP sync processing methods (delegated to IGPSyncProcessor)
-func (uc *UpdateCoordinator) processIGPLinkUpdate(msg *ProcessingMessage, igpSync *IGPSyncProcessor) error {
+func (uc *UpdateCoordinator) processIGPLinkUpdate(ctx context.Context, msg *ProcessingMessage, igpSync *IGPSyncProcessor) error {
 	// Sync IGP link changes to full topology
 	// Determine if this is IPv4 or IPv6 based on message data
 	isIPv4 := true
@@ -298,7 +421,7 @@ func (uc *UpdateCoordinator) processIGPLinkUpdate(msg *ProcessingMessage, igpSyn
 		}
 	}
 
-	return igpSync.syncIGPLinkUpdate(context.TODO(), msg.Key, msg.Action, isIPv4)
+	return igpSync.syncIGPLinkUpdate(ctx, msg.Key, msg.Action, isIPv4)
 }
 
 // handlePrefixConflictUpdate handles real-time IGP-BGP prefix conflict resolution
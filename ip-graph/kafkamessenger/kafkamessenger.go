@@ -25,11 +25,16 @@ package kafkamessenger
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/flowcontrol"
+	"github.com/cisco-open/jalapeno/gobmp-arango/health"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 )
@@ -42,32 +47,49 @@ type Srv interface {
 
 // MessageHandler handles Kafka messages
 type MessageHandler struct {
-	dbSrv dbclient.Srv
+	dbSrv      dbclient.Srv
+	partitions *flowcontrol.Partitions
+	member     *health.State
 }
 
 // Setup implements sarama.ConsumerGroupHandler
 func (h *MessageHandler) Setup(sarama.ConsumerGroupSession) error {
+	h.member.Ready()
 	return nil
 }
 
 // Cleanup implements sarama.ConsumerGroupHandler
 func (h *MessageHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	h.member.NotReady("consumer group session ended, re-joining the group")
 	return nil
 }
 
-// ConsumeClaim implements sarama.ConsumerGroupHandler
+// ConsumeClaim implements sarama.ConsumerGroupHandler, the offset of a message is marked once
+// the message has been processed.
 func (h *MessageHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	h.partitions.Claim(claim.Topic(), claim.Partition())
+	defer h.partitions.Release(claim.Topic(), claim.Partition())
+	tracker := flowcontrol.NewOffsetTracker(session, claim.Topic(), claim.Partition())
 	for message := range claim.Messages() {
-		if err := h.processMessage(message); err != nil {
+		offset := message.Offset
+		tracker.Add(offset)
+		// The span ends once the message is handed over, its processing is recorded by child spans
+		ctx, span := tracing.Consume(message)
+		err := h.processMessage(ctx, message, func() { tracker.Ack(offset) })
+		tracing.End(span, err)
+		if err != nil {
+			if errors.Is(err, flowcontrol.ErrStopped) {
+				// The message was not processed, it is consumed again after a restart
+				return nil
+			}
 			glog.Errorf("Failed to process message from topic %s: %v", message.Topic, err)
-			continue
+			tracker.Ack(offset)
 		}
-		session.MarkMessage(message, "")
 	}
 	return nil
 }
 
-func (h *MessageHandler) processMessage(message *sarama.ConsumerMessage) error {
+func (h *MessageHandler) processMessage(ctx context.Context, message *sarama.ConsumerMessage, ack func()) error {
 	// Determine message type based on topic
 	// Note: IGP topics are handled by igp-graph processor, not ip-graph
 	var msgType dbclient.CollectionType
@@ -80,6 +102,7 @@ func (h *MessageHandler) processMessage(message *sarama.ConsumerMessage) error {
 		msgType = bmp.UnicastPrefixV6Msg
 	default:
 		glog.V(5).Infof("Ignoring message from unsupported topic: %s", message.Topic)
+		ack()
 		return nil
 	}
 
@@ -102,20 +125,26 @@ func (h *MessageHandler) processMessage(message *sarama.ConsumerMessage) error {
 	}
 
 	// Send to database processor
-	return h.dbSrv.GetInterface().StoreMessage(msgType, processedData)
+	return dbclient.Store(ctx, h.dbSrv.GetInterface(), msgType, processedData, ack)
 }
 
 type kafka struct {
-	stopCh   chan struct{}
-	brokers  []string
-	dbSrv    dbclient.Srv
-	config   *sarama.Config
-	consumer sarama.ConsumerGroup
-	topics   []string
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	brokers    []string
+	dbSrv      dbclient.Srv
+	config     *sarama.Config
+	consumer   sarama.ConsumerGroup
+	partitions *flowcontrol.Partitions
+	topics     []string
+	member     *health.State
 }
 
-// NewKafkaMessenger returns an instance of a kafka consumer acting as a messenger server
-func NewKafkaMessenger(kafkaConn string, dbSrv dbclient.Srv) (Srv, error) {
+// NewKafkaMessenger returns an instance of a kafka consumer acting as a messenger server, security
+// configures TLS and SASL of connections to the brokers, nil connects in plaintext.
+func NewKafkaMessenger(kafkaConn string, dbSrv dbclient.Srv, security *kafkaclient.Config) (Srv, error) {
 	glog.Info("Initializing IP Graph Kafka messenger")
 
 	brokers := strings.Split(kafkaConn, ",")
@@ -138,19 +167,30 @@ func NewKafkaMessenger(kafkaConn string, dbSrv dbclient.Srv) (Srv, error) {
 	config.Consumer.Group.Session.Timeout = 10 * time.Second
 	config.Consumer.Group.Heartbeat.Interval = 3 * time.Second
 	config.Version = sarama.V2_6_0_0
+	if err := security.Apply(config); err != nil {
+		return nil, err
+	}
 
 	consumer, err := sarama.NewConsumerGroup(brokers, "ip-graph-processor", config)
 	if err != nil {
 		return nil, err
 	}
 
+	// The context ends the consumer group session when the messenger stops, offsets are committed
+	// when the session ends.
+	ctx, cancel := context.WithCancel(context.Background())
 	k := &kafka{
-		stopCh:   make(chan struct{}),
-		brokers:  brokers,
-		dbSrv:    dbSrv,
-		config:   config,
-		consumer: consumer,
-		topics:   topics,
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		brokers:    brokers,
+		dbSrv:      dbSrv,
+		config:     config,
+		consumer:   consumer,
+		topics:     topics,
+		partitions: flowcontrol.NewPartitions(consumer),
+		member:     health.NewState("consumer group is not joined"),
 	}
 
 	return k, nil
@@ -161,15 +201,15 @@ func (k *kafka) Start() error {
 		"ip-graph-processor", k.topics)
 
 	go func() {
+		defer close(k.doneCh)
 		for {
 			select {
 			case <-k.stopCh:
 				return
 			default:
-				ctx := context.Background()
-				handler := &MessageHandler{dbSrv: k.dbSrv}
+				handler := &MessageHandler{dbSrv: k.dbSrv, partitions: k.partitions, member: k.member}
 
-				if err := k.consumer.Consume(ctx, k.topics, handler); err != nil {
+				if err := k.consumer.Consume(k.ctx, k.topics, handler); err != nil {
 					glog.Errorf("Error consuming from Kafka: %v", err)
 					time.Sleep(1 * time.Second)
 				}
@@ -188,9 +228,32 @@ func (k *kafka) Start() error {
 	return nil
 }
 
+// Check reports whether the messenger is a member of the consumer group, it implements health.Checker
+func (k *kafka) Check(ctx context.Context) error {
+	return k.member.Check(ctx)
+}
+
+// Pause stops fetching messages of the types, it implements flowcontrol.Pauser
+func (k *kafka) Pause(types []dbclient.CollectionType) {
+	k.partitions.Pause(types)
+}
+
+// Resume resumes fetching messages of the types, it implements flowcontrol.Pauser
+func (k *kafka) Resume(types []dbclient.CollectionType) {
+	k.partitions.Resume(types)
+}
+
+// PauseAll stops fetching all messages, it implements flowcontrol.Pauser
+func (k *kafka) PauseAll() {
+	k.partitions.PauseAll()
+}
+
 func (k *kafka) Stop() error {
 	glog.Info("Stopping IP Graph Kafka messenger...")
 	close(k.stopCh)
+	// Offsets of processed messages are committed when the consumer group session ends
+	k.cancel()
+	<-k.doneCh
 
 	if err := k.consumer.Close(); err != nil {
 		glog.Errorf("Error closing Kafka consumer: %v", err)
@@ -26,10 +26,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 
 	driver "github.com/arangodb/go-driver"
-	"github.com/arangodb/go-driver/http"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/golang/glog"
 )
 
@@ -41,10 +40,12 @@ var (
 )
 
 type ArangoConfig struct {
-	URL      string `desc:"Arangodb server URL (http://127.0.0.1:8529)"`
+	URL      string `desc:"Arangodb server URL (http://127.0.0.1:8529), or a comma separated list of coordinators"`
 	User     string `desc:"Arangodb server username"`
 	Password string `desc:"Arangodb server user password"`
 	Database string `desc:"Arangodb database name"`
+	// Connection configures TLS, authentication and timeouts, nil connects over HTTP with basic authentication
+	Connection *arangoclient.Config
 }
 
 func NewConfig() ArangoConfig {
@@ -61,40 +62,28 @@ var (
 
 func NewArango(cfg ArangoConfig) (*ArangoConn, error) {
 	// Connect to DB
-	if cfg.URL == "" || cfg.User == "" || cfg.Password == "" || cfg.Database == "" {
+	if cfg.URL == "" || cfg.Database == "" {
 		return nil, ErrEmptyConfig
 	}
-	if !strings.Contains(cfg.URL, "http") {
-		cfg.URL = "http://" + cfg.URL
-	}
-	conn, err := http.NewConnection(http.ConnectionConfig{
-		Endpoints: []string{cfg.URL},
-	})
-	if err != nil {
-		glog.Errorf("Failed to create HTTP connection: %v", err)
-		return nil, err
-	}
-
-	// Authenticate with DB
-	conn, err = conn.SetAuthentication(driver.BasicAuthentication(cfg.User, cfg.Password))
-	if err != nil {
-		glog.Errorf("Failed to authenticate with arango: %v", err)
-		return nil, err
+	if cfg.Connection.UsesCredentials() && (cfg.User == "" || cfg.Password == "") {
+		return nil, ErrEmptyConfig
 	}
-
-	c, err := driver.NewClient(driver.ClientConfig{
-		Connection: conn,
-	})
+	c, err := cfg.Connection.NewClient(cfg.URL, cfg.User, cfg.Password)
 	if err != nil {
 		glog.Errorf("Failed to create client: %v", err)
 		return nil, err
 	}
 
 	// If Jalapeno databse does not exist, the topology is not running, goig into a crash loop
-	db, err := c.Database(context.Background(), cfg.Database)
+	db, err := arangoclient.Database(context.Background(), c, cfg.Database, false)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ArangoConn{db: db}, nil
 }
+
+// Check reports whether the database is reachable, it implements health.Checker
+func (a *ArangoConn) Check(ctx context.Context) error {
+	return arangoclient.Ping(ctx, a.db)
+}
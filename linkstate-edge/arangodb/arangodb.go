@@ -27,12 +27,15 @@ import (
 	"encoding/json"
 
 	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/arangoclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	gobmpnotifier "github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/cisco-open/jalapeno/linkstate-edge/kafkanotifier"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 	"github.com/sbezverk/gobmp/pkg/message"
-	"github.com/sbezverk/gobmp/pkg/tools"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type arangoDB struct {
@@ -43,24 +46,32 @@ type arangoDB struct {
 	edge     driver.Collection
 	graph    driver.Collection
 	notifier kafkanotifier.Event
+	// database is the name of the database, events of documents of other databases are ignored
+	database string
+	// events drops topology events older than the last processed event of the same document
+	events *gobmpnotifier.SequenceFilter
 }
 
-// NewDBSrvClient returns an instance of a DB server client process
-func NewDBSrvClient(arangoSrv, user, pass, dbname, vcn string, ecn string, notifier kafkanotifier.Event) (dbclient.Srv, error) {
-	if err := tools.URLAddrValidation(arangoSrv); err != nil {
+// NewDBSrvClient returns an instance of a DB server client process, conn configures TLS, authentication
+// and timeouts of database connections, nil connects over HTTP with basic authentication.
+func NewDBSrvClient(arangoSrv, user, pass, dbname, vcn string, ecn string, notifier kafkanotifier.Event, conn *arangoclient.Config) (dbclient.Srv, error) {
+	if err := conn.Validate(arangoSrv); err != nil {
 		return nil, err
 	}
 	arangoConn, err := NewArango(ArangoConfig{
-		URL:      arangoSrv,
-		User:     user,
-		Password: pass,
-		Database: dbname,
+		URL:        arangoSrv,
+		User:       user,
+		Password:   pass,
+		Database:   dbname,
+		Connection: conn,
 	})
 	if err != nil {
 		return nil, err
 	}
 	arango := &arangoDB{
-		stop: make(chan struct{}),
+		stop:     make(chan struct{}),
+		database: dbname,
+		events:   gobmpnotifier.NewSequenceFilter(),
 	}
 	arango.DB = arango
 	arango.ArangoConn = arangoConn
@@ -111,15 +122,39 @@ func (a *arangoDB) GetArangoDBInterface() *ArangoConn {
 }
 
 func (a *arangoDB) StoreMessage(msgType dbclient.CollectionType, msg []byte) error {
+	return a.StoreMessageWithContext(context.Background(), msgType, msg, nil)
+}
+
+// StoreMessageWithContext processes the event, the processing is recorded as a span of the trace carried
+// by the context and the trace continues in the event sent to the ls_node_edge topic. ack, if not nil,
+// is called once the event has been processed, it implements dbclient.TraceDB.
+func (a *arangoDB) StoreMessageWithContext(ctx context.Context, msgType dbclient.CollectionType, msg []byte, ack func()) error {
+	if ack != nil {
+		defer ack()
+	}
 	event := &kafkanotifier.EventMessage{}
 	if err := json.Unmarshal(msg, event); err != nil {
 		return err
 	}
 	glog.V(9).Infof("Received event from topology: %+v", *event)
 	event.TopicType = msgType
+	// gobmp-arango sends events of all tenant databases to the same topics
+	if event.Database != "" && event.Database != a.database {
+		glog.V(5).Infof("Ignoring event for %s of database %s", event.ID, event.Database)
+		return nil
+	}
+	if !a.events.Accept(event.Database+"/"+event.ID, event.Sequence, event.Action == "del" || event.Action == "down") {
+		glog.V(5).Infof("Dropping stale event for %s sequence: %d", event.ID, event.Sequence)
+		return nil
+	}
 	switch msgType {
 	case bmp.LSLinkMsg:
-		return a.lsLinkHandler(event)
+		ctx, span := tracing.Start(ctx, "process "+a.edge.Name()+" event",
+			attribute.String("jalapeno.key", event.Key),
+			attribute.String("jalapeno.action", event.Action))
+		err := a.lsLinkHandler(ctx, event)
+		tracing.End(span, err)
+		return err
 	}
 
 	return nil
@@ -24,11 +24,13 @@ package arangodb
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 
 	driver "github.com/arangodb/go-driver"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/cisco-open/jalapeno/linkstate-edge/kafkanotifier"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/base"
@@ -37,8 +39,7 @@ import (
 
 const LSNodeEdgeCollection = "ls_node_edge"
 
-func (a *arangoDB) lsLinkHandler(obj *kafkanotifier.EventMessage) error {
-	ctx := context.TODO()
+func (a *arangoDB) lsLinkHandler(ctx context.Context, obj *kafkanotifier.EventMessage) error {
 	if obj == nil {
 		return fmt.Errorf("event message is nil")
 	}
@@ -49,7 +50,16 @@ func (a *arangoDB) lsLinkHandler(obj *kafkanotifier.EventMessage) error {
 	}
 	glog.V(5).Infof("Processing action: %s for key: %s ID: %s", obj.Action, obj.Key, obj.ID)
 	var o message.LSLink
-	_, err := a.edge.ReadDocument(ctx, obj.Key, &o)
+	var err error
+	if len(obj.Document) != 0 && (obj.Action == "add" || obj.Action == "update") {
+		// The event carries the document, no need to read it back from the database
+		err = json.Unmarshal(obj.Document, &o)
+		if err != nil {
+			return fmt.Errorf("failed to decode document %s carried by the event with error: %+v", obj.Key, err)
+		}
+	} else {
+		_, err = a.edge.ReadDocument(ctx, obj.Key, &o)
+	}
 	if err != nil {
 		// In case of a ls_link removal notification, reading it will return Not Found error
 		if !driver.IsNotFound(err) {
@@ -64,6 +74,7 @@ func (a *arangoDB) lsLinkHandler(obj *kafkanotifier.EventMessage) error {
 			return err
 		}
 		// write event into ls_node_edge topic
+		obj.TraceContext = tracing.Inject(ctx)
 		a.notifier.EventNotification(obj)
 		return nil
 	}
@@ -78,6 +89,7 @@ func (a *arangoDB) lsLinkHandler(obj *kafkanotifier.EventMessage) error {
 	glog.V(5).Infof("Complete processing action: %s for key: %s ID: %s", obj.Action, obj.Key, obj.ID)
 
 	// write event into ls_node_edge topic
+	obj.TraceContext = tracing.Inject(ctx)
 	a.notifier.EventNotification(obj)
 
 	return nil
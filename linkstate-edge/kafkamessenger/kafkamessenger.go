@@ -23,11 +23,16 @@
 package kafkamessenger
 
 import (
+	"context"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
 	"github.com/cisco-open/jalapeno/gobmp-arango/kafkanotifier"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 	"github.com/sbezverk/gobmp/pkg/tools"
@@ -52,10 +57,13 @@ type kafka struct {
 	db      dbclient.DB
 	config  *sarama.Config
 	master  sarama.Consumer
+	// consuming is the number of topics with a running partition consumer
+	consuming int32
 }
 
-// NewKafkaMessenger returns an instance of a kafka consumer acting as a messenger server
-func NewKafkaMessenger(kafkaSrv string, db dbclient.DB) (Srv, error) {
+// NewKafkaMessenger returns an instance of a kafka consumer acting as a messenger server, security
+// configures TLS and SASL of connections to the broker, nil connects in plaintext.
+func NewKafkaMessenger(kafkaSrv string, db dbclient.DB, security *kafkaclient.Config) (Srv, error) {
 	glog.Infof("LS Node Vertex kafka reader")
 	if err := tools.HostAddrValidator(kafkaSrv); err != nil {
 		return nil, err
@@ -65,6 +73,9 @@ func NewKafkaMessenger(kafkaSrv string, db dbclient.DB) (Srv, error) {
 	config.ClientID = "lslinknode-edge-collection"
 	config.Consumer.Return.Errors = true
 	config.Version = sarama.V0_11_0_0
+	if err := security.Apply(config); err != nil {
+		return nil, err
+	}
 
 	brokers := []string{kafkaSrv}
 
@@ -98,6 +109,14 @@ func (k *kafka) Stop() error {
 	return nil
 }
 
+// Check reports whether every topic is consumed, it implements health.Checker
+func (k *kafka) Check(ctx context.Context) error {
+	if n := atomic.LoadInt32(&k.consuming); int(n) != len(topics) {
+		return fmt.Errorf("%d of %d topics are consumed", n, len(topics))
+	}
+	return nil
+}
+
 func (k *kafka) topicReader(topicType dbclient.CollectionType, topicName string) {
 	ticker := time.NewTicker(200 * time.Millisecond)
 	for {
@@ -114,15 +133,20 @@ func (k *kafka) topicReader(topicType dbclient.CollectionType, topicName string)
 			continue
 		}
 		glog.Infof("Starting Kafka reader for topic: %s topicType: %d", topicName, topicType)
+		atomic.AddInt32(&k.consuming, 1)
+		defer atomic.AddInt32(&k.consuming, -1)
 		for {
 			select {
 			case msg := <-consumer.Messages():
 				if msg == nil {
 					continue
 				}
-				if err := k.db.StoreMessage(topicType, msg.Value); err != nil {
+				ctx, span := tracing.Consume(msg)
+				err := dbclient.Store(ctx, k.db, topicType, msg.Value, nil)
+				if err != nil {
 					glog.Errorf("failed to process a message from topic %s with error: %+v", topicName, err)
 				}
+				tracing.End(span, err)
 			case consumerError := <-consumer.Errors():
 				if consumerError == nil {
 					break
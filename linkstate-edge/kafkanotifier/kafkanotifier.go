@@ -32,6 +32,8 @@ import (
 
 	"github.com/Shopify/sarama"
 	"github.com/cisco-open/jalapeno/gobmp-arango/dbclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/kafkaclient"
+	"github.com/cisco-open/jalapeno/gobmp-arango/tracing"
 	"github.com/golang/glog"
 	"github.com/sbezverk/gobmp/pkg/bmp"
 )
@@ -59,7 +61,18 @@ type EventMessage struct {
 	TopicType dbclient.CollectionType
 	Key       string `json:"_key"`
 	ID        string `json:"_id"`
+	Rev       string `json:"_rev,omitempty"`
+	Sequence  uint64 `json:"sequence,omitempty"`
 	Action    string `json:"action"`
+	// Database is the database of the changed document, gobmp-arango sends events of all tenant databases
+	// to the same topics
+	Database string `json:"database,omitempty"`
+	// Document and Previous are present when gobmp-arango is configured to send documents in events
+	Document      json.RawMessage `json:"document,omitempty"`
+	Previous      json.RawMessage `json:"previous,omitempty"`
+	ChangedFields []string        `json:"changed_fields,omitempty"`
+	// TraceContext carries the trace context of the processed event, it is propagated in record headers
+	TraceContext map[string]string `json:"-"`
 }
 
 type Event interface {
@@ -83,7 +96,9 @@ func (n *notifier) EventNotification(msg *EventMessage) error {
 	return fmt.Errorf("unknown topic type %d", msg.TopicType)
 }
 
-func NewKafkaNotifier(kafkaSrv string) (Event, error) {
+// NewKafkaNotifier returns a notifier publishing events to Kafka, security configures TLS and SASL
+// of connections to the broker, nil connects in plaintext.
+func NewKafkaNotifier(kafkaSrv string, security *kafkaclient.Config) (Event, error) {
 	glog.Infof("Initializing Kafka events producer client")
 	if err := validator(kafkaSrv); err != nil {
 		glog.Errorf("Failed to validate Kafka server address %s with error: %+v", kafkaSrv, err)
@@ -92,6 +107,9 @@ func NewKafkaNotifier(kafkaSrv string) (Event, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	config.Version = sarama.V0_11_0_0
+	if err := security.Apply(config); err != nil {
+		return nil, err
+	}
 
 	br := sarama.NewBroker(kafkaSrv)
 	if err := br.Open(config); err != nil {
@@ -129,11 +147,14 @@ func (n *notifier) triggerNotification(topic string, msg *EventMessage) error {
 	k = []byte(msg.Key)
 	m := sarama.ByteEncoder{}
 	m, _ = json.Marshal(msg)
-	_, _, err := n.producer.SendMessage(&sarama.ProducerMessage{
+	pm := &sarama.ProducerMessage{
 		Topic: topic,
 		Key:   k,
 		Value: m,
-	})
+	}
+	span := tracing.Produce(tracing.Extract(msg.TraceContext), pm)
+	_, _, err := n.producer.SendMessage(pm)
+	tracing.End(span, err)
 
 	return err
 }